@@ -5,22 +5,40 @@ import (
 	"flag"
 	"fmt"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/golang/glog"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/annotation"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy"
 
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/endpoint"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener/ingress"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/health"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/validation"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
+	"k8s.io/client-go/tools/leaderelection"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 const grpcMaxConcurrentStreams = 1000000
 const defaultGRPCPort = "18000"
+const defaultSignerPort = "18444"
+const controlPlaneService = "traffic-control"
+const defaultDebugPort = "18001"
+const defaultMetricsPort = "18002"
+const defaultWebhookPort = "18443"
+const defaultDrainPeriod = 10 * time.Second
+const grpcStopTimeout = 5 * time.Second
 
 var (
 	BuildVersion = "0.1.0"
@@ -32,11 +50,83 @@ func main() {
 	if grpcPort == "" {
 		grpcPort = defaultGRPCPort
 	}
+	signerPort := os.Getenv("TRAFFIC_SIGNER_PORT")
+	if signerPort == "" {
+		signerPort = defaultSignerPort
+	}
+	//debug server only listens on loopback, use kubectl port-forward to access it
+	debugPort := os.Getenv("TRAFFIC_DEBUG_PORT")
+	if debugPort == "" {
+		debugPort = defaultDebugPort
+	}
+	metricsPort := os.Getenv("TRAFFIC_METRICS_PORT")
+	if metricsPort == "" {
+		metricsPort = defaultMetricsPort
+	}
+	webhookPort := os.Getenv("TRAFFIC_WEBHOOK_PORT")
+	if webhookPort == "" {
+		webhookPort = defaultWebhookPort
+	}
+	//ads streams are closed evenly in this period on shutdown
+	drainPeriod := defaultDrainPeriod
+	if value := os.Getenv("TRAFFIC_DRAIN_PERIOD"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			panic(err.Error())
+		}
+		drainPeriod = time.Duration(seconds) * time.Second
+	}
 	flag.Parse()
 
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	namespaceFilter, err := kubernetes.NamespaceFilterFromEnv()
+	if err != nil {
+		panic(err.Error())
+	}
+	k8sManager, err := kubernetes.NewK8sResourceManager(namespaceFilter)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	namespace := common.ControlPlaneNamespace()
+	secretManager, err := common.LoadOrCreateSecretManager(k8sManager, namespace)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = secretManager.EnsureNodeSecret(k8sManager, common.INGRESS_CLIENT_SECRET_NAME, namespace, envoy.IngressNodeId)
+	if err != nil {
+		panic(err.Error())
+	}
+	err = secretManager.EnsureRootCertSecret(k8sManager, namespace)
+	if err != nil {
+		panic(err.Error())
+	}
+	hosts := []string{
+		controlPlaneService,
+		fmt.Sprintf("%s.%s", controlPlaneService, namespace),
+		fmt.Sprintf("%s.%s.svc", controlPlaneService, namespace),
+		"localhost",
+		"127.0.0.1",
+	}
+	//plaintext and envoy without client certificate are accepted in permissive mode
+	permissive, err := common.MTLSPermissive()
+	if err != nil {
+		panic(err.Error())
+	}
+	tlsConfig, err := secretManager.ServerTLSConfig(hosts, permissive)
+	if err != nil {
+		panic(err.Error())
+	}
+	//envoy-manager authenticates to certificate signer with service account token instead of client certificate
+	signerTLSConfig, err := secretManager.ServerTLSConfig(hosts, true)
+	if err != nil {
+		panic(err.Error())
+	}
 
 	grpcServer := grpc.NewServer(
+		grpc.Creds(common.NewServerCredentials(tlsConfig, permissive)),
 		grpc.MaxConcurrentStreams(grpcMaxConcurrentStreams))
 
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", grpcPort))
@@ -46,33 +136,155 @@ func main() {
 		panic(errInfo)
 	}
 
-	k8sManager, err := kubernetes.NewK8sResourceManager()
-	if err != nil {
-		panic(err.Error())
-	}
-
 	cds := cluster.NewClustersControlPlaneService(k8sManager)
 	eds := endpoint.NewEndpointsControlPlaneService(k8sManager)
+	useEndpointSlices := endpoint.EndpointSlicesEnabled()
+	if useEndpointSlices {
+		eds.UseEndpointSlices(endpoint.ReportNotReadyFromEnv())
+	}
 	lds := listener.NewListenersControlPlaneService(k8sManager)
 	ilds := ingress.NewIngressListenersControlPlaneService(k8sManager)
+
+	guard := common.NewShrinkGuardFromEnv()
+	cds.SetShrinkGuard(guard)
+	lds.SetShrinkGuard(guard)
+	ilds.SetShrinkGuard(guard)
+
+	rds := listener.NewRoutesControlPlaneService(k8sManager)
+	irds := ingress.NewIngressRoutesControlPlaneService(k8sManager)
 	sds := envoy.NewSecretsControlPlaneService(k8sManager)
+	verifier := envoy.NewNodeIdentityVerifier(k8sManager)
+	verifier.SetPermissive(permissive)
+	//client certificates of envoy proxies are requested by envoy-manager, root key never leaves traffic-control
+	signer := envoy.NewNodeCertificateSigner(k8sManager, secretManager)
+
+	//service and workload config is joined in memory, pod annotations only show it
+	podHandlers := []kubernetes.PodEventHandler{k8sManager, eds, cds, lds, rds, verifier, signer}
+	var leaderHandlers []kubernetes.LeaderEventHandler
+	if kubernetes.GetLabelValueBool(os.Getenv("TRAFFIC_POD_ANNOTATIONS")) {
+		serviceToPodAnnotator := annotation.NewServiceToPodAnnotator(k8sManager)
+		deploymentToPodAnnotator := annotation.NewDeploymentToPodAnnotator(k8sManager)
+		podHandlers = append(podHandlers, deploymentToPodAnnotator, serviceToPodAnnotator)
+		leaderHandlers = append(leaderHandlers, serviceToPodAnnotator, deploymentToPodAnnotator)
+	}
+	configReporter := validation.NewConfigReporter(k8sManager, kubernetes.GetLabelValueBool(os.Getenv("TRAFFIC_CONFIG_STATUS_ANNOTATION")))
 
-	serviceToPodAnnotator := annotation.NewServiceToPodAnnotator(k8sManager)
-	deploymentToPodAnnotator := annotation.NewDeploymentToPodAnnotator(k8sManager)
+	//every replica serves xds, only the leader writes kubernetes resources
+	var elector *leaderelection.LeaderElector
+	if kubernetes.LeaderElectionEnabled() {
+		identity := os.Getenv("POD_NAME")
+		if identity == "" {
+			identity, _ = os.Hostname()
+		}
+		elector, err = k8sManager.NewLeaderElector(namespace, identity, kubernetes.DEFAULT_LEASE_DURATION)
+		if err != nil {
+			panic(err.Error())
+		}
+		k8sManager.AddLeaderEventHandler(append(leaderHandlers, ilds, configReporter)...)
+	}
 
-	ads := envoy.NewAggregatedDiscoveryService(cds, eds, lds, ilds, sds)
+	ads := envoy.NewAggregatedDiscoveryService(cds, eds, lds, ilds, rds, irds, sds, verifier)
 
 	discovery.RegisterAggregatedDiscoveryServiceServer(grpcServer, ads)
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(grpcServer, envoy.NewAggregatedDiscoveryServiceV3(ads))
 
 	stopper := make(chan struct{})
-	defer close(stopper)
-	go k8sManager.WatchPods(stopper, k8sManager, eds, cds, lds, deploymentToPodAnnotator, serviceToPodAnnotator)
-	go k8sManager.WatchServices(stopper, k8sManager, cds, lds, ilds, serviceToPodAnnotator)
-	go k8sManager.WatchDeployments(stopper, k8sManager, deploymentToPodAnnotator)
-	go k8sManager.WatchStatefulSets(stopper, k8sManager, deploymentToPodAnnotator)
-	go k8sManager.WatchDaemonSets(stopper, k8sManager, deploymentToPodAnnotator)
+	go k8sManager.WatchPods(stopper, append(podHandlers, configReporter)...)
+	serviceHandlers := []kubernetes.ServiceEventHandler{k8sManager, cds, eds, lds, ilds, rds, irds, sds, configReporter}
+	syncResources := []string{"pods", "services", "deployments", "statefulsets", "daemonsets", "replicasets", "jobs", "cronjobs", "secrets", "ingresses",
+		"trafficpolicies", "defaulttrafficpolicies", "namespaces", "configmaps"}
+	if useEndpointSlices {
+		syncResources = append(syncResources, "endpointslices")
+		go k8sManager.WatchEndpointSlices(stopper, eds)
+	}
+	go k8sManager.WatchServices(stopper, serviceHandlers...)
+	go k8sManager.WatchDeployments(stopper, k8sManager, configReporter)
+	go k8sManager.WatchStatefulSets(stopper, k8sManager, configReporter)
+	go k8sManager.WatchDaemonSets(stopper, k8sManager, configReporter)
+	go k8sManager.WatchReplicaSets(stopper, k8sManager, configReporter)
+	go k8sManager.WatchJobs(stopper, k8sManager, configReporter)
+	go k8sManager.WatchCronJobs(stopper, k8sManager, configReporter)
 	go k8sManager.WatchSecrets(stopper, sds)
 	go k8sManager.WatchIngresss(stopper, ilds)
+	go k8sManager.WatchTrafficPolicies(stopper, k8sManager)
+	go k8sManager.WatchDefaultTrafficPolicies(stopper, k8sManager)
+	go k8sManager.WatchNamespaces(stopper, k8sManager, configReporter)
+	go k8sManager.WatchMeshDefaults(stopper, k8sManager, configReporter)
+
+	mux := http.NewServeMux()
+	mux.Handle(common.SIGN_NODE_PATH, signer)
+	signerServer := &http.Server{
+		Addr:      fmt.Sprintf(":%s", signerPort),
+		Handler:   mux,
+		TLSConfig: signerTLSConfig,
+	}
+	go func() {
+		glog.Infof("certificate signer listening %s", signerPort)
+		if err := signerServer.ListenAndServeTLS("", ""); err != nil {
+			glog.Error(err)
+		}
+	}()
+
+	go func() {
+		if k8sManager.WaitForSync(stopper, syncResources...) {
+			glog.Info("All informers synced, start serving xds responses")
+			ads.SetSynced()
+			if elector != nil {
+				//the leader resyncs annotations from complete cache
+				k8sManager.RunLeaderElection(ctx, elector)
+			}
+		}
+	}()
+
+	debugServer := envoy.NewDebugServer(ads)
+	go func() {
+		err := http.ListenAndServe(fmt.Sprintf("127.0.0.1:%s", debugPort), debugServer.Handler())
+		if err != nil {
+			glog.Errorf("debug server failed: %s", err.Error())
+		}
+	}()
+
+	checker := health.NewChecker()
+	checker.AddReadinessCheck("informers", func() error {
+		if !ads.IsSynced() {
+			return fmt.Errorf("informers are not synced")
+		}
+		return nil
+	})
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.Handler())
+		checker.Register(mux)
+		err := http.ListenAndServe(fmt.Sprintf(":%s", metricsPort), mux)
+		if err != nil {
+			glog.Errorf("metrics server failed: %s", err.Error())
+		}
+	}()
+
+	if kubernetes.GetLabelValueBool(os.Getenv("TRAFFIC_WEBHOOK_ENABLED")) {
+		webhookTLSConfig, err := secretManager.WebhookTLSConfig(hosts)
+		if err != nil {
+			panic(err.Error())
+		}
+		err = k8sManager.EnsureValidatingWebhook(controlPlaneService, namespace, secretManager.PemRootCertBytes)
+		if err != nil {
+			//labels are still validated by ConfigReporter
+			glog.Errorf("Failed to register ValidatingWebhookConfiguration %s: %s", kubernetes.VALIDATING_WEBHOOK_NAME, err.Error())
+		}
+		mux := http.NewServeMux()
+		mux.Handle(kubernetes.VALIDATING_WEBHOOK_PATH, validation.WebhookHandler())
+		webhookServer := &http.Server{
+			Addr:      fmt.Sprintf(":%s", webhookPort),
+			Handler:   mux,
+			TLSConfig: webhookTLSConfig,
+		}
+		go func() {
+			if err := webhookServer.ListenAndServeTLS("", ""); err != nil {
+				glog.Errorf("webhook server failed: %s", err.Error())
+			}
+		}()
+	}
 
 	glog.Infof("grpc server listening %s, version=%s", grpcPort, BuildVersion)
 	go func() {
@@ -80,7 +292,30 @@ func main() {
 			glog.Error(err)
 		}
 	}()
-	<-ctx.Done()
 
-	grpcServer.GracefulStop()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	glog.Infof("Received %s, shutting down", sig.String())
+
+	checker.ShutDown()
+	//release leader lease so that another replica takes over immediately
+	cancel()
+	ads.Drain(drainPeriod)
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(grpcStopTimeout):
+		glog.Warning("Graceful stop timeout, closing remaining connections")
+		grpcServer.Stop()
+	}
+
+	close(stopper)
+	glog.Info("traffic-control terminated")
+	glog.Flush()
 }
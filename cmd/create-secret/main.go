@@ -36,7 +36,7 @@ func main() {
 		panic(err.Error())
 	}
 
-	k8sManager, err := kubernetes.NewK8sResourceManager()
+	k8sManager, err := kubernetes.NewK8sResourceManager(nil)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -12,7 +12,9 @@ import (
 	"github.com/gogo/protobuf/proto"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/client"
 	envoy "github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type StreamClient interface {
@@ -39,8 +41,22 @@ func main() {
 
 	flag.StringVar(&typeUrl, "typeUrl", envoy.ListenerResource, fmt.Sprintf("one of %v", urls))
 	flag.Parse()
+	k8sManager, err := kubernetes.NewK8sResourceManager(nil)
+	if err != nil {
+		panic(err)
+	}
+	//control plane only accepts client certificate issued by its root certificate
+	secretManager, err := envoy.LoadExistingSecretManager(k8sManager, envoy.ControlPlaneNamespace())
+	if err != nil {
+		panic(err)
+	}
+	tlsConfig, err := secretManager.ClientTLSConfig(nodeId)
+	if err != nil {
+		panic(err)
+	}
+
 	fmt.Printf("connecting %s\n", serverAddr)
-	conn, err := grpc.Dial(serverAddr, grpc.WithInsecure())
+	conn, err := grpc.Dial(serverAddr, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	if err != nil {
 		panic(err)
 	}
@@ -2,24 +2,105 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"github.com/golang/glog"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/docker"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/health"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+const defaultHealthPort = "18003"
+
+//resources whose config is joined to pods
+var podConfigResources = []string{"deployments", "statefulsets", "daemonsets", "replicasets", "jobs", "cronjobs", "namespaces", "configmaps"}
+
 func main() {
 	flag.Parse()
-	k8sManager, err := kubernetes.NewK8sResourceManager()
+	healthPort := os.Getenv("ENVOY_MANAGER_HEALTH_PORT")
+	if healthPort == "" {
+		healthPort = defaultHealthPort
+	}
+
+	namespaceFilter, err := kubernetes.NamespaceFilterFromEnv()
+	if err != nil {
+		panic(err.Error())
+	}
+	k8sManager, err := kubernetes.NewK8sResourceManager(namespaceFilter)
 	if err != nil {
 		panic(err.Error())
 	}
 	stopper := make(chan struct{})
-	defer close(stopper)
 
-	envoyManager, err := docker.NewEnvoyManager(k8sManager)
+	checker := health.NewChecker()
+	go func() {
+		mux := http.NewServeMux()
+		checker.Register(mux)
+		err := http.ListenAndServe(fmt.Sprintf(":%s", healthPort), mux)
+		if err != nil {
+			glog.Errorf("health server failed: %s", err.Error())
+		}
+	}()
+
+	var rootCert []byte
+	for {
+		//root certificate is created by traffic-control, its key is never read here
+		rootCert, err = common.LoadRootCertificate(k8sManager, common.ControlPlaneNamespace())
+		if err == nil {
+			break
+		}
+		glog.Warningf("Load root certificate failed, retry later: %s", err.Error())
+		time.Sleep(5 * time.Second)
+	}
+	certificateClient, err := docker.NewCertificateClient(rootCert)
 	if err != nil {
 		panic(err.Error())
 	}
+
+	envoyManager, err := docker.NewEnvoyManager(k8sManager, certificateClient)
+	if err != nil {
+		panic(err.Error())
+	}
+	checker.AddReadinessCheck("docker", envoyManager.CheckDocker)
+	checker.AddReadinessCheck("informers", func() error {
+		if !k8sManager.InformersSynced(append(podConfigResources, "pods")...) {
+			return fmt.Errorf("informers are not synced")
+		}
+		return nil
+	})
+
 	envoyManager.CheckExistingEnvoy()
-	k8sManager.WatchPods(stopper, k8sManager, envoyManager)
+	terminated := make(chan struct{})
+	//traffic.envoy.enabled labels of workloads, namespaces and traffic-defaults are joined to pods in memory
+	go k8sManager.WatchDeployments(stopper, k8sManager)
+	go k8sManager.WatchStatefulSets(stopper, k8sManager)
+	go k8sManager.WatchDaemonSets(stopper, k8sManager)
+	go k8sManager.WatchReplicaSets(stopper, k8sManager)
+	go k8sManager.WatchJobs(stopper, k8sManager)
+	go k8sManager.WatchCronJobs(stopper, k8sManager)
+	go k8sManager.WatchNamespaces(stopper, k8sManager)
+	go k8sManager.WatchMeshDefaults(stopper, k8sManager)
+	go func() {
+		//otherwise envoy of pods whose workload or namespace is not delivered yet would be stopped
+		if k8sManager.WaitForSync(stopper, podConfigResources...) {
+			k8sManager.WatchPods(stopper, k8sManager, envoyManager)
+		}
+		close(terminated)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	glog.Infof("Received %s, shutting down", sig.String())
 
+	checker.ShutDown()
+	close(stopper)
+	<-terminated
+	glog.Info("envoy-manager terminated")
+	glog.Flush()
 }
@@ -1,18 +1,24 @@
 package annotation
 
 import (
-	"github.com/golang/glog"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/endpoint"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 )
 
+/**
+ * Optionally show labels of workloads controlling a pod in its annotations, e.g. traffic.rs.endpoint.weight=50.
+ * The annotations are for display only, handlers use PodInfo.WorkloadConfig joined in memory.
+ */
 type DeploymentToPodAnnotator struct {
 	k8sManager *kubernetes.K8sResourceManager
+	//pods to annotate again when this replica becomes leader
+	podMap map[string]*kubernetes.PodInfo
 }
 
 func NewDeploymentToPodAnnotator(k8sManager *kubernetes.K8sResourceManager) *DeploymentToPodAnnotator {
 	return &DeploymentToPodAnnotator{
 		k8sManager: k8sManager,
+		podMap:     make(map[string]*kubernetes.PodInfo),
 	}
 }
 
@@ -20,25 +26,18 @@ func (annotator *DeploymentToPodAnnotator) PodValid(pod *kubernetes.PodInfo) boo
 	return pod.Valid()
 }
 
-func (annotator *DeploymentToPodAnnotator) removeDeploymentAnnotateToPod(pod *kubernetes.PodInfo, deployment *kubernetes.DeploymentInfo) {
-	var annotationKeys []string
-	for key, _ := range pod.Annotations {
-		if kubernetes.AnnotationHasDeploymentLabel(key) {
-			annotationKeys = append(annotationKeys, key)
+//annotations showing current labels of workloads controlling the pod
+func deploymentAnnotations(pod *kubernetes.PodInfo) map[string]string {
+	result := make(map[string]string)
+	for key, value := range pod.WorkloadConfig {
+		if value != "" && endpoint.NeedDeploymentToPodAnnotation(key) {
+			result[kubernetes.DeploymentLabelToPodAnnotation(key)] = value
 		}
 	}
-
-	if len(annotationKeys) == 0 {
-		return
-	}
-
-	err := annotator.k8sManager.RemovePodAnnotation(pod, annotationKeys)
-	if err != nil {
-		glog.Errorf("Remove Pod %s Annotations %v failed: %s", pod.Name(), annotationKeys, err.Error())
-	}
+	return result
 }
 
-func (annotator *DeploymentToPodAnnotator) addDeploymentAnnotateToPod(pod *kubernetes.PodInfo, deployment *kubernetes.DeploymentInfo) {
+func (annotator *DeploymentToPodAnnotator) annotate(pod *kubernetes.PodInfo) {
 	annotations := make(map[string]string)
 
 	for key, _ := range pod.Annotations {
@@ -49,52 +48,37 @@ func (annotator *DeploymentToPodAnnotator) addDeploymentAnnotateToPod(pod *kuber
 		}
 	}
 
-	//propagate deployment labels to pod
-	for key, value := range deployment.Labels {
-		if endpoint.NeedDeploymentToPodAnnotation(key) {
-			podKey := kubernetes.DeploymentLabelToPodAnnotation(key)
-			annotations[podKey] = value
-		}
+	for key, value := range deploymentAnnotations(pod) {
+		annotations[key] = value
 	}
 
 	if len(annotations) == 0 {
 		return
 	}
-	err := annotator.k8sManager.UpdatePodAnnotation(pod, annotations)
-	if err != nil {
-		glog.Infof("Annotate pod %s with %v failed: %s", pod.Name(), annotations, err.Error())
-	}
+	annotator.k8sManager.QueuePodAnnotation(pod, annotations)
 }
 
 func (annotator *DeploymentToPodAnnotator) PodAdded(pod *kubernetes.PodInfo) {
-	for _, resource := range annotator.k8sManager.GetMatchedResources(pod, kubernetes.DEPLOYMENT_TYPE) {
-		deployment := resource.(*kubernetes.DeploymentInfo)
-		annotator.addDeploymentAnnotateToPod(pod, deployment)
-	}
+	annotator.podMap[podKey(pod)] = pod
+	annotator.annotate(pod)
 }
 
-func (*DeploymentToPodAnnotator) PodDeleted(pod *kubernetes.PodInfo) {
-	//ignore
+func (annotator *DeploymentToPodAnnotator) PodDeleted(pod *kubernetes.PodInfo) {
+	delete(annotator.podMap, podKey(pod))
 }
+
+//pods are delivered again when workloads controlling them change
 func (annotator *DeploymentToPodAnnotator) PodUpdated(oldPod, newPod *kubernetes.PodInfo) {
-	//ignore
+	annotator.PodAdded(newPod)
 }
 
-func (annotator *DeploymentToPodAnnotator) DeploymentValid(deployment *kubernetes.DeploymentInfo) bool {
-	return true
-}
-func (annotator *DeploymentToPodAnnotator) DeploymentAdded(deployment *kubernetes.DeploymentInfo) {
-	for _, resource := range annotator.k8sManager.GetMatchedResources(deployment, kubernetes.POD_TYPE) {
-		pod := resource.(*kubernetes.PodInfo)
-		annotator.addDeploymentAnnotateToPod(pod, deployment)
+/**
+ * Annotations may be skipped or missed while another replica was the leader,
+ * annotations of workload labels which are gone meanwhile are removed.
+ */
+func (annotator *DeploymentToPodAnnotator) StartedLeading() {
+	removeOrphanAnnotations(annotator.k8sManager, annotator.podMap, kubernetes.AnnotationHasDeploymentLabel, deploymentAnnotations)
+	for _, pod := range annotator.podMap {
+		annotator.annotate(pod)
 	}
 }
-func (annotator *DeploymentToPodAnnotator) DeploymentDeleted(deployment *kubernetes.DeploymentInfo) {
-	for _, resource := range annotator.k8sManager.GetMatchedResources(deployment, kubernetes.POD_TYPE) {
-		pod := resource.(*kubernetes.PodInfo)
-		annotator.removeDeploymentAnnotateToPod(pod, deployment)
-	}
-}
-func (annotator *DeploymentToPodAnnotator) DeploymentUpdated(oldDeployment, newDeployment *kubernetes.DeploymentInfo) {
-	annotator.DeploymentAdded(newDeployment)
-}
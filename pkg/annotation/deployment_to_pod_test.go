@@ -1,10 +1,11 @@
 package annotation
 
 import (
+	"context"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"testing"
 	"time"
@@ -20,17 +21,17 @@ func TestDeploymentEnvoyToPod(t *testing.T) {
 	podWatchlist := k8sManager.GetListerWatcher("pods")
 	deploymentWatchlist := k8sManager.GetListerWatcher("deployments")
 	go k8sManager.WatchPods(stopper, k8sManager, annotator)
-	go k8sManager.WatchDeployments(stopper, k8sManager, annotator)
+	go k8sManager.WatchDeployments(stopper, k8sManager)
 
 	var pod corev1.Pod
 	pod.Namespace = "test-ns"
 	pod.Labels = map[string]string{"a": "b", "c": "d"}
 	pod.Status.PodIP = "10.1.1.1"
 	pod.Name = "Comp1-pod"
-	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(&pod)
+	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &pod, metav1.CreateOptions{})
 	podWatchlist.Add(&pod)
 
-	var deploy v1beta1.Deployment
+	var deploy appsv1.Deployment
 	deploy.Name = "Comp1"
 	deploy.Namespace = "test-ns"
 	deploy.Labels = map[string]string{"traffic.envoy.enabled": "true"}
@@ -39,13 +40,13 @@ func TestDeploymentEnvoyToPod(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 
 	assert.Equal(t, pod1.Annotations["traffic.rs.envoy.enabled"], "true")
 	podWatchlist.Modify(pod1)
 	time.Sleep(time.Second)
 
-	var deploy2 v1beta1.Deployment
+	var deploy2 appsv1.Deployment
 	deploy2.Name = "Comp1"
 	deploy2.Namespace = "test-ns"
 	deploy2.Labels = map[string]string{}
@@ -53,7 +54,7 @@ func TestDeploymentEnvoyToPod(t *testing.T) {
 	deploymentWatchlist.Modify(&deploy2)
 	time.Sleep(time.Second)
 
-	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 
 	assert.Equal(t, pod1.Annotations["traffic.rs.envoy.enabled"], "")
 }
@@ -68,17 +69,17 @@ func TestDeploymentWeightToPod(t *testing.T) {
 	podWatchlist := k8sManager.GetListerWatcher("pods")
 	deploymentWatchlist := k8sManager.GetListerWatcher("deployments")
 	go k8sManager.WatchPods(stopper, k8sManager, annotator)
-	go k8sManager.WatchDeployments(stopper, k8sManager, annotator)
+	go k8sManager.WatchDeployments(stopper, k8sManager)
 
 	var pod corev1.Pod
 	pod.Namespace = "test-ns"
 	pod.Labels = map[string]string{"a": "b", "c": "d"}
 	pod.Status.PodIP = "10.1.1.1"
 	pod.Name = "Comp1-pod"
-	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(&pod)
+	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &pod, metav1.CreateOptions{})
 	podWatchlist.Add(&pod)
 
-	var deploy v1beta1.Deployment
+	var deploy appsv1.Deployment
 	deploy.Name = "Comp1"
 	deploy.Namespace = "test-ns"
 	deploy.Labels = map[string]string{"traffic.endpoint.weight": "50"}
@@ -87,13 +88,13 @@ func TestDeploymentWeightToPod(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 
 	assert.Equal(t, pod1.Annotations["traffic.rs.endpoint.weight"], "50")
 	podWatchlist.Modify(pod1)
 	time.Sleep(time.Second)
 
-	var deploy2 v1beta1.Deployment
+	var deploy2 appsv1.Deployment
 	deploy2.Name = "Comp1"
 	deploy2.Namespace = "test-ns"
 	deploy2.Labels = map[string]string{"traffic.endpoint.weight": "80"}
@@ -101,7 +102,7 @@ func TestDeploymentWeightToPod(t *testing.T) {
 	deploymentWatchlist.Modify(&deploy2)
 	time.Sleep(time.Second)
 
-	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 
 	assert.Equal(t, pod1.Annotations["traffic.rs.endpoint.weight"], "80")
 	podWatchlist.Modify(pod1)
@@ -110,6 +111,92 @@ func TestDeploymentWeightToPod(t *testing.T) {
 	deploymentWatchlist.Delete(&deploy2)
 	time.Sleep(time.Second)
 
-	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 	assert.Equal(t, pod1.Annotations["traffic.rs.endpoint.weight"], "")
 }
+
+func TestDeploymentExpressionsToPod(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	annotator := NewDeploymentToPodAnnotator(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+
+	go k8sManager.WatchPods(stopper, k8sManager, annotator)
+	go k8sManager.WatchDeployments(stopper, k8sManager)
+
+	var pod corev1.Pod
+	pod.Namespace = "test-ns"
+	pod.Labels = map[string]string{"app": "reviews", "version": "v2"}
+	pod.Status.PodIP = "10.1.1.1"
+	pod.Name = "reviews-pod"
+	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &pod, metav1.CreateOptions{})
+	k8sManager.GetListerWatcher("pods").Add(&pod)
+
+	var deploy appsv1.Deployment
+	deploy.Name = "reviews"
+	deploy.Namespace = "test-ns"
+	deploy.Labels = map[string]string{"traffic.endpoint.weight": "30"}
+	deploy.Spec.Selector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+		{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{"reviews"}},
+		{Key: "version", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"v1"}},
+	}}
+	k8sManager.GetListerWatcher("deployments").Add(&deploy)
+	time.Sleep(time.Second)
+
+	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "reviews-pod", metav1.GetOptions{})
+	assert.Equal(t, pod1.Annotations["traffic.rs.endpoint.weight"], "30")
+}
+
+func TestOwnerReferencesToPod(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	annotator := NewDeploymentToPodAnnotator(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+
+	go k8sManager.WatchPods(stopper, k8sManager, annotator)
+	go k8sManager.WatchDeployments(stopper, k8sManager)
+	go k8sManager.WatchReplicaSets(stopper, k8sManager)
+
+	controller := true
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+
+	var deploy appsv1.Deployment
+	deploy.Name = "web"
+	deploy.Namespace = "test-ns"
+	deploy.Labels = map[string]string{"traffic.endpoint.weight": "50", "traffic.envoy.enabled": "true"}
+	deploy.Spec.Selector = selector
+	k8sManager.GetListerWatcher("deployments").Add(&deploy)
+
+	//overlapping selector must not affect pods controlled by another workload
+	var other appsv1.Deployment
+	other.Name = "other"
+	other.Namespace = "test-ns"
+	other.Labels = map[string]string{"traffic.endpoint.weight": "0"}
+	other.Spec.Selector = selector
+	k8sManager.GetListerWatcher("deployments").Add(&other)
+
+	var rs appsv1.ReplicaSet
+	rs.Name = "web-abc"
+	rs.Namespace = "test-ns"
+	rs.Labels = map[string]string{"traffic.endpoint.weight": "20"}
+	rs.OwnerReferences = []metav1.OwnerReference{{Kind: "Deployment", Name: "web", Controller: &controller}}
+	rs.Spec.Selector = selector
+	k8sManager.GetListerWatcher("replicasets").Add(&rs)
+
+	var pod corev1.Pod
+	pod.Namespace = "test-ns"
+	pod.Labels = map[string]string{"app": "web"}
+	pod.Status.PodIP = "10.1.1.1"
+	pod.Name = "web-abc-pod"
+	pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web-abc", Controller: &controller}}
+	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &pod, metav1.CreateOptions{})
+	time.Sleep(time.Second)
+	k8sManager.GetListerWatcher("pods").Add(&pod)
+	time.Sleep(time.Second)
+
+	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "web-abc-pod", metav1.GetOptions{})
+	assert.Equal(t, pod1.Annotations["traffic.rs.endpoint.weight"], "20")
+	assert.Equal(t, pod1.Annotations["traffic.rs.envoy.enabled"], "true")
+}
@@ -1,125 +1,131 @@
 package annotation
 
 import (
+	"fmt"
 	"github.com/golang/glog"
-	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
-	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"k8s.io/apimachinery/pkg/labels"
+	"sort"
+	"strings"
 )
 
+/**
+ * Optionally show config of services selecting a pod in its annotations, e.g. traffic.svc.service1.port.8080=http.
+ * The annotations are for display only, handlers use PodInfo.ServiceConfig joined in memory.
+ */
 type ServiceToPodAnnotator struct {
 	k8sManager *kubernetes.K8sResourceManager
+	//pods to annotate again when this replica becomes leader
+	podMap map[string]*kubernetes.PodInfo
 }
 
 func NewServiceToPodAnnotator(k8sManager *kubernetes.K8sResourceManager) *ServiceToPodAnnotator {
 	return &ServiceToPodAnnotator{
 		k8sManager: k8sManager,
+		podMap:     make(map[string]*kubernetes.PodInfo),
 	}
 }
 
+func podKey(pod *kubernetes.PodInfo) string {
+	return fmt.Sprintf("%s.%s", pod.Name(), pod.Namespace())
+}
+
 func (pa *ServiceToPodAnnotator) PodValid(pod *kubernetes.PodInfo) bool {
 	return pod.Valid()
 }
 
-func (pa *ServiceToPodAnnotator) removeServiceAnnotationToPod(pod *kubernetes.PodInfo, svc *kubernetes.ServiceInfo) {
-	var annotationKeys []string
-	for key, _ := range pod.Annotations {
-		if kubernetes.AnnotationHasServiceLabel(svc.Name(), key) {
-			annotationKeys = append(annotationKeys, key)
+/**
+ * Remove annotations recognized by isManaged from pods in informer cache, except for the ones expected
+ * by the current config. pods are the pods delivered to the annotator, other pods (e.g. host network pods)
+ * should not have such annotations at all.
+ * Should be called with K8sResourceManager locked.
+ */
+func removeOrphanAnnotations(k8sManager *kubernetes.K8sResourceManager, pods map[string]*kubernetes.PodInfo,
+	isManaged func(key string) bool, expected func(pod *kubernetes.PodInfo) map[string]string) {
+	rawPods, err := k8sManager.PodLister().List(labels.Everything())
+	if err != nil {
+		glog.Errorf("Failed to list pods: %s", err.Error())
+		return
+	}
+	for _, rawPod := range rawPods {
+		pod := pods[fmt.Sprintf("%s.%s", rawPod.Name, rawPod.Namespace)]
+		var wanted map[string]string
+		if pod != nil {
+			wanted = expected(pod)
+		}
+		var orphans []string
+		for key, _ := range rawPod.Annotations {
+			if isManaged(key) && wanted[key] == "" {
+				orphans = append(orphans, key)
+			}
+		}
+		//pods without ip are never annotated
+		if info := kubernetes.NewPodInfo(rawPod); info != nil && len(orphans) > 0 {
+			sort.Strings(orphans)
+			k8sManager.QueueRemovePodAnnotation(info, orphans)
 		}
 	}
+}
 
-	if len(annotationKeys) == 0 {
-		return
-	}
+func isServiceAnnotation(key string) bool {
+	return strings.HasPrefix(key, kubernetes.POD_SERVICE_PREFIX)
+}
 
-	err := pa.k8sManager.RemovePodAnnotation(pod, annotationKeys)
-	if err != nil {
-		glog.Errorf("Remove Pod %s Annotations %v failed: %s", pod.Name(), annotationKeys, err.Error())
+//annotations showing current config of services selecting the pod
+func serviceAnnotations(pod *kubernetes.PodInfo) map[string]string {
+	result := make(map[string]string)
+	for service, config := range pod.ServiceConfig {
+		for key, value := range config {
+			if value != "" {
+				result[kubernetes.ServiceLabelToPodAnnotation(service, key)] = value
+			}
+		}
 	}
+	return result
 }
 
-func (pa *ServiceToPodAnnotator) addServiceAnnotationToPod(pod *kubernetes.PodInfo, svc *kubernetes.ServiceInfo) {
+func (pa *ServiceToPodAnnotator) annotate(pod *kubernetes.PodInfo) {
 	annotations := make(map[string]string)
 
 	for key, _ := range pod.Annotations {
-		if kubernetes.AnnotationHasServiceLabel(svc.Name(), key) {
-			//ensure non-exists service annotation being removed
-			//existing service annotation will be overided later
+		if isServiceAnnotation(key) {
+			//ensure annotations of removed services and config being removed
+			//existing ones will be overrided later
 			annotations[key] = ""
 		}
 	}
 
-	for _, port := range svc.Ports {
-		var protocol string
-		if svc.IsIngressHttpPort(port.Port) {
-			protocol = "http"
-		} else {
-			svc_key := kubernetes.ServicePortProtocol(port.Port)
-			protocol = svc.Labels[svc_key]
-		}
-		if protocol != "" {
-			key := kubernetes.PodPortProtcolByService(svc.Name(), port.Port)
-			annotations[key] = protocol
-
-			//used for headless cluster, should create a listener for each podip:targetPort of the cluster
-			key = kubernetes.PodTargetPortProtcolByService(svc.Name(), port.TargetPort)
-			annotations[key] = protocol
-		}
-
-	}
-
-	for key, value := range svc.Labels {
-		if value == "" {
-			continue
-		}
-		if cluster.NeedServiceToPodAnnotation(key) || listener.NeedServiceToPodAnnotation(key) {
-			podKey := kubernetes.ServiceLabelToPodAnnotation(svc.Name(), key)
-			annotations[podKey] = value
-		}
+	for key, value := range serviceAnnotations(pod) {
+		annotations[key] = value
 	}
 
 	if len(annotations) == 0 {
 		return
 	}
-	err := pa.k8sManager.UpdatePodAnnotation(pod, annotations)
-	if err != nil {
-		glog.Infof("Annotate pod %s with %v failed: %s", pod.Name(), annotations, err.Error())
-	}
+	pa.k8sManager.QueuePodAnnotation(pod, annotations)
 }
 
 func (pa *ServiceToPodAnnotator) PodAdded(pod *kubernetes.PodInfo) {
-
-	for _, resource := range pa.k8sManager.GetMatchedResources(pod, kubernetes.SERVICE_TYPE) {
-		svc := resource.(*kubernetes.ServiceInfo)
-		pa.addServiceAnnotationToPod(pod, svc)
-	}
+	pa.podMap[podKey(pod)] = pod
+	pa.annotate(pod)
 }
 
-func (*ServiceToPodAnnotator) PodDeleted(pod *kubernetes.PodInfo) {
-	//ignore
-}
-func (pa *ServiceToPodAnnotator) PodUpdated(oldPod, newPod *kubernetes.PodInfo) {
-	//ignore
+func (pa *ServiceToPodAnnotator) PodDeleted(pod *kubernetes.PodInfo) {
+	delete(pa.podMap, podKey(pod))
 }
 
-func (manager *ServiceToPodAnnotator) ServiceValid(svc *kubernetes.ServiceInfo) bool {
-	return true
+//pods are delivered again when services selecting them change
+func (pa *ServiceToPodAnnotator) PodUpdated(oldPod, newPod *kubernetes.PodInfo) {
+	pa.PodAdded(newPod)
 }
 
-func (pa *ServiceToPodAnnotator) ServiceAdded(svc *kubernetes.ServiceInfo) {
-	for _, resource := range pa.k8sManager.GetMatchedResources(svc, kubernetes.POD_TYPE) {
-		pod := resource.(*kubernetes.PodInfo)
-		pa.addServiceAnnotationToPod(pod, svc)
+/**
+ * Annotations may be skipped or missed while another replica was the leader,
+ * annotations of services which are gone meanwhile are removed.
+ */
+func (pa *ServiceToPodAnnotator) StartedLeading() {
+	removeOrphanAnnotations(pa.k8sManager, pa.podMap, isServiceAnnotation, serviceAnnotations)
+	for _, pod := range pa.podMap {
+		pa.annotate(pod)
 	}
 }
-func (pa *ServiceToPodAnnotator) ServiceDeleted(svc *kubernetes.ServiceInfo) {
-	for _, resource := range pa.k8sManager.GetMatchedResources(svc, kubernetes.POD_TYPE) {
-		pod := resource.(*kubernetes.PodInfo)
-		pa.removeServiceAnnotationToPod(pod, svc)
-	}
-}
-
-func (pa *ServiceToPodAnnotator) ServiceUpdated(oldService, newService *kubernetes.ServiceInfo) {
-	pa.ServiceAdded(newService)
-}
@@ -1,6 +1,7 @@
 package annotation
 
 import (
+	"context"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
@@ -19,14 +20,14 @@ func TestServiceTracingToPod(t *testing.T) {
 	podWatchlist := k8sManager.GetListerWatcher("pods")
 	serviceWatchlist := k8sManager.GetListerWatcher("services")
 	go k8sManager.WatchPods(stopper, k8sManager, annotator)
-	go k8sManager.WatchServices(stopper, k8sManager, annotator)
+	go k8sManager.WatchServices(stopper, k8sManager)
 
 	var pod corev1.Pod
 	pod.Namespace = "test-ns"
 	pod.Labels = map[string]string{"a": "b", "c": "d"}
 	pod.Status.PodIP = "10.1.1.1"
 	pod.Name = "Comp1-pod"
-	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(&pod)
+	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &pod, metav1.CreateOptions{})
 	podWatchlist.Add(&pod)
 
 	var service corev1.Service
@@ -39,7 +40,7 @@ func TestServiceTracingToPod(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 
 	assert.Equal(t, pod1.Annotations["traffic.svc.Service1.rate.limit"], "100")
 	assert.Equal(t, pod1.Annotations["traffic.svc.Service1.tracing.enabled"], "true")
@@ -56,14 +57,14 @@ func TestServiceConfigToPod(t *testing.T) {
 	podWatchlist := k8sManager.GetListerWatcher("pods")
 	serviceWatchlist := k8sManager.GetListerWatcher("services")
 	go k8sManager.WatchPods(stopper, k8sManager, annotator)
-	go k8sManager.WatchServices(stopper, k8sManager, annotator)
+	go k8sManager.WatchServices(stopper, k8sManager)
 
 	var pod corev1.Pod
 	pod.Namespace = "test-ns"
 	pod.Labels = map[string]string{"a": "b", "c": "d"}
 	pod.Status.PodIP = "10.1.1.1"
 	pod.Name = "Comp1-pod"
-	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(&pod)
+	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &pod, metav1.CreateOptions{})
 	podWatchlist.Add(&pod)
 
 	var service corev1.Service
@@ -75,7 +76,7 @@ func TestServiceConfigToPod(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 
 	assert.Equal(t, pod1.Annotations["traffic.svc.Service1.rate.limit"], "100")
 	assert.Equal(t, pod1.Annotations["traffic.svc.Service1.tracing.enabled"], "true")
@@ -86,7 +87,7 @@ func TestServiceConfigToPod(t *testing.T) {
 	serviceWatchlist.Delete(&service)
 
 	time.Sleep(time.Second)
-	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 
 	assert.Equal(t, pod1.Annotations["traffic.svc.Service1.headless"], "")
 	assert.Equal(t, pod1.Annotations["traffic.svc.Service1.rate.limit"], "")
@@ -103,14 +104,14 @@ func TestServiceToPodUpdate(t *testing.T) {
 	podWatchlist := k8sManager.GetListerWatcher("pods")
 	serviceWatchlist := k8sManager.GetListerWatcher("services")
 	go k8sManager.WatchPods(stopper, k8sManager, annotator)
-	go k8sManager.WatchServices(stopper, k8sManager, annotator)
+	go k8sManager.WatchServices(stopper, k8sManager)
 
 	var pod corev1.Pod
 	pod.Namespace = "test-ns"
 	pod.Labels = map[string]string{"a": "b", "c": "d"}
 	pod.Status.PodIP = "10.1.1.1"
 	pod.Name = "Comp1-pod"
-	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(&pod)
+	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &pod, metav1.CreateOptions{})
 	podWatchlist.Add(&pod)
 
 	var service corev1.Service
@@ -122,7 +123,7 @@ func TestServiceToPodUpdate(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 
 	assert.Equal(t, pod1.Annotations["traffic.svc.Service1.tracing.enabled"], "true")
 
@@ -136,8 +137,65 @@ func TestServiceToPodUpdate(t *testing.T) {
 	serviceWatchlist.Modify(&service1)
 
 	time.Sleep(time.Second)
-	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get("Comp1-pod", metav1.GetOptions{})
+	pod1, _ = k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
 
 	assert.Equal(t, pod1.Annotations["traffic.svc.Service1.tracing.enabled"], "")
 
 }
+
+func TestServiceOrphanAnnotations(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	annotator := NewServiceToPodAnnotator(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+
+	podWatchlist := k8sManager.GetListerWatcher("pods")
+	serviceWatchlist := k8sManager.GetListerWatcher("services")
+	go k8sManager.WatchPods(stopper, k8sManager, annotator)
+	go k8sManager.WatchServices(stopper, k8sManager)
+
+	//annotations written by the previous leader for services which are gone
+	var pod corev1.Pod
+	pod.Namespace = "test-ns"
+	pod.Labels = map[string]string{"c": "d"}
+	pod.Annotations = map[string]string{"traffic.svc.Service2.port.8080": "http", "traffic.svc.Service3.rate.limit": ""}
+	pod.Status.PodIP = "10.1.1.1"
+	pod.Name = "Comp1-pod"
+	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &pod, metav1.CreateOptions{})
+	podWatchlist.Add(&pod)
+
+	//pods not delivered to the annotator are reconciled too
+	var hostPod corev1.Pod
+	hostPod.Namespace = "test-ns"
+	hostPod.Annotations = map[string]string{"traffic.svc.Service2.port.8080": "http", "other": "value"}
+	hostPod.Spec.HostNetwork = true
+	hostPod.Status.PodIP = "10.1.0.1"
+	hostPod.Name = "Comp2-pod"
+	k8sManager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &hostPod, metav1.CreateOptions{})
+	podWatchlist.Add(&hostPod)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Labels = map[string]string{"traffic.port.8080": "http"}
+	service.Spec.Selector = map[string]string{"c": "d"}
+	service.Name = "Service1"
+	service.Spec.Ports = []corev1.ServicePort{{Name: "test", Port: 8080}}
+	serviceWatchlist.Add(&service)
+
+	time.Sleep(time.Second)
+
+	k8sManager.Lock()
+	annotator.StartedLeading()
+	k8sManager.Unlock()
+
+	time.Sleep(time.Second)
+
+	pod1, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp1-pod", metav1.GetOptions{})
+	assert.Equal(t, pod1.Annotations, map[string]string{
+		"traffic.svc.Service1.port.8080":        "http",
+		"traffic.svc.Service1.target.port.8080": "http",
+	})
+	pod2, _ := k8sManager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "Comp2-pod", metav1.GetOptions{})
+	assert.Equal(t, pod2.Annotations, map[string]string{"other": "value"})
+}
@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	SERVICE_ACCOUNT_TOKEN_FILE = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+	//time to wait for traffic-control to sign client certificate
+	CERTIFICATE_REQUEST_TIMEOUT = 30 * time.Second
+)
+
+/**
+ * Request client certificates of envoy from the certificate signer of traffic-control,
+ * authenticated by the service account token of envoy-manager.
+ */
+type CertificateClient struct {
+	client *http.Client
+	url    string
+}
+
+//rootCert is the pem root certificate, used to verify the signer service
+func NewCertificateClient(rootCert []byte) (*CertificateClient, error) {
+	service := os.Getenv("CONTROL_PLANE_SERVICE")
+	port := os.Getenv("CONTROL_PLANE_SIGNER_PORT")
+	if service == "" || port == "" {
+		return nil, fmt.Errorf("Missing env CONTROL_PLANE_SERVICE or CONTROL_PLANE_SIGNER_PORT")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(rootCert) {
+		return nil, fmt.Errorf("Invalid root certificate pem")
+	}
+	return &CertificateClient{
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+			},
+		},
+		url: fmt.Sprintf("https://%s.%s.svc:%s%s", service, common.ControlPlaneNamespace(), port, common.SIGN_NODE_PATH),
+	}, nil
+}
+
+func (c *CertificateClient) sign(request []byte) ([]byte, error) {
+	token, err := ioutil.ReadFile(SERVICE_ACCOUNT_TOKEN_FILE)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	httpRequest.Header.Set("Authorization", "Bearer "+string(bytes.TrimSpace(token)))
+	response, err := c.client.Do(httpRequest)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", response.Status, string(bytes.TrimSpace(body)))
+	}
+	return body, nil
+}
+
+/**
+ * Return the pem certificate chain of the certificate request, see common.SecretManager.SignNodeRequest.
+ * Retry until CERTIFICATE_REQUEST_TIMEOUT since the pod may not be delivered to traffic-control yet.
+ */
+func (c *CertificateClient) SignNodeRequest(request []byte) ([]byte, error) {
+	var chain []byte
+	var lastErr error
+	err := wait.PollImmediate(time.Second, CERTIFICATE_REQUEST_TIMEOUT, func() (bool, error) {
+		chain, lastErr = c.sign(request)
+		if lastErr != nil {
+			glog.Warningf("Certificate request failed, retry later: %s", lastErr.Error())
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil && lastErr != nil {
+		return nil, lastErr
+	}
+	return chain, err
+}
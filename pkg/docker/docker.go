@@ -1,6 +1,8 @@
 package docker
 
 import (
+	"archive/tar"
+	"bytes"
 	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -19,6 +21,9 @@ const (
 	DOCKER_LABEL_NAMESPACE = "traffic.envoy.namespace"
 	DOCKER_LABEL_POD       = "traffic.envoy.pod"
 	DOCKER_LABEL_PROXY     = "traffic.envoy.proxy"
+
+	//directory of envoy container holding certificates to connect control plane
+	ENVOY_TLS_DIR = "/etc/envoy/tls"
 )
 
 type DockerInstanceInfo struct {
@@ -115,7 +120,36 @@ func (client *DockerClient) ListDockerInstances(name string) ([]*DockerInstanceI
 func (client *DockerClient) GetName(podInfo *kubernetes.PodInfo) string {
 	return fmt.Sprintf("envoy_%s_%s", podInfo.Name(), podInfo.Namespace())
 }
-func (client *DockerClient) CreateDockerInstance(podInfo *kubernetes.PodInfo) (string, error) {
+func tarFiles(dir string, files map[string][]byte) (io.Reader, error) {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+	err := writer.WriteHeader(&tar.Header{Name: dir[1:] + "/", Mode: 0755, Typeflag: tar.TypeDir})
+	if err != nil {
+		return nil, err
+	}
+	for name, content := range files {
+		err = writer.WriteHeader(&tar.Header{
+			Name: fmt.Sprintf("%s/%s", dir[1:], name),
+			Mode: 0444,
+			Size: int64(len(content)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		_, err = writer.Write(content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+//tlsFiles are copied to ENVOY_TLS_DIR of the envoy container before it starts
+func (client *DockerClient) CreateDockerInstance(podInfo *kubernetes.PodInfo, tlsFiles map[string][]byte) (string, error) {
 	ctx := context.Background()
 	var pauseDocker string
 
@@ -154,6 +188,7 @@ func (client *DockerClient) CreateDockerInstance(podInfo *kubernetes.PodInfo) (s
 		////used for envoy's --service-cluster option
 		fmt.Sprintf("SERVICE_CLUSTER=%s.%s", podInfo.Name(), podInfo.Namespace()),
 		fmt.Sprintf("NODE_ID=%s.%s", podInfo.Name(), podInfo.Namespace()),
+		fmt.Sprintf("CONTROL_PLANE_TLS_DIR=%s", ENVOY_TLS_DIR),
 	}
 
 	proxy_config := &container.Config{
@@ -177,7 +212,13 @@ func (client *DockerClient) CreateDockerInstance(podInfo *kubernetes.PodInfo) (s
 		return "", err
 	}
 	glog.Infof("Create proxy docker %s for pod %s, env=%v, network:%s", resp.ID, podInfo.Name(), env, pauseDocker)
-	err = client.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	tlsContent, err := tarFiles(ENVOY_TLS_DIR, tlsFiles)
+	if err == nil {
+		err = client.client.CopyToContainer(ctx, resp.ID, "/", tlsContent, types.CopyToContainerOptions{})
+	}
+	if err == nil {
+		err = client.client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	}
 	if err != nil {
 		glog.Warningf("Removing proxy docker %s for start failure", resp.ID)
 		removeErr := client.client.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{})
@@ -225,6 +266,13 @@ func (client *DockerClient) GetDockerInstanceLog(dockerId string) (io.ReadCloser
 		ShowStderr: true,
 	})
 }
+
+//Check whether docker daemon is reachable
+func (client *DockerClient) Ping() error {
+	_, err := client.client.Ping(context.Background())
+	return err
+}
+
 func (client *DockerClient) IsDockerInstanceRunning(dockerId string) bool {
 	ctx := context.Background()
 	containerJson, err := client.client.ContainerInspect(ctx, dockerId)
@@ -2,6 +2,7 @@ package docker
 
 import (
 	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 
 	"os"
@@ -9,29 +10,52 @@ import (
 )
 
 const (
-	TRAFFIC_INGRESS_PROXY = "ingress"
+	TRAFFIC_INGRESS_PROXY = kubernetes.ENVOY_PROXY_INGRESS
 )
 
 type EnvoyManager struct {
-	dockerClient *DockerClient
-	k8sManager   *kubernetes.K8sResourceManager
-	envoyMutex   *sync.RWMutex
-	myHostIp     string
+	dockerClient      *DockerClient
+	k8sManager        *kubernetes.K8sResourceManager
+	certificateClient *CertificateClient
+	envoyMutex        *sync.RWMutex
+	myHostIp          string
 }
 
-func NewEnvoyManager(k8sManager *kubernetes.K8sResourceManager) (*EnvoyManager, error) {
+func NewEnvoyManager(k8sManager *kubernetes.K8sResourceManager, certificateClient *CertificateClient) (*EnvoyManager, error) {
 	dockerClient, err := NewDockerClient()
 	if err != nil {
 		return nil, err
 	}
 	return &EnvoyManager{
-		dockerClient: dockerClient,
-		k8sManager:   k8sManager,
-		envoyMutex:   &sync.RWMutex{},
+		dockerClient:      dockerClient,
+		k8sManager:        k8sManager,
+		certificateClient: certificateClient,
+		envoyMutex:        &sync.RWMutex{},
 
 		myHostIp: os.Getenv("MY_HOST_IP"),
 	}, nil
 }
+
+//Readiness check of envoy-manager, envoy can not be started if docker daemon is unreachable
+func (manager *EnvoyManager) CheckDocker() error {
+	return manager.dockerClient.Ping()
+}
+
+/**
+ * Client certificate files for envoy of the pod to connect control plane.
+ * The private key is generated here, the certificate is signed by traffic-control.
+ */
+func (manager *EnvoyManager) tlsFiles(podInfo *kubernetes.PodInfo) (map[string][]byte, error) {
+	request, key, err := common.GenerateNodeRequest(podInfo.NodeId())
+	if err != nil {
+		return nil, err
+	}
+	chain, err := manager.certificateClient.SignNodeRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	return common.NodeTLSFiles(chain, key)
+}
 func (manager *EnvoyManager) CheckExistingEnvoy() {
 	instances, err := manager.dockerClient.ListDockerInstances("")
 	if err != nil {
@@ -114,7 +138,12 @@ func (manager *EnvoyManager) checkEnvoy(podInfo *kubernetes.PodInfo) {
 		}
 
 		if envoyEnabled {
-			dockerId, err := manager.dockerClient.CreateDockerInstance(podInfo)
+			tlsFiles, err := manager.tlsFiles(podInfo)
+			if err != nil {
+				glog.Errorf("Generate certificate for %s failed: %s", podInfo.Name(), err.Error())
+				return
+			}
+			dockerId, err := manager.dockerClient.CreateDockerInstance(podInfo, tlsFiles)
 			if err != nil {
 				glog.Errorf("Create docker instances for %s failed: %s", podInfo.Name(), err.Error())
 				return
@@ -1,8 +1,10 @@
 package envoy
 
 import (
+	"context"
 	"fmt"
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v2"
 	"github.com/golang/glog"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
@@ -10,7 +12,12 @@ import (
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/endpoint"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener/ingress"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/metrics"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,85 +31,423 @@ type AggregatedDiscoveryService struct {
 	eds  *endpoint.EndpointsControlPlaneService
 	lds  *listener.ListenersControlPlaneService
 	ilds *ingress.IngressListenersControlPlaneService
+	rds  *listener.RoutesControlPlaneService
+	irds *ingress.IngressRoutesControlPlaneService
 	sds  *SecretsControlPlaneService
+
+	verifier *NodeIdentityVerifier
+
+	streamMutex *sync.Mutex
+	streams     map[int64]*streamInfo
+	lastStream  int64
+	//new streams are rejected once draining
+	draining bool
+
+	//closed when all informers are synced, no response is sent before that
+	synced   chan struct{}
+	syncOnce *sync.Once
+}
+
+//connected stream, used by debug server
+type streamInfo struct {
+	id        int64
+	nodeId    string
+	delta     bool
+	connected time.Time
+	acks      map[string]*common.AckState
+	//closed to ask envoy to reconnect to another control plane
+	drain chan struct{}
 }
 
 func NewAggregatedDiscoveryService(cds *cluster.ClustersControlPlaneService,
 	eds *endpoint.EndpointsControlPlaneService,
 	lds *listener.ListenersControlPlaneService,
 	ilds *ingress.IngressListenersControlPlaneService,
-	sds *SecretsControlPlaneService) *AggregatedDiscoveryService {
+	rds *listener.RoutesControlPlaneService,
+	irds *ingress.IngressRoutesControlPlaneService,
+	sds *SecretsControlPlaneService,
+	verifier *NodeIdentityVerifier) *AggregatedDiscoveryService {
 	return &AggregatedDiscoveryService{
-		cds: cds, eds: eds, lds: lds, ilds: ilds, sds: sds,
+		cds: cds, eds: eds, lds: lds, ilds: ilds, rds: rds, irds: irds, sds: sds,
+		verifier: verifier,
+
+		streamMutex: &sync.Mutex{},
+		streams:     make(map[int64]*streamInfo),
+
+		synced:   make(chan struct{}),
+		syncOnce: &sync.Once{},
+	}
+}
+
+//Start sending responses, resources are complete once informers are synced
+func (ads *AggregatedDiscoveryService) SetSynced() {
+	ads.syncOnce.Do(func() {
+		close(ads.synced)
+	})
+}
+
+func (ads *AggregatedDiscoveryService) IsSynced() bool {
+	select {
+	case <-ads.synced:
+		return true
+	default:
+		return false
+	}
+}
+
+func (ads *AggregatedDiscoveryService) addStream(delta bool) *streamInfo {
+	ads.streamMutex.Lock()
+	defer ads.streamMutex.Unlock()
+	ads.lastStream++
+	info := &streamInfo{
+		id:        ads.lastStream,
+		delta:     delta,
+		connected: time.Now(),
+		acks:      make(map[string]*common.AckState),
+		drain:     make(chan struct{}),
+	}
+	if ads.draining {
+		close(info.drain)
+	}
+	ads.streams[info.id] = info
+	return info
+}
+
+/**
+ * Close all streams with Unavailable status so that envoy reconnects to another control plane replica.
+ * Streams are closed evenly over period, so that remaining replicas are not flooded by reconnections.
+ */
+func (ads *AggregatedDiscoveryService) Drain(period time.Duration) {
+	ads.streamMutex.Lock()
+	ads.draining = true
+	var streams []*streamInfo
+	for _, info := range ads.streams {
+		streams = append(streams, info)
+	}
+	ads.streamMutex.Unlock()
+
+	glog.Infof("Draining %d ads streams in %s", len(streams), period.String())
+	for _, info := range streams {
+		close(info.drain)
+		time.Sleep(period / time.Duration(len(streams)))
 	}
 }
-func (ads *AggregatedDiscoveryService) processRequest(req *envoy_api_v2.DiscoveryRequest) (*envoy_api_v2.DiscoveryResponse, error) {
-	switch req.TypeUrl {
+
+var errDraining = status.Error(codes.Unavailable, "control plane is shutting down")
+
+//return nil if envoy closed the stream
+func recvError(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	glog.Error(err.Error())
+	return err
+}
+
+func (ads *AggregatedDiscoveryService) removeStream(info *streamInfo) {
+	ads.streamMutex.Lock()
+	defer ads.streamMutex.Unlock()
+	delete(ads.streams, info.id)
+}
+
+func (ads *AggregatedDiscoveryService) updateStream(info *streamInfo, nodeId string, ack *common.AckState) {
+	ads.streamMutex.Lock()
+	defer ads.streamMutex.Unlock()
+	info.nodeId = nodeId
+	if ack != nil {
+		info.acks[ack.TypeUrl] = ack
+	}
+}
+
+//node id of a stream, verified on the first request and can not be changed later
+type streamNode struct {
+	id string
+	//false if the peer has no client certificate and is verified by its address in permissive mode
+	certified bool
+}
+
+//secrets are only sent to peers verified by client certificate
+func (ads *AggregatedDiscoveryService) checkNode(ctx context.Context, node *streamNode, nodeId string, typeUrl string) error {
+	if node.id != "" {
+		if node.id != nodeId {
+			return status.Errorf(codes.PermissionDenied, "Node id changed from %s to %s", node.id, nodeId)
+		}
+	} else {
+		certified := true
+		if ads.verifier != nil {
+			var err error
+			certified, err = ads.verifier.VerifyNode(ctx, nodeId)
+			if err != nil {
+				return status.Errorf(codes.PermissionDenied, "Node verification failed: %s", err.Error())
+			}
+		}
+		node.id = nodeId
+		node.certified = certified
+	}
+	if typeUrl == common.SecretResource && !node.certified {
+		return status.Errorf(codes.PermissionDenied, "Secrets are not served to node %s without client certificate", nodeId)
+	}
+	return nil
+}
+
+func (ads *AggregatedDiscoveryService) getService(typeUrl string, node *core.Node) (*common.ControlPlaneService, common.ResponseBuilder, error) {
+	switch typeUrl {
 	case common.EndpointResource:
-		return ads.eds.ProcessRequest(req, ads.eds.BuildResource)
+		return ads.eds.ControlPlaneService, ads.eds.BuildResource, nil
 	case common.ClusterResource:
-		return ads.cds.ProcessRequest(req, ads.cds.BuildResource)
+		return ads.cds.ControlPlaneService, ads.cds.BuildResource, nil
 	case common.ListenerResource:
-		//always request all resources
-		req.ResourceNames = nil
-		if req.Node.Id == IngressNodeId {
-			return ads.ilds.ProcessRequest(req, ads.ilds.BuildResource)
+		if node.Id == IngressNodeId {
+			return ads.ilds.ControlPlaneService, ads.ilds.BuildResource, nil
+		} else {
+			return ads.lds.ControlPlaneService, ads.lds.BuildResource, nil
+		}
+	case common.RouteResource:
+		if node.Id == IngressNodeId {
+			return ads.irds.ControlPlaneService, ads.irds.BuildResource, nil
 		} else {
-			return ads.lds.ProcessRequest(req, ads.lds.BuildResource)
+			return ads.rds.ControlPlaneService, ads.rds.BuildResource, nil
 		}
-	//case common.RouteResource:
 	case common.SecretResource:
-		return ads.sds.ProcessRequest(req, ads.sds.BuildResource)
+		return ads.sds.ControlPlaneService, ads.sds.BuildResource, nil
 	default:
-		return nil, fmt.Errorf("Unsupported TypeUrl" + req.TypeUrl)
+		return nil, nil, fmt.Errorf("Unsupported TypeUrl" + typeUrl)
 	}
+}
+
+//Send responses of one type to the queue until the stream is closed
+func (ads *AggregatedDiscoveryService) watch(queue *responseQueue, cps *common.ControlPlaneService, builder common.ResponseBuilder, state *common.WatchState) {
+	//response built from half populated resources could remove most config of envoy
+	<-ads.synced
+	for {
+		resp, err := cps.WaitResponse(state, builder)
+		if err != nil {
+			glog.Errorf("Failed to process %s:%s", state.String(), err.Error())
+			continue
+		}
+		if resp == nil || !queue.Push(state.TypeUrl, resp) {
+			//stream closed
+			return
+		}
+	}
+}
+
+func (ads *AggregatedDiscoveryService) watchDelta(queue *responseQueue, cps *common.ControlPlaneService, builder common.ResponseBuilder, state *common.DeltaStreamState) {
+	<-ads.synced
+	for {
+		resp, err := cps.WaitDeltaResponse(state, builder)
+		if err != nil {
+			glog.Errorf("Failed to process delta %s:%s", state.String(), err.Error())
+			continue
+		}
+		if resp == nil || !queue.Push(state.TypeUrl, resp) {
+			//stream closed
+			return
+		}
+	}
+}
 
+type adsStream interface {
+	Send(*envoy_api_v2.DiscoveryResponse) error
+	Recv() (*envoy_api_v2.DiscoveryRequest, error)
+	Context() context.Context
 }
+
+type deltaAdsStream interface {
+	Send(*envoy_api_v2.DeltaDiscoveryResponse) error
+	Recv() (*envoy_api_v2.DeltaDiscoveryRequest, error)
+	Context() context.Context
+}
+
 func (ads *AggregatedDiscoveryService) StreamAggregatedResources(stream discovery.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
-	requestCh := make(chan *envoy_api_v2.DiscoveryRequest)
+	return ads.processStream(stream)
+}
+
+func (ads *AggregatedDiscoveryService) DeltaAggregatedResources(stream discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	return ads.processDeltaStream(stream)
+}
+
+func (ads *AggregatedDiscoveryService) processStream(stream adsStream) error {
+	queue := newResponseQueue()
+	states := make(map[string]*common.WatchState)
+	services := make(map[string]*common.ControlPlaneService)
+	var node streamNode
+	info := ads.addStream(false)
+
+	defer func() {
+		ads.removeStream(info)
+		queue.Close()
+		for typeUrl, state := range states {
+			services[typeUrl].CloseWatch(state)
+			metrics.AdsStreams.WithLabelValues(typeUrl).Dec()
+		}
+	}()
+
+	go func() {
+		err := queue.Run(func(resp interface{}) error {
+			response := resp.(*envoy_api_v2.DiscoveryResponse)
+			if glog.V(2) {
+				glog.Infof("Send %s, version=%s, nonce=%s", response.TypeUrl, response.VersionInfo, response.Nonce)
+			}
+			return stream.Send(response)
+		})
+		if err != nil {
+			glog.Errorf("Failed to send response: %s", err.Error())
+		}
+	}()
+
+	//receive in another goroutine, so that the stream can be closed on drain
+	requests := make(chan *envoy_api_v2.DiscoveryRequest)
+	errs := make(chan error, 1)
 	go func() {
 		for {
 			req, err := stream.Recv()
 			if err != nil {
-				glog.Error(err.Error())
-				requestCh <- nil
+				errs <- err
 				return
 			}
-			if req.Node == nil || req.Node.Id == "" {
-				err := fmt.Errorf("Missing node id info, type=%s, resource=%s", req.TypeUrl, strings.Join(req.ResourceNames, ","))
+			select {
+			case requests <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		var req *envoy_api_v2.DiscoveryRequest
+		select {
+		case <-info.drain:
+			return errDraining
+		case err := <-errs:
+			return recvError(err)
+		case req = <-requests:
+		}
+		if req.Node == nil || req.Node.Id == "" {
+			err := fmt.Errorf("Missing node id info, type=%s, resource=%s", req.TypeUrl, strings.Join(req.ResourceNames, ","))
+			glog.Error(err.Error())
+			continue
+		}
+		err := ads.checkNode(stream.Context(), &node, req.Node.Id, req.TypeUrl)
+		if err != nil {
+			glog.Error(err.Error())
+			return err
+		}
+		if glog.V(2) {
+			glog.Infof("Request recevied: type=%s, nonce=%s, version=%s, resource=%s, node=%s",
+				req.TypeUrl, req.GetResponseNonce(), req.VersionInfo, strings.Join(req.ResourceNames, ","), req.Node.Id)
+		}
+		if req.TypeUrl == common.ListenerResource {
+			//always request all resources
+			req.ResourceNames = nil
+		}
+
+		state := states[req.TypeUrl]
+		if state == nil {
+			cps, builder, err := ads.getService(req.TypeUrl, req.Node)
+			if err != nil {
 				glog.Error(err.Error())
 				continue
 			}
+			state = cps.NewWatchState(req)
+			states[req.TypeUrl] = state
+			ads.updateStream(info, node.id, state.Ack())
+			metrics.AdsStreams.WithLabelValues(req.TypeUrl).Inc()
+			services[req.TypeUrl] = cps
+			go ads.watch(queue, cps, builder, state)
+		}
+		services[req.TypeUrl].ProcessRequest(state, req)
+	}
+}
+
+func (ads *AggregatedDiscoveryService) processDeltaStream(stream deltaAdsStream) error {
+	queue := newResponseQueue()
+	states := make(map[string]*common.DeltaStreamState)
+	services := make(map[string]*common.ControlPlaneService)
+	var node streamNode
+	info := ads.addStream(true)
 
-			requestCh <- req
+	defer func() {
+		ads.removeStream(info)
+		queue.Close()
+		for typeUrl, state := range states {
+			services[typeUrl].CloseDeltaStream(state)
+			metrics.AdsStreams.WithLabelValues(typeUrl).Dec()
 		}
 	}()
 
-	for _ = range time.Tick(time.Millisecond * 100) {
-		req := <-requestCh
-		if req == nil {
-			break
-		}
-		go func(req *envoy_api_v2.DiscoveryRequest) {
+	go func() {
+		err := queue.Run(func(resp interface{}) error {
+			response := resp.(*envoy_api_v2.DeltaDiscoveryResponse)
 			if glog.V(2) {
-				glog.Infof("Request recevied: type=%s, nonce=%s, version=%s, resource=%s, node=%s",
-					req.TypeUrl, req.GetResponseNonce(), req.VersionInfo, strings.Join(req.ResourceNames, ","), req.Node.Id)
+				glog.Infof("Send delta %s, changed=%d, removed=%d, nonce=%s", response.TypeUrl, len(response.Resources), len(response.RemovedResources), response.Nonce)
 			}
-			resp, err := ads.processRequest(req)
+			return stream.Send(response)
+		})
+		if err != nil {
+			glog.Errorf("Failed to send delta response: %s", err.Error())
+		}
+	}()
+
+	requests := make(chan *envoy_api_v2.DeltaDiscoveryRequest)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
 			if err != nil {
-				glog.Errorf("Failed to process %s, version=%s:%s", req.TypeUrl, resp.VersionInfo, err.Error())
-			} else {
-				if glog.V(2) {
-					glog.Infof("Send %s, version=%s", req.TypeUrl, resp.VersionInfo)
-				}
-				stream.Send(resp)
+				errs <- err
+				return
 			}
+			select {
+			case requests <- req:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
 
-		}(req)
-	}
-	return nil
-}
+	for {
+		var req *envoy_api_v2.DeltaDiscoveryRequest
+		select {
+		case <-info.drain:
+			return errDraining
+		case err := <-errs:
+			return recvError(err)
+		case req = <-requests:
+		}
+		if req.Node == nil || req.Node.Id == "" {
+			err := fmt.Errorf("Missing node id info, type=%s, resource=%s", req.TypeUrl, strings.Join(req.ResourceNamesSubscribe, ","))
+			glog.Error(err.Error())
+			continue
+		}
+		err := ads.checkNode(stream.Context(), &node, req.Node.Id, req.TypeUrl)
+		if err != nil {
+			glog.Error(err.Error())
+			return err
+		}
+		if glog.V(2) {
+			glog.Infof("Delta request recevied: type=%s, nonce=%s, subscribe=%s, unsubscribe=%s, node=%s",
+				req.TypeUrl, req.ResponseNonce, strings.Join(req.ResourceNamesSubscribe, ","),
+				strings.Join(req.ResourceNamesUnsubscribe, ","), req.Node.Id)
+		}
 
-func (ads *AggregatedDiscoveryService) DeltaAggregatedResources(discovery.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
-	return nil
+		state := states[req.TypeUrl]
+		if state == nil {
+			cps, builder, err := ads.getService(req.TypeUrl, req.Node)
+			if err != nil {
+				glog.Error(err.Error())
+				continue
+			}
+			//listener is always requested as a whole, cds is wildcard if nothing is subscribed in first request
+			wildcard := req.TypeUrl == common.ListenerResource ||
+				(req.TypeUrl == common.ClusterResource && len(req.ResourceNamesSubscribe) == 0)
+			state = cps.NewDeltaStreamState(req, wildcard)
+			states[req.TypeUrl] = state
+			ads.updateStream(info, node.id, state.Ack())
+			metrics.AdsStreams.WithLabelValues(req.TypeUrl).Inc()
+			services[req.TypeUrl] = cps
+			go ads.watchDelta(queue, cps, builder, state)
+		}
+		services[req.TypeUrl].ProcessDeltaRequest(state, req)
+	}
 }
@@ -0,0 +1,76 @@
+package envoy
+
+import (
+	"context"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+)
+
+/**
+ * Envoy v3 xds api, requests and responses are converted so that
+ * v2 and v3 nodes are served by same AggregatedDiscoveryService.
+ */
+type AggregatedDiscoveryServiceV3 struct {
+	ads *AggregatedDiscoveryService
+}
+
+func NewAggregatedDiscoveryServiceV3(ads *AggregatedDiscoveryService) *AggregatedDiscoveryServiceV3 {
+	return &AggregatedDiscoveryServiceV3{ads: ads}
+}
+
+func (ads *AggregatedDiscoveryServiceV3) StreamAggregatedResources(stream discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesServer) error {
+	return ads.ads.processStream(&adsStreamV3{stream: stream})
+}
+
+func (ads *AggregatedDiscoveryServiceV3) DeltaAggregatedResources(stream discoveryv3.AggregatedDiscoveryService_DeltaAggregatedResourcesServer) error {
+	return ads.ads.processDeltaStream(&deltaAdsStreamV3{stream: stream})
+}
+
+type adsStreamV3 struct {
+	stream discoveryv3.AggregatedDiscoveryService_StreamAggregatedResourcesServer
+}
+
+func (s *adsStreamV3) Send(resp *envoy_api_v2.DiscoveryResponse) error {
+	respV3, err := common.ResponseToV3(resp)
+	if err != nil {
+		return err
+	}
+	return s.stream.Send(respV3)
+}
+
+func (s *adsStreamV3) Context() context.Context {
+	return s.stream.Context()
+}
+
+func (s *adsStreamV3) Recv() (*envoy_api_v2.DiscoveryRequest, error) {
+	req, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return common.RequestToV2(req)
+}
+
+type deltaAdsStreamV3 struct {
+	stream discoveryv3.AggregatedDiscoveryService_DeltaAggregatedResourcesServer
+}
+
+func (s *deltaAdsStreamV3) Send(resp *envoy_api_v2.DeltaDiscoveryResponse) error {
+	respV3, err := common.DeltaResponseToV3(resp)
+	if err != nil {
+		return err
+	}
+	return s.stream.Send(respV3)
+}
+
+func (s *deltaAdsStreamV3) Context() context.Context {
+	return s.stream.Context()
+}
+
+func (s *deltaAdsStreamV3) Recv() (*envoy_api_v2.DeltaDiscoveryRequest, error) {
+	req, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	return common.DeltaRequestToV2(req)
+}
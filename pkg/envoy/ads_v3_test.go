@@ -0,0 +1,117 @@
+package envoy
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	faultv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestResourcesV3(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	os.Setenv("ENVOY_PROXY_PORT", "10000")
+	lds := listener.NewListenersControlPlaneService(k8sManager)
+	cds := cluster.NewClustersControlPlaneService(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+	go k8sManager.WatchPods(stopper, k8sManager, lds, cds)
+	go k8sManager.WatchServices(stopper, k8sManager, lds, cds)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "Service1"
+	service.Labels = map[string]string{"traffic.port.8080": "http", "traffic.port.9090": "tcp", "traffic.fault.abort.percentage": "10"}
+	service.Spec.Selector = map[string]string{"c": "d"}
+	service.Spec.Ports = []corev1.ServicePort{
+		{Name: "http", Port: 8080, TargetPort: intstr.FromInt(8080)},
+		{Name: "tcp", Port: 9090, TargetPort: intstr.FromInt(9090)},
+	}
+	k8sManager.GetListerWatcher("services").Add(&service)
+
+	var pod corev1.Pod
+	pod.Namespace = "test-ns"
+	pod.Name = "Comp1-pod"
+	pod.Labels = map[string]string{"traffic.envoy.enabled": "true", "c": "d"}
+	pod.Status.PodIP = "10.1.1.1"
+	k8sManager.GetListerWatcher("pods").Add(&pod)
+
+	time.Sleep(time.Second)
+
+	node := &core.Node{Id: "Comp1-pod.test-ns"}
+	k8sManager.Lock()
+	resources, _ := lds.GetResources(nil)
+	resp, err := lds.BuildResource(resources, "1", node)
+	k8sManager.Unlock()
+	assert.Nil(t, err)
+	respV3, err := common.ResponseToV3(resp)
+	assert.Nil(t, err)
+
+	l := &listenerv3.Listener{}
+	assert.Nil(t, ptypes.UnmarshalAny(respV3.Resources[0], l))
+	assert.Equal(t, l.ListenerFilters[0].Name, "envoy.filters.listener.original_dst")
+	assert.NotNil(t, l.ListenerFilters[0].GetTypedConfig())
+
+	filters := make(map[string]int)
+	for _, filterChain := range l.FilterChains {
+		for _, filter := range filterChain.Filters {
+			filters[filter.Name]++
+			switch filter.Name {
+			case "envoy.filters.network.http_connection_manager":
+				manager := &hcmv3.HttpConnectionManager{}
+				assert.Nil(t, ptypes.UnmarshalAny(filter.GetTypedConfig(), manager))
+				for _, httpFilter := range manager.HttpFilters {
+					filters[httpFilter.Name]++
+					assert.NotNil(t, httpFilter.GetTypedConfig())
+					if httpFilter.Name == "envoy.filters.http.fault" {
+						fault := &faultv3.HTTPFault{}
+						assert.Nil(t, ptypes.UnmarshalAny(httpFilter.GetTypedConfig(), fault))
+						assert.Equal(t, fault.Abort.GetHttpStatus(), uint32(503))
+					}
+				}
+			case "envoy.filters.network.tcp_proxy":
+				assert.Nil(t, ptypes.UnmarshalAny(filter.GetTypedConfig(), &tcpv3.TcpProxy{}))
+			default:
+				t.Errorf("Unexpected v3 filter %s", filter.Name)
+			}
+		}
+	}
+	assert.True(t, filters["envoy.filters.network.http_connection_manager"] > 0)
+	assert.True(t, filters["envoy.filters.network.tcp_proxy"] > 0)
+	assert.True(t, filters["envoy.filters.http.router"] > 0)
+	assert.Equal(t, filters["envoy.filters.http.fault"], 1)
+
+	k8sManager.Lock()
+	resources, _ = cds.GetResources(nil)
+	resp, err = cds.BuildResource(resources, "1", node)
+	k8sManager.Unlock()
+	assert.Nil(t, err)
+	respV3, err = common.ResponseToV3(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, respV3.TypeUrl, "type.googleapis.com/envoy.config.cluster.v3.Cluster")
+
+	edsClusters := 0
+	for _, resource := range respV3.Resources {
+		c := &clusterv3.Cluster{}
+		assert.Nil(t, ptypes.UnmarshalAny(resource, c))
+		if c.EdsClusterConfig != nil {
+			edsClusters++
+			assert.Equal(t, c.EdsClusterConfig.EdsConfig.ResourceApiVersion, corev3.ApiVersion_V3)
+		}
+	}
+	assert.True(t, edsClusters > 0)
+}
@@ -19,7 +19,9 @@ type ClustersControlPlaneService struct {
 }
 
 func NewClustersControlPlaneService(k8sManager *kubernetes.K8sResourceManager) *ClustersControlPlaneService {
-	return &ClustersControlPlaneService{ControlPlaneService: common.NewControlPlaneService(k8sManager)}
+	result := &ClustersControlPlaneService{ControlPlaneService: common.NewControlPlaneService(k8sManager)}
+	result.SetMetricName("clusters")
+	return result
 }
 
 func (cps *ClustersControlPlaneService) BuildResource(resourceMap map[string]common.EnvoyResource, version string, node *core.Node) (*envoy_api_v2.DiscoveryResponse, error) {
@@ -55,12 +57,12 @@ func (cps *ClustersControlPlaneService) ServiceUpdated(oldService, newService *k
 			protocol := newService.Protocol(port.Port)
 			if protocol == kubernetes.PROTO_DIRECT {
 				cluster := NewByPassClusterInfo(newService, port.Port)
-				cluster.Config(newService.Labels)
+				cluster.Config(newService.ConfigLabels())
 				visited[cluster.Name()] = true
 				cps.UpdateResource(cluster, newService.ResourceVersion)
 			} else if protocol >= 0 {
 				cluster := NewServiceClusterInfo(newService, port.Port)
-				cluster.Config(newService.Labels)
+				cluster.Config(newService.ConfigLabels())
 				visited[cluster.Name()] = true
 				cps.UpdateResource(cluster, newService.ResourceVersion)
 			}
@@ -93,6 +95,7 @@ func (cps *ClustersControlPlaneService) PodUpdated(oldPod, newPod *kubernetes.Po
 	if newPod != nil {
 		for port, config := range newPod.GetTargetPortConfig() {
 			cluster := NewStaticClusterInfo(newPod.PodIP, port, newPod.NodeId())
+			cluster.Visibility, cluster.Exposed = newPod.TargetPortVisibility(port)
 
 			visited[cluster.Name()] = true
 			cluster.Config(config.ConfigMap)
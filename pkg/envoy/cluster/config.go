@@ -6,8 +6,11 @@ import (
 	duration "github.com/golang/protobuf/ptypes/duration"
 	wrappers "github.com/golang/protobuf/ptypes/wrappers"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"time"
 )
 
+const DEFAULT_CONNECTION_TIMEOUT = 60 * time.Second
+
 type ClusterConfigInfo struct {
 	MaxRetries         uint32
 	MaxConnections     uint32
@@ -16,27 +19,23 @@ type ClusterConfigInfo struct {
 	ConnectionTimeout  *duration.Duration
 }
 
-func NeedServiceToPodAnnotation(label string) bool {
-	switch label {
+//Validate cluster config labels, return false if the key is not a cluster config label
+func ValidateLabel(key string, value string) (bool, error) {
+	switch key {
 	case "traffic.connection.timeout":
-		fallthrough
-	case "traffic.retries.max":
-		fallthrough
-	case "traffic.connection.max":
-		fallthrough
-	case "traffic.request.max-pending":
-		fallthrough
-	case "traffic.request.max":
-		return true
+		return true, kubernetes.ValidateDuration(value, time.Nanosecond)
+	case "traffic.retries.max", "traffic.connection.max", "traffic.request.max-pending", "traffic.request.max":
+		return true, kubernetes.ValidateUInt32(value)
+	case "traffic.lb.policy":
+		return true, kubernetes.ValidateOneOf(value, "ROUND_ROBIN", "LEAST_REQUEST", "RING_HASH", "RANDOM", "MAGLEV")
 	default:
-		return false
+		return validateSubsetLabel(key, value)
 	}
-
 }
 
 func (info *ClusterConfigInfo) Config(config map[string]string) {
 	info.ConnectionTimeout = &duration.Duration{
-		Seconds: 60,
+		Seconds: int64(DEFAULT_CONNECTION_TIMEOUT / time.Second),
 	}
 	for k, v := range config {
 		if v == "" {
@@ -44,12 +43,15 @@ func (info *ClusterConfigInfo) Config(config map[string]string) {
 		}
 		switch k {
 		case "traffic.connection.timeout":
-			value := kubernetes.GetLabelValueInt64(v)
-			info.ConnectionTimeout =
-				&duration.Duration{
-					Seconds: value / 1e9,
-					Nanos:   int32(value % 1e9),
-				}
+			//bare integer is nanoseconds, invalid value keeps the default
+			value := kubernetes.GetLabelValueDuration(v, time.Nanosecond, DEFAULT_CONNECTION_TIMEOUT)
+			if value > 0 {
+				info.ConnectionTimeout =
+					&duration.Duration{
+						Seconds: int64(value / time.Second),
+						Nanos:   int32(value % time.Second),
+					}
+			}
 
 		case "traffic.retries.max":
 			info.MaxRetries = kubernetes.GetLabelValueUInt32(v)
@@ -12,11 +12,14 @@ import (
 type ServiceClusterInfo struct {
 	ClusterConfigInfo
 
-	Service   string
-	Namespace string
-	Port      uint32
+	Service    string
+	Namespace  string
+	Port       uint32
+	Visibility string
 
 	LbPolicy int32
+	//endpoint metadata key of subsets, empty if requests are not routed to subsets
+	SubsetKey string
 }
 
 func ServiceClusterName(svc string, ns string, port uint32) string {
@@ -25,9 +28,10 @@ func ServiceClusterName(svc string, ns string, port uint32) string {
 
 func NewServiceClusterInfo(svc *kubernetes.ServiceInfo, port uint32) *ServiceClusterInfo {
 	return &ServiceClusterInfo{
-		Service:   svc.Name(),
-		Namespace: svc.Namespace(),
-		Port:      port,
+		Service:    svc.Name(),
+		Namespace:  svc.Namespace(),
+		Port:       port,
+		Visibility: svc.Visibility(),
 	}
 }
 func (info *ServiceClusterInfo) Config(config map[string]string) {
@@ -37,6 +41,7 @@ func (info *ServiceClusterInfo) Config(config map[string]string) {
 	if v != "" {
 		info.LbPolicy = envoy_api_v2.Cluster_LbPolicy_value[v]
 	}
+	info.SubsetKey = SubsetKey(config)
 }
 
 func (info *ServiceClusterInfo) String() string {
@@ -47,6 +52,10 @@ func (info *ServiceClusterInfo) Name() string {
 	return ServiceClusterName(info.Service, info.Namespace, info.Port)
 }
 
+func (info *ServiceClusterInfo) VisibleTo(nodeId string) bool {
+	return kubernetes.IsVisible(info.Visibility, info.Namespace, kubernetes.NodeNamespace(nodeId))
+}
+
 func (info *ServiceClusterInfo) Type() string {
 	return common.ClusterResource
 }
@@ -67,6 +76,15 @@ func (info *ServiceClusterInfo) CreateCluster() *envoy_api_v2.Cluster {
 		},
 		LbPolicy: envoy_api_v2.Cluster_LbPolicy(info.LbPolicy),
 	}
+	if info.SubsetKey != "" {
+		//requests without metadata match or with an empty subset use all endpoints
+		result.LbSubsetConfig = &envoy_api_v2.Cluster_LbSubsetConfig{
+			FallbackPolicy: envoy_api_v2.Cluster_LbSubsetConfig_ANY_ENDPOINT,
+			SubsetSelectors: []*envoy_api_v2.Cluster_LbSubsetConfig_LbSubsetSelector{{
+				Keys: []string{info.SubsetKey},
+			}},
+		}
+	}
 	info.ApplyClusterConfig(result)
 	return result
 }
@@ -7,6 +7,7 @@ import (
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	duration "github.com/golang/protobuf/ptypes/duration"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 	"strings"
 )
 
@@ -16,6 +17,9 @@ type StaticClusterInfo struct {
 	IP     string
 	Port   uint32
 	NodeId string
+	//visibility of the services targeting the port, only the pod itself can see the cluster if not exposed
+	Visibility string
+	Exposed    bool
 }
 
 func NewStaticClusterInfo(ip string, port uint32, nodeId string) *StaticClusterInfo {
@@ -42,6 +46,17 @@ func (info *StaticClusterInfo) Name() string {
 	return StaticClusterName(info.IP, info.Port)
 }
 
+//pod itself and the pods which can access its services targeting the port
+func (info *StaticClusterInfo) VisibleTo(nodeId string) bool {
+	if nodeId == info.NodeId {
+		return true
+	}
+	if !info.Exposed {
+		return false
+	}
+	return kubernetes.IsVisible(info.Visibility, kubernetes.NodeNamespace(info.NodeId), kubernetes.NodeNamespace(nodeId))
+}
+
 func (info *StaticClusterInfo) Type() string {
 	return common.ClusterResource
 }
@@ -0,0 +1,112 @@
+package cluster
+
+import (
+	"fmt"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	//endpoint metadata used by subset routing: workload (default) or version
+	SUBSET_KEY_LABEL = "traffic.subset.key"
+	//subset of requests matching no subset route, all endpoints if not set
+	SUBSET_DEFAULT_LABEL = "traffic.subset.default"
+
+	//name of the top level workload of the pod, e.g. Deployment reviews-v3
+	SUBSET_WORKLOAD = "workload"
+	//value of the pod version label, e.g. v3
+	SUBSET_VERSION = "version"
+	//pod label giving the version subset, as used by bookinfo
+	VERSION_LABEL = "version"
+
+	LB_METADATA_FILTER = "envoy.lb"
+)
+
+//traffic.subset.(subset).(header|cookie|query).(name)=(value)
+var subsetRoutePattern = regexp.MustCompile(`^traffic\.subset\.(.+)\.(header|cookie|query)\.(.+)$`)
+
+type SubsetRouteInfo struct {
+	Subset string
+	//header, cookie or query
+	Match string
+	Name  string
+	Value string
+}
+
+//Return the subset route of a traffic.subset.* key, nil if the key is not a subset route
+func ParseSubsetRoute(key string, value string) *SubsetRouteInfo {
+	tokens := subsetRoutePattern.FindStringSubmatch(key)
+	if tokens == nil {
+		return nil
+	}
+	return &SubsetRouteInfo{
+		Subset: tokens[1],
+		Match:  tokens[2],
+		Name:   tokens[3],
+		Value:  value,
+	}
+}
+
+//Return subset routes in the config sorted by key, so that generated routes are stable
+func SubsetRoutes(config map[string]string) []*SubsetRouteInfo {
+	var keys []string
+	for key, value := range config {
+		if value != "" && strings.HasPrefix(key, "traffic.subset.") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var result []*SubsetRouteInfo
+	for _, key := range keys {
+		if route := ParseSubsetRoute(key, config[key]); route != nil {
+			result = append(result, route)
+		}
+	}
+	return result
+}
+
+//Return the endpoint metadata key of subsets if the config routes requests to subsets, empty otherwise
+func SubsetKey(config map[string]string) string {
+	if config[SUBSET_DEFAULT_LABEL] == "" && len(SubsetRoutes(config)) == 0 {
+		return ""
+	}
+	if config[SUBSET_KEY_LABEL] == SUBSET_VERSION {
+		return SUBSET_VERSION
+	}
+	return SUBSET_WORKLOAD
+}
+
+//Validate subset config labels, return false if the key is not one of them
+func validateSubsetLabel(key string, value string) (bool, error) {
+	switch {
+	case key == SUBSET_KEY_LABEL:
+		return true, kubernetes.ValidateOneOf(value, SUBSET_WORKLOAD, SUBSET_VERSION)
+	case key == SUBSET_DEFAULT_LABEL:
+		return true, nil
+	case ParseSubsetRoute(key, value) != nil:
+		return true, nil
+	case strings.HasPrefix(key, "traffic.subset."):
+		return true, fmt.Errorf("key should be like traffic.subset.(subset).(header, cookie or query).(name)")
+	default:
+		return false, nil
+	}
+}
+
+//Metadata of envoy.lb filter, matched by subset load balancer
+func SubsetMetadata(values map[string]string) *core.Metadata {
+	fields := make(map[string]*structpb.Value)
+	for key, value := range values {
+		fields[key] = &structpb.Value{
+			Kind: &structpb.Value_StringValue{StringValue: value},
+		}
+	}
+	return &core.Metadata{
+		FilterMetadata: map[string]*structpb.Struct{
+			LB_METADATA_FILTER: {Fields: fields},
+		},
+	}
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/metrics"
+	"time"
+)
+
+type NackInfo struct {
+	NodeId  string
+	TypeUrl string
+	Version string
+	Nonce   string
+	Message string
+	Time    time.Time
+}
+
+func (info *NackInfo) String() string {
+	return fmt.Sprintf("%s rejected by %s, version=%s, nonce=%s: %s", info.TypeUrl, info.NodeId, info.Version, info.Nonce, info.Message)
+}
+
+/**
+ * Nonce and version state of one resource type on one stream.
+ * SentVersion is the version of last response, AckedVersion is the last version confirmed by envoy,
+ * RejectedVersion is the version rejected by envoy, which should not be sent again.
+ * Should be accessed with K8sResourceManager locked.
+ */
+type AckState struct {
+	TypeUrl         string
+	NodeId          string
+	SentVersion     string
+	AckedVersion    string
+	RejectedVersion string
+
+	nonce     int64
+	lastNonce string
+}
+
+func NewAckState(typeUrl string, nodeId string) *AckState {
+	return &AckState{
+		TypeUrl: typeUrl,
+		NodeId:  nodeId,
+	}
+}
+
+func (state *AckState) nextNonce(version string) string {
+	state.nonce++
+	state.lastNonce = fmt.Sprintf("%d", state.nonce)
+	state.SentVersion = version
+	return state.lastNonce
+}
+
+func (state *AckState) LastNonce() string {
+	return state.lastNonce
+}
+
+func (state *AckState) String() string {
+	return fmt.Sprintf("%s, node=%s, sent=%s, acked=%s, rejected=%s", state.TypeUrl, state.NodeId, state.SentVersion, state.AckedVersion, state.RejectedVersion)
+}
+
+/**
+ * Match the response nonce of a request with the last sent response.
+ * Return false if the request is stale, i.e. envoy has not seen the latest response.
+ * A request with error detail is a NACK, the rejected version is recorded for the node.
+ */
+func (cps *ControlPlaneService) processAck(state *AckState, responseNonce string, version string, errorMessage *string) bool {
+	if responseNonce == "" {
+		//initial request or reconnected
+		return true
+	}
+	if responseNonce != state.lastNonce {
+		if glog.V(2) {
+			glog.Infof("Ignore stale request %s, nonce=%s, expected=%s", state.TypeUrl, responseNonce, state.lastNonce)
+		}
+		return false
+	}
+	if errorMessage != nil {
+		nack := &NackInfo{
+			NodeId:  state.NodeId,
+			TypeUrl: state.TypeUrl,
+			Version: state.SentVersion,
+			Nonce:   responseNonce,
+			Message: *errorMessage,
+			Time:    time.Now(),
+		}
+		glog.Warningf("NACK: %s", nack.String())
+		metrics.XdsNacks.WithLabelValues(state.TypeUrl).Inc()
+		state.RejectedVersion = state.SentVersion
+		cps.nackMap[state.NodeId] = nack
+	} else {
+		state.AckedVersion = version
+		state.RejectedVersion = ""
+		delete(cps.nackMap, state.NodeId)
+	}
+	return true
+}
+
+//Return last rejected config of each node
+func (cps *ControlPlaneService) GetNacks() map[string]*NackInfo {
+	if !cps.k8sManager.IsLocked() {
+		panic("K8sResourceManager should be locked in GetNacks")
+	}
+	result := make(map[string]*NackInfo)
+	for node, nack := range cps.nackMap {
+		result[node] = nack
+	}
+	return result
+}
@@ -0,0 +1,123 @@
+package common
+
+import (
+	"fmt"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	rpc "google.golang.org/genproto/googleapis/rpc/status"
+	"testing"
+	"time"
+)
+
+func TestAckNack(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	updateTestResource(cps, "a", "1")
+
+	req := &envoy_api_v2.DiscoveryRequest{
+		TypeUrl: ClusterResource,
+		Node:    &core.Node{Id: "test-pod.test-ns"},
+	}
+	state := cps.NewWatchState(req)
+	assert.True(t, cps.ProcessRequest(state, req))
+
+	resp, err := cps.WaitResponse(state, buildTestResource)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.Nonce, "1")
+	version := resp.VersionInfo
+
+	//stale request is ignored
+	assert.False(t, cps.ProcessRequest(state, &envoy_api_v2.DiscoveryRequest{
+		TypeUrl:       ClusterResource,
+		Node:          req.Node,
+		ResponseNonce: "0",
+	}))
+
+	//rejected version should not be sent again
+	assert.True(t, cps.ProcessRequest(state, &envoy_api_v2.DiscoveryRequest{
+		TypeUrl:       ClusterResource,
+		Node:          req.Node,
+		ResponseNonce: "1",
+		ErrorDetail:   &rpc.Status{Message: "bad config"},
+	}))
+
+	cps.GetK8sManager().Lock()
+	nacks := cps.GetNacks()
+	assert.Equal(t, nacks[req.Node.Id].Version, version)
+	assert.Equal(t, state.Ack().RejectedVersion, version)
+	cps.GetK8sManager().Unlock()
+
+	done := make(chan *envoy_api_v2.DiscoveryResponse)
+	go func() {
+		resp, _ := cps.WaitResponse(state, buildTestResource)
+		done <- resp
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("rejected version is sent again")
+	default:
+	}
+
+	updateTestResource(cps, "b", "1")
+	resp = <-done
+	assert.NotEqual(t, resp.VersionInfo, version)
+	assert.Equal(t, resp.Nonce, "2")
+}
+
+func TestCloseWatch(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	req := &envoy_api_v2.DiscoveryRequest{
+		TypeUrl: ClusterResource,
+		Node:    &core.Node{Id: "test-pod.test-ns"},
+	}
+	state := cps.NewWatchState(req)
+	cps.ProcessRequest(state, req)
+
+	done := make(chan *envoy_api_v2.DiscoveryResponse)
+	go func() {
+		resp, _ := cps.WaitResponse(state, buildTestResource)
+		done <- resp
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cps.CloseWatch(state)
+	assert.Nil(t, <-done)
+}
+
+func TestBuildFailure(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	updateTestResource(cps, "a", "1")
+
+	req := &envoy_api_v2.DiscoveryRequest{
+		TypeUrl: ClusterResource,
+		Node:    &core.Node{Id: "test-pod.test-ns"},
+	}
+	state := cps.NewWatchState(req)
+	defer cps.CloseWatch(state)
+	assert.True(t, cps.ProcessRequest(state, req))
+
+	resp, err := cps.WaitResponse(state, func(resourceMap map[string]EnvoyResource, version string, node *core.Node) (*envoy_api_v2.DiscoveryResponse, error) {
+		return nil, fmt.Errorf("marshal failed")
+	})
+	assert.Nil(t, resp)
+	assert.NotNil(t, err)
+
+	//the failed version is not retried, the next version is sent with the nonce envoy waits for
+	done := make(chan *envoy_api_v2.DiscoveryResponse)
+	go func() {
+		resp, _ := cps.WaitResponse(state, buildTestResource)
+		done <- resp
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("failed version is built again")
+	default:
+	}
+
+	updateTestResource(cps, "b", "1")
+	resp = <-done
+	assert.Equal(t, len(resp.Resources), 2)
+	assert.Equal(t, resp.Nonce, "1")
+}
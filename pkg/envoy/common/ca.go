@@ -0,0 +1,118 @@
+package common
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+)
+
+const (
+	CA_SECRET_NAME             = "traffic-ca"
+	INGRESS_CLIENT_SECRET_NAME = "traffic-ingress-client"
+	//root certificate without key, read by envoy-manager to verify certificate signer of traffic-control
+	CA_CERT_SECRET_NAME = "traffic-ca-cert"
+
+	//path of traffic-control https service which signs certificate requests of envoy nodes
+	SIGN_NODE_PATH = "/sign/node"
+)
+
+//namespace of traffic-control, root certificate and ingress client certificate are stored in it
+func ControlPlaneNamespace() string {
+	return kubernetes.ControlPlaneNamespace()
+}
+
+func loadSecretManager(info *kubernetes.SecretInfo) (*SecretManager, error) {
+	return LoadSecretManager(info.Data[CA_CERT_KEY], info.Data[CA_KEY_KEY])
+}
+
+/**
+ * Load root certificate from secret, the secret is created if not exists.
+ * Root certificate and key are stored with non tls.* keys so that sds never serves them.
+ */
+func LoadOrCreateSecretManager(k8sManager *kubernetes.K8sResourceManager, namespace string) (*SecretManager, error) {
+	info, err := k8sManager.GetSecret(CA_SECRET_NAME, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if info != nil {
+		return loadSecretManager(info)
+	}
+
+	manager, err := NewSecretManager()
+	if err != nil {
+		return nil, err
+	}
+	created, err := k8sManager.PostOpaqueSecret(CA_SECRET_NAME, namespace, map[string][]byte{
+		CA_CERT_KEY: manager.PemRootCertBytes,
+		CA_KEY_KEY:  manager.PemRootKeyBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		//created by another replica
+		return LoadExistingSecretManager(k8sManager, namespace)
+	}
+	glog.Infof("Created root certificate secret %s.%s", CA_SECRET_NAME, namespace)
+	return manager, nil
+}
+
+//Load root certificate from secret created by traffic-control
+func LoadExistingSecretManager(k8sManager *kubernetes.K8sResourceManager, namespace string) (*SecretManager, error) {
+	info, err := k8sManager.GetSecret(CA_SECRET_NAME, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("Root certificate secret %s.%s does not exist", CA_SECRET_NAME, namespace)
+	}
+	return loadSecretManager(info)
+}
+
+//Create a secret holding client certificate of given node id if not exists
+func (manager *SecretManager) EnsureNodeSecret(k8sManager *kubernetes.K8sResourceManager, name string, namespace string, nodeId string) error {
+	info, err := k8sManager.GetSecret(name, namespace)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		return nil
+	}
+	cert, key, err := manager.GenerateNodeSecret(nodeId)
+	if err != nil {
+		return err
+	}
+	_, err = k8sManager.PostOpaqueSecret(name, namespace, map[string][]byte{
+		CA_CERT_KEY:     manager.PemRootCertBytes,
+		CLIENT_CERT_KEY: cert,
+		CLIENT_KEY_KEY:  key,
+	})
+	return err
+}
+
+//Create a secret holding only the root certificate if not exists
+func (manager *SecretManager) EnsureRootCertSecret(k8sManager *kubernetes.K8sResourceManager, namespace string) error {
+	info, err := k8sManager.GetSecret(CA_CERT_SECRET_NAME, namespace)
+	if err != nil {
+		return err
+	}
+	if info != nil {
+		return nil
+	}
+	_, err = k8sManager.PostOpaqueSecret(CA_CERT_SECRET_NAME, namespace, map[string][]byte{
+		CA_CERT_KEY: manager.PemRootCertBytes,
+	})
+	return err
+}
+
+//Load root certificate from secret created by traffic-control, the root key is not readable
+func LoadRootCertificate(k8sManager *kubernetes.K8sResourceManager, namespace string) ([]byte, error) {
+	info, err := k8sManager.GetSecret(CA_CERT_SECRET_NAME, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil || len(info.Data[CA_CERT_KEY]) == 0 {
+		return nil, fmt.Errorf("Root certificate secret %s.%s does not exist", CA_CERT_SECRET_NAME, namespace)
+	}
+	return info.Data[CA_CERT_KEY], nil
+}
@@ -8,10 +8,10 @@ import (
 	"encoding/hex"
 	"github.com/golang/glog"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/metrics"
 	"reflect"
 	"sort"
 	"strings"
-	"sync"
 )
 
 type EnvoyResource interface {
@@ -31,16 +31,30 @@ type stream interface {
 type ControlPlaneService struct {
 	resourceMap map[string]EnvoyResource
 	k8sManager  *kubernetes.K8sResourceManager
-	cond        *sync.Cond
 	versionMap  map[string]string
+	//cached resource versions of each node which has stream connected
+	viewMap map[string]*nodeView
+	//last NACK of each node
+	nackMap map[string]*NackInfo
+
+	//if not empty, all resources are merged into one envoy resource with this name, e.g. listener
+	aggregatedName string
+	//name used as label of metrics
+	metricName string
+	//nil if shrinking pushes are allowed
+	guard *ShrinkGuard
+	//shrink guard state of each node
+	shrinkMap map[string]*shrinkState
 }
 
 func NewControlPlaneService(k8sManager *kubernetes.K8sResourceManager) *ControlPlaneService {
 	return &ControlPlaneService{
 		resourceMap: make(map[string]EnvoyResource),
 		versionMap:  make(map[string]string),
+		nackMap:     make(map[string]*NackInfo),
+		viewMap:     make(map[string]*nodeView),
+		shrinkMap:   make(map[string]*shrinkState),
 		k8sManager:  k8sManager,
-		cond:        k8sManager.NewCond(),
 	}
 }
 
@@ -48,14 +62,37 @@ func (cps *ControlPlaneService) GetK8sManager() *kubernetes.K8sResourceManager {
 	return cps.k8sManager
 }
 
+//All resources of this service will be built into one envoy resource with the given name
+func (cps *ControlPlaneService) SetAggregatedName(name string) {
+	cps.aggregatedName = name
+}
+
+func (cps *ControlPlaneService) SetMetricName(name string) {
+	cps.metricName = name
+}
+
+func (cps *ControlPlaneService) updateMetrics() {
+	if cps.metricName != "" {
+		metrics.Resources.WithLabelValues(cps.metricName).Set(float64(len(cps.resourceMap)))
+	}
+}
+
+func (cps *ControlPlaneService) GetAggregatedName() string {
+	return cps.aggregatedName
+}
+
 func (cps *ControlPlaneService) GetResources(resourceNames []string) (map[string]EnvoyResource, string) {
+	return cps.getResources(cps.versionMap, resourceNames)
+}
+
+func (cps *ControlPlaneService) getResources(versionMap map[string]string, resourceNames []string) (map[string]EnvoyResource, string) {
 	requested := make(map[string]EnvoyResource)
 	var versions []string
 	if len(resourceNames) > 0 {
 		sort.Strings(resourceNames)
 		for _, name := range resourceNames {
 			resource := cps.resourceMap[name]
-			version := cps.versionMap[name]
+			version := versionMap[name]
 			if version == "" || resource == nil {
 				glog.Warningf("Could not find requested '%s'", name)
 				continue
@@ -65,7 +102,7 @@ func (cps *ControlPlaneService) GetResources(resourceNames []string) (map[string
 		}
 
 	} else {
-		for name, version := range cps.versionMap {
+		for name, version := range versionMap {
 			resource := cps.resourceMap[name]
 			if version == "" || resource == nil {
 				glog.Warningf("Could not find requested %s", name)
@@ -76,18 +113,22 @@ func (cps *ControlPlaneService) GetResources(resourceNames []string) (map[string
 		}
 	}
 
+	return requested, AggregateVersion(versions)
+}
+
+//Combine versions of several resources into one version
+func AggregateVersion(versions []string) string {
 	switch len(versions) {
 	case 0:
-		return requested, ""
+		return ""
 	case 1:
-		return requested, versions[0]
+		return versions[0]
 	default:
 		sort.Strings(versions)
 		hasher := md5.New()
 		hasher.Write([]byte(strings.Join(versions, ",")))
-		return requested, hex.EncodeToString(hasher.Sum(nil))
+		return hex.EncodeToString(hasher.Sum(nil))
 	}
-
 }
 
 func (cps *ControlPlaneService) GetResourceNoCopy(name string) (EnvoyResource, string) {
@@ -128,7 +169,8 @@ func (cps *ControlPlaneService) UpdateResource(resource EnvoyResource, resourceV
 		delete(cps.resourceMap, name)
 		delete(cps.versionMap, name)
 
-		cps.cond.Broadcast()
+		cps.updateViews(resource, "")
+		cps.updateMetrics()
 		return
 	}
 
@@ -143,30 +185,8 @@ func (cps *ControlPlaneService) UpdateResource(resource EnvoyResource, resourceV
 	cps.resourceMap[name] = resource
 
 	cps.versionMap[name] = resourceVersion
-	cps.cond.Broadcast()
+	cps.updateViews(resource, resourceVersion)
+	cps.updateMetrics()
 }
 
 type ResponseBuilder func(resourceMap map[string]EnvoyResource, version string, node *core.Node) (*envoy_api_v2.DiscoveryResponse, error)
-
-func (cps *ControlPlaneService) ProcessRequest(req *envoy_api_v2.DiscoveryRequest, builder ResponseBuilder) (*envoy_api_v2.DiscoveryResponse, error) {
-	cps.k8sManager.Lock()
-
-	var currentVersion string
-	var resourceMap map[string]EnvoyResource
-	for {
-		resourceMap, currentVersion = cps.GetResources(req.ResourceNames)
-
-		if currentVersion == req.VersionInfo {
-			if glog.V(2) {
-				glog.Infof("Waiting update on %s for %v, current version=%s", req.TypeUrl, req.ResourceNames, currentVersion)
-			}
-			cps.cond.Wait()
-		} else {
-			break
-		}
-	}
-
-	cps.k8sManager.Unlock()
-
-	return builder(resourceMap, currentVersion, req.Node)
-}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"os"
+)
+
+const (
+	MTLS_MODE_ENV        = "TRAFFIC_MTLS_MODE"
+	MTLS_MODE_PERMISSIVE = "permissive"
+	MTLS_MODE_STRICT     = "strict"
+
+	//first byte of tls handshake record
+	tlsRecordHandshake = 0x16
+)
+
+//Whether TRAFFIC_MTLS_MODE env selects permissive mode, default is strict. Unknown mode is an error, so that a typo never disables mtls
+func MTLSPermissive() (bool, error) {
+	mode := os.Getenv(MTLS_MODE_ENV)
+	switch mode {
+	case "", MTLS_MODE_STRICT:
+		return false, nil
+	case MTLS_MODE_PERMISSIVE:
+		return true, nil
+	default:
+		return false, fmt.Errorf("Invalid %s %s, must be %s or %s", MTLS_MODE_ENV, mode, MTLS_MODE_STRICT, MTLS_MODE_PERMISSIVE)
+	}
+}
+
+//auth info of plaintext connections accepted in permissive mode
+type plaintextInfo struct {
+	credentials.CommonAuthInfo
+}
+
+func (info plaintextInfo) AuthType() string {
+	return "insecure"
+}
+
+//connection whose first bytes are peeked
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *peekedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+/**
+ * Accept both tls and plaintext connections on the same port, tls is detected by the first byte sent by client.
+ * Peers of plaintext connections or tls connections without client certificate are only bound to the pod of their address,
+ * see NodeIdentityVerifier.
+ */
+type permissiveCredentials struct {
+	credentials.TransportCredentials
+}
+
+func (c *permissiveCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn := &peekedConn{Conn: rawConn, reader: bufio.NewReader(rawConn)}
+	first, err := conn.reader.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	if first[0] == tlsRecordHandshake {
+		return c.TransportCredentials.ServerHandshake(conn)
+	}
+	return conn, plaintextInfo{credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}}, nil
+}
+
+func (c *permissiveCredentials) Clone() credentials.TransportCredentials {
+	return &permissiveCredentials{c.TransportCredentials.Clone()}
+}
+
+//Server credentials of control plane grpc service, see ServerTLSConfig
+func NewServerCredentials(config *tls.Config, permissive bool) credentials.TransportCredentials {
+	result := credentials.NewTLS(config)
+	if permissive {
+		return &permissiveCredentials{result}
+	}
+	return result
+}
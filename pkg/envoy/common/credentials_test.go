@@ -0,0 +1,64 @@
+package common
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestPermissiveCredentials(t *testing.T) {
+	manager, err := NewSecretManager()
+	assert.Nil(t, err)
+	config, err := manager.ServerTLSConfig([]string{"localhost"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, config.ClientAuth, tls.VerifyClientCertIfGiven)
+	creds := NewServerCredentials(config, true)
+
+	//plaintext http2 preface
+	server, client := net.Pipe()
+	go client.Write([]byte("PRI * HTTP/2.0\r\n"))
+	conn, info, err := creds.ServerHandshake(server)
+	assert.Nil(t, err)
+	assert.Equal(t, info.AuthType(), "insecure")
+	buf := make([]byte, 3)
+	conn.Read(buf)
+	assert.Equal(t, string(buf), "PRI")
+	client.Close()
+
+	//tls with client certificate
+	clientConfig, err := manager.ClientTLSConfig("test-pod.test-ns")
+	assert.Nil(t, err)
+	clientConfig.ServerName = "localhost"
+	server, client = net.Pipe()
+	go tls.Client(client, clientConfig).Handshake()
+	_, info, err = creds.ServerHandshake(server)
+	assert.Nil(t, err)
+	tlsInfo, ok := info.(credentials.TLSInfo)
+	assert.True(t, ok)
+	assert.Equal(t, tlsInfo.State.VerifiedChains[0][0].Subject.CommonName, "test-pod.test-ns")
+	client.Close()
+
+	config, err = manager.ServerTLSConfig([]string{"localhost"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, config.ClientAuth, tls.RequireAndVerifyClientCert)
+}
+
+func TestMTLSPermissive(t *testing.T) {
+	defer os.Unsetenv(MTLS_MODE_ENV)
+	os.Unsetenv(MTLS_MODE_ENV)
+	permissive, err := MTLSPermissive()
+	assert.Nil(t, err)
+	assert.False(t, permissive)
+
+	os.Setenv(MTLS_MODE_ENV, MTLS_MODE_PERMISSIVE)
+	permissive, err = MTLSPermissive()
+	assert.Nil(t, err)
+	assert.True(t, permissive)
+
+	os.Setenv(MTLS_MODE_ENV, "permisive")
+	_, err = MTLSPermissive()
+	assert.NotNil(t, err)
+}
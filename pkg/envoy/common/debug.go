@@ -0,0 +1,32 @@
+package common
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+)
+
+type ResourceDump struct {
+	Version  string
+	Resource string
+}
+
+//Should be called with K8sResourceManager locked
+func (cps *ControlPlaneService) DumpResources() map[string]*ResourceDump {
+	result := make(map[string]*ResourceDump)
+	for name, resource := range cps.resourceMap {
+		result[name] = &ResourceDump{
+			Version:  cps.versionMap[name],
+			Resource: resource.String(),
+		}
+	}
+	return result
+}
+
+//Build the response containing all resources visible to the node
+func (cps *ControlPlaneService) BuildNodeResponse(node *core.Node, builder ResponseBuilder) (*envoy_api_v2.DiscoveryResponse, error) {
+	cps.k8sManager.Lock()
+	resourceMap, version := cps.GetNodeResources(node.Id, nil)
+	cps.k8sManager.Unlock()
+
+	return builder(resourceMap, version, node)
+}
@@ -0,0 +1,327 @@
+package common
+
+import (
+	"fmt"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/golang/glog"
+	"sort"
+)
+
+/**
+ * State of one resource type on a delta xds stream.
+ * Subscribed holds the requested resource names, SentVersions holds the version
+ * of each resource which envoy already has, so that only added, changed and removed
+ * resources will be sent. Versions of a response are pending until envoy acks it,
+ * they are dropped if envoy rejects it.
+ */
+type DeltaStreamState struct {
+	TypeUrl      string
+	Node         *core.Node
+	Wildcard     bool
+	Subscribed   map[string]bool
+	SentVersions map[string]string
+
+	ack    *AckState
+	view   *nodeView
+	shrink *shrinkState
+	closed bool
+	//version whose response failed to build, retried once resources change
+	failedVersion string
+	//versions of sent responses not acked yet, empty version for removed resources
+	pendingVersions map[string]string
+}
+
+//Create the state of a delta stream, CloseDeltaStream should be called when the stream is closed
+func (cps *ControlPlaneService) NewDeltaStreamState(req *envoy_api_v2.DeltaDiscoveryRequest, wildcard bool) *DeltaStreamState {
+	cps.k8sManager.Lock()
+	defer cps.k8sManager.Unlock()
+
+	state := &DeltaStreamState{
+		TypeUrl:      req.TypeUrl,
+		Node:         req.Node,
+		Wildcard:     wildcard,
+		Subscribed:   make(map[string]bool),
+		SentVersions: make(map[string]string),
+		ack:          NewAckState(req.TypeUrl, req.Node.Id),
+		view:         cps.acquireView(req.Node.Id),
+		shrink:       cps.acquireShrinkState(req.Node.Id),
+	}
+	//envoy reconnected with resources it already has
+	for name, version := range req.InitialResourceVersions {
+		state.SentVersions[name] = version
+	}
+	return state
+}
+
+func (state *DeltaStreamState) String() string {
+	return fmt.Sprintf("%s, node=%s, wildcard=%v, subscribed=%d", state.TypeUrl, state.Node.Id, state.Wildcard, len(state.Subscribed))
+}
+
+func (state *DeltaStreamState) Ack() *AckState {
+	return state.ack
+}
+
+func (state *DeltaStreamState) failed(version string) bool {
+	return state.failedVersion != "" && state.failedVersion == version
+}
+
+//version of each resource envoy has once pending responses are acked
+func (state *DeltaStreamState) knownVersions() map[string]string {
+	if len(state.pendingVersions) == 0 {
+		return state.SentVersions
+	}
+	result := make(map[string]string)
+	for name, version := range state.SentVersions {
+		result[name] = version
+	}
+	for name, version := range state.pendingVersions {
+		if version == "" {
+			delete(result, name)
+		} else {
+			result[name] = version
+		}
+	}
+	return result
+}
+
+func (state *DeltaStreamState) commitPending() {
+	for name, version := range state.pendingVersions {
+		if version == "" {
+			delete(state.SentVersions, name)
+		} else {
+			state.SentVersions[name] = version
+		}
+	}
+	state.pendingVersions = nil
+}
+
+func (state *DeltaStreamState) subscribedNames() []string {
+	if state.Wildcard {
+		return nil
+	}
+	var result []string
+	for name := range state.Subscribed {
+		result = append(result, name)
+	}
+	return result
+}
+
+func (state *DeltaStreamState) isSubscribed(name string) bool {
+	return state.Wildcard || state.Subscribed[name]
+}
+
+//Update subscribed resources of the stream, should be called for each received DeltaDiscoveryRequest
+func (cps *ControlPlaneService) ProcessDeltaRequest(state *DeltaStreamState, req *envoy_api_v2.DeltaDiscoveryRequest) {
+	cps.k8sManager.Lock()
+	defer cps.k8sManager.Unlock()
+
+	var errorMessage *string
+	if req.ErrorDetail != nil {
+		errorMessage = &req.ErrorDetail.Message
+	}
+	//subscription changes in a stale request should still be applied
+	if cps.processAck(state.ack, req.ResponseNonce, state.ack.SentVersion, errorMessage) && req.ResponseNonce != "" {
+		if errorMessage == nil {
+			state.commitPending()
+		} else {
+			//envoy keeps the resources it had, the rejected version is not sent again
+			state.pendingVersions = nil
+		}
+	}
+
+	changed := false
+	for _, name := range req.ResourceNamesSubscribe {
+		if name == "*" {
+			changed = changed || !state.Wildcard
+			state.Wildcard = true
+			continue
+		}
+		if !state.Subscribed[name] {
+			state.Subscribed[name] = true
+			changed = true
+		}
+	}
+	for _, name := range req.ResourceNamesUnsubscribe {
+		if name == "*" {
+			changed = changed || state.Wildcard
+			state.Wildcard = false
+			continue
+		}
+		if state.Subscribed[name] {
+			delete(state.Subscribed, name)
+			changed = true
+		}
+		//envoy has forgotten the resource, it should be sent again on next subscription
+		delete(state.SentVersions, name)
+		delete(state.pendingVersions, name)
+	}
+	if changed {
+		state.view.cond.Broadcast()
+	}
+}
+
+//Stop WaitDeltaResponse on the state, should be called when the stream is closed
+func (cps *ControlPlaneService) CloseDeltaStream(state *DeltaStreamState) {
+	cps.k8sManager.Lock()
+	defer cps.k8sManager.Unlock()
+
+	if !state.closed {
+		state.closed = true
+		cps.releaseView(state.view)
+		cps.releaseShrinkState(state.shrink)
+	}
+}
+
+type deltaChange struct {
+	resourceMap map[string]EnvoyResource
+	versions    map[string]string
+	removed     []string
+	version     string
+	//number of subscribed resources envoy will have
+	count int
+}
+
+//should be called with K8sResourceManager locked
+func (cps *ControlPlaneService) getDeltaChange(state *DeltaStreamState) *deltaChange {
+	var resourceMap map[string]EnvoyResource
+	var version string
+	if state.Wildcard || len(state.Subscribed) > 0 {
+		resourceMap, version = cps.getResources(state.view.versionMap, state.subscribedNames())
+	}
+
+	result := &deltaChange{
+		resourceMap: make(map[string]EnvoyResource),
+		versions:    make(map[string]string),
+		version:     version,
+		count:       len(resourceMap),
+	}
+
+	sentVersions := state.knownVersions()
+	if cps.aggregatedName != "" {
+		sentVersion, sent := sentVersions[cps.aggregatedName]
+		if version == "" {
+			if sent {
+				result.removed = append(result.removed, cps.aggregatedName)
+			}
+		} else if sentVersion != version {
+			result.resourceMap = resourceMap
+			result.versions[cps.aggregatedName] = version
+		}
+		return result
+	}
+
+	for name, resource := range resourceMap {
+		version := state.view.versionMap[name]
+		if sentVersions[name] != version {
+			result.resourceMap[name] = resource
+			result.versions[name] = version
+		}
+	}
+	for name := range sentVersions {
+		if resourceMap[name] == nil && state.isSubscribed(name) {
+			result.removed = append(result.removed, name)
+		}
+	}
+	sort.Strings(result.removed)
+	return result
+}
+
+func (change *deltaChange) empty() bool {
+	return len(change.versions) == 0 && len(change.removed) == 0
+}
+
+func (cps *ControlPlaneService) buildDeltaResources(change *deltaChange, node *core.Node, builder ResponseBuilder) ([]*envoy_api_v2.Resource, error) {
+	var resources []*envoy_api_v2.Resource
+	if cps.aggregatedName != "" {
+		if len(change.versions) > 0 {
+			version := change.versions[cps.aggregatedName]
+			resp, err := builder(change.resourceMap, version, node)
+			if err != nil {
+				return nil, err
+			}
+			if len(resp.Resources) > 0 {
+				resources = append(resources, &envoy_api_v2.Resource{
+					Name:     cps.aggregatedName,
+					Version:  version,
+					Resource: resp.Resources[0],
+				})
+			}
+		}
+		return resources, nil
+	}
+	for name, resource := range change.resourceMap {
+		version := change.versions[name]
+		resp, err := builder(map[string]EnvoyResource{name: resource}, version, node)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resp.Resources {
+			resources = append(resources, &envoy_api_v2.Resource{
+				Name:     name,
+				Version:  version,
+				Resource: resource,
+			})
+		}
+	}
+	return resources, nil
+}
+
+/**
+ * Block until some subscribed resources are added, changed or removed, then return a response
+ * with only those resources. Return nil if the stream is closed.
+ */
+func (cps *ControlPlaneService) WaitDeltaResponse(state *DeltaStreamState, builder ResponseBuilder) (*envoy_api_v2.DeltaDiscoveryResponse, error) {
+	cps.k8sManager.Lock()
+
+	var change *deltaChange
+	for {
+		if state.closed {
+			cps.k8sManager.Unlock()
+			return nil, nil
+		}
+		change = cps.getDeltaChange(state)
+		if !change.empty() && !state.failed(change.version) && change.version != state.ack.RejectedVersion &&
+			cps.allowPush(state.shrink, state.view, state.TypeUrl, change.version, change.count) {
+			break
+		}
+		if glog.V(2) {
+			glog.Infof("Waiting delta update on %s", state.String())
+		}
+		cps.k8sManager.Wait(state.view.cond)
+	}
+
+	node := state.Node
+	cps.k8sManager.Unlock()
+
+	resources, err := cps.buildDeltaResources(change, node, builder)
+
+	cps.k8sManager.Lock()
+	defer cps.k8sManager.Unlock()
+	if err != nil {
+		//nothing is sent, envoy still has what SentVersions records
+		state.failedVersion = change.version
+		return nil, err
+	}
+	state.failedVersion = ""
+	//committed to SentVersions when envoy acks the response
+	if state.pendingVersions == nil {
+		state.pendingVersions = make(map[string]string)
+	}
+	for name, version := range change.versions {
+		state.pendingVersions[name] = version
+	}
+	for _, name := range change.removed {
+		state.pendingVersions[name] = ""
+	}
+	state.shrink.pushed(change.count)
+	nonce := state.ack.nextNonce(change.version)
+
+	return &envoy_api_v2.DeltaDiscoveryResponse{
+		SystemVersionInfo: change.version,
+		Resources:         resources,
+		RemovedResources:  change.removed,
+		TypeUrl:           state.TypeUrl,
+		Nonce:             nonce,
+	}, nil
+}
@@ -0,0 +1,190 @@
+package common
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/gogo/protobuf/proto"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	rpc "google.golang.org/genproto/googleapis/rpc/status"
+	"testing"
+	"time"
+)
+
+type testResource struct {
+	name    string
+	version string
+}
+
+func (info *testResource) Name() string {
+	return info.name
+}
+
+func (info *testResource) Type() string {
+	return ClusterResource
+}
+
+func (info *testResource) String() string {
+	return info.name
+}
+
+func buildTestResource(resourceMap map[string]EnvoyResource, version string, node *core.Node) (*envoy_api_v2.DiscoveryResponse, error) {
+	var clusters []proto.Message
+	for name := range resourceMap {
+		clusters = append(clusters, &envoy_api_v2.Cluster{Name: name})
+	}
+	return MakeResource(clusters, ClusterResource, version)
+}
+
+func buildTestListener(resourceMap map[string]EnvoyResource, version string, node *core.Node) (*envoy_api_v2.DiscoveryResponse, error) {
+	return MakeResource([]proto.Message{&envoy_api_v2.Listener{Name: "mylistener"}}, ListenerResource, version)
+}
+
+func updateTestResource(cps *ControlPlaneService, name string, version string) {
+	cps.GetK8sManager().Lock()
+	defer cps.GetK8sManager().Unlock()
+	cps.UpdateResource(&testResource{name: name, version: version}, version)
+}
+
+func TestDeltaResponse(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	updateTestResource(cps, "a", "1")
+	updateTestResource(cps, "b", "1")
+
+	req := &envoy_api_v2.DeltaDiscoveryRequest{
+		TypeUrl:                 ClusterResource,
+		Node:                    &core.Node{Id: "test-pod.test-ns"},
+		InitialResourceVersions: map[string]string{"b": "1"},
+	}
+	state := cps.NewDeltaStreamState(req, true)
+	cps.ProcessDeltaRequest(state, req)
+
+	resp, err := cps.WaitDeltaResponse(state, buildTestResource)
+	assert.Nil(t, err)
+	assert.Equal(t, len(resp.Resources), 1)
+	assert.Equal(t, resp.Resources[0].Name, "a")
+	assert.Equal(t, resp.Resources[0].Version, "1")
+
+	updateTestResource(cps, "b", "2")
+	resp, _ = cps.WaitDeltaResponse(state, buildTestResource)
+	assert.Equal(t, len(resp.Resources), 1)
+	assert.Equal(t, resp.Resources[0].Name, "b")
+	assert.Equal(t, resp.Resources[0].Version, "2")
+	assert.Equal(t, len(resp.RemovedResources), 0)
+
+	updateTestResource(cps, "a", "")
+	resp, _ = cps.WaitDeltaResponse(state, buildTestResource)
+	assert.Equal(t, len(resp.Resources), 0)
+	assert.Equal(t, resp.RemovedResources, []string{"a"})
+
+	cps.CloseDeltaStream(state)
+	resp, err = cps.WaitDeltaResponse(state, buildTestResource)
+	assert.Nil(t, err)
+	assert.Nil(t, resp)
+}
+
+func TestDeltaSubscribe(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	updateTestResource(cps, "a", "1")
+	updateTestResource(cps, "b", "1")
+
+	req := &envoy_api_v2.DeltaDiscoveryRequest{
+		TypeUrl:                ClusterResource,
+		Node:                   &core.Node{Id: "test-pod.test-ns"},
+		ResourceNamesSubscribe: []string{"b"},
+	}
+	state := cps.NewDeltaStreamState(req, false)
+	cps.ProcessDeltaRequest(state, req)
+
+	resp, _ := cps.WaitDeltaResponse(state, buildTestResource)
+	assert.Equal(t, len(resp.Resources), 1)
+	assert.Equal(t, resp.Resources[0].Name, "b")
+
+	cps.ProcessDeltaRequest(state, &envoy_api_v2.DeltaDiscoveryRequest{
+		TypeUrl:                  ClusterResource,
+		ResourceNamesSubscribe:   []string{"a"},
+		ResourceNamesUnsubscribe: []string{"b"},
+	})
+	resp, _ = cps.WaitDeltaResponse(state, buildTestResource)
+	assert.Equal(t, len(resp.Resources), 1)
+	assert.Equal(t, resp.Resources[0].Name, "a")
+	assert.Equal(t, len(resp.RemovedResources), 0)
+}
+
+func TestDeltaAggregated(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	cps.SetAggregatedName("mylistener")
+	updateTestResource(cps, "a", "1")
+	updateTestResource(cps, "b", "1")
+
+	req := &envoy_api_v2.DeltaDiscoveryRequest{
+		TypeUrl: ListenerResource,
+		Node:    &core.Node{Id: "test-pod.test-ns"},
+	}
+	state := cps.NewDeltaStreamState(req, true)
+	cps.ProcessDeltaRequest(state, req)
+
+	resp, _ := cps.WaitDeltaResponse(state, buildTestListener)
+	assert.Equal(t, len(resp.Resources), 1)
+	assert.Equal(t, resp.Resources[0].Name, "mylistener")
+	version := resp.Resources[0].Version
+
+	updateTestResource(cps, "b", "")
+	resp, _ = cps.WaitDeltaResponse(state, buildTestListener)
+	assert.Equal(t, len(resp.Resources), 1)
+	assert.NotEqual(t, resp.Resources[0].Version, version)
+}
+
+func TestDeltaNack(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	updateTestResource(cps, "a", "1")
+	updateTestResource(cps, "b", "1")
+
+	req := &envoy_api_v2.DeltaDiscoveryRequest{
+		TypeUrl:                 ClusterResource,
+		Node:                    &core.Node{Id: "test-pod.test-ns"},
+		InitialResourceVersions: map[string]string{"b": "1"},
+	}
+	state := cps.NewDeltaStreamState(req, true)
+	cps.ProcessDeltaRequest(state, req)
+
+	resp, _ := cps.WaitDeltaResponse(state, buildTestResource)
+	assert.Equal(t, resp.Resources[0].Name, "a")
+	assert.Equal(t, state.SentVersions["a"], "")
+	cps.ProcessDeltaRequest(state, &envoy_api_v2.DeltaDiscoveryRequest{
+		TypeUrl:       ClusterResource,
+		ResponseNonce: resp.Nonce,
+	})
+	assert.Equal(t, state.SentVersions["a"], "1")
+
+	updateTestResource(cps, "a", "2")
+	resp, _ = cps.WaitDeltaResponse(state, buildTestResource)
+	assert.Equal(t, resp.Resources[0].Version, "2")
+	cps.ProcessDeltaRequest(state, &envoy_api_v2.DeltaDiscoveryRequest{
+		TypeUrl:       ClusterResource,
+		ResponseNonce: resp.Nonce,
+		ErrorDetail:   &rpc.Status{Message: "rejected"},
+	})
+	assert.Equal(t, state.SentVersions["a"], "1")
+
+	//rejected version is not sent again
+	respChan := make(chan *envoy_api_v2.DeltaDiscoveryResponse)
+	go func() {
+		resp, _ := cps.WaitDeltaResponse(state, buildTestResource)
+		respChan <- resp
+	}()
+	select {
+	case <-respChan:
+		t.Error("Rejected version should not be sent again")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	//changes after the rejected version include the rolled back resources
+	updateTestResource(cps, "b", "2")
+	resp = <-respChan
+	versions := make(map[string]string)
+	for _, resource := range resp.Resources {
+		versions[resource.Name] = resource.Version
+	}
+	assert.Equal(t, versions, map[string]string{"a": "2", "b": "2"})
+}
@@ -0,0 +1,155 @@
+package common
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	SHRINK_GUARD_THRESHOLD = "TRAFFIC_SHRINK_GUARD_THRESHOLD"
+	SHRINK_GUARD_MIN       = "TRAFFIC_SHRINK_GUARD_MIN"
+	SHRINK_GUARD_HOLD      = "TRAFFIC_SHRINK_GUARD_HOLD"
+
+	SHRINK_REFUSED_REASON = "ShrinkRefused"
+)
+
+/**
+ * Refuse to push a resource set which suddenly shrinks, e.g. built from a half populated cache
+ * or after a mistaken bulk delete. A push shrinks if all resources are removed, or if at least
+ * MinRemoved resources and more than Threshold of the previously sent ones are removed.
+ * A refused push is sent anyway after Hold, so that intended changes eventually take effect.
+ */
+type ShrinkGuard struct {
+	Threshold  float64
+	MinRemoved int
+	Hold       time.Duration
+}
+
+func envFloat(name string, defaultValue float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		glog.Errorf("Invalid %s=%s: %s", name, value, err.Error())
+		return defaultValue
+	}
+	return result
+}
+
+//Create guard from TRAFFIC_SHRINK_GUARD_* env, return nil if disabled by threshold 0
+func NewShrinkGuardFromEnv() *ShrinkGuard {
+	threshold := envFloat(SHRINK_GUARD_THRESHOLD, 0.5)
+	if threshold <= 0 {
+		return nil
+	}
+	return &ShrinkGuard{
+		Threshold:  threshold,
+		MinRemoved: int(envFloat(SHRINK_GUARD_MIN, 10)),
+		Hold:       time.Duration(envFloat(SHRINK_GUARD_HOLD, 120) * float64(time.Second)),
+	}
+}
+
+func (guard *ShrinkGuard) shrinks(previous int, count int) bool {
+	if previous <= 0 || count >= previous {
+		return false
+	}
+	if count == 0 {
+		return true
+	}
+	removed := previous - count
+	return removed >= guard.MinRemoved && float64(removed)/float64(previous) > guard.Threshold
+}
+
+//states of nodes without stream are dropped after this time
+const SHRINK_STATE_TTL = time.Hour
+
+/**
+ * Shrink guard state of one node. It is kept when envoy reconnects,
+ * so that a refused push is not sent to the new stream before hold expires.
+ */
+type shrinkState struct {
+	sent           bool
+	sentCount      int
+	refusedVersion string
+	refusedSince   time.Time
+
+	//number of streams of the node and when the last one was closed
+	refCount int
+	released time.Time
+}
+
+//should be called with K8sResourceManager locked, the state should be released by releaseShrinkState
+func (cps *ControlPlaneService) acquireShrinkState(nodeId string) *shrinkState {
+	now := time.Now()
+	for id, state := range cps.shrinkMap {
+		if state.refCount <= 0 && now.Sub(state.released) > SHRINK_STATE_TTL {
+			delete(cps.shrinkMap, id)
+		}
+	}
+	state := cps.shrinkMap[nodeId]
+	if state == nil {
+		state = &shrinkState{}
+		cps.shrinkMap[nodeId] = state
+	}
+	state.refCount++
+	return state
+}
+
+//should be called with K8sResourceManager locked
+func (cps *ControlPlaneService) releaseShrinkState(state *shrinkState) {
+	state.refCount--
+	state.released = time.Now()
+}
+
+func (cps *ControlPlaneService) SetShrinkGuard(guard *ShrinkGuard) {
+	cps.guard = guard
+}
+
+/**
+ * Return false if the push of version with count resources should be refused.
+ * Should be called with K8sResourceManager locked.
+ */
+func (cps *ControlPlaneService) allowPush(state *shrinkState, view *nodeView, typeUrl string, version string, count int) bool {
+	guard := cps.guard
+	if guard == nil || !state.sent || !guard.shrinks(state.sentCount, count) {
+		return true
+	}
+	now := time.Now()
+	if state.refusedSince.IsZero() {
+		state.refusedSince = now
+		//wake up streams of the node when hold expires, envoy may have reconnected by then
+		nodeId := view.nodeId
+		time.AfterFunc(guard.Hold, func() {
+			cps.k8sManager.Lock()
+			defer cps.k8sManager.Unlock()
+			if current := cps.viewMap[nodeId]; current != nil {
+				current.cond.Broadcast()
+			}
+		})
+	}
+	if now.Sub(state.refusedSince) >= guard.Hold {
+		glog.Warningf("Pushing %s version %s with %d resources to %s after hold", typeUrl, version, count, view.nodeId)
+		return true
+	}
+	if state.refusedVersion != version {
+		state.refusedVersion = version
+		message := fmt.Sprintf("Refused to push %s version %s, resource count shrinks from %d to %d",
+			typeUrl, version, state.sentCount, count)
+		glog.Warningf("%s, node=%s", message, view.nodeId)
+		cps.k8sManager.NodeWarning(view.nodeId, SHRINK_REFUSED_REASON, message)
+	}
+	return false
+}
+
+//should be called with K8sResourceManager locked
+func (state *shrinkState) pushed(count int) {
+	state.sent = true
+	state.sentCount = count
+	state.refusedVersion = ""
+	state.refusedSince = time.Time{}
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestShrinks(t *testing.T) {
+	guard := &ShrinkGuard{Threshold: 0.5, MinRemoved: 10}
+	assert.False(t, guard.shrinks(0, 0))
+	assert.True(t, guard.shrinks(3, 0))
+	assert.False(t, guard.shrinks(3, 1))
+	assert.False(t, guard.shrinks(20, 10))
+	assert.True(t, guard.shrinks(20, 9))
+	assert.False(t, guard.shrinks(20, 30))
+}
+
+func TestShrinkGuard(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	cps.SetShrinkGuard(&ShrinkGuard{Threshold: 0.5, MinRemoved: 1, Hold: 500 * time.Millisecond})
+	updateTestResource(cps, "a", "1")
+	updateTestResource(cps, "b", "1")
+	updateTestResource(cps, "c", "1")
+
+	req := &envoy_api_v2.DiscoveryRequest{
+		TypeUrl: ClusterResource,
+		Node:    &core.Node{Id: "test-pod.test-ns"},
+	}
+	state := cps.NewWatchState(req)
+	defer cps.CloseWatch(state)
+	assert.True(t, cps.ProcessRequest(state, req))
+
+	resp, _ := cps.WaitResponse(state, buildTestResource)
+	assert.Equal(t, len(resp.Resources), 3)
+	assert.True(t, cps.ProcessRequest(state, &envoy_api_v2.DiscoveryRequest{
+		TypeUrl:       ClusterResource,
+		Node:          req.Node,
+		VersionInfo:   resp.VersionInfo,
+		ResponseNonce: resp.Nonce,
+	}))
+
+	updateTestResource(cps, "a", "")
+	updateTestResource(cps, "b", "")
+
+	done := make(chan *envoy_api_v2.DiscoveryResponse)
+	go func() {
+		resp, _ := cps.WaitResponse(state, buildTestResource)
+		done <- resp
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("shrinking push is sent before hold expires")
+	default:
+	}
+
+	//sent once hold expires
+	resp = <-done
+	assert.Equal(t, len(resp.Resources), 1)
+}
+
+func TestShrinkGuardReconnect(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	cps.SetShrinkGuard(&ShrinkGuard{Threshold: 0.5, MinRemoved: 1, Hold: 500 * time.Millisecond})
+	updateTestResource(cps, "a", "1")
+	updateTestResource(cps, "b", "1")
+	updateTestResource(cps, "c", "1")
+
+	req := &envoy_api_v2.DiscoveryRequest{
+		TypeUrl: ClusterResource,
+		Node:    &core.Node{Id: "test-pod.test-ns"},
+	}
+	state := cps.NewWatchState(req)
+	assert.True(t, cps.ProcessRequest(state, req))
+	resp, _ := cps.WaitResponse(state, buildTestResource)
+	assert.Equal(t, len(resp.Resources), 3)
+	cps.CloseWatch(state)
+
+	updateTestResource(cps, "a", "")
+	updateTestResource(cps, "b", "")
+
+	//envoy reconnects, the guard still remembers what the node has
+	state = cps.NewWatchState(req)
+	defer cps.CloseWatch(state)
+	assert.True(t, cps.ProcessRequest(state, req))
+
+	done := make(chan *envoy_api_v2.DiscoveryResponse)
+	go func() {
+		resp, _ := cps.WaitResponse(state, buildTestResource)
+		done <- resp
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("shrinking push is sent to the reconnected stream before hold expires")
+	default:
+	}
+
+	resp = <-done
+	assert.Equal(t, len(resp.Resources), 1)
+}
@@ -3,14 +3,23 @@ package common
 import (
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"fmt"
 	"math/big"
 	"net"
 	"time"
 )
 
+const (
+	CA_CERT_KEY     = "ca.crt"
+	CA_KEY_KEY      = "ca.key"
+	CLIENT_CERT_KEY = "client.crt"
+	CLIENT_KEY_KEY  = "client.key"
+)
+
 type SecretManager struct {
 	RootCertificate *x509.Certificate
 	RootKey         *rsa.PrivateKey
@@ -115,3 +124,225 @@ func (manager *SecretManager) GenerateGatewaySecret(hosts []string) ([]byte, []b
 		}), nil
 
 }
+
+//Load the root certificate and key persisted by a previous NewSecretManager()
+func LoadSecretManager(pemRootCertBytes []byte, pemRootKeyBytes []byte) (*SecretManager, error) {
+	certBlock, _ := pem.Decode(pemRootCertBytes)
+	if certBlock == nil {
+		return nil, fmt.Errorf("Invalid root certificate pem")
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	keyBlock, _ := pem.Decode(pemRootKeyBytes)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("Invalid root key pem")
+	}
+	priv, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretManager{
+		RootCertificate:  caCert,
+		RootKey:          priv,
+		PemRootCertBytes: pemRootCertBytes,
+		PemRootKeyBytes:  pemRootKeyBytes,
+	}, nil
+}
+
+func (manager *SecretManager) generateSecret(commonName string, hosts []string, usage x509.ExtKeyUsage) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := manager.signCertificate(commonName, hosts, usage, &key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}), nil
+}
+
+func (manager *SecretManager) signCertificate(commonName string, hosts []string, usage x509.ExtKeyUsage, publicKey interface{}) ([]byte, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Issuer:       manager.RootCertificate.Subject,
+		Subject: pkix.Name{
+			Organization: []string{"Simplistio"},
+			CommonName:   commonName,
+		},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		IsCA:                  false,
+		ExtKeyUsage:           []x509.ExtKeyUsage{usage},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+	cert_b, err := x509.CreateCertificate(rand.Reader, &template, manager.RootCertificate, publicKey, manager.RootKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert_b}), nil
+}
+
+//Client certificate used by envoy to connect control plane, common name is the envoy node id
+func (manager *SecretManager) GenerateNodeSecret(nodeId string) ([]byte, []byte, error) {
+	return manager.generateSecret(nodeId, nil, x509.ExtKeyUsageClientAuth)
+}
+
+//Certificate request and private key of given node id, the key never leaves the requester
+func GenerateNodeRequest(nodeId string) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr_b, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			Organization: []string{"Simplistio"},
+			CommonName:   nodeId,
+		},
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr_b}),
+		pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}), nil
+}
+
+//Parse pem certificate request and check its signature
+func ParseCertificateRequest(pemBytes []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("Invalid certificate request pem")
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	err = request.CheckSignature()
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+/**
+ * Sign client certificate of the node id in request's common name, only the common name of request is used.
+ * The root certificate is appended to the returned pem chain, see NodeTLSFiles.
+ */
+func (manager *SecretManager) SignNodeRequest(request *x509.CertificateRequest) ([]byte, error) {
+	cert, err := manager.signCertificate(request.Subject.CommonName, nil, x509.ExtKeyUsageClientAuth, request.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(cert, manager.PemRootCertBytes...), nil
+}
+
+//Files of CONTROL_PLANE_TLS_DIR from the chain returned by SignNodeRequest and the key of the request
+func NodeTLSFiles(chain []byte, key []byte) (map[string][]byte, error) {
+	var certs [][]byte
+	for rest := chain; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, pem.EncodeToMemory(block))
+		}
+	}
+	if len(certs) < 2 {
+		return nil, fmt.Errorf("Certificate chain should contain client and root certificate")
+	}
+	return map[string][]byte{
+		CA_CERT_KEY:     certs[len(certs)-1],
+		CLIENT_CERT_KEY: certs[0],
+		CLIENT_KEY_KEY:  key,
+	}, nil
+}
+
+//Server certificate of control plane grpc service
+func (manager *SecretManager) GenerateServerSecret(hosts []string) ([]byte, []byte, error) {
+	return manager.generateSecret(hosts[0], hosts, x509.ExtKeyUsageServerAuth)
+}
+
+/**
+ * Server side tls config which only accepts client certificates issued by root certificate.
+ * Client certificate is required unless permissive, see NewServerCredentials.
+ */
+func (manager *SecretManager) ServerTLSConfig(hosts []string, permissive bool) (*tls.Config, error) {
+	certPem, keyPem, err := manager.GenerateServerSecret(hosts)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(manager.RootCertificate)
+	clientAuth := tls.RequireAndVerifyClientCert
+	if permissive {
+		clientAuth = tls.VerifyClientCertIfGiven
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   clientAuth,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+//Client side tls config with certificate of given node id
+func (manager *SecretManager) ClientTLSConfig(nodeId string) (*tls.Config, error) {
+	certPem, keyPem, err := manager.GenerateNodeSecret(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(manager.RootCertificate)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+//Server side tls config of the admission webhook, kube-apiserver verifies it with the caBundle of webhook configuration
+func (manager *SecretManager) WebhookTLSConfig(hosts []string) (*tls.Config, error) {
+	certPem, keyPem, err := manager.GenerateServerSecret(hosts)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
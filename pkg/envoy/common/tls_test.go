@@ -0,0 +1,70 @@
+package common
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNodeSecret(t *testing.T) {
+	manager, err := NewSecretManager()
+	assert.Nil(t, err)
+
+	loaded, err := LoadSecretManager(manager.PemRootCertBytes, manager.PemRootKeyBytes)
+	assert.Nil(t, err)
+
+	certPem, _, err := loaded.GenerateNodeSecret("test-pod.test-ns")
+	assert.Nil(t, err)
+
+	block, _ := pem.Decode(certPem)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Subject.CommonName, "test-pod.test-ns")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(manager.RootCertificate)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.Nil(t, err)
+
+	_, err = LoadSecretManager([]byte("invalid"), manager.PemRootKeyBytes)
+	assert.NotNil(t, err)
+}
+
+func TestNodeRequest(t *testing.T) {
+	manager, err := NewSecretManager()
+	assert.Nil(t, err)
+
+	requestPem, keyPem, err := GenerateNodeRequest("test-pod.test-ns")
+	assert.Nil(t, err)
+	request, err := ParseCertificateRequest(requestPem)
+	assert.Nil(t, err)
+
+	chain, err := manager.SignNodeRequest(request)
+	assert.Nil(t, err)
+	files, err := NodeTLSFiles(chain, keyPem)
+	assert.Nil(t, err)
+	assert.Equal(t, files[CA_CERT_KEY], manager.PemRootCertBytes)
+	assert.Equal(t, files[CLIENT_KEY_KEY], keyPem)
+
+	block, _ := pem.Decode(files[CLIENT_CERT_KEY])
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.Nil(t, err)
+	assert.Equal(t, cert.Subject.CommonName, "test-pod.test-ns")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(manager.RootCertificate)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.Nil(t, err)
+
+	_, err = ParseCertificateRequest(keyPem)
+	assert.NotNil(t, err)
+	_, err = NodeTLSFiles(files[CLIENT_CERT_KEY], keyPem)
+	assert.NotNil(t, err)
+}
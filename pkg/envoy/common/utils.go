@@ -2,8 +2,10 @@ package common
 
 import (
 	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/glog"
+	golangproto "github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes/any"
 )
 
@@ -19,10 +21,19 @@ func ContainsResource(resourceNames []string, resource string) bool {
 	return empty
 }
 
+//Config source for resources served by this control plane through ADS
+func AdsConfigSource() *core.ConfigSource {
+	return &core.ConfigSource{
+		ConfigSourceSpecifier: &core.ConfigSource_Ads{
+			Ads: &core.AggregatedConfigSource{},
+		},
+	}
+}
+
 func MakeResource(resources []proto.Message, typeURL string, version string) (*envoy_api_v2.DiscoveryResponse, error) {
 	var resoureList []*any.Any
 	for _, resource := range resources {
-		data, err := proto.Marshal(resource)
+		data, err := golangproto.Marshal(resource)
 		if err != nil {
 			glog.Error(err.Error())
 			return nil, err
@@ -35,8 +46,8 @@ func MakeResource(resources []proto.Message, typeURL string, version string) (*e
 		resoureList = append(resoureList, resourceAny)
 	}
 
+	//nonce is set by the stream which sends the response
 	out := &envoy_api_v2.DiscoveryResponse{
-		Nonce:       "0",
 		VersionInfo: version,
 		Resources:   resoureList,
 		TypeUrl:     typeURL,
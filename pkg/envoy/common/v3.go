@@ -0,0 +1,304 @@
+package common
+
+import (
+	"fmt"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	listener "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
+)
+
+const (
+	typePrefixV3         = "type.googleapis.com/envoy."
+	TLS_TRANSPORT_SOCKET = "envoy.transport_sockets.tls"
+)
+
+/**
+ * v3 api is wire compatible with v2, resources are built with v2 api and converted for v3 nodes,
+ * only type urls, config source versions, filter names and the fields removed in v3 need to be changed.
+ */
+var v3TypeUrls = map[string]string{
+	ClusterResource:  typePrefixV3 + "config.cluster.v3.Cluster",
+	EndpointResource: typePrefixV3 + "config.endpoint.v3.ClusterLoadAssignment",
+	RouteResource:    typePrefixV3 + "config.route.v3.RouteConfiguration",
+	ListenerResource: typePrefixV3 + "config.listener.v3.Listener",
+	SecretResource:   typePrefixV3 + "extensions.transport_sockets.tls.v3.Secret",
+
+	"type.googleapis.com/envoy.config.filter.network.http_connection_manager.v2.HttpConnectionManager": typePrefixV3 + "extensions.filters.network.http_connection_manager.v3.HttpConnectionManager",
+	"type.googleapis.com/envoy.config.filter.network.tcp_proxy.v2.TcpProxy":                            typePrefixV3 + "extensions.filters.network.tcp_proxy.v3.TcpProxy",
+	"type.googleapis.com/envoy.config.filter.http.fault.v2.HTTPFault":                                  typePrefixV3 + "extensions.filters.http.fault.v3.HTTPFault",
+	"type.googleapis.com/envoy.config.accesslog.v2.FileAccessLog":                                      typePrefixV3 + "extensions.access_loggers.file.v3.FileAccessLog",
+	"type.googleapis.com/envoy.api.v2.auth.DownstreamTlsContext":                                       typePrefixV3 + "extensions.transport_sockets.tls.v3.DownstreamTlsContext",
+	"type.googleapis.com/envoy.api.v2.auth.UpstreamTlsContext":                                         typePrefixV3 + "extensions.transport_sockets.tls.v3.UpstreamTlsContext",
+}
+
+//deprecated extension names are not accepted by recent envoy
+var v3FilterNames = map[string]string{
+	RouterHttpFilter:        "envoy.filters.http.router",
+	HTTPConnectionManager:   "envoy.filters.network.http_connection_manager",
+	TCPProxy:                "envoy.filters.network.tcp_proxy",
+	TLS_INSPECTOR:           "envoy.filters.listener.tls_inspector",
+	ORIGINAL_DST:            "envoy.filters.listener.original_dst",
+	HttpFaultInjection:      "envoy.filters.http.fault",
+	"envoy.file_access_log": "envoy.access_loggers.file",
+}
+
+//extensions without config should have typed config in v3
+var v3EmptyConfigs = map[string]string{
+	RouterHttpFilter: typePrefixV3 + "extensions.filters.http.router.v3.Router",
+	ORIGINAL_DST:     typePrefixV3 + "extensions.filters.listener.original_dst.v3.OriginalDst",
+	TLS_INSPECTOR:    typePrefixV3 + "extensions.filters.listener.tls_inspector.v3.TlsInspector",
+}
+
+func ToV3TypeUrl(typeUrl string) string {
+	if v3 := v3TypeUrls[typeUrl]; v3 != "" {
+		return v3
+	}
+	return typeUrl
+}
+
+func ToV2TypeUrl(typeUrl string) string {
+	for v2, v3 := range v3TypeUrls {
+		if v3 == typeUrl {
+			return v2
+		}
+	}
+	return typeUrl
+}
+
+func toV3Name(name string) string {
+	if v3 := v3FilterNames[name]; v3 != "" {
+		return v3
+	}
+	return name
+}
+
+func emptyConfigV3(name string) *any.Any {
+	typeUrl := v3EmptyConfigs[name]
+	if typeUrl == "" {
+		return nil
+	}
+	return &any.Any{TypeUrl: typeUrl}
+}
+
+//Convert message between api versions through wire format
+func ConvertMessage(from proto.Message, to proto.Message) error {
+	data, err := proto.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, to)
+}
+
+func configSourceToV3(source *core.ConfigSource) {
+	if source != nil {
+		source.ResourceApiVersion = core.ApiVersion_V3
+	}
+}
+
+func marshalAnyV3(typeUrl string, msg proto.Message) (*any.Any, error) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &any.Any{TypeUrl: ToV3TypeUrl(typeUrl), Value: data}, nil
+}
+
+func tlsContextToV3(tlsContext *auth.DownstreamTlsContext) (*core.TransportSocket, error) {
+	for _, sdsConfig := range tlsContext.CommonTlsContext.GetTlsCertificateSdsSecretConfigs() {
+		configSourceToV3(sdsConfig.SdsConfig)
+	}
+	typedConfig, err := marshalAnyV3("type.googleapis.com/envoy.api.v2.auth.DownstreamTlsContext", tlsContext)
+	if err != nil {
+		return nil, err
+	}
+	return &core.TransportSocket{
+		Name:       TLS_TRANSPORT_SOCKET,
+		ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: typedConfig},
+	}, nil
+}
+
+func connectionManagerToV3(config *any.Any) (*any.Any, error) {
+	manager := &hcm.HttpConnectionManager{}
+	if err := proto.Unmarshal(config.Value, manager); err != nil {
+		return nil, err
+	}
+	if rds := manager.GetRds(); rds != nil {
+		configSourceToV3(rds.ConfigSource)
+	}
+	if manager.Tracing != nil {
+		//removed in v3, traffic direction is decided by listener
+		manager.Tracing.OperationName = hcm.HttpConnectionManager_Tracing_INGRESS
+	}
+	for _, filter := range manager.HttpFilters {
+		if typedConfig := filter.GetTypedConfig(); typedConfig != nil {
+			typedConfig.TypeUrl = ToV3TypeUrl(typedConfig.TypeUrl)
+		} else if typedConfig := emptyConfigV3(filter.Name); typedConfig != nil {
+			filter.ConfigType = &hcm.HttpFilter_TypedConfig{TypedConfig: typedConfig}
+		}
+		filter.Name = toV3Name(filter.Name)
+	}
+	for _, accessLog := range manager.AccessLog {
+		if typedConfig := accessLog.GetTypedConfig(); typedConfig != nil {
+			typedConfig.TypeUrl = ToV3TypeUrl(typedConfig.TypeUrl)
+		}
+		accessLog.Name = toV3Name(accessLog.Name)
+	}
+	return marshalAnyV3(config.TypeUrl, manager)
+}
+
+func listenerToV3(l *envoy_api_v2.Listener) error {
+	for _, listenerFilter := range l.ListenerFilters {
+		if listenerFilter.GetTypedConfig() == nil {
+			if typedConfig := emptyConfigV3(listenerFilter.Name); typedConfig != nil {
+				listenerFilter.ConfigType = &listener.ListenerFilter_TypedConfig{TypedConfig: typedConfig}
+			}
+		}
+		listenerFilter.Name = toV3Name(listenerFilter.Name)
+	}
+	for _, filterChain := range l.FilterChains {
+		if filterChain.TlsContext != nil {
+			//tls_context is removed in v3
+			transportSocket, err := tlsContextToV3(filterChain.TlsContext)
+			if err != nil {
+				return err
+			}
+			filterChain.TransportSocket = transportSocket
+			filterChain.TlsContext = nil
+		}
+		for _, filter := range filterChain.Filters {
+			typedConfig := filter.GetTypedConfig()
+			if typedConfig != nil {
+				var err error
+				if filter.Name == HTTPConnectionManager {
+					typedConfig, err = connectionManagerToV3(typedConfig)
+					if err != nil {
+						return err
+					}
+				} else {
+					typedConfig.TypeUrl = ToV3TypeUrl(typedConfig.TypeUrl)
+				}
+				filter.ConfigType = &listener.Filter_TypedConfig{TypedConfig: typedConfig}
+			}
+			filter.Name = toV3Name(filter.Name)
+		}
+	}
+	return nil
+}
+
+func clusterToV3(cluster *envoy_api_v2.Cluster) {
+	if cluster.EdsClusterConfig != nil {
+		configSourceToV3(cluster.EdsClusterConfig.EdsConfig)
+	}
+}
+
+//Convert a resource built with v2 api to v3
+func ResourceToV3(resource *any.Any) (*any.Any, error) {
+	switch resource.TypeUrl {
+	case ListenerResource:
+		l := &envoy_api_v2.Listener{}
+		if err := proto.Unmarshal(resource.Value, l); err != nil {
+			return nil, err
+		}
+		if err := listenerToV3(l); err != nil {
+			return nil, err
+		}
+		return marshalAnyV3(resource.TypeUrl, l)
+	case ClusterResource:
+		cluster := &envoy_api_v2.Cluster{}
+		if err := proto.Unmarshal(resource.Value, cluster); err != nil {
+			return nil, err
+		}
+		clusterToV3(cluster)
+		return marshalAnyV3(resource.TypeUrl, cluster)
+	case EndpointResource, RouteResource, SecretResource:
+		return &any.Any{TypeUrl: ToV3TypeUrl(resource.TypeUrl), Value: resource.Value}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported TypeUrl %s", resource.TypeUrl)
+	}
+}
+
+func NodeToV2(node *corev3.Node) (*core.Node, error) {
+	if node == nil {
+		return nil, nil
+	}
+	result := &core.Node{}
+	if err := ConvertMessage(node, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func RequestToV2(req *discoveryv3.DiscoveryRequest) (*envoy_api_v2.DiscoveryRequest, error) {
+	node, err := NodeToV2(req.Node)
+	if err != nil {
+		return nil, err
+	}
+	return &envoy_api_v2.DiscoveryRequest{
+		VersionInfo:   req.VersionInfo,
+		Node:          node,
+		ResourceNames: req.ResourceNames,
+		TypeUrl:       ToV2TypeUrl(req.TypeUrl),
+		ResponseNonce: req.ResponseNonce,
+		ErrorDetail:   req.ErrorDetail,
+	}, nil
+}
+
+func ResponseToV3(resp *envoy_api_v2.DiscoveryResponse) (*discoveryv3.DiscoveryResponse, error) {
+	result := &discoveryv3.DiscoveryResponse{
+		VersionInfo: resp.VersionInfo,
+		TypeUrl:     ToV3TypeUrl(resp.TypeUrl),
+		Nonce:       resp.Nonce,
+	}
+	for _, resource := range resp.Resources {
+		resourceV3, err := ResourceToV3(resource)
+		if err != nil {
+			return nil, err
+		}
+		result.Resources = append(result.Resources, resourceV3)
+	}
+	return result, nil
+}
+
+func DeltaRequestToV2(req *discoveryv3.DeltaDiscoveryRequest) (*envoy_api_v2.DeltaDiscoveryRequest, error) {
+	node, err := NodeToV2(req.Node)
+	if err != nil {
+		return nil, err
+	}
+	return &envoy_api_v2.DeltaDiscoveryRequest{
+		Node:                     node,
+		TypeUrl:                  ToV2TypeUrl(req.TypeUrl),
+		ResourceNamesSubscribe:   req.ResourceNamesSubscribe,
+		ResourceNamesUnsubscribe: req.ResourceNamesUnsubscribe,
+		InitialResourceVersions:  req.InitialResourceVersions,
+		ResponseNonce:            req.ResponseNonce,
+		ErrorDetail:              req.ErrorDetail,
+	}, nil
+}
+
+func DeltaResponseToV3(resp *envoy_api_v2.DeltaDiscoveryResponse) (*discoveryv3.DeltaDiscoveryResponse, error) {
+	result := &discoveryv3.DeltaDiscoveryResponse{
+		SystemVersionInfo: resp.SystemVersionInfo,
+		TypeUrl:           ToV3TypeUrl(resp.TypeUrl),
+		RemovedResources:  resp.RemovedResources,
+		Nonce:             resp.Nonce,
+	}
+	for _, resource := range resp.Resources {
+		resourceV3, err := ResourceToV3(resource.Resource)
+		if err != nil {
+			return nil, err
+		}
+		result.Resources = append(result.Resources, &discoveryv3.Resource{
+			Name:     resource.Name,
+			Version:  resource.Version,
+			Aliases:  resource.Aliases,
+			Resource: resourceV3,
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
+	listener "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	hcmv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestListenerToV3(t *testing.T) {
+	manager, _ := ptypes.MarshalAny(&hcm.HttpConnectionManager{
+		StatPrefix: "test",
+		RouteSpecifier: &hcm.HttpConnectionManager_Rds{
+			Rds: &hcm.Rds{
+				RouteConfigName: "test-route",
+				ConfigSource:    AdsConfigSource(),
+			},
+		},
+		HttpFilters: []*hcm.HttpFilter{{
+			Name: RouterHttpFilter,
+		}},
+	})
+	l := &envoy_api_v2.Listener{
+		Name: "test",
+		FilterChains: []*listener.FilterChain{{
+			Filters: []*listener.Filter{{
+				Name:       HTTPConnectionManager,
+				ConfigType: &listener.Filter_TypedConfig{TypedConfig: manager},
+			}},
+			TlsContext: &auth.DownstreamTlsContext{},
+		}},
+	}
+	resp, err := MakeResource([]proto.Message{l}, ListenerResource, "1")
+	assert.Nil(t, err)
+
+	respV3, err := ResponseToV3(resp)
+	assert.Nil(t, err)
+	assert.Equal(t, respV3.TypeUrl, "type.googleapis.com/envoy.config.listener.v3.Listener")
+
+	lv3 := &listenerv3.Listener{}
+	assert.Nil(t, ptypes.UnmarshalAny(respV3.Resources[0], lv3))
+	filterChain := lv3.FilterChains[0]
+	assert.Equal(t, filterChain.TransportSocket.Name, TLS_TRANSPORT_SOCKET)
+	assert.Equal(t, filterChain.Filters[0].Name, "envoy.filters.network.http_connection_manager")
+
+	managerV3 := &hcmv3.HttpConnectionManager{}
+	assert.Nil(t, ptypes.UnmarshalAny(filterChain.Filters[0].GetTypedConfig(), managerV3))
+	assert.Equal(t, managerV3.GetRds().RouteConfigName, "test-route")
+	assert.Equal(t, managerV3.GetRds().ConfigSource.ResourceApiVersion, corev3.ApiVersion_V3)
+	assert.Equal(t, managerV3.HttpFilters[0].Name, "envoy.filters.http.router")
+	assert.NotNil(t, managerV3.HttpFilters[0].GetTypedConfig())
+}
+
+func TestTypeUrl(t *testing.T) {
+	for _, typeUrl := range []string{ClusterResource, EndpointResource, ListenerResource, RouteResource, SecretResource} {
+		assert.NotEqual(t, ToV3TypeUrl(typeUrl), typeUrl)
+		assert.Equal(t, ToV2TypeUrl(ToV3TypeUrl(typeUrl)), typeUrl)
+	}
+}
@@ -0,0 +1,93 @@
+package common
+
+import (
+	"sync"
+)
+
+//Resource which is only visible to some envoy nodes, resources not implementing it are visible to all nodes
+type NodeScopedResource interface {
+	VisibleTo(nodeId string) bool
+}
+
+/**
+ * Versions of resources visible to one envoy node.
+ * Streams of the node wait on its own cond, so that change of resources invisible to the node
+ * will not wake them up.
+ */
+type nodeView struct {
+	nodeId     string
+	versionMap map[string]string
+	cond       *sync.Cond
+	refCount   int
+}
+
+func isVisible(resource EnvoyResource, nodeId string) bool {
+	scoped, ok := resource.(NodeScopedResource)
+	return !ok || scoped.VisibleTo(nodeId)
+}
+
+//should be called with K8sResourceManager locked, the view should be released by releaseView
+func (cps *ControlPlaneService) acquireView(nodeId string) *nodeView {
+	view := cps.viewMap[nodeId]
+	if view == nil {
+		view = &nodeView{
+			nodeId:     nodeId,
+			versionMap: make(map[string]string),
+			cond:       cps.k8sManager.NewCond(),
+		}
+		for name, version := range cps.versionMap {
+			if isVisible(cps.resourceMap[name], nodeId) {
+				view.versionMap[name] = version
+			}
+		}
+		cps.viewMap[nodeId] = view
+	}
+	view.refCount++
+	return view
+}
+
+//should be called with K8sResourceManager locked
+func (cps *ControlPlaneService) releaseView(view *nodeView) {
+	view.refCount--
+	if view.refCount <= 0 {
+		delete(cps.viewMap, view.nodeId)
+	}
+	//wake up streams which is closing
+	view.cond.Broadcast()
+}
+
+//Update the views which can see the resource before or after the change
+func (cps *ControlPlaneService) updateViews(resource EnvoyResource, version string) {
+	name := resource.Name()
+	for nodeId, view := range cps.viewMap {
+		oldVersion, exists := view.versionMap[name]
+		if version != "" && isVisible(resource, nodeId) {
+			if !exists || oldVersion != version {
+				view.versionMap[name] = version
+				view.cond.Broadcast()
+			}
+		} else if exists {
+			delete(view.versionMap, name)
+			view.cond.Broadcast()
+		}
+	}
+}
+
+//Return resources visible to the node, all resources if resourceNames is empty
+func (cps *ControlPlaneService) GetNodeResources(nodeId string, resourceNames []string) (map[string]EnvoyResource, string) {
+	view := cps.viewMap[nodeId]
+	if view == nil {
+		view = cps.acquireView(nodeId)
+		defer cps.releaseView(view)
+	}
+	return cps.getResources(view.versionMap, resourceNames)
+}
+
+//Should be called with K8sResourceManager locked when VisibleTo() of an existing resource changes without new version
+func (cps *ControlPlaneService) RefreshVisibility(name string) {
+	resource := cps.resourceMap[name]
+	if resource == nil {
+		return
+	}
+	cps.updateViews(resource, cps.versionMap[name])
+}
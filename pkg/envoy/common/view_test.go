@@ -0,0 +1,52 @@
+package common
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type scopedTestResource struct {
+	testResource
+	namespace string
+}
+
+func (info *scopedTestResource) VisibleTo(nodeId string) bool {
+	return kubernetes.IsVisible(kubernetes.VISIBILITY_NAMESPACE, info.namespace, kubernetes.NodeNamespace(nodeId))
+}
+
+func TestNodeView(t *testing.T) {
+	cps := NewControlPlaneService(kubernetes.NewFakeK8sResourceManager())
+	updateTestResource(cps, "a", "1")
+
+	req := &envoy_api_v2.DiscoveryRequest{
+		TypeUrl: ClusterResource,
+		Node:    &core.Node{Id: "pod1.ns1"},
+	}
+	state := cps.NewWatchState(req)
+	defer cps.CloseWatch(state)
+
+	cps.GetK8sManager().Lock()
+	cps.UpdateResource(&scopedTestResource{testResource{name: "b", version: "1"}, "ns1"}, "1")
+	cps.UpdateResource(&scopedTestResource{testResource{name: "c", version: "1"}, "ns2"}, "1")
+
+	resources, _ := cps.GetNodeResources("pod1.ns1", nil)
+	assert.Equal(t, len(resources), 2)
+	assert.NotNil(t, resources["a"])
+	assert.NotNil(t, resources["b"])
+
+	resources, _ = cps.GetNodeResources("pod2.ns2", nil)
+	assert.Equal(t, len(resources), 2)
+	assert.NotNil(t, resources["a"])
+	assert.NotNil(t, resources["c"])
+
+	resources, _ = cps.GetNodeResources("traffic-ingress", nil)
+	assert.Equal(t, len(resources), 3)
+	cps.GetK8sManager().Unlock()
+
+	assert.True(t, cps.ProcessRequest(state, req))
+	resp, _ := cps.WaitResponse(state, buildTestResource)
+	assert.Equal(t, len(resp.Resources), 2)
+}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"fmt"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/golang/glog"
+	"strings"
+)
+
+/**
+ * State of one resource type on a state of the world xds stream.
+ * It holds the latest accepted request, a response is sent only when envoy is waiting for one,
+ * i.e. the request has not been answered yet.
+ */
+type WatchState struct {
+	TypeUrl       string
+	Node          *core.Node
+	ResourceNames []string
+	VersionInfo   string
+
+	ack     *AckState
+	view    *nodeView
+	shrink  *shrinkState
+	waiting bool
+	closed  bool
+	//version whose response failed to build, retried once resources change
+	failedVersion string
+}
+
+//Create the watch state of a stream, CloseWatch should be called when the stream is closed
+func (cps *ControlPlaneService) NewWatchState(req *envoy_api_v2.DiscoveryRequest) *WatchState {
+	cps.k8sManager.Lock()
+	defer cps.k8sManager.Unlock()
+
+	return &WatchState{
+		TypeUrl: req.TypeUrl,
+		Node:    req.Node,
+		ack:     NewAckState(req.TypeUrl, req.Node.Id),
+		view:    cps.acquireView(req.Node.Id),
+		shrink:  cps.acquireShrinkState(req.Node.Id),
+	}
+}
+
+func (state *WatchState) String() string {
+	return fmt.Sprintf("%s, node=%s, version=%s, resource=%s", state.TypeUrl, state.Node.Id, state.VersionInfo, strings.Join(state.ResourceNames, ","))
+}
+
+func (state *WatchState) Ack() *AckState {
+	return state.ack
+}
+
+func (state *WatchState) failed(version string) bool {
+	return state.failedVersion != "" && state.failedVersion == version
+}
+
+/**
+ * Update the watch with a received DiscoveryRequest.
+ * Return false if the request is stale and ignored.
+ */
+func (cps *ControlPlaneService) ProcessRequest(state *WatchState, req *envoy_api_v2.DiscoveryRequest) bool {
+	cps.k8sManager.Lock()
+	defer cps.k8sManager.Unlock()
+
+	var errorMessage *string
+	if req.ErrorDetail != nil {
+		errorMessage = &req.ErrorDetail.Message
+	}
+	if !cps.processAck(state.ack, req.ResponseNonce, req.VersionInfo, errorMessage) {
+		return false
+	}
+
+	state.ResourceNames = req.ResourceNames
+	state.VersionInfo = req.VersionInfo
+	state.waiting = true
+	state.view.cond.Broadcast()
+	return true
+}
+
+//Stop WaitResponse on the state, should be called when the stream is closed
+func (cps *ControlPlaneService) CloseWatch(state *WatchState) {
+	cps.k8sManager.Lock()
+	defer cps.k8sManager.Unlock()
+
+	if !state.closed {
+		state.closed = true
+		cps.releaseView(state.view)
+		cps.releaseShrinkState(state.shrink)
+	}
+}
+
+/**
+ * Block until envoy is waiting for a response and the requested resources have a version
+ * different from the one envoy has. A version rejected by envoy will not be sent again.
+ * The nonce is only consumed by a built response, if building fails the version is skipped
+ * and envoy receives the next version. Return nil if the stream is closed.
+ */
+func (cps *ControlPlaneService) WaitResponse(state *WatchState, builder ResponseBuilder) (*envoy_api_v2.DiscoveryResponse, error) {
+	cps.k8sManager.Lock()
+
+	var currentVersion string
+	var resourceMap map[string]EnvoyResource
+	for {
+		if state.closed {
+			cps.k8sManager.Unlock()
+			return nil, nil
+		}
+		if state.waiting {
+			resourceMap, currentVersion = cps.getResources(state.view.versionMap, state.ResourceNames)
+			rejected := state.ack.RejectedVersion != "" && currentVersion == state.ack.RejectedVersion
+			if currentVersion != state.VersionInfo && !rejected && !state.failed(currentVersion) &&
+				cps.allowPush(state.shrink, state.view, state.TypeUrl, currentVersion, len(resourceMap)) {
+				break
+			}
+		}
+		if glog.V(2) {
+			glog.Infof("Waiting update on %s", state.String())
+		}
+		cps.k8sManager.Wait(state.view.cond)
+	}
+	state.waiting = false
+	node := state.Node
+
+	cps.k8sManager.Unlock()
+
+	resp, err := builder(resourceMap, currentVersion, node)
+
+	cps.k8sManager.Lock()
+	defer cps.k8sManager.Unlock()
+	if err != nil {
+		//nothing is sent, envoy still waits on the last nonce
+		state.waiting = true
+		state.failedVersion = currentVersion
+		return nil, err
+	}
+	state.failedVersion = ""
+	state.shrink.pushed(len(resourceMap))
+	resp.Nonce = state.ack.nextNonce(currentVersion)
+	return resp, nil
+}
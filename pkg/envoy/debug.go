@@ -0,0 +1,181 @@
+package envoy
+
+import (
+	"encoding/json"
+	"fmt"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"net/http"
+	"sort"
+	"time"
+)
+
+/**
+ * Admin http server showing what traffic-control holds and would send:
+ * /debug/resources?type=clusters   resources and versions of each control plane service
+ * /debug/config?node=ID&type=cds   rendered xds response for a node, secrets are redacted
+ * /debug/streams                   connected streams with sent and acked versions
+ * /debug/labels                    label index of K8sResourceManager
+ */
+type DebugServer struct {
+	ads *AggregatedDiscoveryService
+}
+
+type StreamStatus struct {
+	Id        int64
+	NodeId    string
+	Delta     bool
+	Connected time.Time
+	Types     []common.AckState
+}
+
+var debugConfigTypes = map[string]string{
+	"cds": common.ClusterResource,
+	"eds": common.EndpointResource,
+	"lds": common.ListenerResource,
+	"rds": common.RouteResource,
+	"sds": common.SecretResource,
+}
+
+func NewDebugServer(ads *AggregatedDiscoveryService) *DebugServer {
+	return &DebugServer{ads: ads}
+}
+
+func (server *DebugServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/resources", server.resources)
+	mux.HandleFunc("/debug/config", server.config)
+	mux.HandleFunc("/debug/streams", server.streams)
+	mux.HandleFunc("/debug/labels", server.labels)
+	return mux
+}
+
+func (server *DebugServer) services() map[string]*common.ControlPlaneService {
+	ads := server.ads
+	return map[string]*common.ControlPlaneService{
+		"clusters":          ads.cds.ControlPlaneService,
+		"endpoints":         ads.eds.ControlPlaneService,
+		"listeners":         ads.lds.ControlPlaneService,
+		"ingress-listeners": ads.ilds.ControlPlaneService,
+		"routes":            ads.rds.ControlPlaneService,
+		"ingress-routes":    ads.irds.ControlPlaneService,
+		"secrets":           ads.sds.ControlPlaneService,
+	}
+}
+
+func writeJson(w http.ResponseWriter, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (server *DebugServer) resources(w http.ResponseWriter, r *http.Request) {
+	services := server.services()
+	typeName := r.URL.Query().Get("type")
+	if typeName != "" && services[typeName] == nil {
+		http.Error(w, fmt.Sprintf("Unknown type %s", typeName), http.StatusBadRequest)
+		return
+	}
+
+	k8sManager := server.ads.cds.GetK8sManager()
+	k8sManager.Lock()
+	result := make(map[string]map[string]*common.ResourceDump)
+	for name, cps := range services {
+		if typeName == "" || typeName == name {
+			result[name] = cps.DumpResources()
+		}
+	}
+	k8sManager.Unlock()
+
+	writeJson(w, result)
+}
+
+func (server *DebugServer) config(w http.ResponseWriter, r *http.Request) {
+	nodeId := r.URL.Query().Get("node")
+	if nodeId == "" {
+		http.Error(w, "Missing node parameter", http.StatusBadRequest)
+		return
+	}
+	typeName := r.URL.Query().Get("type")
+	if typeName != "" && debugConfigTypes[typeName] == "" {
+		http.Error(w, fmt.Sprintf("Unknown type %s", typeName), http.StatusBadRequest)
+		return
+	}
+
+	node := &core.Node{Id: nodeId}
+	marshaler := &jsonpb.Marshaler{}
+	result := make(map[string]json.RawMessage)
+	for name, typeUrl := range debugConfigTypes {
+		if typeName != "" && typeName != name {
+			continue
+		}
+		cps, builder, err := server.ads.getService(typeUrl, node)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if typeUrl == common.SecretResource {
+			builder = server.ads.sds.BuildRedactedResource
+		}
+		resp, err := cps.BuildNodeResponse(node, builder)
+		if err != nil {
+			glog.Errorf("Failed to build %s for %s: %s", typeUrl, nodeId, err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data, err := marshaler.MarshalToString(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		result[name] = json.RawMessage(data)
+	}
+	writeJson(w, result)
+}
+
+func (server *DebugServer) streams(w http.ResponseWriter, r *http.Request) {
+	ads := server.ads
+	k8sManager := ads.cds.GetK8sManager()
+
+	//ack states are updated with K8sResourceManager locked
+	k8sManager.Lock()
+	ads.streamMutex.Lock()
+	var result []*StreamStatus
+	for _, info := range ads.streams {
+		status := &StreamStatus{
+			Id:        info.id,
+			NodeId:    info.nodeId,
+			Delta:     info.delta,
+			Connected: info.connected,
+		}
+		for _, ack := range info.acks {
+			status.Types = append(status.Types, *ack)
+		}
+		sort.Slice(status.Types, func(i, j int) bool {
+			return status.Types[i].TypeUrl < status.Types[j].TypeUrl
+		})
+		result = append(result, status)
+	}
+	ads.streamMutex.Unlock()
+	k8sManager.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+	writeJson(w, result)
+}
+
+func (server *DebugServer) labels(w http.ResponseWriter, r *http.Request) {
+	k8sManager := server.ads.cds.GetK8sManager()
+	k8sManager.Lock()
+	result := k8sManager.DumpLabelIndex()
+	k8sManager.Unlock()
+
+	writeJson(w, result)
+}
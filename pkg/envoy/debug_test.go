@@ -0,0 +1,73 @@
+package envoy
+
+import (
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/endpoint"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener/ingress"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDebugServer(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	os.Setenv("ENVOY_PROXY_PORT", "10000")
+	sds := NewSecretsControlPlaneService(k8sManager)
+	ads := NewAggregatedDiscoveryService(
+		cluster.NewClustersControlPlaneService(k8sManager),
+		endpoint.NewEndpointsControlPlaneService(k8sManager),
+		listener.NewListenersControlPlaneService(k8sManager),
+		ingress.NewIngressListenersControlPlaneService(k8sManager),
+		listener.NewRoutesControlPlaneService(k8sManager),
+		ingress.NewIngressRoutesControlPlaneService(k8sManager),
+		sds, nil)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "Service1"
+	service.Annotations = map[string]string{
+		kubernetes.IngressAttrLabel(80, "config"): "/test@www.test.com",
+		kubernetes.IngressAttrLabel(80, "secret"): "tls-secret.test-ns",
+	}
+	service.Spec.Ports = []corev1.ServicePort{{Name: "test", Port: 80}}
+
+	k8sManager.Lock()
+	sds.SecretAdded(&kubernetes.SecretInfo{
+		Name:            "tls-secret",
+		Namespace:       "test-ns",
+		Data:            map[string][]byte{"tls.crt": []byte("test-cert"), "tls.key": []byte("test-key")},
+		ResourceVersion: "1",
+	})
+	sds.ServiceAdded(kubernetes.NewServiceInfo(&service))
+	k8sManager.Unlock()
+
+	handler := NewDebugServer(ads).Handler()
+	get := func(url string) (int, string) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, url, nil))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	code, body := get("/debug/resources?type=secrets")
+	assert.Equal(t, code, http.StatusOK)
+	assert.True(t, strings.Contains(body, "tls-secret.test-ns"))
+	assert.False(t, strings.Contains(body, "test-key"))
+
+	code, body = get("/debug/config?node=traffic-ingress&type=sds")
+	assert.Equal(t, code, http.StatusOK)
+	assert.True(t, strings.Contains(body, "tls-secret.test-ns"))
+	assert.False(t, strings.Contains(body, "dGVzdC1rZXk="))
+
+	code, _ = get("/debug/config?type=sds")
+	assert.Equal(t, code, http.StatusBadRequest)
+
+	code, body = get("/debug/streams")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, body, "null")
+}
@@ -5,18 +5,23 @@ import (
 	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/api/v2/endpoint"
 	wrappers "github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 )
 
 const (
-	WEIGHT_LABEL            = "traffic.endpoint.weight"
-	DEPLOYMENT_WEIGHT_LABEL = kubernetes.POD_DEPLOYMENT_PREFIX + "endpoint.weight"
+	WEIGHT_LABEL = "traffic.endpoint.weight"
 )
 
 type EndpointInfo struct {
 	PodIP   string
 	Weight  uint32
 	Version string
+	//0 to use port of the cluster
+	Port   uint32
+	Health core.HealthStatus
+	//subset metadata, e.g. workload=reviews-v3 and version=v3
+	Subsets map[string]string
 }
 
 func (info EndpointInfo) String() string {
@@ -34,12 +39,26 @@ func NeedDeploymentToPodAnnotation(key string) bool {
 	}
 }
 
+//Validate endpoint config labels, return false if the key is not one of them
+func ValidateLabel(key string, value string) (bool, error) {
+	switch key {
+	case WEIGHT_LABEL:
+		return true, kubernetes.ValidateRange(value, 0, 128)
+	default:
+		return false, nil
+	}
+}
+
 func (info *EndpointInfo) Config(pod *kubernetes.PodInfo) {
 
 	weight := pod.Labels[WEIGHT_LABEL]
 	if weight == "" {
 		//pod label override deployment label
-		weight = pod.Annotations[DEPLOYMENT_WEIGHT_LABEL]
+		weight = pod.WorkloadConfig[WEIGHT_LABEL]
+	}
+	if weight == "" {
+		//namespace label or mesh default
+		weight = pod.DefaultConfig[WEIGHT_LABEL]
 	}
 	if weight != "" {
 		info.Weight = kubernetes.GetLabelValueUInt32(weight)
@@ -50,12 +69,23 @@ func (info *EndpointInfo) Config(pod *kubernetes.PodInfo) {
 	} else {
 		info.Weight = 100
 	}
+
+	info.Subsets = make(map[string]string)
+	if pod.Workload != "" {
+		info.Subsets[cluster.SUBSET_WORKLOAD] = pod.Workload
+	}
+	if version := pod.Labels[cluster.VERSION_LABEL]; version != "" {
+		info.Subsets[cluster.SUBSET_VERSION] = version
+	}
 }
 
 func (info *EndpointInfo) CreateLoadBalanceEndpoint(port uint32) *endpoint.LbEndpoint {
 	if info.Weight == 0 {
 		return nil
 	}
+	if info.Port != 0 {
+		port = info.Port
+	}
 	result := &endpoint.LbEndpoint{
 		HostIdentifier: &endpoint.LbEndpoint_Endpoint{
 			Endpoint: &endpoint.Endpoint{
@@ -72,10 +102,13 @@ func (info *EndpointInfo) CreateLoadBalanceEndpoint(port uint32) *endpoint.LbEnd
 				},
 			},
 		},
+		HealthStatus: info.Health,
 		LoadBalancingWeight: &wrappers.UInt32Value{
 			Value: info.Weight,
 		},
 	}
-
+	if len(info.Subsets) > 0 {
+		result.Metadata = cluster.SubsetMetadata(info.Subsets)
+	}
 	return result
 }
@@ -13,12 +13,19 @@ import (
 
 type EndpointsControlPlaneService struct {
 	*common.ControlPlaneService
+	//services by name.namespace, visibility of cluster assignments comes from their services
+	services map[string]*kubernetes.ServiceInfo
+	//nil if endpoints are built from pods selected by services
+	slices *sliceSource
 }
 
 func NewEndpointsControlPlaneService(k8sManager *kubernetes.K8sResourceManager) *EndpointsControlPlaneService {
-	return &EndpointsControlPlaneService{
+	result := &EndpointsControlPlaneService{
 		ControlPlaneService: common.NewControlPlaneService(k8sManager),
+		services:            make(map[string]*kubernetes.ServiceInfo),
 	}
+	result.SetMetricName("endpoints")
+	return result
 }
 
 func (manager *EndpointsControlPlaneService) PodValid(pod *kubernetes.PodInfo) bool {
@@ -33,6 +40,8 @@ func (cps *EndpointsControlPlaneService) addClusterAssignment(pod *kubernetes.Po
 		clusterAssignment = envoyResource.(*ClusterAssignmentInfo)
 	} else if clusterAssignment.EndpointMap == nil {
 		clusterAssignment.EndpointMap = make(map[string]*EndpointInfo)
+		//until the service is delivered
+		clusterAssignment.Visibility = pod.ServiceVisibility(clusterAssignment.Service)
 	}
 
 	endpoint := &EndpointInfo{
@@ -42,9 +51,33 @@ func (cps *EndpointsControlPlaneService) addClusterAssignment(pod *kubernetes.Po
 	endpoint.Config(pod)
 
 	key := fmt.Sprintf("%s@%s", pod.Name(), pod.Namespace())
+	if svc := cps.services[objectKey(clusterAssignment.Service, clusterAssignment.Namespace)]; svc != nil {
+		for _, port := range svc.Ports {
+			if port.Port != clusterAssignment.Port {
+				continue
+			}
+			//named target port differs between pods, pod without the container port is not an endpoint
+			endpoint.Port = port.ResolveTargetPort(pod)
+			if endpoint.Port == 0 {
+				if clusterAssignment.EndpointMap[key] != nil {
+					delete(clusterAssignment.EndpointMap, key)
+					cps.updateClusterAssignment(clusterAssignment)
+				}
+				return
+			}
+		}
+	}
 	clusterAssignment.EndpointMap[key] = endpoint
 
-	cps.UpdateResource(clusterAssignment, clusterAssignment.Version())
+	cps.updateClusterAssignment(clusterAssignment)
+}
+
+//visibility may change without new endpoint versions, so it is part of the resource version
+func (cps *EndpointsControlPlaneService) updateClusterAssignment(clusterAssignment *ClusterAssignmentInfo) {
+	if svc := cps.services[objectKey(clusterAssignment.Service, clusterAssignment.Namespace)]; svc != nil {
+		clusterAssignment.Visibility = svc.Visibility()
+	}
+	cps.UpdateResource(clusterAssignment, fmt.Sprintf("%s-%s", clusterAssignment.Visibility, clusterAssignment.Version()))
 }
 
 func (cps *EndpointsControlPlaneService) removeClusterAssignment(pod *kubernetes.PodInfo, clusterName string) {
@@ -55,11 +88,43 @@ func (cps *EndpointsControlPlaneService) removeClusterAssignment(pod *kubernetes
 		key := fmt.Sprintf("%s@%s", pod.Name(), pod.Namespace())
 		if clusterAssignment.EndpointMap[key] != nil {
 			delete(clusterAssignment.EndpointMap, key)
-			cps.UpdateResource(clusterAssignment, clusterAssignment.Version())
+			cps.updateClusterAssignment(clusterAssignment)
+		}
+	}
+}
+
+func (cps *EndpointsControlPlaneService) ServiceValid(svc *kubernetes.ServiceInfo) bool {
+	return true
+}
+
+func (cps *EndpointsControlPlaneService) ServiceAdded(svc *kubernetes.ServiceInfo) {
+	key := objectKey(svc.Name(), svc.Namespace())
+	cps.services[key] = svc
+	if cps.slices != nil {
+		cps.buildService(key)
+		return
+	}
+	for _, port := range svc.Ports {
+		envoyResource, _ := cps.GetResourceClone(cluster.ServiceClusterName(svc.Name(), svc.Namespace(), port.Port))
+		if envoyResource != nil {
+			cps.updateClusterAssignment(envoyResource.(*ClusterAssignmentInfo))
 		}
 	}
 }
 
+//cluster assignments built from pods are removed when the pods are updated
+func (cps *EndpointsControlPlaneService) ServiceDeleted(svc *kubernetes.ServiceInfo) {
+	key := objectKey(svc.Name(), svc.Namespace())
+	delete(cps.services, key)
+	if cps.slices != nil {
+		cps.buildService(key)
+	}
+}
+
+func (cps *EndpointsControlPlaneService) ServiceUpdated(oldService, newService *kubernetes.ServiceInfo) {
+	cps.ServiceAdded(newService)
+}
+
 func (cps *EndpointsControlPlaneService) PodAdded(pod *kubernetes.PodInfo) {
 	cps.PodUpdated(nil, pod)
 
@@ -69,6 +134,10 @@ func (cps *EndpointsControlPlaneService) PodDeleted(pod *kubernetes.PodInfo) {
 
 }
 func (cps *EndpointsControlPlaneService) PodUpdated(oldPod, newPod *kubernetes.PodInfo) {
+	if cps.slices != nil {
+		cps.slicePodUpdated(oldPod, newPod)
+		return
+	}
 	visited := make(map[string]bool)
 	if newPod != nil {
 		for port, serviceMap := range newPod.GetPortSet() {
@@ -0,0 +1,104 @@
+package endpoint
+
+import (
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"testing"
+	"time"
+)
+
+func TestServiceVisibility(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	eds := NewEndpointsControlPlaneService(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+
+	go k8sManager.WatchPods(stopper, k8sManager, eds)
+	go k8sManager.WatchServices(stopper, k8sManager, eds)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "svc1"
+	service.Labels = map[string]string{"traffic.port.80": "http", kubernetes.VISIBILITY_LABEL: "ns2"}
+	service.Spec.Selector = map[string]string{"app": "svc1"}
+	service.Spec.Ports = []corev1.ServicePort{{Name: "web", Port: 80}}
+	k8sManager.GetListerWatcher("services").Add(&service)
+
+	for _, name := range []string{"pod1", "pod2"} {
+		var pod corev1.Pod
+		pod.Namespace = "test-ns"
+		pod.Name = name
+		pod.Labels = map[string]string{"app": "svc1"}
+		pod.Status.PodIP = "10.1.1.1"
+		k8sManager.GetListerWatcher("pods").Add(&pod)
+	}
+	time.Sleep(time.Second)
+
+	result, _ := eds.GetResources([]string{})
+	assignment, _ := result["80|test-ns|svc1.outbound"].(*ClusterAssignmentInfo)
+	assert.NotNil(t, assignment)
+	assert.Equal(t, len(assignment.EndpointMap), 2)
+	assert.True(t, assignment.VisibleTo("pod3.ns2"))
+	assert.False(t, assignment.VisibleTo("pod3.ns3"))
+
+	//visibility follows the service without pod changes
+	service.Labels = map[string]string{"traffic.port.80": "http", kubernetes.VISIBILITY_LABEL: "ns3"}
+	k8sManager.GetListerWatcher("services").Modify(&service)
+	time.Sleep(time.Second)
+
+	result, _ = eds.GetResources([]string{})
+	assignment, _ = result["80|test-ns|svc1.outbound"].(*ClusterAssignmentInfo)
+	assert.False(t, assignment.VisibleTo("pod3.ns2"))
+	assert.True(t, assignment.VisibleTo("pod3.ns3"))
+}
+
+func TestNamedTargetPort(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	eds := NewEndpointsControlPlaneService(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+
+	go k8sManager.WatchPods(stopper, k8sManager, eds)
+	go k8sManager.WatchServices(stopper, k8sManager, eds)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "svc1"
+	service.Labels = map[string]string{"traffic.port.80": "http"}
+	service.Spec.Selector = map[string]string{"app": "svc1"}
+	service.Spec.Ports = []corev1.ServicePort{{Name: "web", Port: 80, TargetPort: intstr.FromString("http")}}
+	k8sManager.GetListerWatcher("services").Add(&service)
+	time.Sleep(100 * time.Millisecond)
+
+	podPorts := map[string]int32{"pod1": 8080, "pod2": 9090, "pod3": 0}
+	for name, containerPort := range podPorts {
+		var pod corev1.Pod
+		pod.Namespace = "test-ns"
+		pod.Name = name
+		pod.Labels = map[string]string{"app": "svc1"}
+		pod.Status.PodIP = "10.1.1.1"
+		if containerPort != 0 {
+			pod.Spec.Containers = []corev1.Container{{
+				Name:  "app",
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: containerPort}},
+			}}
+		}
+		k8sManager.GetListerWatcher("pods").Add(&pod)
+	}
+	time.Sleep(time.Second)
+
+	result, _ := eds.GetResources([]string{})
+	assignment, _ := result["80|test-ns|svc1.outbound"].(*ClusterAssignmentInfo)
+	assert.NotNil(t, assignment)
+	//pod3 has no container port named http
+	assert.Equal(t, len(assignment.EndpointMap), 2)
+	assert.Equal(t, assignment.EndpointMap["pod1@test-ns"].Port, uint32(8080))
+	assert.Equal(t, assignment.EndpointMap["pod2@test-ns"].Port, uint32(9090))
+
+	lbEndpoint := assignment.EndpointMap["pod2@test-ns"].CreateLoadBalanceEndpoint(assignment.Port)
+	assert.Equal(t, lbEndpoint.GetEndpoint().Address.GetSocketAddress().GetPortValue(), uint32(9090))
+}
@@ -7,12 +7,14 @@ import (
 
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 )
 
 type ClusterAssignmentInfo struct {
 	Service     string
 	Namespace   string
 	Port        uint32
+	Visibility  string
 	EndpointMap map[string]*EndpointInfo
 }
 
@@ -36,6 +38,10 @@ func (info *ClusterAssignmentInfo) Name() string {
 	return cluster.ServiceClusterName(info.Service, info.Namespace, info.Port)
 }
 
+func (info *ClusterAssignmentInfo) VisibleTo(nodeId string) bool {
+	return kubernetes.IsVisible(info.Visibility, info.Namespace, kubernetes.NodeNamespace(nodeId))
+}
+
 func (info *ClusterAssignmentInfo) Type() string {
 	return common.EndpointResource
 }
@@ -45,6 +51,7 @@ func (info *ClusterAssignmentInfo) Clone() common.EnvoyResourceClonable {
 		Service:     info.Service,
 		Namespace:   info.Namespace,
 		Port:        info.Port,
+		Visibility:  info.Visibility,
 		EndpointMap: make(map[string]*EndpointInfo),
 	}
 	for k, v := range info.EndpointMap {
@@ -0,0 +1,243 @@
+package endpoint
+
+import (
+	"fmt"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"os"
+)
+
+const (
+	ENDPOINT_SOURCE_ENV    = "TRAFFIC_ENDPOINT_SOURCE"
+	ENDPOINT_SOURCE_PODS   = "pods"
+	ENDPOINT_SOURCE_SLICES = "endpointslices"
+
+	ENDPOINT_NOT_READY_ENV     = "TRAFFIC_ENDPOINT_NOT_READY"
+	ENDPOINT_NOT_READY_EXCLUDE = "exclude"
+	ENDPOINT_NOT_READY_REPORT  = "report"
+)
+
+//Whether TRAFFIC_ENDPOINT_SOURCE env selects EndpointSlice driven EDS, default is pods
+func EndpointSlicesEnabled() bool {
+	source := os.Getenv(ENDPOINT_SOURCE_ENV)
+	switch source {
+	case "", ENDPOINT_SOURCE_PODS:
+		return false
+	case ENDPOINT_SOURCE_SLICES:
+		return true
+	default:
+		glog.Errorf("Invalid %s %s, use %s", ENDPOINT_SOURCE_ENV, source, ENDPOINT_SOURCE_PODS)
+		return false
+	}
+}
+
+/**
+ * Whether TRAFFIC_ENDPOINT_NOT_READY env asks to report not ready and terminating endpoints
+ * with UNHEALTHY and DRAINING health status, default is excluding them.
+ */
+func ReportNotReadyFromEnv() bool {
+	value := os.Getenv(ENDPOINT_NOT_READY_ENV)
+	switch value {
+	case "", ENDPOINT_NOT_READY_EXCLUDE:
+		return false
+	case ENDPOINT_NOT_READY_REPORT:
+		return true
+	default:
+		glog.Errorf("Invalid %s %s, use %s", ENDPOINT_NOT_READY_ENV, value, ENDPOINT_NOT_READY_EXCLUDE)
+		return false
+	}
+}
+
+//state of EndpointSlice driven EDS
+type sliceSource struct {
+	reportNotReady bool
+
+	pods map[string]*kubernetes.PodInfo
+	//service key => slice name => slice
+	slices map[string]map[string]*kubernetes.EndpointSliceInfo
+	//pod key => service key => number of slices referring the pod
+	podRefs map[string]map[string]int
+	//service key => cluster name => assignment built last time
+	assignments map[string]map[string]*ClusterAssignmentInfo
+}
+
+func objectKey(name string, ns string) string {
+	return fmt.Sprintf("%s.%s", name, ns)
+}
+
+/**
+ * Build endpoints from EndpointSlices instead of pods selected by services.
+ * Endpoints use ports resolved by kubernetes, so named target ports work, and services without
+ * selector work as long as their endpoints are mirrored to EndpointSlices.
+ * Pods are still watched for traffic.endpoint.weight label and deletion.
+ * Should be called before watching.
+ */
+func (cps *EndpointsControlPlaneService) UseEndpointSlices(reportNotReady bool) {
+	cps.slices = &sliceSource{
+		reportNotReady: reportNotReady,
+		pods:           make(map[string]*kubernetes.PodInfo),
+		slices:         make(map[string]map[string]*kubernetes.EndpointSliceInfo),
+		podRefs:        make(map[string]map[string]int),
+		assignments:    make(map[string]map[string]*ClusterAssignmentInfo),
+	}
+}
+
+func (cps *EndpointsControlPlaneService) slicePodUpdated(oldPod, newPod *kubernetes.PodInfo) {
+	var pod *kubernetes.PodInfo
+	if newPod != nil {
+		pod = newPod
+		cps.slices.pods[objectKey(pod.Name(), pod.Namespace())] = pod
+	} else {
+		pod = oldPod
+		delete(cps.slices.pods, objectKey(pod.Name(), pod.Namespace()))
+	}
+	for serviceKey, _ := range cps.slices.podRefs[objectKey(pod.Name(), pod.Namespace())] {
+		cps.buildService(serviceKey)
+	}
+}
+
+func (source *sliceSource) refPods(serviceKey string, slice *kubernetes.EndpointSliceInfo, delta int) {
+	for _, endpoint := range slice.Endpoints {
+		if endpoint.PodName == "" {
+			continue
+		}
+		podKey := objectKey(endpoint.PodName, slice.Namespace())
+		refs := source.podRefs[podKey]
+		if refs == nil {
+			refs = make(map[string]int)
+			source.podRefs[podKey] = refs
+		}
+		refs[serviceKey] += delta
+		if refs[serviceKey] <= 0 {
+			delete(refs, serviceKey)
+		}
+		if len(refs) == 0 {
+			delete(source.podRefs, podKey)
+		}
+	}
+}
+
+func (cps *EndpointsControlPlaneService) EndpointSliceAdded(slice *kubernetes.EndpointSliceInfo) {
+	cps.EndpointSliceUpdated(nil, slice)
+}
+
+func (cps *EndpointsControlPlaneService) EndpointSliceDeleted(slice *kubernetes.EndpointSliceInfo) {
+	cps.EndpointSliceUpdated(slice, nil)
+}
+
+func (cps *EndpointsControlPlaneService) EndpointSliceUpdated(oldSlice, newSlice *kubernetes.EndpointSliceInfo) {
+	source := cps.slices
+	if oldSlice != nil {
+		key := objectKey(oldSlice.Service, oldSlice.Namespace())
+		source.refPods(key, oldSlice, -1)
+		delete(source.slices[key], oldSlice.Name())
+		if len(source.slices[key]) == 0 {
+			delete(source.slices, key)
+		}
+		if newSlice == nil || newSlice.Service != oldSlice.Service {
+			cps.buildService(key)
+		}
+	}
+	if newSlice != nil {
+		key := objectKey(newSlice.Service, newSlice.Namespace())
+		source.refPods(key, newSlice, 1)
+		if source.slices[key] == nil {
+			source.slices[key] = make(map[string]*kubernetes.EndpointSliceInfo)
+		}
+		source.slices[key][newSlice.Name()] = newSlice
+		cps.buildService(key)
+	}
+}
+
+//return nil if the endpoint should not receive traffic and not ready endpoints are excluded
+func (source *sliceSource) endpointInfo(slice *kubernetes.EndpointSliceInfo, address *kubernetes.EndpointAddressInfo, port uint32) *EndpointInfo {
+	result := &EndpointInfo{
+		Port:    port,
+		Health:  core.HealthStatus_HEALTHY,
+		Weight:  100,
+		Version: slice.ResourceVersion,
+	}
+	var pod *kubernetes.PodInfo
+	if address.PodName != "" {
+		pod = source.pods[objectKey(address.PodName, slice.Namespace())]
+	}
+	if pod != nil {
+		result.Config(pod)
+		result.Version = fmt.Sprintf("%s.%s", slice.ResourceVersion, pod.ResourceVersion)
+	}
+
+	if pod != nil && pod.Terminating {
+		result.Health = core.HealthStatus_DRAINING
+	} else if !address.Ready {
+		result.Health = core.HealthStatus_UNHEALTHY
+	}
+	if result.Health != core.HealthStatus_HEALTHY && !source.reportNotReady {
+		return nil
+	}
+	return result
+}
+
+/**
+ * Build cluster assignments of all ports of a service from its slices.
+ * Slice ports are mapped to service ports by name. Clusters built last time but not this time
+ * are removed.
+ */
+func (cps *EndpointsControlPlaneService) buildService(serviceKey string) {
+	source := cps.slices
+	built := make(map[string]*ClusterAssignmentInfo)
+	svc := cps.services[serviceKey]
+	serviceVersion := ""
+	if svc != nil {
+		serviceVersion = svc.ResourceVersion
+		for _, slice := range source.slices[serviceKey] {
+			for _, slicePort := range slice.Ports {
+				var servicePort *kubernetes.ServicePortInfo
+				for _, port := range svc.Ports {
+					if port.Name == slicePort.Name {
+						servicePort = port
+						break
+					}
+				}
+				if servicePort == nil {
+					continue
+				}
+				name := cluster.ServiceClusterName(svc.Name(), svc.Namespace(), servicePort.Port)
+				assignment := built[name]
+				if assignment == nil {
+					assignment = NewClusterAssignmentInfo(svc.Name(), svc.Namespace(), servicePort.Port)
+					assignment.Visibility = svc.Visibility()
+					assignment.EndpointMap = make(map[string]*EndpointInfo)
+					built[name] = assignment
+				}
+				for _, address := range slice.Endpoints {
+					info := source.endpointInfo(slice, address, slicePort.Port)
+					if info == nil {
+						continue
+					}
+					for _, ip := range address.Addresses {
+						endpoint := *info
+						endpoint.PodIP = ip
+						assignment.EndpointMap[fmt.Sprintf("%s:%d", ip, slicePort.Port)] = &endpoint
+					}
+				}
+			}
+		}
+	}
+
+	for name, assignment := range source.assignments[serviceKey] {
+		if built[name] == nil {
+			cps.UpdateResource(assignment, "")
+		}
+	}
+	for _, assignment := range built {
+		//visibility comes from service labels, so service version is part of the resource version
+		cps.UpdateResource(assignment, fmt.Sprintf("%s-%s", serviceVersion, assignment.Version()))
+	}
+	if len(built) == 0 {
+		delete(source.assignments, serviceKey)
+	} else {
+		source.assignments[serviceKey] = built
+	}
+}
@@ -0,0 +1,105 @@
+package endpoint
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	discovery "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"testing"
+	"time"
+)
+
+func newSlice(name string, ready bool, podName string, ip string, port int32) *discovery.EndpointSlice {
+	portName := "web"
+	var slice discovery.EndpointSlice
+	slice.Name = name
+	slice.Namespace = "test-ns"
+	slice.Labels = map[string]string{discovery.LabelServiceName: "svc1"}
+	slice.AddressType = discovery.AddressTypeIPv4
+	slice.Ports = []discovery.EndpointPort{{Name: &portName, Port: &port}}
+	endpoint := discovery.Endpoint{
+		Addresses:  []string{ip},
+		Conditions: discovery.EndpointConditions{Ready: &ready},
+	}
+	if podName != "" {
+		endpoint.TargetRef = &corev1.ObjectReference{Kind: "Pod", Name: podName, Namespace: "test-ns"}
+	}
+	slice.Endpoints = []discovery.Endpoint{endpoint}
+	return &slice
+}
+
+func getEndpoints(eds *EndpointsControlPlaneService) map[string]*EndpointInfo {
+	result, _ := eds.GetResources([]string{})
+	assignment := result["80|test-ns|svc1.outbound"]
+	if assignment == nil {
+		return nil
+	}
+	return assignment.(*ClusterAssignmentInfo).EndpointMap
+}
+
+func TestEndpointSlices(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	eds := NewEndpointsControlPlaneService(k8sManager)
+	eds.UseEndpointSlices(true)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+
+	go k8sManager.WatchPods(stopper, k8sManager, eds)
+	go k8sManager.WatchServices(stopper, k8sManager, eds)
+	go k8sManager.WatchEndpointSlices(stopper, eds)
+
+	//selector-less service with named target port
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "svc1"
+	service.Spec.Ports = []corev1.ServicePort{{Name: "web", Port: 80, TargetPort: intstr.FromString("http")}}
+	k8sManager.GetListerWatcher("services").Add(&service)
+
+	var pod corev1.Pod
+	pod.Namespace = "test-ns"
+	pod.Name = "pod1"
+	pod.Labels = map[string]string{WEIGHT_LABEL: "20"}
+	pod.Status.PodIP = "10.1.1.1"
+	k8sManager.GetListerWatcher("pods").Add(&pod)
+
+	slices := k8sManager.GetListerWatcher("endpointslices")
+	slices.Add(newSlice("slice1", true, "pod1", "10.1.1.1", 8080))
+	slices.Add(newSlice("slice2", false, "pod2", "10.1.1.2", 9090))
+	slices.Add(newSlice("slice3", true, "", "10.1.1.3", 7070))
+	time.Sleep(time.Second)
+
+	//pod1 has no container port named http, endpoints use the ports resolved in slices
+	endpoints := getEndpoints(eds)
+	assert.Equal(t, len(endpoints), 3)
+	assert.Equal(t, endpoints["10.1.1.1:8080"].Weight, uint32(20))
+	assert.Equal(t, endpoints["10.1.1.1:8080"].Health, core.HealthStatus_HEALTHY)
+	assert.Equal(t, endpoints["10.1.1.2:9090"].Health, core.HealthStatus_UNHEALTHY)
+	assert.Equal(t, endpoints["10.1.1.3:7070"].Weight, uint32(100))
+	lbEndpoint := endpoints["10.1.1.3:7070"].CreateLoadBalanceEndpoint(80)
+	assert.Equal(t, lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress().GetPortValue(), uint32(7070))
+
+	//terminating pod is draining
+	now := metav1.Now()
+	pod.DeletionTimestamp = &now
+	k8sManager.GetListerWatcher("pods").Modify(&pod)
+	time.Sleep(time.Second)
+	assert.Equal(t, getEndpoints(eds)["10.1.1.1:8080"].Health, core.HealthStatus_DRAINING)
+
+	//not ready endpoints are excluded
+	k8sManager.Lock()
+	eds.slices.reportNotReady = false
+	k8sManager.Unlock()
+	slices.Delete(newSlice("slice3", true, "", "10.1.1.3", 7070))
+	time.Sleep(time.Second)
+	assert.Equal(t, len(getEndpoints(eds)), 0)
+
+	//cluster assignment is removed with the service
+	k8sManager.GetListerWatcher("services").Delete(&service)
+	time.Sleep(time.Second)
+	result, _ := eds.GetResources([]string{})
+	assert.Nil(t, result["80|test-ns|svc1.outbound"])
+}
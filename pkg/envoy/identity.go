@@ -0,0 +1,143 @@
+package envoy
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"net"
+)
+
+/**
+ * Verify node id claimed in xds requests.
+ * The common name of the client certificate must be the node id, and the node id must
+ * belong to a pod in informer cache whose ip is the peer address of the stream.
+ * Envoy proxy shares network namespace with its target pod, ingress envoy runs in pods
+ * annotated with traffic.envoy.proxy=ingress.
+ * Loopback peers are tools running in traffic-control pod (e.g. envoy-config), only
+ * certificate is checked for them.
+ * In permissive mode, peers without client certificate are accepted if the node id belongs to the pod of
+ * peer address, they are never verified as traffic-ingress or loopback peers.
+ */
+type NodeIdentityVerifier struct {
+	k8sManager  *kubernetes.K8sResourceManager
+	podMap      map[string]*kubernetes.PodInfo
+	ingressPods map[string]*kubernetes.PodInfo
+	permissive  bool
+}
+
+func NewNodeIdentityVerifier(k8sManager *kubernetes.K8sResourceManager) *NodeIdentityVerifier {
+	return &NodeIdentityVerifier{
+		k8sManager:  k8sManager,
+		podMap:      make(map[string]*kubernetes.PodInfo),
+		ingressPods: make(map[string]*kubernetes.PodInfo),
+	}
+}
+
+//Accept peers without client certificate, see common.MTLSPermissive()
+func (verifier *NodeIdentityVerifier) SetPermissive(permissive bool) {
+	verifier.permissive = permissive
+}
+
+func (verifier *NodeIdentityVerifier) PodValid(pod *kubernetes.PodInfo) bool {
+	return pod.Valid()
+}
+
+func (verifier *NodeIdentityVerifier) PodAdded(pod *kubernetes.PodInfo) {
+	verifier.podMap[pod.NodeId()] = pod
+	if pod.EnvoyDockerId() == kubernetes.ENVOY_PROXY_INGRESS {
+		verifier.ingressPods[pod.NodeId()] = pod
+	} else {
+		delete(verifier.ingressPods, pod.NodeId())
+	}
+}
+
+func (verifier *NodeIdentityVerifier) PodDeleted(pod *kubernetes.PodInfo) {
+	delete(verifier.podMap, pod.NodeId())
+	delete(verifier.ingressPods, pod.NodeId())
+}
+
+func (verifier *NodeIdentityVerifier) PodUpdated(oldPod, newPod *kubernetes.PodInfo) {
+	verifier.PodAdded(newPod)
+}
+
+//common name is empty if peer has no verified client certificate
+func peerIdentity(ctx context.Context) (string, string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", "", fmt.Errorf("Missing peer info")
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return "", "", err
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return "", host, nil
+	}
+	chains := tlsInfo.State.VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return "", host, nil
+	}
+	return chains[0][0].Subject.CommonName, host, nil
+}
+
+/**
+ * Return error if nodeId is not the identity of stream peer.
+ * Return false if the peer has no client certificate and is only verified by its address in permissive mode.
+ */
+func (verifier *NodeIdentityVerifier) VerifyNode(ctx context.Context, nodeId string) (bool, error) {
+	commonName, peerIp, err := peerIdentity(ctx)
+	if err != nil {
+		return false, err
+	}
+	if commonName == "" {
+		if !verifier.permissive {
+			return false, fmt.Errorf("Peer %s has no verified client certificate", peerIp)
+		}
+		if nodeId == IngressNodeId {
+			return false, fmt.Errorf("Node %s from %s requires client certificate", nodeId, peerIp)
+		}
+		if glog.V(2) {
+			glog.Infof("Node %s from %s has no client certificate, verified by pod ip in permissive mode", nodeId, peerIp)
+		}
+		return false, verifier.verifyPeer(nodeId, peerIp)
+	}
+	return true, verifier.verify(nodeId, commonName, peerIp)
+}
+
+func (verifier *NodeIdentityVerifier) verify(nodeId string, commonName string, peerIp string) error {
+	if commonName != nodeId {
+		return fmt.Errorf("Node id %s does not match certificate %s", nodeId, commonName)
+	}
+	if ip := net.ParseIP(peerIp); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return verifier.verifyPeer(nodeId, peerIp)
+}
+
+//return error if peerIp is not the ip of the pod of nodeId
+func (verifier *NodeIdentityVerifier) verifyPeer(nodeId string, peerIp string) error {
+	verifier.k8sManager.Lock()
+	defer verifier.k8sManager.Unlock()
+
+	if nodeId == IngressNodeId {
+		for _, pod := range verifier.ingressPods {
+			if pod.PodIP == peerIp {
+				return nil
+			}
+		}
+		return fmt.Errorf("Node %s from %s is not an ingress pod", nodeId, peerIp)
+	}
+
+	pod := verifier.podMap[nodeId]
+	if pod == nil {
+		return fmt.Errorf("Pod of node %s does not exist", nodeId)
+	}
+	if pod.PodIP != peerIp {
+		return fmt.Errorf("Node %s connected from %s, but pod ip is %s", nodeId, peerIp, pod.PodIP)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package envoy
+
+import (
+	"context"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/peer"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNodeIdentity(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	verifier := NewNodeIdentityVerifier(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+	go k8sManager.WatchPods(stopper, verifier)
+
+	source := k8sManager.GetListerWatcher("pods")
+	source.Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns"},
+		Status:     v1.PodStatus{PodIP: "10.0.0.1"},
+	})
+	source.Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "ingress-pod",
+			Namespace:   "default",
+			Annotations: map[string]string{kubernetes.ENVOY_PROXY_ANNOTATION: kubernetes.ENVOY_PROXY_INGRESS},
+		},
+		Status: v1.PodStatus{PodIP: "10.0.0.2"},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	assert.Nil(t, verifier.verify("test-pod.test-ns", "test-pod.test-ns", "10.0.0.1"))
+	assert.Nil(t, verifier.verify(IngressNodeId, IngressNodeId, "10.0.0.2"))
+	assert.Nil(t, verifier.verify("test-pod.test-ns", "test-pod.test-ns", "127.0.0.1"))
+
+	//certificate of other node
+	assert.NotNil(t, verifier.verify(IngressNodeId, "test-pod.test-ns", "10.0.0.1"))
+	//pod ip mismatch
+	assert.NotNil(t, verifier.verify("test-pod.test-ns", "test-pod.test-ns", "10.0.0.2"))
+	assert.NotNil(t, verifier.verify(IngressNodeId, IngressNodeId, "10.0.0.1"))
+	//pod not exists
+	assert.NotNil(t, verifier.verify("other-pod.test-ns", "other-pod.test-ns", "10.0.0.1"))
+
+	source.Delete(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "test-ns"},
+		Status:     v1.PodStatus{PodIP: "10.0.0.1"},
+	})
+	time.Sleep(100 * time.Millisecond)
+	assert.NotNil(t, verifier.verify("test-pod.test-ns", "test-pod.test-ns", "10.0.0.1"))
+}
+
+func TestNodeIdentityPermissive(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	verifier := NewNodeIdentityVerifier(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+	go k8sManager.WatchPods(stopper, verifier)
+
+	k8sManager.GetListerWatcher("pods").Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "ingress-pod",
+			Namespace:   "default",
+			Annotations: map[string]string{kubernetes.ENVOY_PROXY_ANNOTATION: kubernetes.ENVOY_PROXY_INGRESS},
+		},
+		Status: v1.PodStatus{PodIP: "10.0.0.1"},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	//plaintext peer
+	plaintext := func(ip string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 10000},
+		})
+	}
+	_, err := verifier.VerifyNode(plaintext("10.0.0.1"), "ingress-pod.default")
+	assert.NotNil(t, err)
+
+	verifier.SetPermissive(true)
+	certified, err := verifier.VerifyNode(plaintext("10.0.0.1"), "ingress-pod.default")
+	assert.Nil(t, err)
+	assert.False(t, certified)
+	//node id of other pod
+	_, err = verifier.VerifyNode(plaintext("10.0.0.2"), "ingress-pod.default")
+	assert.NotNil(t, err)
+	_, err = verifier.VerifyNode(plaintext("10.0.0.1"), "other-pod.default")
+	assert.NotNil(t, err)
+	//loopback and ingress require certificate
+	_, err = verifier.VerifyNode(plaintext("127.0.0.1"), "ingress-pod.default")
+	assert.NotNil(t, err)
+	_, err = verifier.VerifyNode(plaintext("10.0.0.1"), IngressNodeId)
+	assert.NotNil(t, err)
+	//identity is still checked if certificate is presented
+	assert.NotNil(t, verifier.verify("test-pod.test-ns", "other-pod.test-ns", "10.0.0.1"))
+
+	//secrets are not served without certificate
+	ads := &AggregatedDiscoveryService{verifier: verifier}
+	var node streamNode
+	assert.Nil(t, ads.checkNode(plaintext("10.0.0.1"), &node, "ingress-pod.default", common.ClusterResource))
+	assert.Equal(t, node.id, "ingress-pod.default")
+	assert.NotNil(t, ads.checkNode(plaintext("10.0.0.1"), &node, "ingress-pod.default", common.SecretResource))
+	assert.NotNil(t, ads.checkNode(plaintext("10.0.0.1"), &node, "other-pod.default", common.ClusterResource))
+}
@@ -15,18 +15,20 @@ import (
 )
 
 type ClusterIpFilterInfo struct {
-	clusterIP string
-	service   string
-	namespace string
-	port      uint32
+	clusterIP  string
+	service    string
+	namespace  string
+	port       uint32
+	visibility string
 }
 
 func NewClusterIpFilterInfo(svc *kubernetes.ServiceInfo, port uint32) *ClusterIpFilterInfo {
 	return &ClusterIpFilterInfo{
-		port:      port,
-		clusterIP: svc.ClusterIP,
-		service:   svc.Name(),
-		namespace: svc.Namespace(),
+		port:       port,
+		clusterIP:  svc.ClusterIP,
+		service:    svc.Name(),
+		namespace:  svc.Namespace(),
+		visibility: svc.Visibility(),
 	}
 }
 
@@ -34,6 +36,10 @@ func (info *ClusterIpFilterInfo) String() string {
 	return fmt.Sprintf("%s, clusterIp=%v", info.Name(), info.clusterIP)
 }
 
+func (info *ClusterIpFilterInfo) VisibleTo(nodeId string) bool {
+	return kubernetes.IsVisible(info.visibility, info.namespace, kubernetes.NodeNamespace(nodeId))
+}
+
 func (info *ClusterIpFilterInfo) Type() string {
 	return common.ListenerResource
 }
@@ -33,21 +33,25 @@ func (info *HttpClusterIpFilterInfo) String() string {
 	return fmt.Sprintf("%s,%s,tracing=%v", info.Name(), info.clusterIP, info.Tracing)
 }
 
-func (info *HttpClusterIpFilterInfo) CreateFilterChain(node *core.Node) (*listener.FilterChain, error) {
+func (info *HttpClusterIpFilterInfo) CreateRouteConfiguration(node *core.Node) *envoy_api_v2.RouteConfiguration {
 	if info.clusterIP == "" || info.clusterIP == "None" {
-		return nil, nil
+		return nil
 	}
-	routeConfig := &envoy_api_v2.RouteConfiguration{
+	return &envoy_api_v2.RouteConfiguration{
 		Name:         info.Name(),
 		VirtualHosts: []*route.VirtualHost{info.CreateVirtualHost(info.ClusterName(), common.ALL_DOMAIN)},
 	}
+}
+
+func (info *HttpClusterIpFilterInfo) CreateFilterChain(node *core.Node) (*listener.FilterChain, error) {
+	if info.clusterIP == "" || info.clusterIP == "None" {
+		return nil, nil
+	}
 
 	manager := &hcm.HttpConnectionManager{
-		CodecType:  hcm.HttpConnectionManager_AUTO,
-		StatPrefix: info.Name(),
-		RouteSpecifier: &hcm.HttpConnectionManager_RouteConfig{
-			RouteConfig: routeConfig,
-		},
+		CodecType:      hcm.HttpConnectionManager_AUTO,
+		StatPrefix:     info.Name(),
+		RouteSpecifier: CreateRdsRouteSpecifier(info.Name()),
 	}
 	info.ConfigConnectionManager(manager)
 
@@ -7,14 +7,21 @@ import (
 	httpfault "github.com/envoyproxy/go-control-plane/envoy/config/filter/http/fault/v2"
 	hcm "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/http_connection_manager/v2"
 	_type "github.com/envoyproxy/go-control-plane/envoy/type"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher"
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/ptypes"
 	duration "github.com/golang/protobuf/ptypes/duration"
 	wrappers "github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"regexp"
+	"time"
 )
 
+//route timeout of envoy
+const DEFAULT_REQUEST_TIMEOUT = 15 * time.Second
+
 type HttpListenerConfigInfo struct {
 	Tracing        bool
 	RequestTimeout *duration.Duration
@@ -33,34 +40,44 @@ type HttpListenerConfigInfo struct {
 	HashCookieName string
 	HashHeaderName string
 	HashCookieTTL  *duration.Duration
+
+	//endpoint metadata key of subsets, empty if requests are not routed to subsets
+	SubsetKey string
+	//subset of requests matching no subset route, all endpoints if empty
+	SubsetDefault string
+	SubsetRoutes  []*cluster.SubsetRouteInfo
 }
 
-func NeedServiceToPodAnnotation(label string) bool {
-	switch label {
-	case "traffic.request.timeout":
-		fallthrough
-	case "traffic.retries.5xx":
-		fallthrough
-	case "traffic.retries.connect-failure":
-		fallthrough
-	case "traffic.retries.gateway-error":
-		fallthrough
-	case "traffic.fault.delay.time":
-		fallthrough
-	case "traffic.fault.delay.percentage":
-		fallthrough
-	case "traffic.fault.abort.status":
-		fallthrough
-	case "traffic.fault.abort.percentage":
-		fallthrough
+//Validate http listener and route config labels, return false if the key is not one of them
+func ValidateLabel(key string, value string) (bool, error) {
+	switch key {
+	case "traffic.hash.cookie.name", "traffic.hash.header.name":
+		return true, nil
+	case "traffic.hash.cookie.ttl":
+		return true, kubernetes.ValidateDuration(value, time.Second)
+	case "traffic.request.timeout", "traffic.fault.delay.time":
+		return true, kubernetes.ValidateDuration(value, time.Nanosecond)
 	case "traffic.rate.limit":
-		fallthrough
+		return true, kubernetes.ValidateUInt64(value)
 	case "traffic.tracing.enabled":
-		fallthrough
+		return true, kubernetes.ValidateBool(value)
 	case "traffic.tracing.sampling":
-		return true
+		return true, kubernetes.ValidateFloatRange(value, 0, 100)
+	case "traffic.retries.5xx", "traffic.retries.connect-failure", "traffic.retries.gateway-error":
+		return true, kubernetes.ValidateUInt32(value)
+	case "traffic.fault.delay.percentage", "traffic.fault.abort.percentage":
+		return true, kubernetes.ValidateRange(value, 0, 100)
+	case "traffic.fault.abort.status":
+		return true, kubernetes.ValidateRange(value, 200, 599)
 	default:
-		return false
+		return false, nil
+	}
+}
+
+func durationProto(d time.Duration) *duration.Duration {
+	return &duration.Duration{
+		Seconds: int64(d / time.Second),
+		Nanos:   int32(d % time.Second),
 	}
 }
 
@@ -77,9 +94,8 @@ func (info *HttpListenerConfigInfo) Config(config map[string]string) {
 		case "traffic.hash.header.name":
 			info.HashHeaderName = v
 		case "traffic.hash.cookie.ttl":
-			info.HashCookieTTL = &duration.Duration{
-				Seconds: kubernetes.GetLabelValueInt64(v),
-			}
+			//bare integer is seconds, invalid value is a session cookie
+			info.HashCookieTTL = durationProto(kubernetes.GetLabelValueDuration(v, time.Second, 0))
 
 		case "traffic.tracing.enabled":
 			info.Tracing = kubernetes.GetLabelValueBool(v)
@@ -87,11 +103,8 @@ func (info *HttpListenerConfigInfo) Config(config map[string]string) {
 			info.TraceSamplingPercent = kubernetes.GetLabelValueFloat64(v)
 
 		case "traffic.request.timeout":
-			value := kubernetes.GetLabelValueInt64(v)
-			info.RequestTimeout = &duration.Duration{
-				Seconds: value / 1e9,
-				Nanos:   int32(value % 1e9),
-			}
+			//bare integer is nanoseconds, invalid value keeps the envoy default instead of disabling the timeout
+			info.RequestTimeout = durationProto(kubernetes.GetLabelValueDuration(v, time.Nanosecond, DEFAULT_REQUEST_TIMEOUT))
 		case "traffic.retries.5xx":
 			info.RetryOn = "5xx"
 			info.RetryTimes = kubernetes.GetLabelValueUInt32(v)
@@ -102,11 +115,8 @@ func (info *HttpListenerConfigInfo) Config(config map[string]string) {
 			info.RetryOn = "gateway-error"
 			info.RetryTimes = kubernetes.GetLabelValueUInt32(v)
 		case "traffic.fault.delay.time":
-			value := kubernetes.GetLabelValueInt64(v)
-			info.FaultInjectionFixDelay = &duration.Duration{
-				Seconds: value / 1e9,
-				Nanos:   int32(value % 1e9),
-			}
+			//bare integer is nanoseconds, invalid value is no delay
+			info.FaultInjectionFixDelay = durationProto(kubernetes.GetLabelValueDuration(v, time.Nanosecond, 0))
 		case "traffic.fault.delay.percentage":
 			info.FaultInjectionFixDelayPercentage = kubernetes.GetLabelValueUInt32(v)
 		case "traffic.fault.abort.status":
@@ -118,6 +128,33 @@ func (info *HttpListenerConfigInfo) Config(config map[string]string) {
 
 		}
 	}
+	info.SubsetKey = cluster.SubsetKey(config)
+	if info.SubsetKey != "" {
+		info.SubsetDefault = config[cluster.SUBSET_DEFAULT_LABEL]
+		info.SubsetRoutes = cluster.SubsetRoutes(config)
+	}
+}
+
+//Return a copy which only has the config of http connection manager, route config is served by rds
+func (info *HttpListenerConfigInfo) ConnectionManagerConfig() HttpListenerConfigInfo {
+	return HttpListenerConfigInfo{
+		Tracing:                          info.Tracing,
+		TraceSamplingPercent:             info.TraceSamplingPercent,
+		FaultInjectionFixDelayPercentage: info.FaultInjectionFixDelayPercentage,
+		FaultInjectionFixDelay:           info.FaultInjectionFixDelay,
+		FaultInjectionAbortPercentage:    info.FaultInjectionAbortPercentage,
+		FaultInjectionAbortStatus:        info.FaultInjectionAbortStatus,
+		RateLimitKbps:                    info.RateLimitKbps,
+	}
+}
+
+func CreateRdsRouteSpecifier(routeConfigName string) *hcm.HttpConnectionManager_Rds {
+	return &hcm.HttpConnectionManager_Rds{
+		Rds: &hcm.Rds{
+			RouteConfigName: routeConfigName,
+			ConfigSource:    common.AdsConfigSource(),
+		},
+	}
 }
 
 func (info *HttpListenerConfigInfo) CreateRouteAction(cluster string) *route.RouteAction {
@@ -161,20 +198,83 @@ func (info *HttpListenerConfigInfo) CreateRouteAction(cluster string) *route.Rou
 	return routeAction
 }
 
+//Route action to endpoints of the subset, all endpoints if subset is empty or requests are not routed to subsets
+func (info *HttpListenerConfigInfo) CreateSubsetRouteAction(clusterName string, subset string) *route.RouteAction {
+	routeAction := info.CreateRouteAction(clusterName)
+	if info.SubsetKey != "" && subset != "" {
+		routeAction.MetadataMatch = cluster.SubsetMetadata(map[string]string{info.SubsetKey: subset})
+	}
+	return routeAction
+}
+
+//cookie header contains name=value, both are matched literally
+func cookieMatcher(name string, value string) *route.HeaderMatcher {
+	return &route.HeaderMatcher{
+		Name: "cookie",
+		HeaderMatchSpecifier: &route.HeaderMatcher_SafeRegexMatch{
+			SafeRegexMatch: &matcher.RegexMatcher{
+				EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+				Regex:      fmt.Sprintf(`(.*;\s*)?%s=%s(;.*)?`, regexp.QuoteMeta(name), regexp.QuoteMeta(value)),
+			},
+		},
+	}
+}
+
+func (info *HttpListenerConfigInfo) createSubsetMatch(subsetRoute *cluster.SubsetRouteInfo, match *route.RouteMatch) *route.RouteMatch {
+	result := &route.RouteMatch{PathSpecifier: match.PathSpecifier}
+	switch subsetRoute.Match {
+	case "header":
+		result.Headers = []*route.HeaderMatcher{{
+			Name:                 subsetRoute.Name,
+			HeaderMatchSpecifier: &route.HeaderMatcher_ExactMatch{ExactMatch: subsetRoute.Value},
+		}}
+	case "cookie":
+		result.Headers = []*route.HeaderMatcher{cookieMatcher(subsetRoute.Name, subsetRoute.Value)}
+	case "query":
+		result.QueryParameters = []*route.QueryParameterMatcher{{
+			Name: subsetRoute.Name,
+			QueryParameterMatchSpecifier: &route.QueryParameterMatcher_StringMatch{
+				StringMatch: &matcher.StringMatcher{
+					MatchPattern: &matcher.StringMatcher_Exact{Exact: subsetRoute.Value},
+				},
+			},
+		}}
+	}
+	return result
+}
+
+/**
+ * Routes of requests matching the path of match to the cluster.
+ * Each subset route is matched first by header, cookie or query parameter (only the path of match is kept),
+ * then other requests are routed to the default subset.
+ */
+func (info *HttpListenerConfigInfo) CreateRoutes(clusterName string, match *route.RouteMatch) []*route.Route {
+	var result []*route.Route
+	for _, subsetRoute := range info.SubsetRoutes {
+		result = append(result, &route.Route{
+			Match: info.createSubsetMatch(subsetRoute, match),
+			Action: &route.Route_Route{
+				Route: info.CreateSubsetRouteAction(clusterName, subsetRoute.Subset),
+			},
+		})
+	}
+	return append(result, &route.Route{
+		Match: match,
+		Action: &route.Route_Route{
+			Route: info.CreateSubsetRouteAction(clusterName, info.SubsetDefault),
+		},
+	})
+}
+
 func (info *HttpListenerConfigInfo) CreateVirtualHost(cluster string, domains []string) *route.VirtualHost {
 	return &route.VirtualHost{
 		Name:    fmt.Sprintf("%s_vh", cluster),
 		Domains: domains,
-		Routes: []*route.Route{{
-			Match: &route.RouteMatch{
-				PathSpecifier: &route.RouteMatch_Prefix{
-					Prefix: "/",
-				},
-			},
-			Action: &route.Route_Route{
-				Route: info.CreateRouteAction(cluster),
+		Routes: info.CreateRoutes(cluster, &route.RouteMatch{
+			PathSpecifier: &route.RouteMatch_Prefix{
+				Prefix: "/",
 			},
-		}},
+		}),
 	}
 }
 
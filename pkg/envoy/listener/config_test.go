@@ -0,0 +1,58 @@
+package listener
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDurationConfig(t *testing.T) {
+	var info HttpListenerConfigInfo
+	info.Config(map[string]string{
+		"traffic.request.timeout":  "250ms",
+		"traffic.fault.delay.time": "1m",
+		"traffic.hash.cookie.ttl":  "100",
+	})
+	assert.Equal(t, info.RequestTimeout.Seconds, int64(0))
+	assert.Equal(t, info.RequestTimeout.Nanos, int32(250000000))
+	assert.Equal(t, info.FaultInjectionFixDelay.Seconds, int64(60))
+	//bare integer of cookie ttl is seconds
+	assert.Equal(t, info.HashCookieTTL.Seconds, int64(100))
+
+	//bare integer of timeouts is nanoseconds
+	info = HttpListenerConfigInfo{}
+	info.Config(map[string]string{
+		"traffic.request.timeout": "1500000000",
+	})
+	assert.Equal(t, info.RequestTimeout.Seconds, int64(1))
+	assert.Equal(t, info.RequestTimeout.Nanos, int32(500000000))
+}
+
+func TestSubsetRoutes(t *testing.T) {
+	var info HttpListenerConfigInfo
+	info.Config(map[string]string{
+		"traffic.subset.reviews-v3.header.x-canary": "true",
+		"traffic.subset.reviews-v2.cookie.user":     "jason",
+		"traffic.subset.default":                    "reviews-v1",
+	})
+	assert.Equal(t, info.SubsetKey, "workload")
+
+	routes := info.CreateVirtualHost("test-cluster", []string{"*"}).Routes
+	assert.Equal(t, len(routes), 3)
+	//sorted by key, cookie route first
+	assert.Equal(t, routes[0].Match.Headers[0].Name, "cookie")
+	assert.Equal(t, routes[0].Match.Headers[0].GetSafeRegexMatch().Regex, `(.*;\s*)?user=jason(;.*)?`)
+	assert.Equal(t, routes[0].GetRoute().MetadataMatch.FilterMetadata["envoy.lb"].Fields["workload"].GetStringValue(), "reviews-v2")
+	assert.Equal(t, routes[1].Match.Headers[0].Name, "x-canary")
+	assert.Equal(t, routes[1].Match.Headers[0].GetExactMatch(), "true")
+	assert.Equal(t, routes[1].Match.GetPrefix(), "/")
+	assert.Equal(t, routes[1].GetRoute().MetadataMatch.FilterMetadata["envoy.lb"].Fields["workload"].GetStringValue(), "reviews-v3")
+	assert.Equal(t, len(routes[2].Match.Headers), 0)
+	assert.Equal(t, routes[2].GetRoute().MetadataMatch.FilterMetadata["envoy.lb"].Fields["workload"].GetStringValue(), "reviews-v1")
+
+	//without subset labels, requests are routed to all endpoints
+	info = HttpListenerConfigInfo{}
+	info.Config(map[string]string{"traffic.subset.key": "version"})
+	routes = info.CreateVirtualHost("test-cluster", []string{"*"}).Routes
+	assert.Equal(t, len(routes), 1)
+	assert.Nil(t, routes[0].GetRoute().MetadataMatch)
+}
@@ -1,7 +1,6 @@
 package ingress
 
 import (
-	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
 	auth "github.com/envoyproxy/go-control-plane/envoy/api/v2/auth"
 	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	listener "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
@@ -12,9 +11,10 @@ import (
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	trafficlistener "github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener"
 )
 
-func createFilters(virtualHosts []*route.VirtualHost, pathList []*IngressHttpInfo) []*listener.Filter {
+func createFilters(routeName string, pathList []*IngressHttpInfo) []*listener.Filter {
 	logAny, err := ptypes.MarshalAny(&accesslog.FileAccessLog{
 		Path: "/dev/stdout",
 		AccessLogFormat: &accesslog.FileAccessLog_Format{
@@ -32,14 +32,9 @@ func createFilters(virtualHosts []*route.VirtualHost, pathList []*IngressHttpInf
 				TypedConfig: logAny,
 			},
 		}},
-		CodecType:  hcm.HttpConnectionManager_AUTO,
-		StatPrefix: "traffic-ingress",
-		RouteSpecifier: &hcm.HttpConnectionManager_RouteConfig{
-			RouteConfig: &envoy_api_v2.RouteConfiguration{
-				Name:         "traffic-ingress",
-				VirtualHosts: virtualHosts,
-			},
-		},
+		CodecType:      hcm.HttpConnectionManager_AUTO,
+		StatPrefix:     "traffic-ingress",
+		RouteSpecifier: trafficlistener.CreateRdsRouteSpecifier(routeName),
 
 		HttpFilters: []*hcm.HttpFilter{{
 			Name: common.RouterHttpFilter,
@@ -73,16 +68,17 @@ func createFilters(virtualHosts []*route.VirtualHost, pathList []*IngressHttpInf
 
 }
 
-func CreateHttpFilterChain(pathList []*IngressHttpInfo) *listener.FilterChain {
+//Group routes by host into virtual hosts, the path list should be sorted
+func CreateVirtualHosts(pathList []*IngressHttpInfo) []*route.VirtualHost {
 	var virtualHosts []*route.VirtualHost
 	var routes []*route.Route
 	for index, info := range pathList {
-		if index > 0 && info.Path == pathList[index-1].Path && info.Host == pathList[index-1].Host {
+		if index > 0 && info.Path == pathList[index-1].Path && info.PathType == pathList[index-1].PathType && info.Host == pathList[index-1].Host {
 			//ignore same host and path
 			continue
 		}
 
-		routes = append(routes, info.CreateRoute())
+		routes = append(routes, info.CreateRoutes()...)
 		if index == len(pathList)-1 || info.Host != pathList[index+1].Host {
 			virtualHosts = append(virtualHosts, &route.VirtualHost{
 				Name:    IngressName(info.Host),
@@ -92,25 +88,19 @@ func CreateHttpFilterChain(pathList []*IngressHttpInfo) *listener.FilterChain {
 			routes = nil
 		}
 	}
+	return virtualHosts
+}
 
+func CreateHttpFilterChain(pathList []*IngressHttpInfo) *listener.FilterChain {
 	return &listener.FilterChain{
-		Filters: createFilters(virtualHosts, pathList),
+		Filters: createFilters(IngressRouteName, pathList),
 	}
 }
 
 func CreateTlsHttpFilterChain(host string, pathList []*IngressHttpInfo) *listener.FilterChain {
-	var routes []*route.Route
 	secrets := make(map[string]bool)
-
 	for _, info := range pathList {
-		routes = append(routes, info.CreateRoute())
 		secrets[info.Secret] = true
-
-	}
-	virtualHost := &route.VirtualHost{
-		Name:    IngressName(host),
-		Domains: []string{host},
-		Routes:  routes,
 	}
 
 	var sdsConfig []*auth.SdsSecretConfig
@@ -130,7 +120,7 @@ func CreateTlsHttpFilterChain(host string, pathList []*IngressHttpInfo) *listene
 			TransportProtocol: "tls",
 		},
 
-		Filters: createFilters([]*route.VirtualHost{virtualHost}, pathList),
+		Filters: createFilters(pathList[0].RouteName(), pathList),
 
 		TlsContext: &auth.DownstreamTlsContext{
 			CommonTlsContext: &auth.CommonTlsContext{
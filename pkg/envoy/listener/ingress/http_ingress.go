@@ -6,14 +6,20 @@ import (
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 	"sort"
 	"strings"
 )
 
+const (
+	IngressRouteName = "traffic-ingress"
+)
+
 type IngressHttpInfo struct {
 	listener.HttpListenerConfigInfo
 	Host      string
 	Path      string
+	PathType  string
 	Service   string
 	Namespace string
 	Port      uint32
@@ -24,6 +30,7 @@ func NewIngressHttpInfo(host string, path string, svc string, ns string, port ui
 	return &IngressHttpInfo{
 		Host:      host,
 		Path:      path,
+		PathType:  kubernetes.INGRESS_PATH_IMPLEMENTATION_SPECIFIC,
 		Service:   svc,
 		Namespace: ns,
 		Port:      port,
@@ -46,7 +53,15 @@ func (info *IngressHttpInfo) Name() string {
 	if info.Host == "*" {
 		fmt.Sprintf("http|all|%s", info.Path)
 	}
-	return fmt.Sprintf("http|%s|%s", info.Host, info.Path)
+	return fmt.Sprintf("http|%s|%s", info.Host, kubernetes.IngressPathConfig(info.PathType, info.Path))
+}
+
+//Name of the route configuration which contains this path
+func (info *IngressHttpInfo) RouteName() string {
+	if info.Secret != "" && info.Host != "*" {
+		return fmt.Sprintf("%s|%s", IngressRouteName, info.Host)
+	}
+	return IngressRouteName
 }
 
 func (info *IngressHttpInfo) Type() string {
@@ -57,19 +72,41 @@ func (info *IngressHttpInfo) String() string {
 	return info.Name()
 }
 
-func (info *IngressHttpInfo) CreateRoute() *route.Route {
-	routeAction := info.CreateRouteAction(info.GetCluster())
-	return &route.Route{
-		Match: &route.RouteMatch{
-			PathSpecifier: &route.RouteMatch_Prefix{
-				Prefix: info.Path,
-			},
-		},
-		Action: &route.Route_Route{
-			Route: routeAction,
+//subset routes of the service come before the route of the path itself
+func (info *IngressHttpInfo) createRoutes(match *route.RouteMatch) []*route.Route {
+	return info.HttpListenerConfigInfo.CreateRoutes(info.GetCluster(), match)
+}
+
+/**
+ * Exact path matches the path only. Prefix path matches by path elements, so /foo matches /foo and /foo/bar but not /foobar.
+ * ImplementationSpecific path is a plain string prefix.
+ */
+func (info *IngressHttpInfo) CreateRoutes() []*route.Route {
+	exact := &route.RouteMatch{
+		PathSpecifier: &route.RouteMatch_Path{
+			Path: info.Path,
 		},
 	}
+	switch info.PathType {
+	case kubernetes.INGRESS_PATH_EXACT:
+		return info.createRoutes(exact)
+	case kubernetes.INGRESS_PATH_PREFIX:
+		if !strings.HasSuffix(info.Path, "/") {
+			return append(info.createRoutes(exact),
+				info.createRoutes(&route.RouteMatch{
+					PathSpecifier: &route.RouteMatch_Prefix{
+						Prefix: info.Path + "/",
+					},
+				})...)
+		}
+	}
+	return info.createRoutes(&route.RouteMatch{
+		PathSpecifier: &route.RouteMatch_Prefix{
+			Prefix: info.Path,
+		},
+	})
 }
+
 func SortIngressHttpInfo(pathList []*IngressHttpInfo) {
 	sort.SliceStable(pathList, func(i, j int) bool {
 		a := pathList[i]
@@ -86,6 +123,10 @@ func SortIngressHttpInfo(pathList []*IngressHttpInfo) {
 
 			return a.Host > b.Host
 		}
-		return a.Path > b.Path
+		if a.Path != b.Path {
+			return a.Path > b.Path
+		}
+		//exact path first
+		return a.PathType == kubernetes.INGRESS_PATH_EXACT && b.PathType != kubernetes.INGRESS_PATH_EXACT
 	})
 }
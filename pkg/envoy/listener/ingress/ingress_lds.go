@@ -9,18 +9,26 @@ import (
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 )
 
+const (
+	IngressListenerName = "ingress_listener"
+)
+
 type IngressListenerInfo interface {
 	common.EnvoyResource
 }
 
 type IngressListenersControlPlaneService struct {
 	*common.ControlPlaneService
-	proxyPort  uint32
+	proxyPort uint32
+	//copies of delivered ingresses with resolved backend ports, used to remove the service annotations written for them
 	ingressMap map[string]*kubernetes.IngressInfo
+	//only ingresses of this class are served if not empty
+	ingressClass string
 }
 
 func NewIngressListenersControlPlaneService(k8sManager *kubernetes.K8sResourceManager) *IngressListenersControlPlaneService {
@@ -38,13 +46,16 @@ func NewIngressListenersControlPlaneService(k8sManager *kubernetes.K8sResourceMa
 		ControlPlaneService: common.NewControlPlaneService(k8sManager),
 		proxyPort:           uint32(proxyPort),
 		ingressMap:          make(map[string]*kubernetes.IngressInfo),
+		ingressClass:        os.Getenv("TRAFFIC_INGRESS_CLASS"),
 	}
-
+	result.SetAggregatedName(IngressListenerName)
+	result.SetMetricName("ingress-listeners")
 	return result
 }
 
+//With TRAFFIC_INGRESS_CLASS env, ingresses of other classes or without class are ignored
 func (cps *IngressListenersControlPlaneService) IngressValid(ingressInfo *kubernetes.IngressInfo) bool {
-	return true
+	return cps.ingressClass == "" || ingressInfo.Class == cps.ingressClass
 }
 
 func getNameAndNamespace(svc string, ns string) (string, string) {
@@ -57,31 +68,105 @@ func getNameAndNamespace(svc string, ns string) (string, string) {
 	return svc, ns
 }
 
+//Return the ingress paths configured in service annotations
+func GetIngressHttpInfos(svc *kubernetes.ServiceInfo) []*IngressHttpInfo {
+	var result []*IngressHttpInfo
+	for _, port := range svc.Ports {
+		configList := svc.Annotations[kubernetes.IngressAttrLabel(port.Port, "config")]
+		secret := svc.Annotations[kubernetes.IngressAttrLabel(port.Port, "secret")]
+		for _, config := range strings.Split(configList, ",") {
+			pathHost := strings.Split(config, "@")
+			if len(pathHost) != 2 {
+				continue
+			}
+			pathType, path := kubernetes.ParseIngressPathConfig(pathHost[0])
+			info := NewIngressHttpInfo(pathHost[1], path, svc.Name(), svc.Namespace(), port.Port)
+			info.PathType = pathType
+			info.Secret = secret
+			info.Config(svc.ConfigLabels())
+			result = append(result, info)
+		}
+	}
+	return result
+}
+
+func ingressKey(ingressInfo *kubernetes.IngressInfo) string {
+	return fmt.Sprintf("%s.%s", ingressInfo.Name(), ingressInfo.Namespace())
+}
+
 func (cps *IngressListenersControlPlaneService) IngressAdded(ingressInfo *kubernetes.IngressInfo) {
-	cps.ingressMap[fmt.Sprintf("%s.%s",ingressInfo.Name(), ingressInfo.Namespace())] = ingressInfo
+	ingressInfo = ingressInfo.Clone()
 	for _, hostInfo := range ingressInfo.HostPathToClusterMap {
 		for _, clusterInfo := range hostInfo.PathMap {
-			svc, ns := getNameAndNamespace(clusterInfo.Service, ingressInfo.Namespace())
+			if clusterInfo.PortName != "" {
+				svc, ns := getNameAndNamespace(clusterInfo.Service, ingressInfo.Namespace())
+				clusterInfo.ResolvedPort = cps.GetK8sManager().ServicePortByName(svc, ns, clusterInfo.PortName)
+			}
+		}
+	}
+	cps.ingressMap[ingressKey(ingressInfo)] = ingressInfo
+	cps.annotateServices(ingressInfo)
+}
+
+//return 0 if the backend port name is not resolved yet, the service will be annotated once it is added
+func backendPort(clusterInfo *kubernetes.IngressClusterInfo) uint32 {
+	if clusterInfo.PortName == "" {
+		return clusterInfo.Port
+	}
+	return clusterInfo.ResolvedPort
+}
 
-			cps.GetK8sManager().MergeServiceAnnotation(svc, ns, ingressInfo.GetServiceAnnotations(hostInfo, clusterInfo))
+func (cps *IngressListenersControlPlaneService) annotateServices(ingressInfo *kubernetes.IngressInfo) {
+	for _, hostInfo := range ingressInfo.HostPathToClusterMap {
+		for _, clusterInfo := range hostInfo.PathMap {
+			svc, ns := getNameAndNamespace(clusterInfo.Service, ingressInfo.Namespace())
+			port := backendPort(clusterInfo)
+			if port == 0 {
+				continue
+			}
+			cps.GetK8sManager().QueueMergeServiceAnnotation(svc, ns, ingressInfo.GetServiceAnnotations(hostInfo, clusterInfo, port))
 		}
 	}
 }
+
+//annotations are removed with the ports resolved when they were written, the service may be changed or deleted since then
 func (cps *IngressListenersControlPlaneService) IngressDeleted(ingressInfo *kubernetes.IngressInfo) {
-	delete(cps.ingressMap, fmt.Sprintf("%s.%s",ingressInfo.Name(), ingressInfo.Namespace()))
+	if added := cps.ingressMap[ingressKey(ingressInfo)]; added != nil {
+		ingressInfo = added
+	}
+	delete(cps.ingressMap, ingressKey(ingressInfo))
 	for _, hostInfo := range ingressInfo.HostPathToClusterMap {
 		for _, clusterInfo := range hostInfo.PathMap {
 			svc, ns := getNameAndNamespace(clusterInfo.Service, ingressInfo.Namespace())
-
-			cps.GetK8sManager().RemoveServiceAnnotation(svc, ns, ingressInfo.GetServiceAnnotations(hostInfo, clusterInfo))
+			port := backendPort(clusterInfo)
+			if port == 0 {
+				continue
+			}
+			cps.GetK8sManager().QueueRemoveServiceAnnotation(svc, ns, ingressInfo.GetServiceAnnotations(hostInfo, clusterInfo, port))
 		}
 	}
 }
 func (cps *IngressListenersControlPlaneService) IngressUpdated(oldIngress, newIngress *kubernetes.IngressInfo) {
+	if reflect.DeepEqual(oldIngress, newIngress) {
+		//periodic resync, only repair missing service annotations
+		if added := cps.ingressMap[ingressKey(newIngress)]; added != nil {
+			cps.annotateServices(added)
+		} else {
+			cps.IngressAdded(newIngress)
+		}
+		return
+	}
 	cps.IngressDeleted(oldIngress)
 	cps.IngressAdded(newIngress)
 }
 
+//service annotations may be skipped while another replica was the leader
+func (cps *IngressListenersControlPlaneService) StartedLeading() {
+	for _, ingressInfo := range cps.ingressMap {
+		cps.annotateServices(ingressInfo)
+	}
+}
+
 func (cps *IngressListenersControlPlaneService) ServiceValid(svc *kubernetes.ServiceInfo) bool {
 	return true
 }
@@ -91,46 +176,48 @@ func (cps *IngressListenersControlPlaneService) ServiceAdded(svc *kubernetes.Ser
 		for _, hostInfo := range ingressInfo.HostPathToClusterMap {
 			for _, clusterInfo := range hostInfo.PathMap {
 				name, ns := getNameAndNamespace(clusterInfo.Service, ingressInfo.Namespace())
-				if name == svc.Name() && ns ==svc.Namespace() {
-					cps.GetK8sManager().MergeServiceAnnotation(name, ns, ingressInfo.GetServiceAnnotations(hostInfo, clusterInfo))
+				if name != svc.Name() || ns != svc.Namespace() {
+					continue
+				}
+				if clusterInfo.PortName != "" {
+					port := svc.PortByName(clusterInfo.PortName)
+					if clusterInfo.ResolvedPort != 0 && clusterInfo.ResolvedPort != port {
+						//the port name refers another port now
+						cps.GetK8sManager().QueueRemoveServiceAnnotation(name, ns,
+							ingressInfo.GetServiceAnnotations(hostInfo, clusterInfo, clusterInfo.ResolvedPort))
+					}
+					clusterInfo.ResolvedPort = port
+				}
+				if port := backendPort(clusterInfo); port != 0 {
+					cps.GetK8sManager().QueueMergeServiceAnnotation(name, ns, ingressInfo.GetServiceAnnotations(hostInfo, clusterInfo, port))
 				}
 			}
 		}
 	}
-	for _, port := range svc.Ports {
-		configList := svc.Annotations[kubernetes.IngressAttrLabel(port.Port, "config")]
-		secret := svc.Annotations[kubernetes.IngressAttrLabel(port.Port, "secret")]
-		for _, config := range strings.Split(configList, ",") {
-			pathHost := strings.Split(config, "@")
-			if len(pathHost) != 2 {
-				continue
-			}
-			info := NewIngressHttpInfo(pathHost[1], pathHost[0], svc.Name(), svc.Namespace(), port.Port)
-			info.Secret = secret
-			info.Config(svc.Labels)
-			cps.UpdateResource(info, svc.ResourceVersion)
-		}
+	for _, info := range GetIngressHttpInfos(svc) {
+		//route config change should not update listener
+		info.HttpListenerConfigInfo = info.ConnectionManagerConfig()
+		cps.UpdateResource(info, svc.ResourceVersion)
 	}
 
 }
 
 func (cps *IngressListenersControlPlaneService) ServiceDeleted(svc *kubernetes.ServiceInfo) {
-
-	for _, port := range svc.Ports {
-		configList := svc.Annotations[kubernetes.IngressAttrLabel(port.Port, "config")]
-		for _, config := range strings.Split(configList, ",") {
-			pathHost := strings.Split(config, "@")
-			if len(pathHost) != 2 {
-				continue
-			}
-
-			info := NewIngressHttpInfo(pathHost[1], pathHost[0], svc.Name(), svc.Namespace(), port.Port)
-			cps.UpdateResource(info, "")
-		}
+	for _, info := range GetIngressHttpInfos(svc) {
+		cps.UpdateResource(info, "")
 	}
 }
 func (cps *IngressListenersControlPlaneService) ServiceUpdated(oldService, newService *kubernetes.ServiceInfo) {
-	cps.ServiceDeleted(oldService)
+	//only remove the paths which no longer exist, so that listener is not changed by route config
+	visited := make(map[string]bool)
+	for _, info := range GetIngressHttpInfos(newService) {
+		visited[info.Name()] = true
+	}
+	for _, info := range GetIngressHttpInfos(oldService) {
+		if !visited[info.Name()] {
+			cps.UpdateResource(info, "")
+		}
+	}
 	cps.ServiceAdded(newService)
 }
 
@@ -168,7 +255,7 @@ func (cps *IngressListenersControlPlaneService) BuildResource(resourceMap map[st
 	}
 
 	l := &envoy_api_v2.Listener{
-		Name: "ingress_listener",
+		Name: IngressListenerName,
 		Address: &core.Address{
 			Address: &core.Address_SocketAddress{
 				SocketAddress: &core.SocketAddress{
@@ -0,0 +1,69 @@
+package ingress
+
+import (
+	"context"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIngressNamedPortDeleted(t *testing.T) {
+	os.Setenv("ENVOY_PROXY_PORT", "10000")
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	ilds := NewIngressListenersControlPlaneService(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+
+	serviceWatchlist := k8sManager.GetListerWatcher("services")
+	ingressWatchlist := k8sManager.GetListerWatcher("ingresses")
+	go k8sManager.WatchServices(stopper, k8sManager, ilds)
+	go k8sManager.WatchIngresss(stopper, ilds)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "svc1"
+	service.Spec.Ports = []corev1.ServicePort{{Name: "web", Port: 8080}}
+	k8sManager.ClientSet.CoreV1().Services("test-ns").Create(context.TODO(), &service, metav1.CreateOptions{})
+	serviceWatchlist.Add(&service)
+	time.Sleep(time.Second)
+
+	var ingress networkingv1.Ingress
+	ingress.Namespace = "test-ns"
+	ingress.Name = "ingress1"
+	ingress.Spec.Rules = []networkingv1.IngressRule{{
+		Host: "www.test.com",
+		IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{
+					Path: "/test",
+					Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{
+							Name: "svc1",
+							Port: networkingv1.ServiceBackendPort{Name: "web"},
+						},
+					},
+				}},
+			},
+		},
+	}}
+	ingressWatchlist.Add(&ingress)
+	time.Sleep(time.Second)
+
+	svc, _ := k8sManager.ClientSet.CoreV1().Services("test-ns").Get(context.TODO(), "svc1", metav1.GetOptions{})
+	assert.Equal(t, svc.Annotations[kubernetes.IngressAttrLabel(8080, "config")], "/test@www.test.com")
+
+	//the port name can not be resolved from cache any more, annotations are removed with the port resolved when written
+	serviceWatchlist.Delete(&service)
+	time.Sleep(time.Second)
+	ingressWatchlist.Delete(&ingress)
+	time.Sleep(time.Second)
+
+	svc, _ = k8sManager.ClientSet.CoreV1().Services("test-ns").Get(context.TODO(), "svc1", metav1.GetOptions{})
+	assert.Equal(t, svc.Annotations[kubernetes.IngressAttrLabel(8080, "config")], "")
+}
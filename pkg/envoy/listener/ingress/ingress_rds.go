@@ -0,0 +1,117 @@
+package ingress
+
+import (
+	"fmt"
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/gogo/protobuf/proto"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+)
+
+//Route configuration referenced by one filter chain of ingress listener
+type IngressRouteInfo struct {
+	name     string
+	PathList []*IngressHttpInfo
+}
+
+func (info *IngressRouteInfo) Name() string {
+	return info.name
+}
+
+func (info *IngressRouteInfo) Type() string {
+	return common.RouteResource
+}
+
+func (info *IngressRouteInfo) String() string {
+	return fmt.Sprintf("%s, paths=%d", info.name, len(info.PathList))
+}
+
+func (info *IngressRouteInfo) CreateRouteConfiguration() *envoy_api_v2.RouteConfiguration {
+	return &envoy_api_v2.RouteConfiguration{
+		Name:         info.name,
+		VirtualHosts: CreateVirtualHosts(info.PathList),
+	}
+}
+
+type IngressRoutesControlPlaneService struct {
+	*common.ControlPlaneService
+	pathMap        map[string]*IngressHttpInfo
+	pathVersionMap map[string]string
+	routeNames     map[string]bool
+}
+
+func NewIngressRoutesControlPlaneService(k8sManager *kubernetes.K8sResourceManager) *IngressRoutesControlPlaneService {
+	result := &IngressRoutesControlPlaneService{
+		ControlPlaneService: common.NewControlPlaneService(k8sManager),
+		pathMap:             make(map[string]*IngressHttpInfo),
+		pathVersionMap:      make(map[string]string),
+		routeNames:          make(map[string]bool),
+	}
+	result.SetMetricName("ingress-routes")
+	return result
+}
+
+func (cps *IngressRoutesControlPlaneService) ServiceValid(svc *kubernetes.ServiceInfo) bool {
+	return true
+}
+
+func (cps *IngressRoutesControlPlaneService) ServiceAdded(svc *kubernetes.ServiceInfo) {
+	for _, info := range GetIngressHttpInfos(svc) {
+		cps.pathMap[info.Name()] = info
+		cps.pathVersionMap[info.Name()] = svc.ResourceVersion
+	}
+	cps.updateRoutes()
+}
+
+func (cps *IngressRoutesControlPlaneService) ServiceDeleted(svc *kubernetes.ServiceInfo) {
+	for _, info := range GetIngressHttpInfos(svc) {
+		delete(cps.pathMap, info.Name())
+		delete(cps.pathVersionMap, info.Name())
+	}
+	cps.updateRoutes()
+}
+
+func (cps *IngressRoutesControlPlaneService) ServiceUpdated(oldService, newService *kubernetes.ServiceInfo) {
+	for _, info := range GetIngressHttpInfos(oldService) {
+		delete(cps.pathMap, info.Name())
+		delete(cps.pathVersionMap, info.Name())
+	}
+	cps.ServiceAdded(newService)
+}
+
+//Group the paths into route configurations and update the changed ones
+func (cps *IngressRoutesControlPlaneService) updateRoutes() {
+	routes := make(map[string]*IngressRouteInfo)
+	versions := make(map[string][]string)
+	for name, info := range cps.pathMap {
+		routeName := info.RouteName()
+		routeInfo := routes[routeName]
+		if routeInfo == nil {
+			routeInfo = &IngressRouteInfo{name: routeName}
+			routes[routeName] = routeInfo
+		}
+		routeInfo.PathList = append(routeInfo.PathList, info)
+		versions[routeName] = append(versions[routeName], fmt.Sprintf("%s=%s", name, cps.pathVersionMap[name]))
+	}
+
+	for routeName := range cps.routeNames {
+		if routes[routeName] == nil {
+			cps.UpdateResource(&IngressRouteInfo{name: routeName}, "")
+			delete(cps.routeNames, routeName)
+		}
+	}
+	for routeName, routeInfo := range routes {
+		SortIngressHttpInfo(routeInfo.PathList)
+		cps.UpdateResource(routeInfo, common.AggregateVersion(versions[routeName]))
+		cps.routeNames[routeName] = true
+	}
+}
+
+func (cps *IngressRoutesControlPlaneService) BuildResource(resourceMap map[string]common.EnvoyResource, version string, node *core.Node) (*envoy_api_v2.DiscoveryResponse, error) {
+	var routes []proto.Message
+	for _, resource := range resourceMap {
+		routes = append(routes, resource.(*IngressRouteInfo).CreateRouteConfiguration())
+	}
+	return common.MakeResource(routes, common.RouteResource, version)
+}
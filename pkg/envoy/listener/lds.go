@@ -13,6 +13,10 @@ import (
 	"strconv"
 )
 
+const (
+	ListenerName = "mylistener"
+)
+
 type ListenerInfo interface {
 	common.EnvoyResource
 	CreateFilterChain(node *core.Node) (*listener.FilterChain, error)
@@ -34,10 +38,13 @@ func NewListenersControlPlaneService(k8sManager *kubernetes.K8sResourceManager)
 	if err != nil {
 		panic("wrong ENVOY_PROXY_PORT value:" + err.Error())
 	}
-	return &ListenersControlPlaneService{
+	result := &ListenersControlPlaneService{
 		ControlPlaneService: common.NewControlPlaneService(k8sManager),
 		proxyPort:           uint32(proxyPort),
 	}
+	result.SetAggregatedName(ListenerName)
+	result.SetMetricName("listeners")
+	return result
 
 }
 
@@ -50,7 +57,9 @@ func (cps *ListenersControlPlaneService) ServiceAdded(svc *kubernetes.ServiceInf
 		protocol := svc.Protocol(port.Port)
 		if protocol == kubernetes.PROTO_HTTP {
 			info := NewHttpClusterIpFilterInfo(svc, port.Port)
-			info.Config(svc.Labels)
+			info.Config(svc.ConfigLabels())
+			//route config change should not update listener
+			info.HttpListenerConfigInfo = info.ConnectionManagerConfig()
 			cps.UpdateResource(info, svc.ResourceVersion)
 		} else if protocol >= 0 {
 			info := NewClusterIpFilterInfo(svc, port.Port)
@@ -96,6 +105,7 @@ func (cps *ListenersControlPlaneService) PodUpdated(oldPod, newPod *kubernetes.P
 			if portInfo.Protocol == kubernetes.PROTO_HTTP {
 				info := NewHttpPodIpFilterInfo(newPod, port)
 				info.Config(portInfo.ConfigMap)
+				info.HttpListenerConfigInfo = info.ConnectionManagerConfig()
 				visited[info.Name()] = true
 				cps.UpdateResource(info, newPod.ResourceVersion)
 			} else if portInfo.Protocol >= 0 {
@@ -142,7 +152,7 @@ func (cps *ListenersControlPlaneService) BuildResource(resourceMap map[string]co
 	}
 
 	l := &envoy_api_v2.Listener{
-		Name: "mylistener",
+		Name: ListenerName,
 		Address: &core.Address{
 			Address: &core.Address_SocketAddress{
 				SocketAddress: &core.SocketAddress{
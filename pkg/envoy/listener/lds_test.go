@@ -4,6 +4,7 @@ import (
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"os"
 	"testing"
 	"time"
@@ -20,14 +21,19 @@ func TestPodFilter(t *testing.T) {
 	podWatchlist := k8sManager.GetListerWatcher("pods")
 
 	go k8sManager.WatchPods(stopper, k8sManager, lds)
+	go k8sManager.WatchServices(stopper, k8sManager)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Labels = map[string]string{"traffic.port.8080": "http"}
+	service.Spec.Selector = map[string]string{"c": "d"}
+	service.Name = "Service1"
+	service.Spec.Ports = []corev1.ServicePort{{Name: "test", Port: 8080, TargetPort: intstr.FromInt(8080)}}
+	k8sManager.GetListerWatcher("services").Add(&service)
 
 	var pod corev1.Pod
 	pod.Namespace = "test-ns"
 	pod.Labels = map[string]string{"traffic.envoy.enabled": "true", "c": "d"}
-	pod.Annotations = map[string]string{
-		"traffic.svc.Service1.port.8080":        "http",
-		"traffic.svc.Service1.target.port.8080": "http",
-	}
 	pod.Status.PodIP = "10.1.1.1"
 	pod.Name = "Comp1-pod"
 	podWatchlist.Add(&pod)
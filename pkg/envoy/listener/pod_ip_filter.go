@@ -15,16 +15,22 @@ import (
 
 //listener filter for local pod or outbound listener filter for headless service pod
 type PodIpFilterInfo struct {
-	podIP string
-	node  string
-	port  uint32
+	podIP      string
+	node       string
+	port       uint32
+	visibility string
+	//whether any service targets the port, otherwise only the pod itself can see the filter
+	exposed bool
 }
 
 func NewPodIpFilterInfo(pod *kubernetes.PodInfo, port uint32) *PodIpFilterInfo {
+	visibility, exposed := pod.TargetPortVisibility(port)
 	return &PodIpFilterInfo{
-		port:  port,
-		podIP: pod.PodIP,
-		node:  fmt.Sprintf("%s.%s", pod.Name(), pod.Namespace()),
+		port:       port,
+		podIP:      pod.PodIP,
+		node:       fmt.Sprintf("%s.%s", pod.Name(), pod.Namespace()),
+		visibility: visibility,
+		exposed:    exposed,
 	}
 }
 
@@ -32,6 +38,17 @@ func (info *PodIpFilterInfo) String() string {
 	return fmt.Sprintf("%s:%d", info.node, info.port)
 }
 
+//inbound listener of the pod itself, or outbound listener for pods which can access its services targeting the port
+func (info *PodIpFilterInfo) VisibleTo(nodeId string) bool {
+	if nodeId == info.node {
+		return true
+	}
+	if !info.exposed {
+		return false
+	}
+	return kubernetes.IsVisible(info.visibility, kubernetes.NodeNamespace(info.node), kubernetes.NodeNamespace(nodeId))
+}
+
 func (info *PodIpFilterInfo) Type() string {
 	return common.ListenerResource
 }
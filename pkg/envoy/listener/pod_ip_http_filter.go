@@ -67,17 +67,19 @@ func (info *HttpPodIpFilterInfo) CreateVirtualHosts(nodeId string) []*route.Virt
 	return virtualHosts
 }
 
+func (info *HttpPodIpFilterInfo) CreateRouteConfiguration(node *core.Node) *envoy_api_v2.RouteConfiguration {
+	return &envoy_api_v2.RouteConfiguration{
+		Name:         info.Name(),
+		VirtualHosts: info.CreateVirtualHosts(node.Id),
+	}
+}
+
 func (info *HttpPodIpFilterInfo) CreateFilterChain(node *core.Node) (*listener.FilterChain, error) {
 
 	manager := &hcm.HttpConnectionManager{
-		CodecType:  hcm.HttpConnectionManager_AUTO,
-		StatPrefix: info.Name(),
-		RouteSpecifier: &hcm.HttpConnectionManager_RouteConfig{
-			RouteConfig: &envoy_api_v2.RouteConfiguration{
-				Name:         info.Name(),
-				VirtualHosts: info.CreateVirtualHosts(node.Id),
-			},
-		},
+		CodecType:      hcm.HttpConnectionManager_AUTO,
+		StatPrefix:     info.Name(),
+		RouteSpecifier: CreateRdsRouteSpecifier(info.Name()),
 	}
 	info.ConfigConnectionManager(manager)
 
@@ -0,0 +1,102 @@
+package listener
+
+import (
+	"github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	"github.com/gogo/protobuf/proto"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"reflect"
+)
+
+type RouteInfo interface {
+	common.EnvoyResource
+	CreateRouteConfiguration(node *core.Node) *envoy_api_v2.RouteConfiguration
+}
+
+//Serve the route configurations referenced by http filter chains of ListenersControlPlaneService
+type RoutesControlPlaneService struct {
+	*common.ControlPlaneService
+}
+
+func NewRoutesControlPlaneService(k8sManager *kubernetes.K8sResourceManager) *RoutesControlPlaneService {
+	result := &RoutesControlPlaneService{
+		ControlPlaneService: common.NewControlPlaneService(k8sManager),
+	}
+	result.SetMetricName("routes")
+	return result
+}
+
+func (cps *RoutesControlPlaneService) ServiceValid(svc *kubernetes.ServiceInfo) bool {
+	return true
+}
+
+func (cps *RoutesControlPlaneService) ServiceAdded(svc *kubernetes.ServiceInfo) {
+	for _, port := range svc.Ports {
+		if svc.Protocol(port.Port) == kubernetes.PROTO_HTTP {
+			info := NewHttpClusterIpFilterInfo(svc, port.Port)
+			info.Config(svc.ConfigLabels())
+			cps.UpdateResource(info, svc.ResourceVersion)
+		}
+	}
+}
+func (cps *RoutesControlPlaneService) ServiceDeleted(svc *kubernetes.ServiceInfo) {
+	for _, port := range svc.Ports {
+		info := NewHttpClusterIpFilterInfo(svc, port.Port)
+		cps.UpdateResource(info, "")
+	}
+}
+func (cps *RoutesControlPlaneService) ServiceUpdated(oldService, newService *kubernetes.ServiceInfo) {
+	if !reflect.DeepEqual(oldService.Ports, newService.Ports) || !reflect.DeepEqual(oldService.Labels, newService.Labels) {
+		//protocol of the port may be changed
+		cps.ServiceDeleted(oldService)
+	}
+	cps.ServiceAdded(newService)
+}
+
+func (cps *RoutesControlPlaneService) PodValid(pod *kubernetes.PodInfo) bool {
+	return pod.Valid()
+}
+
+func (cps *RoutesControlPlaneService) PodAdded(pod *kubernetes.PodInfo) {
+	cps.PodUpdated(nil, pod)
+}
+func (cps *RoutesControlPlaneService) PodDeleted(pod *kubernetes.PodInfo) {
+	cps.PodUpdated(pod, nil)
+}
+
+func (cps *RoutesControlPlaneService) PodUpdated(oldPod, newPod *kubernetes.PodInfo) {
+	visited := make(map[string]bool)
+
+	if newPod != nil {
+		for port, portInfo := range newPod.GetTargetPortConfig() {
+			if portInfo.Protocol == kubernetes.PROTO_HTTP {
+				info := NewHttpPodIpFilterInfo(newPod, port)
+				info.Config(portInfo.ConfigMap)
+				visited[info.Name()] = true
+				cps.UpdateResource(info, newPod.ResourceVersion)
+			}
+		}
+	}
+	if oldPod != nil {
+		for port, _ := range oldPod.GetTargetPortConfig() {
+			info := NewHttpPodIpFilterInfo(oldPod, port)
+			if visited[info.Name()] {
+				continue
+			}
+			cps.UpdateResource(info, "")
+		}
+	}
+}
+
+func (cps *RoutesControlPlaneService) BuildResource(resourceMap map[string]common.EnvoyResource, version string, node *core.Node) (*envoy_api_v2.DiscoveryResponse, error) {
+	var routes []proto.Message
+	for _, resource := range resourceMap {
+		routeConfig := resource.(RouteInfo).CreateRouteConfiguration(node)
+		if routeConfig == nil {
+			continue
+		}
+		routes = append(routes, routeConfig)
+	}
+	return common.MakeResource(routes, common.RouteResource, version)
+}
@@ -0,0 +1,60 @@
+package listener
+
+import (
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestServiceRoute(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	os.Setenv("ENVOY_PROXY_PORT", "10000")
+	lds := NewListenersControlPlaneService(k8sManager)
+	rds := NewRoutesControlPlaneService(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+
+	serviceWatchlist := k8sManager.GetListerWatcher("services")
+	go k8sManager.WatchServices(stopper, k8sManager, lds, rds)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Labels = map[string]string{"traffic.port.8080": "http"}
+	service.Spec.Selector = map[string]string{"c": "d"}
+	service.Spec.ClusterIP = "10.0.0.1"
+	service.Name = "Service1"
+	service.ResourceVersion = "1"
+	service.Spec.Ports = []corev1.ServicePort{{Name: "test", Port: 8080}}
+	serviceWatchlist.Add(&service)
+
+	time.Sleep(time.Second)
+
+	k8sManager.Lock()
+	routes, routeVersion := rds.GetResources([]string{"8080|test-ns|Service1.outbound"})
+	_, listenerVersion := lds.GetResources(nil)
+	k8sManager.Unlock()
+	assert.Equal(t, len(routes), 1)
+
+	//route config change should not update listener
+	updated := service.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Labels = map[string]string{"traffic.port.8080": "http", "traffic.retries.5xx": "3"}
+	serviceWatchlist.Modify(updated)
+
+	time.Sleep(time.Second)
+
+	k8sManager.Lock()
+	routes, newRouteVersion := rds.GetResources([]string{"8080|test-ns|Service1.outbound"})
+	_, newListenerVersion := lds.GetResources(nil)
+	k8sManager.Unlock()
+
+	assert.Equal(t, len(routes), 1)
+	assert.NotEqual(t, routeVersion, newRouteVersion)
+	assert.Equal(t, listenerVersion, newListenerVersion)
+	info := routes["8080|test-ns|Service1.outbound"].(*HttpClusterIpFilterInfo)
+	assert.Equal(t, info.RetryOn, "5xx")
+}
@@ -7,6 +7,7 @@ import (
 	core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/gogo/protobuf/proto"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener/ingress"
 	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
 )
 
@@ -15,6 +16,8 @@ type SecretResourceInfo struct {
 	key       []byte
 	name      string
 	namespace string
+	//shared reference count of secrets
+	references map[string]int
 }
 
 func (info *SecretResourceInfo) Name() string {
@@ -25,16 +28,30 @@ func (info *SecretResourceInfo) Type() string {
 	return common.SecretResource
 }
 
+//only ingress tls filter chains reference secrets
+func (info *SecretResourceInfo) VisibleTo(nodeId string) bool {
+	return nodeId == IngressNodeId && info.references[info.Name()] > 0
+}
+
 func (info *SecretResourceInfo) String() string {
 	return fmt.Sprintf("secret %s.%s", info.name, info.namespace)
 }
 
 type SecretsControlPlaneService struct {
 	*common.ControlPlaneService
+	//secrets referenced by ingress of each service
+	serviceSecrets map[string]map[string]bool
+	references     map[string]int
 }
 
 func NewSecretsControlPlaneService(k8sManager *kubernetes.K8sResourceManager) *SecretsControlPlaneService {
-	return &SecretsControlPlaneService{ControlPlaneService: common.NewControlPlaneService(k8sManager)}
+	result := &SecretsControlPlaneService{
+		ControlPlaneService: common.NewControlPlaneService(k8sManager),
+		serviceSecrets:      make(map[string]map[string]bool),
+		references:          make(map[string]int),
+	}
+	result.SetMetricName("secrets")
+	return result
 }
 
 func (*SecretsControlPlaneService) SecretValid(info *kubernetes.SecretInfo) bool {
@@ -43,17 +60,19 @@ func (*SecretsControlPlaneService) SecretValid(info *kubernetes.SecretInfo) bool
 
 func (sds *SecretsControlPlaneService) SecretAdded(info *kubernetes.SecretInfo) {
 	sds.UpdateResource(&SecretResourceInfo{
-		name:      info.Name,
-		namespace: info.Namespace,
-		cert:      info.Data["tls.crt"],
-		key:       info.Data["tls.key"],
+		name:       info.Name,
+		namespace:  info.Namespace,
+		cert:       info.Data["tls.crt"],
+		key:        info.Data["tls.key"],
+		references: sds.references,
 	}, info.ResourceVersion)
 }
 
 func (sds *SecretsControlPlaneService) SecretDeleted(info *kubernetes.SecretInfo) {
 	sds.UpdateResource(&SecretResourceInfo{
-		name:      info.Name,
-		namespace: info.Namespace,
+		name:       info.Name,
+		namespace:  info.Namespace,
+		references: sds.references,
 	}, "")
 }
 
@@ -61,6 +80,58 @@ func (sds *SecretsControlPlaneService) SecretUpdated(oldSecret, newSecret *kuber
 	sds.SecretAdded(newSecret)
 }
 
+func (sds *SecretsControlPlaneService) ServiceValid(svc *kubernetes.ServiceInfo) bool {
+	return true
+}
+
+//same condition as the tls filter chains created by ingress lds
+func ingressSecrets(svc *kubernetes.ServiceInfo) map[string]bool {
+	result := make(map[string]bool)
+	for _, info := range ingress.GetIngressHttpInfos(svc) {
+		if info.Secret != "" && info.Host != "*" {
+			result[info.Secret] = true
+		}
+	}
+	return result
+}
+
+func (sds *SecretsControlPlaneService) updateServiceSecrets(svc *kubernetes.ServiceInfo, secrets map[string]bool) {
+	key := fmt.Sprintf("%s.%s", svc.Name(), svc.Namespace())
+	oldSecrets := sds.serviceSecrets[key]
+	for secret, _ := range oldSecrets {
+		if !secrets[secret] {
+			sds.references[secret]--
+			if sds.references[secret] <= 0 {
+				delete(sds.references, secret)
+			}
+			sds.RefreshVisibility(secret)
+		}
+	}
+	for secret, _ := range secrets {
+		if !oldSecrets[secret] {
+			sds.references[secret]++
+			sds.RefreshVisibility(secret)
+		}
+	}
+	if len(secrets) == 0 {
+		delete(sds.serviceSecrets, key)
+	} else {
+		sds.serviceSecrets[key] = secrets
+	}
+}
+
+func (sds *SecretsControlPlaneService) ServiceAdded(svc *kubernetes.ServiceInfo) {
+	sds.updateServiceSecrets(svc, ingressSecrets(svc))
+}
+
+func (sds *SecretsControlPlaneService) ServiceDeleted(svc *kubernetes.ServiceInfo) {
+	sds.updateServiceSecrets(svc, nil)
+}
+
+func (sds *SecretsControlPlaneService) ServiceUpdated(oldService, newService *kubernetes.ServiceInfo) {
+	sds.ServiceAdded(newService)
+}
+
 func (sds *SecretsControlPlaneService) BuildResource(resourceMap map[string]common.EnvoyResource, version string, node *core.Node) (*envoy_api_v2.DiscoveryResponse, error) {
 	var secrets []proto.Message
 
@@ -87,3 +158,14 @@ func (sds *SecretsControlPlaneService) BuildResource(resourceMap map[string]comm
 
 	return common.MakeResource(secrets, common.SecretResource, version)
 }
+
+//Same as BuildResource but private keys are replaced, used by debug server
+func (sds *SecretsControlPlaneService) BuildRedactedResource(resourceMap map[string]common.EnvoyResource, version string, node *core.Node) (*envoy_api_v2.DiscoveryResponse, error) {
+	redacted := make(map[string]common.EnvoyResource)
+	for name, resource := range resourceMap {
+		info := *resource.(*SecretResourceInfo)
+		info.key = []byte("REDACTED")
+		redacted[name] = &info
+	}
+	return sds.BuildResource(redacted, version, node)
+}
@@ -0,0 +1,62 @@
+package envoy
+
+import (
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+	"time"
+)
+
+func TestSecretReference(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	sds := NewSecretsControlPlaneService(k8sManager)
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+
+	serviceWatchlist := k8sManager.GetListerWatcher("services")
+	go k8sManager.WatchServices(stopper, sds)
+
+	k8sManager.Lock()
+	sds.SecretAdded(&kubernetes.SecretInfo{
+		Name:            "tls-secret",
+		Namespace:       "test-ns",
+		Data:            map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+		ResourceVersion: "1",
+	})
+	secrets, _ := sds.GetNodeResources(IngressNodeId, nil)
+	k8sManager.Unlock()
+	assert.Equal(t, len(secrets), 0)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Annotations = map[string]string{
+		kubernetes.IngressAttrLabel(80, "config"): "/test@www.test.com",
+		kubernetes.IngressAttrLabel(80, "secret"): "tls-secret.test-ns",
+	}
+	service.Spec.Selector = map[string]string{"c": "d"}
+	service.Spec.ClusterIP = "10.0.0.1"
+	service.Name = "Service1"
+	service.ResourceVersion = "1"
+	service.Spec.Ports = []corev1.ServicePort{{Name: "test", Port: 80}}
+	serviceWatchlist.Add(&service)
+
+	time.Sleep(time.Second)
+
+	k8sManager.Lock()
+	secrets, _ = sds.GetNodeResources(IngressNodeId, nil)
+	podSecrets, _ := sds.GetNodeResources("test-pod.test-ns", nil)
+	k8sManager.Unlock()
+	assert.Equal(t, len(secrets), 1)
+	assert.NotNil(t, secrets["tls-secret.test-ns"])
+	assert.Equal(t, len(podSecrets), 0)
+
+	serviceWatchlist.Delete(&service)
+	time.Sleep(time.Second)
+
+	k8sManager.Lock()
+	secrets, _ = sds.GetNodeResources(IngressNodeId, nil)
+	k8sManager.Unlock()
+	assert.Equal(t, len(secrets), 0)
+}
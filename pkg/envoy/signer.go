@@ -0,0 +1,137 @@
+package envoy
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	ENVOY_MANAGER_SERVICE_ACCOUNT_ENV     = "ENVOY_MANAGER_SERVICE_ACCOUNT"
+	DEFAULT_ENVOY_MANAGER_SERVICE_ACCOUNT = "traffic-envoy-manager"
+
+	maxCertificateRequestSize = 64 * 1024
+)
+
+/**
+ * Sign client certificates of envoy proxies requested by envoy-manager, so that the root key is only loaded by traffic-control.
+ * envoy-manager posts a pem certificate request with its service account token as bearer token.
+ * A request is signed if the token belongs to envoy-manager service account, and its common name is the node id
+ * of an envoy enabled pod running on the host of the request's source address (envoy-manager uses host network).
+ */
+type NodeCertificateSigner struct {
+	k8sManager    *kubernetes.K8sResourceManager
+	secretManager *common.SecretManager
+	requester     string
+	podMap        map[string]*kubernetes.PodInfo
+}
+
+func NewNodeCertificateSigner(k8sManager *kubernetes.K8sResourceManager, secretManager *common.SecretManager) *NodeCertificateSigner {
+	serviceAccount := os.Getenv(ENVOY_MANAGER_SERVICE_ACCOUNT_ENV)
+	if serviceAccount == "" {
+		serviceAccount = DEFAULT_ENVOY_MANAGER_SERVICE_ACCOUNT
+	}
+	return &NodeCertificateSigner{
+		k8sManager:    k8sManager,
+		secretManager: secretManager,
+		requester:     fmt.Sprintf("system:serviceaccount:%s:%s", common.ControlPlaneNamespace(), serviceAccount),
+		podMap:        make(map[string]*kubernetes.PodInfo),
+	}
+}
+
+func (signer *NodeCertificateSigner) PodValid(pod *kubernetes.PodInfo) bool {
+	return pod.Valid()
+}
+
+func (signer *NodeCertificateSigner) PodAdded(pod *kubernetes.PodInfo) {
+	signer.podMap[pod.NodeId()] = pod
+}
+
+func (signer *NodeCertificateSigner) PodDeleted(pod *kubernetes.PodInfo) {
+	delete(signer.podMap, pod.NodeId())
+}
+
+func (signer *NodeCertificateSigner) PodUpdated(oldPod, newPod *kubernetes.PodInfo) {
+	signer.PodAdded(newPod)
+}
+
+//host ip of the envoy enabled pod of node id
+func (signer *NodeCertificateSigner) hostIp(nodeId string) (string, error) {
+	signer.k8sManager.Lock()
+	defer signer.k8sManager.Unlock()
+
+	pod := signer.podMap[nodeId]
+	if pod == nil || !pod.EnvoyEnabled() {
+		return "", fmt.Errorf("Pod of node %s does not exist or is not envoy enabled", nodeId)
+	}
+	return pod.HostIP, nil
+}
+
+func (signer *NodeCertificateSigner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	status, err := signer.authorize(r)
+	if err != nil {
+		glog.Warningf("Deny certificate request from %s: %s", r.RemoteAddr, err.Error())
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCertificateRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request, err := common.ParseCertificateRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nodeId := request.Subject.CommonName
+	hostIp, err := signer.hostIp(nodeId)
+	if err != nil {
+		//pod may not be delivered to traffic-control yet, envoy-manager retries
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	peerIp, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil || peerIp != hostIp {
+		message := fmt.Sprintf("Pod of node %s runs on host %s, but request comes from %s", nodeId, hostIp, r.RemoteAddr)
+		glog.Warningf("Deny certificate request: %s", message)
+		http.Error(w, message, http.StatusForbidden)
+		return
+	}
+
+	chain, err := signer.secretManager.SignNodeRequest(request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Issued client certificate of %s for %s", nodeId, r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Write(chain)
+}
+
+//check bearer token of the request, return http status if failed
+func (signer *NodeCertificateSigner) authorize(r *http.Request) (int, error) {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return http.StatusUnauthorized, fmt.Errorf("Missing bearer token")
+	}
+	username, err := signer.k8sManager.ReviewToken(strings.TrimPrefix(authorization, "Bearer "))
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	if username != signer.requester {
+		return http.StatusForbidden, fmt.Errorf("Requested by %s instead of %s", username, signer.requester)
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,85 @@
+package envoy
+
+import (
+	"bytes"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNodeCertificateSigner(t *testing.T) {
+	k8sManager := kubernetes.NewFakeK8sResourceManager()
+	secretManager, err := common.NewSecretManager()
+	assert.Nil(t, err)
+	signer := NewNodeCertificateSigner(k8sManager, secretManager)
+
+	//token is the username
+	k8sManager.ClientSet.(*fake.Clientset).PrependReactor("create", "tokenreviews",
+		func(action k8stesting.Action) (bool, runtime.Object, error) {
+			review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+			review.Status.Authenticated = review.Spec.Token != ""
+			review.Status.User.Username = review.Spec.Token
+			return true, review, nil
+		})
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+	go k8sManager.WatchPods(stopper, signer)
+
+	sign := func(nodeId string, token string, remoteAddr string) *httptest.ResponseRecorder {
+		request, _, err := common.GenerateNodeRequest(nodeId)
+		assert.Nil(t, err)
+		r := httptest.NewRequest(http.MethodPost, common.SIGN_NODE_PATH, bytes.NewReader(request))
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		signer.ServeHTTP(w, r)
+		return w
+	}
+
+	//pod not delivered yet
+	w := sign("test-pod.test-ns", signer.requester, "192.168.0.1:40000")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+
+	k8sManager.GetListerWatcher("pods").Add(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-pod",
+			Namespace: "test-ns",
+			Labels:    map[string]string{kubernetes.ENVOY_ENABLED: "true"},
+		},
+		Status: v1.PodStatus{PodIP: "10.0.0.1", HostIP: "192.168.0.1"},
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	w = sign("test-pod.test-ns", signer.requester, "192.168.0.1:40000")
+	assert.Equal(t, w.Code, http.StatusOK)
+	files, err := common.NodeTLSFiles(w.Body.Bytes(), []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, files[common.CA_CERT_KEY], secretManager.PemRootCertBytes)
+
+	//envoy-manager of other host
+	w = sign("test-pod.test-ns", signer.requester, "192.168.0.2:40000")
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	//other requester
+	w = sign("test-pod.test-ns", "system:serviceaccount:default:other", "192.168.0.1:40000")
+	assert.Equal(t, w.Code, http.StatusForbidden)
+
+	//not authenticated
+	w = sign("test-pod.test-ns", "", "192.168.0.1:40000")
+	assert.Equal(t, w.Code, http.StatusUnauthorized)
+
+	//ingress certificate is never signed
+	w = sign(IngressNodeId, signer.requester, "192.168.0.1:40000")
+	assert.Equal(t, w.Code, http.StatusNotFound)
+}
@@ -0,0 +1,106 @@
+package envoy
+
+import (
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/common"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/metrics"
+	"sync"
+	"time"
+)
+
+//xds protocol requires cds before eds and lds before rds
+var typeOrder = []string{
+	common.ClusterResource,
+	common.EndpointResource,
+	common.ListenerResource,
+	common.RouteResource,
+	common.SecretResource,
+}
+
+type pendingResponse struct {
+	typeUrl string
+	resp    interface{}
+	queued  time.Time
+}
+
+/**
+ * Responses waiting to be sent on one stream, at most one for each type.
+ * The responses are sent by a single goroutine in xds type order.
+ */
+type responseQueue struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	pending map[string]*pendingResponse
+	closed  bool
+}
+
+func newResponseQueue() *responseQueue {
+	queue := &responseQueue{
+		pending: make(map[string]*pendingResponse),
+	}
+	queue.cond = sync.NewCond(&queue.mutex)
+	return queue
+}
+
+//Block until previous response of same type is sent, return false if the queue is closed
+func (queue *responseQueue) Push(typeUrl string, resp interface{}) bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for queue.pending[typeUrl] != nil && !queue.closed {
+		queue.cond.Wait()
+	}
+	if queue.closed {
+		return false
+	}
+	queue.pending[typeUrl] = &pendingResponse{typeUrl: typeUrl, resp: resp, queued: time.Now()}
+	queue.cond.Broadcast()
+	return true
+}
+
+//Block until some response is available, return nil if the queue is closed
+func (queue *responseQueue) Pop() *pendingResponse {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	for {
+		if queue.closed {
+			return nil
+		}
+		for _, typeUrl := range typeOrder {
+			resp := queue.pending[typeUrl]
+			if resp != nil {
+				delete(queue.pending, typeUrl)
+				queue.cond.Broadcast()
+				return resp
+			}
+		}
+		queue.cond.Wait()
+	}
+}
+
+func (queue *responseQueue) Close() {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.closed = true
+	queue.cond.Broadcast()
+}
+
+//Send responses until the queue is closed or send failed, at most MAX_RPS responses per second
+func (queue *responseQueue) Run(send func(resp interface{}) error) error {
+	ticker := time.NewTicker(time.Second / MAX_RPS)
+	defer ticker.Stop()
+	defer queue.Close()
+
+	for {
+		pending := queue.Pop()
+		if pending == nil {
+			return nil
+		}
+		if err := send(pending.resp); err != nil {
+			return err
+		}
+		metrics.ObservePush(pending.typeUrl, pending.queued)
+		<-ticker.C
+	}
+}
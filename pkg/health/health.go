@@ -0,0 +1,73 @@
+package health
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Check func() error
+
+/**
+ * Serve /healthz and /readyz for kubernetes probes.
+ * /healthz succeeds as long as the process can serve http, /readyz succeeds when all readiness
+ * checks pass and the process is not shutting down.
+ */
+type Checker struct {
+	mutex        *sync.Mutex
+	checks       map[string]Check
+	shuttingDown int32
+}
+
+func NewChecker() *Checker {
+	return &Checker{
+		mutex:  &sync.Mutex{},
+		checks: make(map[string]Check),
+	}
+}
+
+func (checker *Checker) AddReadinessCheck(name string, check Check) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.checks[name] = check
+}
+
+//Fail readiness from now on, so that kubernetes stops routing new connections to this pod
+func (checker *Checker) ShutDown() {
+	atomic.StoreInt32(&checker.shuttingDown, 1)
+}
+
+//Return error messages of failed checks, empty if ready
+func (checker *Checker) Failures() []string {
+	if atomic.LoadInt32(&checker.shuttingDown) == 1 {
+		return []string{"shutting down"}
+	}
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	var result []string
+	for name, check := range checker.checks {
+		if err := check(); err != nil {
+			result = append(result, fmt.Sprintf("%s: %s", name, err.Error()))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (checker *Checker) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		failures := checker.Failures()
+		if len(failures) > 0 {
+			http.Error(w, strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+}
@@ -0,0 +1,39 @@
+package health
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getStatus(mux *http.ServeMux, path string) int {
+	recorder := httptest.NewRecorder()
+	mux.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder.Code
+}
+
+func TestReadiness(t *testing.T) {
+	checker := NewChecker()
+	mux := http.NewServeMux()
+	checker.Register(mux)
+
+	var synced bool
+	checker.AddReadinessCheck("informers", func() error {
+		if !synced {
+			return fmt.Errorf("not synced")
+		}
+		return nil
+	})
+	assert.Equal(t, getStatus(mux, "/healthz"), http.StatusOK)
+	assert.Equal(t, getStatus(mux, "/readyz"), http.StatusServiceUnavailable)
+	assert.Equal(t, checker.Failures(), []string{"informers: not synced"})
+
+	synced = true
+	assert.Equal(t, getStatus(mux, "/readyz"), http.StatusOK)
+
+	checker.ShutDown()
+	assert.Equal(t, getStatus(mux, "/readyz"), http.StatusServiceUnavailable)
+	assert.Equal(t, getStatus(mux, "/healthz"), http.StatusOK)
+}
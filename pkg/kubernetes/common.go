@@ -3,8 +3,10 @@ package kubernetes
 import (
 	"fmt"
 	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/labels"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ResourceType int
@@ -18,12 +20,14 @@ const (
 	ENVOY_ENABLED = "traffic.envoy.enabled"
 
 	ENVOY_PROXY_ANNOTATION = "traffic.envoy.proxy"
+	//ENVOY_PROXY_ANNOTATION value of traffic-ingress pods
+	ENVOY_PROXY_INGRESS = "ingress"
 
 	DEFAULT_WEIGHT = 100
 
-	POD_SERVICE_PREFIX          = "traffic.svc."
-	POD_DEPLOYMENT_PREFIX       = "traffic.rs."
-	ENVOY_ENABLED_BY_DEPLOYMENT = POD_DEPLOYMENT_PREFIX + "envoy.enabled"
+	//prefixes of pod annotations showing service and workload config
+	POD_SERVICE_PREFIX    = "traffic.svc."
+	POD_DEPLOYMENT_PREFIX = "traffic.rs."
 
 	PROTO_HTTP   = 2
 	PROTO_TCP    = 1
@@ -91,6 +95,28 @@ func GetLabelValueInt64(value string) int64 {
 	return int64(i)
 }
 
+/**
+ * Parse a duration like 3s, 250ms or 1m.
+ * Bare integers are kept for backward compatibility and are multiplied by unit, e.g. nanoseconds for timeouts.
+ * Return defaultValue if the value is empty, invalid or negative.
+ */
+func GetLabelValueDuration(value string, unit time.Duration, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if i < 0 {
+			return defaultValue
+		}
+		return time.Duration(i) * unit
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return defaultValue
+	}
+	return d
+}
+
 func ServicePortProtocol(port uint32) string {
 	return fmt.Sprintf("traffic.port.%d", port)
 }
@@ -99,14 +125,6 @@ func IngressAttrLabel(port uint32, attr string) string {
 	return fmt.Sprintf("traffic.ingress.port.%d.%s", port, attr)
 }
 
-func PodPortProtcolByService(svc string, port uint32) string {
-	return fmt.Sprintf("%s%s.port.%d", POD_SERVICE_PREFIX, svc, port)
-}
-
-func PodTargetPortProtcolByService(svc string, port uint32) string {
-	return fmt.Sprintf("%s%s.target.port.%d", POD_SERVICE_PREFIX, svc, port)
-}
-
 func podKeyByService(svc string, key string) string {
 	return fmt.Sprintf("%s%s.%s", POD_SERVICE_PREFIX, svc, key)
 }
@@ -139,6 +157,8 @@ func (e ResourceType) String() string {
 		return "Deployment"
 	case SERVICE_TYPE:
 		return "Service"
+	case INGRESS_TYPE:
+		return "Ingress"
 	default:
 		return fmt.Sprintf("UNKNOWN(%d)", int(e))
 	}
@@ -152,13 +172,51 @@ type ResourceInfoPointer interface {
 	String() string
 }
 
+/**
+ * Implemented by resources whose selector has set-based requirements (matchExpressions),
+ * GetSelector() only returns the matchLabels part of such selectors.
+ */
+type LabelSelectorResource interface {
+	LabelSelector() labels.Selector
+}
+
+//key of the index entry containing all resources of a namespace
+func namespaceIndexKey(ns string) string {
+	return fmt.Sprintf("%s:", ns)
+}
+
+//index keys of the resource, resources are also indexed by namespace for set-based selectors
+func indexKeys(resource ResourceInfoPointer) []string {
+	keys := []string{namespaceIndexKey(resource.Namespace())}
+	for k, v := range resource.GetSelector() {
+		keys = append(keys, fmt.Sprintf("%s:%s:%s", resource.Namespace(), k, v))
+	}
+	return keys
+}
+
+//whether the selector of resource selects the labels, only set-based requirements are checked
+func selects(resource ResourceInfoPointer, labelSet map[string]string) bool {
+	selectorResource, ok := resource.(LabelSelectorResource)
+	if !ok || selectorResource.LabelSelector() == nil {
+		return true
+	}
+	return selectorResource.LabelSelector().Matches(labels.Set(labelSet))
+}
+
+//whether the resource selects by matchExpressions only
+func hasSetBasedSelectorOnly(resource ResourceInfoPointer) bool {
+	if len(resource.GetSelector()) > 0 {
+		return false
+	}
+	selectorResource, ok := resource.(LabelSelectorResource)
+	return ok && selectorResource.LabelSelector() != nil && !selectorResource.LabelSelector().Empty()
+}
+
 func (manager *K8sResourceManager) addResource(resource ResourceInfoPointer) {
 	if glog.V(2) {
 		glog.Infof("add %s", resource.String())
 	}
-	for k, v := range resource.GetSelector() {
-		key := fmt.Sprintf("%s:%s:%s", resource.Namespace(), k, v)
-
+	for _, key := range indexKeys(resource) {
 		typeResourceMap := manager.labelTypeResourceMap[key]
 		if typeResourceMap == nil {
 			typeResourceMap = make(ResourcesOnLabel)
@@ -173,9 +231,7 @@ func (manager *K8sResourceManager) removeResource(resource ResourceInfoPointer)
 	if glog.V(2) {
 		glog.Infof("remove %s", resource.String())
 	}
-	for k, v := range resource.GetSelector() {
-		key := fmt.Sprintf("%s:%s:%s", resource.Namespace(), k, v)
-
+	for _, key := range indexKeys(resource) {
 		typeResourceMap := manager.labelTypeResourceMap[key]
 		if typeResourceMap == nil {
 			continue
@@ -194,16 +250,67 @@ func (manager *K8sResourceManager) removeResource(resource ResourceInfoPointer)
 	}
 }
 
+//Names of indexed resources by namespace:label:value key and type, should be called with K8sResourceManager locked
+func (manager *K8sResourceManager) DumpLabelIndex() map[string]map[string][]string {
+	result := make(map[string]map[string][]string)
+	for key, typeResourceMap := range manager.labelTypeResourceMap {
+		typeMap := make(map[string][]string)
+		for resourceType, resources := range typeResourceMap {
+			if len(resources) == 0 {
+				continue
+			}
+			var names []string
+			for _, resource := range resources {
+				names = append(names, resource.Name())
+			}
+			typeMap[resourceType.String()] = names
+		}
+		if len(typeMap) > 0 {
+			result[key] = typeMap
+		}
+	}
+	return result
+}
+
+/**
+ * Return resources of matchType selected by the resource (e.g. pods of a service), or resources selecting
+ * the resource (e.g. services of a pod). Both matchLabels and matchExpressions of selectors are applied.
+ */
 func (manager *K8sResourceManager) GetMatchedResources(resource ResourceInfoPointer, matchType ResourceType) []ResourceInfoPointer {
 	if !manager.IsLocked() {
 		panic("K8sResourceManager should be locked in GetMatchedResources()")
 	}
+	//type service < deployment < pod
+	returnParent := resource.Type() > matchType
+	var result []ResourceInfoPointer
+
+	if !returnParent && hasSetBasedSelectorOnly(resource) {
+		//no label to look up, check all resources in the namespace
+		for _, matchResource := range manager.labelTypeResourceMap[namespaceIndexKey(resource.Namespace())][matchType] {
+			if selects(resource, matchResource.GetSelector()) {
+				result = append(result, matchResource)
+			}
+		}
+		return result
+	}
+	if returnParent {
+		for _, matchResource := range manager.labelTypeResourceMap[namespaceIndexKey(resource.Namespace())][matchType] {
+			if hasSetBasedSelectorOnly(matchResource) && selects(matchResource, resource.GetSelector()) {
+				result = append(result, matchResource)
+			}
+		}
+	}
+
 	countMap := make(map[ResourceInfoPointer]*int)
 	for k, v := range resource.GetSelector() {
 		key := fmt.Sprintf("%s:%s:%s", resource.Namespace(), k, v)
 		typeResourceMap := manager.labelTypeResourceMap[key]
 		if typeResourceMap == nil {
-			return nil
+			if returnParent {
+				//parents may select a subset of labels, e.g. pods not indexed yet have labels no one else has
+				continue
+			}
+			return result
 		}
 		resources := typeResourceMap[matchType]
 		for _, matchResource := range resources {
@@ -215,9 +322,6 @@ func (manager *K8sResourceManager) GetMatchedResources(resource ResourceInfoPoin
 			}
 		}
 	}
-	//type service < deployment < pod
-	returnParent := resource.Type() > matchType
-	var result []ResourceInfoPointer
 	for matchResource, countPtr := range countMap {
 		if returnParent {
 			if *countPtr != len(matchResource.GetSelector()) {
@@ -225,10 +329,18 @@ func (manager *K8sResourceManager) GetMatchedResources(resource ResourceInfoPoin
 				//count should be same with service or deployment selector
 				continue
 			}
-		} else if *countPtr != len(resource.GetSelector()) {
-			//return pods from service or deployment
-			//count should be same with pod labels
-			continue
+			if !selects(matchResource, resource.GetSelector()) {
+				continue
+			}
+		} else {
+			if *countPtr != len(resource.GetSelector()) {
+				//return pods from service or deployment
+				//count should be same with pod labels
+				continue
+			}
+			if !selects(resource, matchResource.GetSelector()) {
+				continue
+			}
 		}
 		result = append(result, matchResource)
 	}
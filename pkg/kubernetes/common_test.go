@@ -0,0 +1,22 @@
+package kubernetes
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestGetLabelValueDuration(t *testing.T) {
+	assert.Equal(t, GetLabelValueDuration("3s", time.Nanosecond, time.Minute), 3*time.Second)
+	assert.Equal(t, GetLabelValueDuration("250ms", time.Nanosecond, time.Minute), 250*time.Millisecond)
+	//bare integer is multiplied by unit
+	assert.Equal(t, GetLabelValueDuration("3000", time.Nanosecond, time.Minute), 3000*time.Nanosecond)
+	assert.Equal(t, GetLabelValueDuration("30", time.Second, time.Minute), 30*time.Second)
+	assert.Equal(t, GetLabelValueDuration("0", time.Second, time.Minute), time.Duration(0))
+
+	//empty, invalid and negative values return the default
+	assert.Equal(t, GetLabelValueDuration("", time.Nanosecond, time.Minute), time.Minute)
+	assert.Equal(t, GetLabelValueDuration("3 seconds", time.Nanosecond, time.Minute), time.Minute)
+	assert.Equal(t, GetLabelValueDuration("-1", time.Nanosecond, time.Minute), time.Minute)
+	assert.Equal(t, GetLabelValueDuration("-3s", time.Nanosecond, time.Minute), time.Minute)
+}
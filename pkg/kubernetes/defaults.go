@@ -0,0 +1,190 @@
+package kubernetes
+
+import (
+	"fmt"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"os"
+	"strings"
+)
+
+//name of the ConfigMap in the namespace of traffic-control holding mesh-wide traffic.* defaults
+const DEFAULTS_CONFIGMAP = "traffic-defaults"
+
+//namespace of traffic-control given by TRAFFIC_NAMESPACE env
+func ControlPlaneNamespace() string {
+	ns := os.Getenv("TRAFFIC_NAMESPACE")
+	if ns == "" {
+		return "default"
+	}
+	return ns
+}
+
+//only the defaults ConfigMap is watched, other ConfigMaps are never loaded
+func newDefaultsListWatch(getter cache.Getter) cache.ListerWatcher {
+	return cache.NewFilteredListWatchFromClient(getter, "configmaps", ControlPlaneNamespace(),
+		func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", DEFAULTS_CONFIGMAP).String()
+		})
+}
+
+/**
+ * Whether a traffic.* key may be given as a mesh or namespace default.
+ * Port protocols only make sense on services and pods.
+ */
+func isDefaultConfigKey(key string) bool {
+	return strings.HasPrefix(key, "traffic.") &&
+		!strings.HasPrefix(key, "traffic.port.") && !strings.HasPrefix(key, "traffic.target.port.")
+}
+
+func defaultConfigOf(values map[string]string) map[string]string {
+	result := make(map[string]string)
+	for key, value := range values {
+		if isDefaultConfigKey(key) && value != "" {
+			result[key] = value
+		}
+	}
+	return result
+}
+
+type MeshDefaultsInfo struct {
+	ResourceVersion string
+	UID             types.UID
+	name            string
+	namespace       string
+	Annotations     map[string]string
+	//all data entries of the ConfigMap, used for validation
+	Data map[string]string
+	//traffic.* defaults applied to all services and pods
+	Config map[string]string
+}
+
+func NewMeshDefaultsInfo(configMap *v1.ConfigMap) *MeshDefaultsInfo {
+	return &MeshDefaultsInfo{
+		ResourceVersion: configMap.ResourceVersion,
+		UID:             configMap.UID,
+		name:            configMap.Name,
+		namespace:       configMap.Namespace,
+		Annotations:     configMap.Annotations,
+		Data:            configMap.Data,
+		Config:          defaultConfigOf(configMap.Data),
+	}
+}
+
+func (defaults *MeshDefaultsInfo) Name() string {
+	return defaults.name
+}
+
+func (defaults *MeshDefaultsInfo) Namespace() string {
+	return defaults.namespace
+}
+
+/**
+ * Mesh defaults overridden by traffic.* labels of the namespace.
+ * Also return a version of them, empty if there is no default.
+ */
+func (manager *K8sResourceManager) defaultConfig(namespace string) (map[string]string, string) {
+	manager.policyMutex.RLock()
+	defer manager.policyMutex.RUnlock()
+
+	config := make(map[string]string)
+	var versions []string
+	if defaults := manager.meshDefaults; defaults != nil && len(defaults.Config) > 0 {
+		overrideConfig(config, defaults.Config)
+		versions = append(versions, fmt.Sprintf("ConfigMap/%s/%s", defaults.Name(), defaults.ResourceVersion))
+	}
+	if ns := manager.namespaces[namespace]; ns != nil && len(ns.Config) > 0 {
+		overrideConfig(config, ns.Config)
+		versions = append(versions, fmt.Sprintf("Namespace/%s/%s", ns.Name(), ns.ResourceVersion))
+	}
+	if len(versions) == 0 {
+		return nil, ""
+	}
+	return config, strings.Join(versions, ",")
+}
+
+//set mesh and namespace defaults of the service, their version becomes part of the resource version
+func (manager *K8sResourceManager) applyServiceDefaults(svc *ServiceInfo) {
+	config, version := manager.defaultConfig(svc.Namespace())
+	if version != "" {
+		svc.DefaultConfig = config
+		svc.ResourceVersion = fmt.Sprintf("%s-%s", svc.ResourceVersion, version)
+	}
+}
+
+//set mesh and namespace defaults of the pod, their version becomes part of the resource version
+func (manager *K8sResourceManager) applyPodDefaults(pod *PodInfo) {
+	config, version := manager.defaultConfig(pod.Namespace())
+	if version != "" {
+		pod.DefaultConfig = config
+		pod.ResourceVersion = fmt.Sprintf("%s-%s", pod.ResourceVersion, version)
+	}
+}
+
+type MeshDefaultsEventHandler interface {
+	MeshDefaultsAdded(defaults *MeshDefaultsInfo)
+	MeshDefaultsDeleted(defaults *MeshDefaultsInfo)
+	MeshDefaultsUpdated(oldDefaults, newDefaults *MeshDefaultsInfo)
+}
+
+//services and pods of all namespaces are delivered again with the new defaults
+func (manager *K8sResourceManager) MeshDefaultsAdded(defaults *MeshDefaultsInfo) {
+	manager.MeshDefaultsUpdated(nil, defaults)
+}
+func (manager *K8sResourceManager) MeshDefaultsDeleted(defaults *MeshDefaultsInfo) {
+	manager.MeshDefaultsUpdated(defaults, nil)
+}
+func (manager *K8sResourceManager) MeshDefaultsUpdated(oldDefaults, newDefaults *MeshDefaultsInfo) {
+	manager.policyMutex.Lock()
+	manager.meshDefaults = newDefaults
+	manager.policyMutex.Unlock()
+
+	manager.refresh("services", metav1.NamespaceAll)
+	manager.refresh("pods", metav1.NamespaceAll)
+}
+
+func meshDefaultsDispatcher(h MeshDefaultsEventHandler) dispatchFunc {
+	return func(oldInfo interface{}, newInfo interface{}) (err error) {
+		defer recoverHandler(&err)
+		oldDefaults, _ := oldInfo.(*MeshDefaultsInfo)
+		newDefaults, _ := newInfo.(*MeshDefaultsInfo)
+		if oldDefaults == nil && newDefaults != nil {
+			h.MeshDefaultsAdded(newDefaults)
+		} else if oldDefaults != nil && newDefaults == nil {
+			h.MeshDefaultsDeleted(oldDefaults)
+		} else if oldDefaults != nil && newDefaults != nil {
+			h.MeshDefaultsUpdated(oldDefaults, newDefaults)
+		}
+		return nil
+	}
+}
+
+/**
+ * Watch the traffic-defaults ConfigMap in the namespace of traffic-control,
+ * changes are applied without restart.
+ */
+func (manager *K8sResourceManager) WatchMeshDefaults(stopper chan struct{}, handlers ...MeshDefaultsEventHandler) {
+	var inline, dispatchers []dispatchFunc
+	for _, h := range handlers {
+		if h == MeshDefaultsEventHandler(manager) {
+			inline = append(inline, meshDefaultsDispatcher(h))
+		} else {
+			dispatchers = append(dispatchers, meshDefaultsDispatcher(h))
+		}
+	}
+	//filtered informers are not shared
+	informer := cache.NewSharedIndexInformer(manager.watchListMap["configmaps"], &v1.ConfigMap{}, resyncPeriod(),
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	go informer.Run(stopper)
+	manager.watch(stopper, "configmaps", "configmap", informer,
+		func(obj interface{}) interface{} {
+			configMap := obj.(*v1.ConfigMap)
+			if configMap.Name != DEFAULTS_CONFIGMAP || configMap.Namespace != ControlPlaneNamespace() {
+				return nil
+			}
+			return NewMeshDefaultsInfo(configMap)
+		}, inline, dispatchers)
+}
@@ -0,0 +1,86 @@
+package kubernetes
+
+import (
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+	"time"
+)
+
+func TestConfigDefaults(t *testing.T) {
+	manager := NewFakeK8sResourceManager()
+	services := &lastServiceHandler{services: make(map[string]*ServiceInfo)}
+	pods := &lastPodHandler{pods: make(map[string]*PodInfo)}
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+	go manager.WatchServices(stopper, manager, services)
+	go manager.WatchPods(stopper, manager, pods)
+	go manager.WatchNamespaces(stopper, manager)
+	go manager.WatchMeshDefaults(stopper, manager)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "svc1"
+	service.Labels = map[string]string{"traffic.request.timeout": "1s"}
+	manager.GetListerWatcher("services").Add(&service)
+
+	var pod corev1.Pod
+	pod.Namespace = "test-ns"
+	pod.Name = "pod1"
+	pod.Status.PodIP = "10.1.1.1"
+	manager.GetListerWatcher("pods").Add(&pod)
+
+	var defaults corev1.ConfigMap
+	defaults.Namespace = ControlPlaneNamespace()
+	defaults.Name = DEFAULTS_CONFIGMAP
+	defaults.Data = map[string]string{
+		"traffic.connection.timeout": "5s",
+		"traffic.request.timeout":    "10s",
+		"traffic.tracing.sampling":   "10",
+		"traffic.port.80":            "http",
+	}
+	manager.GetListerWatcher("configmaps").Add(&defaults)
+
+	var namespace corev1.Namespace
+	namespace.Name = "test-ns"
+	namespace.Labels = map[string]string{"traffic.tracing.sampling": "50", "traffic.envoy.enabled": "true"}
+	manager.GetListerWatcher("namespaces").Add(&namespace)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	svc := services.services["svc1"]
+	info := pods.pods["pod1"]
+	manager.Unlock()
+	config := svc.ConfigLabels()
+	assert.Equal(t, config["traffic.connection.timeout"], "5s")
+	//namespace label overrides mesh default, service label overrides both
+	assert.Equal(t, config["traffic.tracing.sampling"], "50")
+	assert.Equal(t, config["traffic.request.timeout"], "1s")
+	//port protocols are not accepted as defaults
+	assert.Equal(t, config["traffic.port.80"], "")
+	assert.True(t, info.EnvoyEnabled())
+
+	//pod label overrides namespace label
+	pod.Labels = map[string]string{"traffic.envoy.enabled": "false"}
+	manager.GetListerWatcher("pods").Modify(&pod)
+	defaults.Data = map[string]string{"traffic.connection.timeout": "2s"}
+	manager.GetListerWatcher("configmaps").Modify(&defaults)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	svc = services.services["svc1"]
+	info = pods.pods["pod1"]
+	manager.Unlock()
+	assert.Equal(t, svc.ConfigLabels()["traffic.connection.timeout"], "2s")
+	assert.False(t, info.EnvoyEnabled())
+
+	manager.GetListerWatcher("namespaces").Delete(&namespace)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	svc = services.services["svc1"]
+	manager.Unlock()
+	assert.Equal(t, svc.ConfigLabels()["traffic.tracing.sampling"], "")
+	assert.Equal(t, svc.ConfigLabels()["traffic.connection.timeout"], "2s")
+}
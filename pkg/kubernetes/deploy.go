@@ -2,20 +2,33 @@ package kubernetes
 
 import (
 	"fmt"
-	apps_v1beta1 "k8s.io/api/apps/v1beta1"
+	"github.com/golang/glog"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+
 	"k8s.io/api/core/v1"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"strings"
 )
 
 type DeploymentInfo struct {
-	name        string
-	namespace   string
-	realType    string
-	selector    map[string]string
-	Labels      map[string]string
-	Ports       []uint32
-	HostNetwork bool
+	ResourceVersion string
+	name            string
+	namespace       string
+	realType        string
+	selector        map[string]string
+	//nil if the selector only has matchLabels
+	labelSelector labels.Selector
+	Labels        map[string]string
+	Annotations   map[string]string
+	UID           types.UID
+	Ports         []uint32
+	HostNetwork   bool
+	//controller owner, e.g. Deployment of a ReplicaSet, empty if not owned
+	OwnerKind string
+	OwnerName string
 }
 
 func (deployment *DeploymentInfo) EnvoyEnabled() bool {
@@ -33,6 +46,10 @@ func (deployment *DeploymentInfo) GetSelector() map[string]string {
 	return deployment.selector
 }
 
+func (deployment *DeploymentInfo) LabelSelector() labels.Selector {
+	return deployment.labelSelector
+}
+
 func (deployment *DeploymentInfo) Type() ResourceType {
 	return DEPLOYMENT_TYPE
 }
@@ -45,6 +62,11 @@ func (deployment *DeploymentInfo) Namespace() string {
 	return deployment.namespace
 }
 
+//Deployment, DaemonSet, StatefulSet, ReplicaSet, Job or CronJob
+func (deployment *DeploymentInfo) Kind() string {
+	return deployment.realType
+}
+
 func (deployment *DeploymentInfo) addPort(addedPort uint32) bool {
 	for _, port := range deployment.Ports {
 		if addedPort == port {
@@ -55,39 +77,59 @@ func (deployment *DeploymentInfo) addPort(addedPort uint32) bool {
 	return true
 }
 
+func newDeploymentInfo(kind string, objectMeta *metav1.ObjectMeta, selector *metav1.LabelSelector) *DeploymentInfo {
+	result := &DeploymentInfo{
+		ResourceVersion: objectMeta.ResourceVersion,
+		name:            objectMeta.Name,
+		namespace:       objectMeta.Namespace,
+		realType:        kind,
+		Labels:          objectMeta.Labels,
+		Annotations:     objectMeta.Annotations,
+		UID:             objectMeta.UID,
+	}
+	if selector != nil {
+		result.selector = selector.MatchLabels
+		if len(selector.MatchExpressions) > 0 {
+			labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+			if err != nil {
+				glog.Errorf("Invalid selector of %s %s@%s: %s", kind, objectMeta.Name, objectMeta.Namespace, err.Error())
+				labelSelector = labels.Nothing()
+			}
+			result.labelSelector = labelSelector
+		}
+	}
+	if owner := metav1.GetControllerOf(objectMeta); owner != nil {
+		result.OwnerKind = owner.Kind
+		result.OwnerName = owner.Name
+	}
+	return result
+}
+
+//Deployment, DaemonSet, StatefulSet, ReplicaSet, Job or CronJob
 func NewDeploymentInfo(obj interface{}) *DeploymentInfo {
 	var result *DeploymentInfo
 	var template *v1.PodTemplateSpec
 
 	switch deployment := obj.(type) {
-	case *v1beta1.Deployment:
-		result = &DeploymentInfo{
-			name:      deployment.Name,
-			namespace: deployment.Namespace,
-			realType:  "Deployment",
-			Labels:    deployment.Labels,
-			selector:  deployment.Spec.Selector.MatchLabels,
-		}
-
+	case *appsv1.Deployment:
+		result = newDeploymentInfo("Deployment", &deployment.ObjectMeta, deployment.Spec.Selector)
 		template = &deployment.Spec.Template
-	case *v1beta1.DaemonSet:
-		result = &DeploymentInfo{
-			name:      deployment.Name,
-			namespace: deployment.Namespace,
-			realType:  "DaemonSet",
-			Labels:    deployment.Labels,
-			selector:  deployment.Spec.Selector.MatchLabels,
-		}
+	case *appsv1.DaemonSet:
+		result = newDeploymentInfo("DaemonSet", &deployment.ObjectMeta, deployment.Spec.Selector)
 		template = &deployment.Spec.Template
-	case *apps_v1beta1.StatefulSet:
-		result = &DeploymentInfo{
-			name:      deployment.Name,
-			namespace: deployment.Namespace,
-			realType:  "StatefulSet",
-			Labels:    deployment.Labels,
-			selector:  deployment.Spec.Selector.MatchLabels,
-		}
+	case *appsv1.StatefulSet:
+		result = newDeploymentInfo("StatefulSet", &deployment.ObjectMeta, deployment.Spec.Selector)
+		template = &deployment.Spec.Template
+	case *appsv1.ReplicaSet:
+		result = newDeploymentInfo("ReplicaSet", &deployment.ObjectMeta, deployment.Spec.Selector)
+		template = &deployment.Spec.Template
+	case *batchv1.Job:
+		result = newDeploymentInfo("Job", &deployment.ObjectMeta, deployment.Spec.Selector)
 		template = &deployment.Spec.Template
+	case *batchv1.CronJob:
+		//pods of cronjob are only associated through jobs owned by it
+		result = newDeploymentInfo("CronJob", &deployment.ObjectMeta, nil)
+		template = &deployment.Spec.JobTemplate.Spec.Template
 	default:
 		panic(fmt.Sprintf("Unexpected type %T", obj))
 		return nil
@@ -1,13 +1,10 @@
 package kubernetes
 
 import (
-	"github.com/golang/glog"
-	apps_v1beta1 "k8s.io/api/apps/v1beta1"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 
-	"k8s.io/client-go/tools/cache"
-	"reflect"
-	"time"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 type DeploymentEventHandler interface {
@@ -20,102 +17,76 @@ type DeploymentEventHandler interface {
 func (manager *K8sResourceManager) DeploymentValid(deployment *DeploymentInfo) bool {
 	return true
 }
+
+//pods of the namespace are delivered again with the new workload labels
 func (manager *K8sResourceManager) DeploymentAdded(deployment *DeploymentInfo) {
 	manager.addResource(deployment)
+	manager.addWorkload(deployment)
+	manager.refresh("pods", deployment.Namespace())
 }
 func (manager *K8sResourceManager) DeploymentDeleted(deployment *DeploymentInfo) {
 	manager.removeResource(deployment)
+	manager.removeWorkload(deployment)
+	manager.refresh("pods", deployment.Namespace())
 }
 func (manager *K8sResourceManager) DeploymentUpdated(oldDeployment, newDeployment *DeploymentInfo) {
-	manager.removeResource(oldDeployment)
-	manager.addResource(newDeployment)
+	manager.DeploymentDeleted(oldDeployment)
+	manager.DeploymentAdded(newDeployment)
 }
 
-func (manager *K8sResourceManager) getDeploymentEventHandler(handlers []DeploymentEventHandler) cache.ResourceEventHandlerFuncs {
-	return cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			deployment := NewDeploymentInfo(obj)
-
-			manager.Lock()
-			defer manager.Unlock()
-
-			for _, h := range handlers {
-				if h.DeploymentValid(deployment) {
-					h.DeploymentAdded(deployment)
-				}
-			}
-
-		},
-		DeleteFunc: func(obj interface{}) {
-			deployment := NewDeploymentInfo(obj)
-
-			manager.Lock()
-			defer manager.Unlock()
-
-			for _, h := range handlers {
-				if h.DeploymentValid(deployment) {
-					h.DeploymentDeleted(deployment)
-				}
-			}
-
-		},
-		UpdateFunc: func(oldObj, newObj interface{}) {
-			oldDeployment := NewDeploymentInfo(oldObj)
-			newDeployment := NewDeploymentInfo(newObj)
-			if reflect.DeepEqual(oldDeployment, newDeployment) {
-				return
-			}
-
-			manager.Lock()
-			defer manager.Unlock()
-			for _, h := range handlers {
-				oldValid := h.DeploymentValid(oldDeployment)
-				newValid := h.DeploymentValid(newDeployment)
-				if !oldValid && newValid {
-					h.DeploymentAdded(newDeployment)
-				} else if oldValid && !newValid {
-					h.DeploymentDeleted(oldDeployment)
-				} else if oldValid && newValid {
-					h.DeploymentUpdated(oldDeployment, newDeployment)
-				}
-			}
-		},
+func deploymentDispatcher(h DeploymentEventHandler) dispatchFunc {
+	return func(oldInfo interface{}, newInfo interface{}) (err error) {
+		defer recoverHandler(&err)
+		oldDeployment, _ := oldInfo.(*DeploymentInfo)
+		newDeployment, _ := newInfo.(*DeploymentInfo)
+		oldValid := (oldDeployment != nil && h.DeploymentValid(oldDeployment))
+		newValid := (newDeployment != nil && h.DeploymentValid(newDeployment))
+		if !oldValid && newValid {
+			h.DeploymentAdded(newDeployment)
+		} else if oldValid && !newValid {
+			h.DeploymentDeleted(oldDeployment)
+		} else if oldValid && newValid {
+			h.DeploymentUpdated(oldDeployment, newDeployment)
+		}
+		return nil
 	}
+}
 
+func (manager *K8sResourceManager) watchDeployments(stopper chan struct{}, resource string, kind string, obj runtime.Object, handlers []DeploymentEventHandler) {
+	var inline, dispatchers []dispatchFunc
+	for _, h := range handlers {
+		if h == DeploymentEventHandler(manager) {
+			inline = append(inline, deploymentDispatcher(h))
+		} else {
+			dispatchers = append(dispatchers, deploymentDispatcher(h))
+		}
+	}
+	manager.watch(stopper, resource, kind, manager.sharedInformer(resource, obj),
+		manager.managedOnly(func(obj interface{}) interface{} {
+			return NewDeploymentInfo(obj)
+		}), inline, dispatchers)
 }
 
 func (manager *K8sResourceManager) WatchDeployments(stopper chan struct{}, handlers ...DeploymentEventHandler) {
-	_, controller := cache.NewInformer(
-		manager.watchListMap["deployments"],
-		&v1beta1.Deployment{},
-		time.Second*0,
-		manager.getDeploymentEventHandler(handlers),
-	)
-	glog.Info("Start watching deployments")
-	controller.Run(stopper)
-	glog.Info("WatchDeployments terminated")
+	manager.watchDeployments(stopper, "deployments", "deployment", &appsv1.Deployment{}, handlers)
 }
 
 func (manager *K8sResourceManager) WatchStatefulSets(stopper chan struct{}, handlers ...DeploymentEventHandler) {
-	_, controller := cache.NewInformer(
-		manager.watchListMap["statefulsets"],
-		&apps_v1beta1.StatefulSet{},
-		time.Second*0,
-		manager.getDeploymentEventHandler(handlers),
-	)
-	glog.Info("Start watching statefulSets")
-	controller.Run(stopper)
-	glog.Info("WatchStatefulSets terminated")
+	manager.watchDeployments(stopper, "statefulsets", "statefulset", &appsv1.StatefulSet{}, handlers)
 }
 
 func (manager *K8sResourceManager) WatchDaemonSets(stopper chan struct{}, handlers ...DeploymentEventHandler) {
-	_, controller := cache.NewInformer(
-		manager.watchListMap["daemonsets"],
-		&v1beta1.DaemonSet{},
-		time.Second*0,
-		manager.getDeploymentEventHandler(handlers),
-	)
-	glog.Info("Start watching daemonSets")
-	controller.Run(stopper)
-	glog.Info("WatchDaemonSets terminated")
+	manager.watchDeployments(stopper, "daemonsets", "daemonset", &appsv1.DaemonSet{}, handlers)
+}
+
+func (manager *K8sResourceManager) WatchReplicaSets(stopper chan struct{}, handlers ...DeploymentEventHandler) {
+	manager.watchDeployments(stopper, "replicasets", "replicaset", &appsv1.ReplicaSet{}, handlers)
+}
+
+func (manager *K8sResourceManager) WatchJobs(stopper chan struct{}, handlers ...DeploymentEventHandler) {
+	manager.watchDeployments(stopper, "jobs", "job", &batchv1.Job{}, handlers)
+}
+
+func (manager *K8sResourceManager) WatchCronJobs(stopper chan struct{}, handlers ...DeploymentEventHandler) {
+	manager.watchDeployments(stopper, "cronjobs", "cronjob", &batchv1.CronJob{}, handlers)
 }
@@ -0,0 +1,89 @@
+package kubernetes
+
+import (
+	"bytes"
+	"fmt"
+	discovery "k8s.io/api/discovery/v1"
+)
+
+type EndpointPortInfo struct {
+	//name of the service port
+	Name string
+	//resolved target port, named target ports may resolve to different numbers in different slices
+	Port uint32
+}
+
+type EndpointAddressInfo struct {
+	Addresses []string
+	//false if the pod is not ready
+	Ready bool
+	//empty if the endpoint is not a pod, e.g. endpoints of service without selector
+	PodName string
+}
+
+type EndpointSliceInfo struct {
+	ResourceVersion string
+	name            string
+	namespace       string
+	Service         string
+	Ports           []*EndpointPortInfo
+	Endpoints       []*EndpointAddressInfo
+}
+
+func (slice *EndpointSliceInfo) Name() string {
+	return slice.name
+}
+
+func (slice *EndpointSliceInfo) Namespace() string {
+	return slice.namespace
+}
+
+func (slice *EndpointSliceInfo) String() string {
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf("EndpointSlice %s@%s Service=%s Port=", slice.name, slice.namespace, slice.Service))
+	for _, port := range slice.Ports {
+		buffer.WriteString(fmt.Sprintf("%s:%d ", port.Name, port.Port))
+	}
+	buffer.WriteString(fmt.Sprintf("Endpoints=%d", len(slice.Endpoints)))
+	return buffer.String()
+}
+
+/**
+ * Return nil if the slice does not belong to a service or has FQDN addresses.
+ * Endpoints without ready condition are ready.
+ */
+func NewEndpointSliceInfo(slice *discovery.EndpointSlice) *EndpointSliceInfo {
+	service := slice.Labels[discovery.LabelServiceName]
+	if service == "" || slice.AddressType == discovery.AddressTypeFQDN {
+		return nil
+	}
+	info := &EndpointSliceInfo{
+		ResourceVersion: slice.ResourceVersion,
+		name:            slice.Name,
+		namespace:       slice.Namespace,
+		Service:         service,
+	}
+	for _, port := range slice.Ports {
+		if port.Port == nil {
+			continue
+		}
+		portInfo := &EndpointPortInfo{
+			Port: uint32(*port.Port),
+		}
+		if port.Name != nil {
+			portInfo.Name = *port.Name
+		}
+		info.Ports = append(info.Ports, portInfo)
+	}
+	for _, endpoint := range slice.Endpoints {
+		endpointInfo := &EndpointAddressInfo{
+			Addresses: endpoint.Addresses,
+			Ready:     endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready,
+		}
+		if endpoint.TargetRef != nil && endpoint.TargetRef.Kind == "Pod" {
+			endpointInfo.PodName = endpoint.TargetRef.Name
+		}
+		info.Endpoints = append(info.Endpoints, endpointInfo)
+	}
+	return info
+}
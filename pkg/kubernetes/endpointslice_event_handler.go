@@ -0,0 +1,41 @@
+package kubernetes
+
+import (
+	discovery "k8s.io/api/discovery/v1"
+)
+
+type EndpointSliceEventHandler interface {
+	EndpointSliceAdded(slice *EndpointSliceInfo)
+	EndpointSliceDeleted(slice *EndpointSliceInfo)
+	EndpointSliceUpdated(oldSlice, newSlice *EndpointSliceInfo)
+}
+
+func endpointSliceDispatcher(h EndpointSliceEventHandler) dispatchFunc {
+	return func(oldInfo interface{}, newInfo interface{}) (err error) {
+		defer recoverHandler(&err)
+		oldSlice, _ := oldInfo.(*EndpointSliceInfo)
+		newSlice, _ := newInfo.(*EndpointSliceInfo)
+		if oldSlice == nil && newSlice != nil {
+			h.EndpointSliceAdded(newSlice)
+		} else if oldSlice != nil && newSlice == nil {
+			h.EndpointSliceDeleted(oldSlice)
+		} else if oldSlice != nil && newSlice != nil {
+			h.EndpointSliceUpdated(oldSlice, newSlice)
+		}
+		return nil
+	}
+}
+
+func (manager *K8sResourceManager) WatchEndpointSlices(stopper chan struct{}, handlers ...EndpointSliceEventHandler) {
+	var dispatchers []dispatchFunc
+	for _, h := range handlers {
+		dispatchers = append(dispatchers, endpointSliceDispatcher(h))
+	}
+	manager.watch(stopper, "endpointslices", "endpointslice", manager.sharedInformer("endpointslices", &discovery.EndpointSlice{}),
+		manager.managedOnly(func(obj interface{}) interface{} {
+			if slice := NewEndpointSliceInfo(obj.(*discovery.EndpointSlice)); slice != nil {
+				return slice
+			}
+			return nil
+		}), nil, dispatchers)
+}
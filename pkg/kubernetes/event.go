@@ -0,0 +1,80 @@
+package kubernetes
+
+import (
+	"github.com/golang/glog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"os"
+	"strings"
+)
+
+const EVENT_COMPONENT = "traffic-control"
+
+//resources of kinds which traffic-control records events on and annotates
+var kindResources = map[string]schema.GroupVersionResource{
+	"Pod":         {Version: "v1", Resource: "pods"},
+	"Service":     {Version: "v1", Resource: "services"},
+	"Namespace":   {Version: "v1", Resource: "namespaces"},
+	"ConfigMap":   {Version: "v1", Resource: "configmaps"},
+	"Deployment":  {Group: "apps", Version: "v1", Resource: "deployments"},
+	"StatefulSet": {Group: "apps", Version: "v1", Resource: "statefulsets"},
+	"DaemonSet":   {Group: "apps", Version: "v1", Resource: "daemonsets"},
+	"ReplicaSet":  {Group: "apps", Version: "v1", Resource: "replicasets"},
+	"Job":         {Group: "batch", Version: "v1", Resource: "jobs"},
+	"CronJob":     {Group: "batch", Version: "v1", Resource: "cronjobs"},
+}
+
+func newEventRecorder(manager *K8sResourceManager) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(glog.Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: manager.ClientSet.CoreV1().Events(""),
+	})
+	return broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: EVENT_COMPONENT})
+}
+
+/**
+ * Record a warning event on the pod of an envoy node.
+ * Node ids without namespace (e.g. traffic-ingress) are recorded on the pod of traffic-control,
+ * which is given by POD_NAME and TRAFFIC_NAMESPACE env.
+ */
+func (manager *K8sResourceManager) NodeWarning(nodeId string, reason string, message string) {
+	var name, namespace string
+	index := strings.LastIndex(nodeId, ".")
+	if index > 0 {
+		name = nodeId[:index]
+		namespace = nodeId[index+1:]
+	} else {
+		name = os.Getenv("POD_NAME")
+		namespace = os.Getenv("TRAFFIC_NAMESPACE")
+	}
+	if name == "" || namespace == "" {
+		return
+	}
+	manager.EventRecorder.Event(&v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       name,
+		Namespace:  namespace,
+	}, v1.EventTypeWarning, reason, message)
+}
+
+//Record a warning event on an object of one of the kinds in kindResources
+func (manager *K8sResourceManager) ObjectWarning(kind string, namespace string, name string, uid types.UID, reason string, message string) {
+	gvr, ok := kindResources[kind]
+	if !ok {
+		glog.Errorf("Unexpected kind %s of %s@%s", kind, name, namespace)
+		return
+	}
+	manager.EventRecorder.Event(&v1.ObjectReference{
+		Kind:       kind,
+		APIVersion: gvr.GroupVersion().String(),
+		Name:       name,
+		Namespace:  namespace,
+		UID:        uid,
+	}, v1.EventTypeWarning, reason, message)
+}
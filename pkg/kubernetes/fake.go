@@ -2,26 +2,48 @@ package kubernetes
 
 import (
 	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/cache"
 	fcache "k8s.io/client-go/tools/cache/testing"
+	"k8s.io/client-go/util/workqueue"
 	"sync"
 )
 
 func NewFakeK8sResourceManager() *K8sResourceManager {
 	glog.Info("Using fake kubernetes client")
 
+	clientSet := fake.NewSimpleClientset()
 	result := &K8sResourceManager{
-		ClientSet: fake.NewSimpleClientset(),
+		ClientSet:     clientSet,
+		DynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()),
 
 		mutex:                &sync.RWMutex{},
 		labelTypeResourceMap: make(map[string]ResourcesOnLabel),
+		workloads:            make(map[string]*DeploymentInfo),
+		ownedWorkloads:       make(map[string]map[string]*DeploymentInfo),
+		policyMutex:          &sync.RWMutex{},
+		policies:             make(map[string]map[string]*TrafficPolicyInfo),
+		namespaces:           make(map[string]*NamespaceInfo),
 		watchListMap:         make(map[string]cache.ListerWatcher),
+		syncMutex:            &sync.Mutex{},
+		informerSynced:       make(map[string]cache.InformerSynced),
+		refreshQueues:        make(map[string]workqueue.Interface),
+		leading:              1,
+		informerFactory:      newInformerFactory(clientSet),
+		writes:               newWriteQueue(),
 	}
 
+	result.EventRecorder = newEventRecorder(result)
+
 	for resource, _ := range GetRESTClientMap(result.ClientSet) {
 		result.watchListMap[resource] = fcache.NewFakeControllerSource()
 	}
+	for resource, _ := range CustomResources {
+		result.watchListMap[resource] = fcache.NewFakeControllerSource()
+	}
+	result.watchListMap["configmaps"] = fcache.NewFakeControllerSource()
 	return result
 }
 
@@ -0,0 +1,369 @@
+package kubernetes
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/metrics"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"os"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_RESYNC_PERIOD = 10 * time.Minute
+	MAX_DISPATCH_RETRIES  = 10
+)
+
+//TRAFFIC_RESYNC_PERIOD env in seconds, cached objects are delivered to handlers again in this period
+func resyncPeriod() time.Duration {
+	value := os.Getenv("TRAFFIC_RESYNC_PERIOD")
+	if value == "" {
+		return DEFAULT_RESYNC_PERIOD
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		glog.Errorf("Invalid TRAFFIC_RESYNC_PERIOD %s: %s", value, err.Error())
+		return DEFAULT_RESYNC_PERIOD
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func newInformerFactory(clientSet kubernetes.Interface) informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactory(clientSet, resyncPeriod())
+}
+
+/**
+ * Return the shared informer of the resource, created on first call.
+ * The informer lists and watches through watchListMap, so that fake sources can be used in tests.
+ */
+func (manager *K8sResourceManager) sharedInformer(resource string, obj runtime.Object) cache.SharedIndexInformer {
+	return manager.informerFactory.InformerFor(obj, func(client kubernetes.Interface, resync time.Duration) cache.SharedIndexInformer {
+		return cache.NewSharedIndexInformer(manager.watchListMap[resource], obj, resync,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	})
+}
+
+//Pods in informer cache, only complete once pods informer is synced
+func (manager *K8sResourceManager) PodLister() corelisters.PodLister {
+	return corelisters.NewPodLister(manager.sharedInformer("pods", &v1.Pod{}).GetIndexer())
+}
+
+//Services in informer cache, only complete once services informer is synced
+func (manager *K8sResourceManager) ServiceLister() corelisters.ServiceLister {
+	return corelisters.NewServiceLister(manager.sharedInformer("services", &v1.Service{}).GetIndexer())
+}
+
+//compare resource infos, ResourceVersion change only is ignored
+func sameIgnoringVersion(oldInfo interface{}, newInfo interface{}) bool {
+	if oldInfo == nil || newInfo == nil || reflect.TypeOf(oldInfo) != reflect.TypeOf(newInfo) {
+		return false
+	}
+	oldVersion := reflect.ValueOf(oldInfo).Elem().FieldByName("ResourceVersion")
+	newVersion := reflect.ValueOf(newInfo).Elem().FieldByName("ResourceVersion")
+	if !newVersion.IsValid() {
+		return reflect.DeepEqual(oldInfo, newInfo)
+	}
+	version := newVersion.String()
+	newVersion.SetString(oldVersion.String())
+	result := reflect.DeepEqual(oldInfo, newInfo)
+	newVersion.SetString(version)
+	return result
+}
+
+/**
+ * Deliver transition of a resource to one handler, old is nil if the resource is added,
+ * new is nil if the resource is deleted. Return error if the handler failed, the transition is retried later.
+ * Called with K8sResourceManager locked.
+ */
+type dispatchFunc func(oldInfo interface{}, newInfo interface{}) error
+
+//turn a panic of the handler into the error of dispatchFunc
+func recoverHandler(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("handler panic: %v", r)
+	}
+}
+
+//pending transition of one resource, old is the state last delivered to the handler
+type transition struct {
+	oldInfo interface{}
+	newInfo interface{}
+}
+
+/**
+ * Rate limited work queue of one handler.
+ * Transitions of the same resource are merged while waiting in the queue, so a slow handler
+ * only sees the latest state and never delays other handlers.
+ */
+type eventQueue struct {
+	manager  *K8sResourceManager
+	queue    workqueue.RateLimitingInterface
+	dispatch dispatchFunc
+
+	mutex   *sync.Mutex
+	pending map[string]*transition
+	active  int
+}
+
+func newEventQueue(manager *K8sResourceManager, name string, dispatch dispatchFunc) *eventQueue {
+	return &eventQueue{
+		manager:  manager,
+		queue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), name),
+		dispatch: dispatch,
+		mutex:    &sync.Mutex{},
+		pending:  make(map[string]*transition),
+	}
+}
+
+func (q *eventQueue) add(key string, oldInfo interface{}, newInfo interface{}) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if t := q.pending[key]; t != nil {
+		t.newInfo = newInfo
+	} else {
+		q.pending[key] = &transition{oldInfo: oldInfo, newInfo: newInfo}
+	}
+	q.queue.Add(key)
+}
+
+//whether all queued transitions are delivered
+func (q *eventQueue) idle() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.pending) == 0 && q.active == 0
+}
+
+func (q *eventQueue) processNext() bool {
+	item, quit := q.queue.Get()
+	if quit {
+		return false
+	}
+	defer q.queue.Done(item)
+	key := item.(string)
+
+	q.mutex.Lock()
+	t := q.pending[key]
+	delete(q.pending, key)
+	if t != nil {
+		q.active++
+	}
+	q.mutex.Unlock()
+
+	if t != nil {
+		var err error
+		if t.oldInfo != nil || t.newInfo != nil {
+			q.manager.Lock()
+			err = q.dispatch(t.oldInfo, t.newInfo)
+			q.manager.Unlock()
+		}
+		q.mutex.Lock()
+		q.active--
+		if err != nil && q.queue.NumRequeues(item) < MAX_DISPATCH_RETRIES {
+			glog.Warningf("Handling %s failed, retry later: %s", key, err.Error())
+			//handler has not seen the transition, merge it with the one queued meanwhile
+			if queued := q.pending[key]; queued != nil {
+				queued.oldInfo = t.oldInfo
+			} else {
+				q.pending[key] = t
+			}
+			q.mutex.Unlock()
+			q.queue.AddRateLimited(item)
+			return true
+		}
+		q.mutex.Unlock()
+		if err != nil {
+			glog.Errorf("Handling %s failed after %d retries: %s", key, MAX_DISPATCH_RETRIES, err.Error())
+		}
+	}
+	q.queue.Forget(item)
+	return true
+}
+
+func (q *eventQueue) run(stopper chan struct{}) {
+	go wait.Until(func() {
+		for q.processNext() {
+		}
+	}, time.Second, stopper)
+	<-stopper
+	q.queue.ShutDown()
+}
+
+/**
+ * Watch a resource with its shared informer.
+ * The inline dispatchers (label index of K8sResourceManager) are called in informer callback, so that
+ * the index is always up to date when queued dispatchers run. Each of other dispatchers has its own queue.
+ * convert returns the resource info of an object, or nil if the object should be ignored.
+ * Block until stopper is closed.
+ */
+func (manager *K8sResourceManager) watch(stopper chan struct{}, resource string, kind string, informer cache.SharedIndexInformer,
+	convert func(obj interface{}) interface{}, inline []dispatchFunc, dispatchers []dispatchFunc) {
+
+	var queues []*eventQueue
+	for i, dispatch := range dispatchers {
+		queues = append(queues, newEventQueue(manager, fmt.Sprintf("%s-%d", resource, i), dispatch))
+	}
+
+	//last info delivered to handlers by key, used to tell whether handlers have seen the initial list
+	//deliverMutex serializes conversion and delivery of informer events and refreshes
+	deliverMutex := &sync.Mutex{}
+	delivered := make(map[string]interface{})
+
+	deliver := func(key string, oldInfo interface{}, newInfo interface{}) {
+		if len(inline) > 0 && (oldInfo != nil || newInfo != nil) {
+			manager.Lock()
+			for _, dispatch := range inline {
+				if err := dispatch(oldInfo, newInfo); err != nil {
+					glog.Errorf("Handling %s failed: %s", key, err.Error())
+				}
+			}
+			manager.Unlock()
+		}
+		for _, q := range queues {
+			q.add(key, oldInfo, newInfo)
+		}
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			metrics.InformerEvent(kind, "add")
+			key, err := cache.MetaNamespaceKeyFunc(obj)
+			if err != nil {
+				glog.Error(err.Error())
+				return
+			}
+			deliverMutex.Lock()
+			defer deliverMutex.Unlock()
+			newInfo := convert(obj)
+			delivered[key] = newInfo
+			deliver(key, nil, newInfo)
+		},
+		DeleteFunc: func(obj interface{}) {
+			metrics.InformerEvent(kind, "delete")
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			key, err := cache.MetaNamespaceKeyFunc(obj)
+			if err != nil {
+				glog.Error(err.Error())
+				return
+			}
+			deliverMutex.Lock()
+			defer deliverMutex.Unlock()
+			//handlers saw the last delivered info, converting obj again may give another one, e.g. namespace is not managed any more
+			oldInfo, seen := delivered[key]
+			if !seen {
+				oldInfo = convert(obj)
+			}
+			delete(delivered, key)
+			deliver(key, oldInfo, nil)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			metrics.InformerEvent(kind, "update")
+			key, err := cache.MetaNamespaceKeyFunc(newObj)
+			if err != nil {
+				glog.Error(err.Error())
+				return
+			}
+			deliverMutex.Lock()
+			defer deliverMutex.Unlock()
+			oldInfo, seen := delivered[key]
+			if !seen {
+				oldInfo = convert(oldObj)
+			}
+			newInfo := convert(newObj)
+			delivered[key] = newInfo
+			//periodic resync delivers the same version again, other updates are ignored if nothing but version changes
+			oldMeta, _ := meta.Accessor(oldObj)
+			newMeta, _ := meta.Accessor(newObj)
+			resync := oldMeta != nil && newMeta != nil && oldMeta.GetResourceVersion() == newMeta.GetResourceVersion()
+			if !resync && (oldInfo == nil && newInfo == nil || sameIgnoringVersion(oldInfo, newInfo)) {
+				return
+			}
+			deliver(key, oldInfo, newInfo)
+		},
+	})
+
+	//convert cached objects of a namespace (or all namespaces) again, deliver those whose info changed
+	refreshes := workqueue.NewNamed(fmt.Sprintf("%s-refresh", resource))
+	manager.registerRefresh(resource, refreshes)
+	go func() {
+		for {
+			item, quit := refreshes.Get()
+			if quit {
+				return
+			}
+			var objs []interface{}
+			if namespace := item.(string); namespace == metav1.NamespaceAll {
+				objs = informer.GetStore().List()
+			} else {
+				var err error
+				objs, err = informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+				if err != nil {
+					glog.Error(err.Error())
+				}
+			}
+			deliverMutex.Lock()
+			for _, obj := range objs {
+				key, err := cache.MetaNamespaceKeyFunc(obj)
+				if err != nil {
+					continue
+				}
+				oldInfo, seen := delivered[key]
+				if !seen {
+					//not delivered yet, informer callback will do it
+					continue
+				}
+				newInfo := convert(obj)
+				if oldInfo == nil && newInfo == nil || sameIgnoringVersion(oldInfo, newInfo) {
+					continue
+				}
+				delivered[key] = newInfo
+				deliver(key, oldInfo, newInfo)
+			}
+			deliverMutex.Unlock()
+			refreshes.Done(item)
+		}
+	}()
+
+	manager.registerInformer(resource, func() bool {
+		if !informer.HasSynced() || refreshes.Len() > 0 {
+			return false
+		}
+		deliverMutex.Lock()
+		for _, key := range informer.GetStore().ListKeys() {
+			if _, seen := delivered[key]; !seen {
+				deliverMutex.Unlock()
+				return false
+			}
+		}
+		deliverMutex.Unlock()
+		for _, q := range queues {
+			if !q.idle() {
+				return false
+			}
+		}
+		return true
+	})
+
+	for _, q := range queues {
+		go q.run(stopper)
+	}
+	manager.startWriteQueue(stopper)
+	manager.informerFactory.Start(stopper)
+	glog.Infof("Start watching %s", resource)
+	<-stopper
+	refreshes.ShutDown()
+	glog.Infof("Watching %s terminated", resource)
+}
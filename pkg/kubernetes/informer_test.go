@@ -0,0 +1,137 @@
+package kubernetes
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/util/workqueue"
+	"testing"
+	"time"
+)
+
+func TestEventQueueMerge(t *testing.T) {
+	manager := NewFakeK8sResourceManager()
+	var delivered []*transition
+	q := newEventQueue(manager, "test", func(oldInfo interface{}, newInfo interface{}) error {
+		delivered = append(delivered, &transition{oldInfo: oldInfo, newInfo: newInfo})
+		return nil
+	})
+
+	//added then updated before the handler runs
+	q.add("ns/a", nil, "a1")
+	q.add("ns/a", "a1", "a2")
+	//added then deleted before the handler runs
+	q.add("ns/b", nil, "b1")
+	q.add("ns/b", "b1", nil)
+	assert.False(t, q.idle())
+
+	assert.True(t, q.processNext())
+	assert.True(t, q.processNext())
+	assert.True(t, q.idle())
+
+	assert.Equal(t, len(delivered), 1)
+	assert.Nil(t, delivered[0].oldInfo)
+	assert.Equal(t, delivered[0].newInfo, "a2")
+}
+
+//pod handler failing the first failures calls
+type failingPodHandler struct {
+	lastPodHandler
+	failures int
+}
+
+func (h *failingPodHandler) PodValid(pod *PodInfo) bool {
+	return true
+}
+func (h *failingPodHandler) PodAdded(pod *PodInfo) {
+	if h.failures > 0 {
+		h.failures--
+		panic("conflict")
+	}
+	h.lastPodHandler.PodAdded(pod)
+}
+
+func TestEventQueueRetry(t *testing.T) {
+	manager := NewFakeK8sResourceManager()
+	handler := &failingPodHandler{lastPodHandler: lastPodHandler{pods: make(map[string]*PodInfo)}, failures: 1}
+	q := newEventQueue(manager, "test", podDispatcher(handler))
+	q.queue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond))
+
+	pod := &PodInfo{}
+	q.add("ns/a", nil, pod)
+	assert.True(t, q.processNext())
+	assert.Equal(t, len(handler.pods), 0)
+	assert.False(t, q.idle())
+	assert.Equal(t, q.queue.NumRequeues("ns/a"), 1)
+
+	//failed transition is delivered again after backoff
+	assert.True(t, q.processNext())
+	assert.Equal(t, handler.pods[pod.Name()], pod)
+	assert.True(t, q.idle())
+	assert.Equal(t, q.queue.NumRequeues("ns/a"), 0)
+}
+
+func TestWriteQueueRetry(t *testing.T) {
+	manager := NewFakeK8sResourceManager()
+	stopper := make(chan struct{})
+	defer close(stopper)
+	manager.startWriteQueue(stopper)
+
+	var calls []string
+	failures := 2
+	manager.writes.add("pod/ns/a", &writeOp{
+		operation: "first",
+		write: func() error {
+			calls = append(calls, "first")
+			if failures > 0 {
+				failures--
+				return fmt.Errorf("conflict")
+			}
+			return nil
+		},
+	})
+	manager.writes.add("pod/ns/a", &writeOp{
+		operation: "second",
+		write: func() error {
+			calls = append(calls, "second")
+			return nil
+		},
+	})
+	time.Sleep(500 * time.Millisecond)
+
+	//writes on the same object keep their order when retried
+	assert.Equal(t, calls, []string{"first", "first", "first", "second"})
+}
+
+func TestWriteQueueGiveUp(t *testing.T) {
+	q := newWriteQueue()
+	q.queue = workqueue.NewRateLimitingQueue(workqueue.NewItemExponentialFailureRateLimiter(time.Millisecond, time.Millisecond))
+
+	var calls []string
+	q.add("pod/ns/a", &writeOp{
+		operation: "first",
+		write: func() error {
+			calls = append(calls, "first")
+			return fmt.Errorf("forbidden")
+		},
+	})
+	failures := 1
+	q.add("pod/ns/a", &writeOp{
+		operation: "second",
+		write: func() error {
+			calls = append(calls, "second")
+			if failures > 0 {
+				failures--
+				return fmt.Errorf("conflict")
+			}
+			return nil
+		},
+	})
+	for i := 0; i <= MAX_WRITE_RETRIES+1; i++ {
+		assert.True(t, q.processNext())
+	}
+
+	//the second write is still retried after the first one is given up
+	assert.Equal(t, len(calls), MAX_WRITE_RETRIES+3)
+	assert.Equal(t, calls[len(calls)-2:], []string{"second", "second"})
+	assert.Equal(t, q.queue.NumRequeues("pod/ns/a"), 0)
+}
@@ -2,10 +2,18 @@ package kubernetes
 
 import (
 	"fmt"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"strings"
 )
 
+const (
+	INGRESS_PATH_EXACT                   = "Exact"
+	INGRESS_PATH_PREFIX                  = "Prefix"
+	INGRESS_PATH_IMPLEMENTATION_SPECIFIC = "ImplementationSpecific"
+
+	INGRESS_CLASS_ANNOTATION = "kubernetes.io/ingress.class"
+)
+
 type IngressHostInfo struct {
 	Host    string
 	PathMap map[string]*IngressClusterInfo
@@ -14,19 +22,63 @@ type IngressHostInfo struct {
 
 type IngressClusterInfo struct {
 	Service string
-	Port    uint32
-	Path    string
+	//0 if the backend refers the service port by name
+	Port     uint32
+	PortName string
+	Path     string
+	PathType string
+	//service port of PortName resolved by the handler, 0 if not resolved yet
+	ResolvedPort uint32
 }
 
 type IngressInfo struct {
 	name            string
 	namespace       string
 	ResourceVersion string
+	//empty if the ingress has no ingressClassName or kubernetes.io/ingress.class annotation
+	Class string
 
 	HostPathToClusterMap map[string]*IngressHostInfo
 }
 
-func NewIngressInfo(ingress *v1beta1.Ingress) *IngressInfo {
+/**
+ * Path with its type used in service annotation, ImplementationSpecific path has no type prefix
+ * so that annotations written by earlier versions keep working.
+ */
+func IngressPathConfig(pathType string, path string) string {
+	switch pathType {
+	case INGRESS_PATH_EXACT, INGRESS_PATH_PREFIX:
+		return fmt.Sprintf("%s:%s", pathType, path)
+	default:
+		return path
+	}
+}
+
+//Return path type and path of the value returned by IngressPathConfig
+func ParseIngressPathConfig(config string) (string, string) {
+	for _, pathType := range []string{INGRESS_PATH_EXACT, INGRESS_PATH_PREFIX} {
+		if strings.HasPrefix(config, pathType+":") {
+			return pathType, config[len(pathType)+1:]
+		}
+	}
+	return INGRESS_PATH_IMPLEMENTATION_SPECIFIC, config
+}
+
+//return nil if the backend is not a service, e.g. resource backend
+func newIngressClusterInfo(backend *networkingv1.IngressBackend, path string, pathType string) *IngressClusterInfo {
+	if backend == nil || backend.Service == nil {
+		return nil
+	}
+	return &IngressClusterInfo{
+		Path:     path,
+		PathType: pathType,
+		Service:  backend.Service.Name,
+		Port:     uint32(backend.Service.Port.Number),
+		PortName: backend.Service.Port.Name,
+	}
+}
+
+func NewIngressInfo(ingress *networkingv1.Ingress) *IngressInfo {
 	hostPathToClusterMap := map[string]*IngressHostInfo{
 		"*": &IngressHostInfo{
 			Host:    "*",
@@ -42,12 +94,8 @@ func NewIngressInfo(ingress *v1beta1.Ingress) *IngressInfo {
 			}
 		}
 	}
-	if ingress.Spec.Backend != nil {
-		defaultCluster := &IngressClusterInfo{
-			Path:    "/",
-			Service: ingress.Spec.Backend.ServiceName,
-			Port:    uint32(ingress.Spec.Backend.ServicePort.IntVal),
-		}
+	defaultCluster := newIngressClusterInfo(ingress.Spec.DefaultBackend, "/", INGRESS_PATH_IMPLEMENTATION_SPECIFIC)
+	if defaultCluster != nil {
 		for _, hostInfo := range hostPathToClusterMap {
 			hostInfo.PathMap["/"] = defaultCluster
 		}
@@ -65,38 +113,66 @@ func NewIngressInfo(ingress *v1beta1.Ingress) *IngressInfo {
 		}
 	}
 	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
 		host := rule.Host
 		if host == "" {
 			host = "*"
 		}
 		hostInfo := hostPathToClusterMap[host]
 
-		for _, cluster := range rule.HTTP.Paths {
-			path := cluster.Path
-			if path == "" {
-				path = "/"
+		for _, path := range rule.HTTP.Paths {
+			pathValue := path.Path
+			if pathValue == "" {
+				pathValue = "/"
+			}
+			pathType := INGRESS_PATH_IMPLEMENTATION_SPECIFIC
+			if path.PathType != nil {
+				pathType = string(*path.PathType)
 			}
-			hostInfo.PathMap[path] = &IngressClusterInfo{
-				Path:    path,
-				Service: cluster.Backend.ServiceName,
-				Port:    uint32(cluster.Backend.ServicePort.IntVal),
+			clusterInfo := newIngressClusterInfo(&path.Backend, pathValue, pathType)
+			if clusterInfo != nil {
+				hostInfo.PathMap[IngressPathConfig(pathType, pathValue)] = clusterInfo
 			}
 		}
 
 	}
 
+	class := ingress.Annotations[INGRESS_CLASS_ANNOTATION]
+	if ingress.Spec.IngressClassName != nil {
+		class = *ingress.Spec.IngressClassName
+	}
 	return &IngressInfo{
 		HostPathToClusterMap: hostPathToClusterMap,
 		namespace:            ingress.Namespace,
 		name:                 ingress.Name,
 		ResourceVersion:      ingress.ResourceVersion,
+		Class:                class,
+	}
+}
+
+//deep copy, so that handlers may record resolved ports without changing the delivered ingress
+func (ingress *IngressInfo) Clone() *IngressInfo {
+	result := *ingress
+	result.HostPathToClusterMap = make(map[string]*IngressHostInfo)
+	for host, hostInfo := range ingress.HostPathToClusterMap {
+		hostCopy := *hostInfo
+		hostCopy.PathMap = make(map[string]*IngressClusterInfo)
+		for path, clusterInfo := range hostInfo.PathMap {
+			clusterCopy := *clusterInfo
+			hostCopy.PathMap[path] = &clusterCopy
+		}
+		result.HostPathToClusterMap[host] = &hostCopy
 	}
+	return &result
 }
 
-func (ingress *IngressInfo) GetServiceAnnotations(hostInfo *IngressHostInfo, clusterInfo *IngressClusterInfo) map[string]string {
+//port is the service port of the backend, resolved by caller if the backend refers port name
+func (ingress *IngressInfo) GetServiceAnnotations(hostInfo *IngressHostInfo, clusterInfo *IngressClusterInfo, port uint32) map[string]string {
 	return map[string]string{
-		IngressAttrLabel(clusterInfo.Port, "config"): fmt.Sprintf("%s@%s", clusterInfo.Path, hostInfo.Host),
-		IngressAttrLabel(clusterInfo.Port, "secret"): hostInfo.Secret,
+		IngressAttrLabel(port, "config"): fmt.Sprintf("%s@%s", IngressPathConfig(clusterInfo.PathType, clusterInfo.Path), hostInfo.Host),
+		IngressAttrLabel(port, "secret"): hostInfo.Secret,
 	}
 }
 
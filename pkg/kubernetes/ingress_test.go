@@ -0,0 +1,66 @@
+package kubernetes
+
+import (
+	"github.com/stretchr/testify/assert"
+	networkingv1 "k8s.io/api/networking/v1"
+	"testing"
+)
+
+func TestNewIngressInfo(t *testing.T) {
+	exact := networkingv1.PathTypeExact
+	prefix := networkingv1.PathTypePrefix
+	class := "traffic"
+
+	var ingress networkingv1.Ingress
+	ingress.Name = "ingress1"
+	ingress.Namespace = "test-ns"
+	ingress.Spec.IngressClassName = &class
+	ingress.Spec.Rules = []networkingv1.IngressRule{{
+		Host: "test.com",
+		IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{
+					{
+						Path:     "/api",
+						PathType: &exact,
+						Backend: networkingv1.IngressBackend{
+							Service: &networkingv1.IngressServiceBackend{
+								Name: "svc1",
+								Port: networkingv1.ServiceBackendPort{Number: 8080},
+							},
+						},
+					},
+					{
+						Path:     "/api",
+						PathType: &prefix,
+						Backend: networkingv1.IngressBackend{
+							Service: &networkingv1.IngressServiceBackend{
+								Name: "svc2",
+								Port: networkingv1.ServiceBackendPort{Name: "http"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}}
+
+	info := NewIngressInfo(&ingress)
+	assert.Equal(t, info.Class, "traffic")
+	pathMap := info.HostPathToClusterMap["test.com"].PathMap
+	assert.Equal(t, len(pathMap), 2)
+	assert.Equal(t, pathMap["Exact:/api"].Service, "svc1")
+	assert.Equal(t, pathMap["Exact:/api"].Port, uint32(8080))
+	assert.Equal(t, pathMap["Prefix:/api"].PortName, "http")
+	assert.Equal(t, pathMap["Prefix:/api"].Port, uint32(0))
+
+	annotations := info.GetServiceAnnotations(info.HostPathToClusterMap["test.com"], pathMap["Exact:/api"], 8080)
+	assert.Equal(t, annotations["traffic.ingress.port.8080.config"], "Exact:/api@test.com")
+
+	pathType, path := ParseIngressPathConfig("/legacy")
+	assert.Equal(t, pathType, INGRESS_PATH_IMPLEMENTATION_SPECIFIC)
+	assert.Equal(t, path, "/legacy")
+	pathType, path = ParseIngressPathConfig("Prefix:/api")
+	assert.Equal(t, pathType, INGRESS_PATH_PREFIX)
+	assert.Equal(t, path, "/api")
+}
@@ -0,0 +1,130 @@
+package kubernetes
+
+import (
+	"context"
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	LEADER_ELECTION_LEASE = "traffic-control"
+	LEADER_ELECTION_ENV   = "TRAFFIC_LEADER_ELECTION"
+
+	DEFAULT_LEASE_DURATION = 15 * time.Second
+)
+
+/**
+ * Handlers which write kubernetes resources (e.g. annotators) implement it to catch up
+ * with the events received while another replica was the leader.
+ */
+type LeaderEventHandler interface {
+	//called with K8sResourceManager locked when this replica becomes leader
+	StartedLeading()
+}
+
+func LeaderElectionEnabled() bool {
+	return GetLabelValueBool(os.Getenv(LEADER_ELECTION_ENV))
+}
+
+/**
+ * Whether this replica should write kubernetes resources.
+ * Always true if leader election is not used, e.g. envoy-manager or single traffic-control replica.
+ */
+func (manager *K8sResourceManager) IsLeader() bool {
+	return atomic.LoadInt32(&manager.leading) == 1
+}
+
+func (manager *K8sResourceManager) AddLeaderEventHandler(handlers ...LeaderEventHandler) {
+	manager.Lock()
+	defer manager.Unlock()
+	manager.leaderHandlers = append(manager.leaderHandlers, handlers...)
+}
+
+func (manager *K8sResourceManager) startedLeading(identity string) {
+	glog.Infof("%s became leader", identity)
+	manager.Lock()
+	defer manager.Unlock()
+
+	atomic.StoreInt32(&manager.leading, 1)
+	for _, h := range manager.leaderHandlers {
+		h.StartedLeading()
+	}
+}
+
+func (manager *K8sResourceManager) stoppedLeading(identity string) {
+	if atomic.SwapInt32(&manager.leading, 0) == 1 {
+		glog.Warningf("%s lost leadership, stop writing kubernetes resources", identity)
+	}
+}
+
+/**
+ * Create an elector on Lease namespace/traffic-control, the manager stops writing kubernetes resources
+ * until the elector acquires the lease. renewDeadline and retryPeriod are derived from leaseDuration.
+ */
+func (manager *K8sResourceManager) NewLeaderElector(namespace string, identity string, leaseDuration time.Duration) (*leaderelection.LeaderElector, error) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      LEADER_ELECTION_LEASE,
+			Namespace: namespace,
+		},
+		Client: manager.ClientSet.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity:      identity,
+			EventRecorder: manager.EventRecorder,
+		},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: leaseDuration * 2 / 3,
+		RetryPeriod:   leaseDuration / 5,
+		//let other replicas take over immediately on shutdown
+		ReleaseOnCancel: true,
+		Name:            LEADER_ELECTION_LEASE,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				manager.startedLeading(identity)
+			},
+			OnStoppedLeading: func() {
+				manager.stoppedLeading(identity)
+			},
+			OnNewLeader: func(leader string) {
+				glog.Infof("Current leader is %s", leader)
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	atomic.StoreInt32(&manager.leading, 0)
+	return elector, nil
+}
+
+//Run the elector until ctx is done, rejoin the election when leadership is lost
+func (manager *K8sResourceManager) RunLeaderElection(ctx context.Context, elector *leaderelection.LeaderElector) {
+	for {
+		elector.Run(ctx)
+		select {
+		case <-ctx.Done():
+			glog.Info("Leader election terminated")
+			return
+		default:
+		}
+	}
+}
+
+//return true if the write should be skipped because another replica is the leader
+func (manager *K8sResourceManager) skipWrite(operation string, name string) bool {
+	if manager.IsLeader() {
+		return false
+	}
+	if glog.V(2) {
+		glog.Infof("Not leader, skip %s on %s", operation, name)
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package kubernetes
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+type testLeaderHandler struct {
+	started int
+}
+
+func (h *testLeaderHandler) StartedLeading() {
+	h.started++
+}
+
+func getServiceAnnotation(t *testing.T, manager *K8sResourceManager, key string) string {
+	svc, err := manager.ClientSet.CoreV1().Services("test-ns").Get(context.TODO(), "svc1", metav1.GetOptions{})
+	assert.Nil(t, err)
+	return svc.Annotations[key]
+}
+
+func TestLeaderElection(t *testing.T) {
+	manager1 := NewFakeK8sResourceManager()
+	manager2 := NewFakeK8sResourceManager()
+	//replicas share the same api server
+	manager2.ClientSet = manager1.ClientSet
+
+	var svc corev1.Service
+	svc.Name = "svc1"
+	svc.Namespace = "test-ns"
+	_, err := manager1.ClientSet.CoreV1().Services("test-ns").Create(context.TODO(), &svc, metav1.CreateOptions{})
+	assert.Nil(t, err)
+
+	handler1 := &testLeaderHandler{}
+	handler2 := &testLeaderHandler{}
+	manager1.AddLeaderEventHandler(handler1)
+	manager2.AddLeaderEventHandler(handler2)
+
+	assert.True(t, manager1.IsLeader())
+	elector1, err := manager1.NewLeaderElector("test-ns", "replica1", time.Second)
+	assert.Nil(t, err)
+	elector2, err := manager2.NewLeaderElector("test-ns", "replica2", time.Second)
+	assert.Nil(t, err)
+	assert.False(t, manager1.IsLeader())
+	assert.False(t, manager2.IsLeader())
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	go manager1.RunLeaderElection(ctx1, elector1)
+	time.Sleep(500 * time.Millisecond)
+	go manager2.RunLeaderElection(ctx2, elector2)
+	time.Sleep(500 * time.Millisecond)
+
+	assert.True(t, manager1.IsLeader())
+	assert.False(t, manager2.IsLeader())
+	assert.Equal(t, handler1.started, 1)
+	assert.Equal(t, handler2.started, 0)
+
+	//follower does not write
+	assert.Nil(t, manager2.MergeServiceAnnotation("svc1", "test-ns", map[string]string{"key2": "value2"}))
+	assert.Equal(t, getServiceAnnotation(t, manager1, "key2"), "")
+	assert.Nil(t, manager1.MergeServiceAnnotation("svc1", "test-ns", map[string]string{"key1": "value1"}))
+	assert.Equal(t, getServiceAnnotation(t, manager1, "key1"), "value1")
+
+	//lease is released on shutdown, follower takes over
+	cancel1()
+	time.Sleep(time.Second)
+
+	assert.False(t, manager1.IsLeader())
+	assert.True(t, manager2.IsLeader())
+	assert.Equal(t, handler2.started, 1)
+	assert.Nil(t, manager2.MergeServiceAnnotation("svc1", "test-ns", map[string]string{"key2": "value2"}))
+	assert.Equal(t, getServiceAnnotation(t, manager1, "key2"), "value2")
+}
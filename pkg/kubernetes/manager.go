@@ -1,82 +1,247 @@
 package kubernetes
 
 import (
+	"context"
 	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/metrics"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/tools/cache"
 )
 
+const OPTIONAL_SYNC_TIMEOUT = 30 * time.Second
+
+//resources whose informers do not gate WaitForSync
+var OptionalResources = map[string]bool{"cronjobs": true, "trafficpolicies": true, "defaulttrafficpolicies": true}
+
 type ResourcesOnLabel map[ResourceType][]ResourceInfoPointer
 
 type K8sResourceManager struct {
 	labelTypeResourceMap map[string]ResourcesOnLabel
-	ClientSet            kubernetes.Interface
-	mutex                *sync.RWMutex
-	locked               int32
+	//workloads by kind/namespace/name, and workloads owned by each of them
+	workloads      map[string]*DeploymentInfo
+	ownedWorkloads map[string]map[string]*DeploymentInfo
+	//namespace => kind/name => policy, guarded by policyMutex since policies are read when converting objects
+	policyMutex *sync.RWMutex
+	policies    map[string]map[string]*TrafficPolicyInfo
+	//traffic-defaults ConfigMap and namespaces by name, also guarded by policyMutex
+	meshDefaults  *MeshDefaultsInfo
+	namespaces    map[string]*NamespaceInfo
+	ClientSet     kubernetes.Interface
+	DynamicClient dynamic.Interface
+	EventRecorder record.EventRecorder
+	mutex         *sync.RWMutex
+	locked        int32
+	//time when the lock is acquired, only accessed by lock holder
+	lockTime time.Time
+
+	watchListMap    map[string]cache.ListerWatcher
+	restClients     map[string]cache.Getter
+	informerFactory informers.SharedInformerFactory
+	writes          *writeQueue
+
+	syncMutex      *sync.Mutex
+	informerSynced map[string]cache.InformerSynced
+	refreshQueues  map[string]workqueue.Interface
+
+	//nil if all namespaces are managed
+	namespaceFilter *NamespaceFilter
 
-	watchListMap map[string]cache.ListerWatcher
-	restClients  map[string]cache.Getter
+	//1 if this replica may write kubernetes resources, see IsLeader()
+	leading        int32
+	leaderHandlers []LeaderEventHandler
 }
 
 func GetRESTClientMap(clientSet kubernetes.Interface) map[string]cache.Getter {
 	return map[string]cache.Getter{
-		"pods":         clientSet.Core().RESTClient(),
-		"services":     clientSet.Core().RESTClient(),
-		"deployments":  clientSet.ExtensionsV1beta1().RESTClient(),
-		"statefulsets": clientSet.AppsV1beta1().RESTClient(),
-		"daemonsets":   clientSet.ExtensionsV1beta1().RESTClient(),
-		"ingresses":    clientSet.ExtensionsV1beta1().RESTClient(),
+		"pods":           clientSet.CoreV1().RESTClient(),
+		"namespaces":     clientSet.CoreV1().RESTClient(),
+		"services":       clientSet.CoreV1().RESTClient(),
+		"deployments":    clientSet.AppsV1().RESTClient(),
+		"statefulsets":   clientSet.AppsV1().RESTClient(),
+		"daemonsets":     clientSet.AppsV1().RESTClient(),
+		"replicasets":    clientSet.AppsV1().RESTClient(),
+		"jobs":           clientSet.BatchV1().RESTClient(),
+		"cronjobs":       clientSet.BatchV1().RESTClient(),
+		"ingresses":      clientSet.NetworkingV1().RESTClient(),
+		"endpointslices": clientSet.DiscoveryV1().RESTClient(),
 	}
 }
 
-func NewK8sResourceManager() (*K8sResourceManager, error) {
+/**
+ * Objects of namespaces not accepted by filter are not delivered to handlers of Watch* functions,
+ * except for namespaces, secrets, traffic policies and traffic-defaults. All namespaces are managed if filter is nil.
+ */
+func NewK8sResourceManager(filter *NamespaceFilter) (*K8sResourceManager, error) {
 
-	clientSet, err := getK8sClientSet()
+	config, err := getK8sConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
 
 	result := &K8sResourceManager{
-		ClientSet: clientSet,
+		ClientSet:     clientSet,
+		DynamicClient: dynamicClient,
 
 		mutex:                &sync.RWMutex{},
 		labelTypeResourceMap: make(map[string]ResourcesOnLabel),
+		workloads:            make(map[string]*DeploymentInfo),
+		ownedWorkloads:       make(map[string]map[string]*DeploymentInfo),
+		policyMutex:          &sync.RWMutex{},
+		policies:             make(map[string]map[string]*TrafficPolicyInfo),
+		namespaces:           make(map[string]*NamespaceInfo),
+		namespaceFilter:      filter,
 		watchListMap:         make(map[string]cache.ListerWatcher),
 		restClients:          GetRESTClientMap(clientSet),
+		syncMutex:            &sync.Mutex{},
+		informerSynced:       make(map[string]cache.InformerSynced),
+		refreshQueues:        make(map[string]workqueue.Interface),
+		leading:              1,
+		informerFactory:      newInformerFactory(clientSet),
+		writes:               newWriteQueue(),
 	}
 
+	result.EventRecorder = newEventRecorder(result)
+
+	namespace, scope := filter.listWatchScope()
 	for resource, getter := range result.restClients {
-		result.watchListMap[resource] = cache.NewListWatchFromClient(
-			getter, resource, "", fields.Everything())
+		if resource == "namespaces" {
+			result.watchListMap[resource] = cache.NewListWatchFromClient(
+				getter, resource, "", fields.Everything())
+		} else {
+			result.watchListMap[resource] = cache.NewFilteredListWatchFromClient(
+				getter, resource, namespace, scope)
+		}
 	}
+	for resource, gvr := range CustomResources {
+		result.watchListMap[resource] = newDynamicListWatch(dynamicClient, gvr)
+	}
+	result.watchListMap["configmaps"] = newDefaultsListWatch(clientSet.CoreV1().RESTClient())
 	return result, nil
 }
+
+//called by Watch* functions once the informer is created
+func (manager *K8sResourceManager) registerInformer(resource string, synced cache.InformerSynced) {
+	manager.syncMutex.Lock()
+	defer manager.syncMutex.Unlock()
+	manager.informerSynced[resource] = synced
+}
+
+//called by Watch* functions once the informer is created
+func (manager *K8sResourceManager) registerRefresh(resource string, queue workqueue.Interface) {
+	manager.syncMutex.Lock()
+	defer manager.syncMutex.Unlock()
+	manager.refreshQueues[resource] = queue
+}
+
+/**
+ * Ask the watch of the resource to convert cached objects of the namespace again and deliver changed ones to handlers,
+ * used when resource infos depend on other resources, e.g. TrafficPolicy. Empty namespace refreshes all namespaces.
+ * Does nothing if the resource is not watched.
+ * Does not block, may be called with K8sResourceManager locked.
+ */
+func (manager *K8sResourceManager) refresh(resource string, namespace string) {
+	manager.syncMutex.Lock()
+	queue := manager.refreshQueues[resource]
+	manager.syncMutex.Unlock()
+	if queue != nil {
+		queue.Add(namespace)
+	}
+}
+
+//Whether informers of all given resources are started and have delivered their initial list
+func (manager *K8sResourceManager) InformersSynced(resources ...string) bool {
+	for _, resource := range resources {
+		manager.syncMutex.Lock()
+		synced := manager.informerSynced[resource]
+		manager.syncMutex.Unlock()
+		if synced == nil || !synced() {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Block until informers of all given resources are synced, return false if stopper is closed before that.
+ * Informers of OptionalResources are waited for at most OPTIONAL_SYNC_TIMEOUT, since their apis may not be served,
+ * e.g. CRDs not installed or batch/v1 CronJob before kubernetes 1.21.
+ */
+func (manager *K8sResourceManager) WaitForSync(stopper chan struct{}, resources ...string) bool {
+	var required, optional []string
+	for _, resource := range resources {
+		if OptionalResources[resource] {
+			optional = append(optional, resource)
+		} else {
+			required = append(required, resource)
+		}
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.Now().Add(OPTIONAL_SYNC_TIMEOUT)
+	for !manager.InformersSynced(required...) || (!manager.InformersSynced(optional...) && time.Now().Before(deadline)) {
+		select {
+		case <-stopper:
+			return false
+		case <-ticker.C:
+		}
+	}
+	for _, resource := range optional {
+		if !manager.InformersSynced(resource) {
+			glog.Warningf("Informer of %s is not synced in %s, continue without it", resource, OPTIONAL_SYNC_TIMEOUT)
+		}
+	}
+	glog.Infof("Informers synced: %v", resources)
+	return true
+}
+
 func (manager *K8sResourceManager) NewCond() *sync.Cond {
 	return sync.NewCond(manager.mutex)
 }
 func (manager *K8sResourceManager) Lock() {
 	manager.mutex.Lock()
 	atomic.AddInt32(&manager.locked, 1)
+	manager.lockTime = time.Now()
 }
 func (manager *K8sResourceManager) Unlock() {
+	metrics.LockHoldDuration.Observe(time.Since(manager.lockTime).Seconds())
 	atomic.StoreInt32(&manager.locked, 0)
 	manager.mutex.Unlock()
 }
 
+//Wait on a cond created by NewCond() with the lock held, waiting time is not counted as lock holding time
+func (manager *K8sResourceManager) Wait(cond *sync.Cond) {
+	metrics.LockHoldDuration.Observe(time.Since(manager.lockTime).Seconds())
+	cond.Wait()
+	manager.lockTime = time.Now()
+}
+
 func (manager *K8sResourceManager) IsLocked() bool {
 	return atomic.LoadInt32(&manager.locked) != 0
 }
 
-func getK8sClientSet() (kubernetes.Interface, error) {
+func getK8sConfig() (*rest.Config, error) {
 	configPath := os.Getenv("KUBECONFIG")
 
 	var config *rest.Config
@@ -88,16 +253,11 @@ func getK8sClientSet() (kubernetes.Interface, error) {
 		glog.Infof("KUBECONFIG:%s\n", configPath)
 		config, err = clientcmd.BuildConfigFromFlags("", configPath)
 	}
-	if err != nil {
-		return nil, err
-	}
-
-	// create the clientset
-	return kubernetes.NewForConfig(config)
+	return config, err
 }
 
 func (manager *K8sResourceManager) PodExists(name string, ns string) (bool, error) {
-	_, err := manager.ClientSet.CoreV1().Pods(ns).Get(name, metav1.GetOptions{})
+	_, err := manager.ClientSet.CoreV1().Pods(ns).Get(context.TODO(), name, metav1.GetOptions{})
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			return false, nil
@@ -2,9 +2,8 @@ package kubernetes
 
 import (
 	"github.com/stretchr/testify/assert"
-	apps_v1beta1 "k8s.io/api/apps/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
-	v1beta1 "k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"reflect"
 	"sort"
@@ -29,19 +28,19 @@ func TestGetMatchedResources(t *testing.T) {
 	go k8sManager.WatchStatefulSets(stopper, k8sManager)
 	go k8sManager.WatchDaemonSets(stopper, k8sManager)
 
-	var deploy v1beta1.Deployment
+	var deploy appsv1.Deployment
 	deploy.Name = "Comp1"
 	deploy.Namespace = "test-ns"
 	deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"a": "b"}}
 	deploymentWatchlist.Add(&deploy)
 
-	var stateful apps_v1beta1.StatefulSet
+	var stateful appsv1.StatefulSet
 	stateful.Name = "Comp2"
 	stateful.Namespace = "test-ns"
 	stateful.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"a": "b"}}
 	statefulsetWatchlist.Add(&stateful)
 
-	var daemonSet v1beta1.DaemonSet
+	var daemonSet appsv1.DaemonSet
 	daemonSet.Name = "Comp3"
 	daemonSet.Namespace = "test-ns"
 	daemonSet.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"a": "b"}}
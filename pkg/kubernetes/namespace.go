@@ -0,0 +1,98 @@
+package kubernetes
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type NamespaceInfo struct {
+	ResourceVersion string
+	UID             types.UID
+	name            string
+	Labels          map[string]string
+	Annotations     map[string]string
+	//traffic.* labels applied to services and pods of the namespace
+	Config map[string]string
+}
+
+func NewNamespaceInfo(namespace *v1.Namespace) *NamespaceInfo {
+	return &NamespaceInfo{
+		ResourceVersion: namespace.ResourceVersion,
+		UID:             namespace.UID,
+		name:            namespace.Name,
+		Labels:          namespace.Labels,
+		Annotations:     namespace.Annotations,
+		Config:          defaultConfigOf(namespace.Labels),
+	}
+}
+
+func (ns *NamespaceInfo) Name() string {
+	return ns.name
+}
+
+type NamespaceEventHandler interface {
+	NamespaceAdded(ns *NamespaceInfo)
+	NamespaceDeleted(ns *NamespaceInfo)
+	NamespaceUpdated(oldNamespace, newNamespace *NamespaceInfo)
+}
+
+//objects of the namespace are delivered again with the new namespace labels
+func (manager *K8sResourceManager) NamespaceAdded(ns *NamespaceInfo) {
+	manager.NamespaceUpdated(nil, ns)
+}
+func (manager *K8sResourceManager) NamespaceDeleted(ns *NamespaceInfo) {
+	manager.NamespaceUpdated(ns, nil)
+}
+func (manager *K8sResourceManager) NamespaceUpdated(oldNamespace, newNamespace *NamespaceInfo) {
+	var name string
+	manager.policyMutex.Lock()
+	if oldNamespace != nil {
+		name = oldNamespace.Name()
+		delete(manager.namespaces, name)
+	}
+	if newNamespace != nil {
+		name = newNamespace.Name()
+		manager.namespaces[name] = newNamespace
+	}
+	manager.policyMutex.Unlock()
+
+	if name == metav1.NamespaceAll {
+		return
+	}
+	//labels may also change whether the namespace is managed
+	for _, resource := range managedResources {
+		manager.refresh(resource, name)
+	}
+}
+
+func namespaceDispatcher(h NamespaceEventHandler) dispatchFunc {
+	return func(oldInfo interface{}, newInfo interface{}) (err error) {
+		defer recoverHandler(&err)
+		oldNamespace, _ := oldInfo.(*NamespaceInfo)
+		newNamespace, _ := newInfo.(*NamespaceInfo)
+		if oldNamespace == nil && newNamespace != nil {
+			h.NamespaceAdded(newNamespace)
+		} else if oldNamespace != nil && newNamespace == nil {
+			h.NamespaceDeleted(oldNamespace)
+		} else if oldNamespace != nil && newNamespace != nil {
+			h.NamespaceUpdated(oldNamespace, newNamespace)
+		}
+		return nil
+	}
+}
+
+func (manager *K8sResourceManager) WatchNamespaces(stopper chan struct{}, handlers ...NamespaceEventHandler) {
+	var inline, dispatchers []dispatchFunc
+	for _, h := range handlers {
+		if h == NamespaceEventHandler(manager) {
+			inline = append(inline, namespaceDispatcher(h))
+		} else {
+			dispatchers = append(dispatchers, namespaceDispatcher(h))
+		}
+	}
+	manager.watch(stopper, "namespaces", "namespace", manager.sharedInformer("namespaces", &v1.Namespace{}),
+		func(obj interface{}) interface{} {
+			return NewNamespaceInfo(obj.(*v1.Namespace))
+		}, inline, dispatchers)
+}
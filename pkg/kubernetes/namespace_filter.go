@@ -0,0 +1,191 @@
+package kubernetes
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"os"
+	"strings"
+)
+
+const (
+	//namespaces excluded if TRAFFIC_EXCLUDE_NAMESPACES env is not set
+	DEFAULT_EXCLUDE_NAMESPACES = "kube-system"
+	//set on every namespace by kubernetes 1.21 and later
+	NAMESPACE_NAME_LABEL = "kubernetes.io/metadata.name"
+)
+
+//namespaced resources whose objects are only delivered to handlers if their namespace is managed
+var managedResources = []string{"services", "pods", "endpointslices", "ingresses",
+	"deployments", "statefulsets", "daemonsets", "replicasets", "jobs", "cronjobs"}
+
+/**
+ * Namespaces managed by traffic-control and envoy-manager.
+ * Objects of other namespaces are never delivered to handlers, so they are invisible to xds services and annotators.
+ */
+type NamespaceFilter struct {
+	//managed namespaces, all namespaces if empty
+	Include []string
+	//namespaces never managed, overrides Include and Selector
+	Exclude []string
+	//labels of managed namespaces, requires WatchNamespaces. Everything if nil
+	Selector labels.Selector
+}
+
+func splitNamespaces(value string) []string {
+	var result []string
+	for _, ns := range strings.Split(value, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns != "" {
+			result = append(result, ns)
+		}
+	}
+	return result
+}
+
+/**
+ * Filter given by TRAFFIC_INCLUDE_NAMESPACES, TRAFFIC_EXCLUDE_NAMESPACES (comma separated) and TRAFFIC_NAMESPACE_SELECTOR
+ * (label selector like traffic.managed=true) env. kube-system is excluded unless TRAFFIC_EXCLUDE_NAMESPACES is set.
+ */
+func NamespaceFilterFromEnv() (*NamespaceFilter, error) {
+	exclude, ok := os.LookupEnv("TRAFFIC_EXCLUDE_NAMESPACES")
+	if !ok {
+		exclude = DEFAULT_EXCLUDE_NAMESPACES
+	}
+	filter := &NamespaceFilter{
+		Include: splitNamespaces(os.Getenv("TRAFFIC_INCLUDE_NAMESPACES")),
+		Exclude: splitNamespaces(exclude),
+	}
+	if value := os.Getenv("TRAFFIC_NAMESPACE_SELECTOR"); value != "" {
+		selector, err := labels.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TRAFFIC_NAMESPACE_SELECTOR %s: %s", value, err.Error())
+		}
+		filter.Selector = selector
+	}
+	return filter, nil
+}
+
+func containsNamespace(namespaces []string, namespace string) bool {
+	for _, ns := range namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+//Whether the namespace with the labels is managed, labels are ignored if the filter has no selector
+func (filter *NamespaceFilter) Matches(namespace string, labelSet map[string]string) bool {
+	if filter == nil {
+		return true
+	}
+	if containsNamespace(filter.Exclude, namespace) {
+		return false
+	}
+	if len(filter.Include) > 0 && !containsNamespace(filter.Include, namespace) {
+		return false
+	}
+	return filter.Selector == nil || filter.Selector.Matches(labels.Set(labelSet))
+}
+
+/**
+ * Namespace and field selector of list watches, so that the api server only sends objects of managed namespaces
+ * as far as it can: a single included namespace is watched directly, excluded ones by metadata.namespace!=ns.
+ */
+func (filter *NamespaceFilter) listWatchScope() (string, func(options *metav1.ListOptions)) {
+	if filter == nil {
+		return metav1.NamespaceAll, func(options *metav1.ListOptions) {}
+	}
+	namespace := metav1.NamespaceAll
+	if len(filter.Include) == 1 {
+		namespace = filter.Include[0]
+	}
+	var selectors []fields.Selector
+	for _, ns := range filter.Exclude {
+		selectors = append(selectors, fields.OneTermNotEqualSelector("metadata.namespace", ns))
+	}
+	fieldSelector := fields.AndSelectors(selectors...).String()
+	return namespace, func(options *metav1.ListOptions) {
+		options.FieldSelector = fieldSelector
+	}
+}
+
+/**
+ * Namespace selector of the validating webhook, so that labels in namespaces not managed are not rejected.
+ * Include and Exclude are matched by kubernetes.io/metadata.name label. Return nil if all namespaces are managed.
+ */
+func (filter *NamespaceFilter) webhookNamespaceSelector() *metav1.LabelSelector {
+	if filter == nil {
+		return nil
+	}
+	result := &metav1.LabelSelector{}
+	if len(filter.Include) > 0 {
+		result.MatchExpressions = append(result.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key: NAMESPACE_NAME_LABEL, Operator: metav1.LabelSelectorOpIn, Values: filter.Include})
+	}
+	if len(filter.Exclude) > 0 {
+		result.MatchExpressions = append(result.MatchExpressions, metav1.LabelSelectorRequirement{
+			Key: NAMESPACE_NAME_LABEL, Operator: metav1.LabelSelectorOpNotIn, Values: filter.Exclude})
+	}
+	if filter.Selector != nil {
+		requirements, _ := filter.Selector.Requirements()
+		for _, r := range requirements {
+			var operator metav1.LabelSelectorOperator
+			switch r.Operator() {
+			case selection.Equals, selection.DoubleEquals, selection.In:
+				operator = metav1.LabelSelectorOpIn
+			case selection.NotEquals, selection.NotIn:
+				operator = metav1.LabelSelectorOpNotIn
+			case selection.Exists:
+				operator = metav1.LabelSelectorOpExists
+			case selection.DoesNotExist:
+				operator = metav1.LabelSelectorOpDoesNotExist
+			default:
+				//gt and lt can not be expressed, the webhook validates more namespaces than managed
+				continue
+			}
+			result.MatchExpressions = append(result.MatchExpressions, metav1.LabelSelectorRequirement{
+				Key: r.Key(), Operator: operator, Values: r.Values().List()})
+		}
+	}
+	if len(result.MatchExpressions) == 0 {
+		return nil
+	}
+	return result
+}
+
+/**
+ * Whether objects of the namespace are delivered to handlers.
+ * With a label selector, namespaces not delivered by WatchNamespaces yet are not managed.
+ */
+func (manager *K8sResourceManager) NamespaceManaged(namespace string) bool {
+	filter := manager.namespaceFilter
+	if filter == nil {
+		return true
+	}
+	var labelSet map[string]string
+	if filter.Selector != nil {
+		manager.policyMutex.RLock()
+		ns := manager.namespaces[namespace]
+		manager.policyMutex.RUnlock()
+		if ns == nil {
+			return false
+		}
+		labelSet = ns.Labels
+	}
+	return filter.Matches(namespace, labelSet)
+}
+
+//convert objects of namespaces not managed to nil, so that handlers never see them or see them deleted
+func (manager *K8sResourceManager) managedOnly(convert func(obj interface{}) interface{}) func(obj interface{}) interface{} {
+	return func(obj interface{}) interface{} {
+		if accessor, err := meta.Accessor(obj); err == nil && !manager.NamespaceManaged(accessor.GetNamespace()) {
+			return nil
+		}
+		return convert(obj)
+	}
+}
@@ -0,0 +1,75 @@
+package kubernetes
+
+import (
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"testing"
+	"time"
+)
+
+func TestNamespaceFilterMatches(t *testing.T) {
+	var filter *NamespaceFilter
+	assert.True(t, filter.Matches("kube-system", nil))
+
+	filter = &NamespaceFilter{Include: []string{"ns1", "ns2"}, Exclude: []string{"ns2"}}
+	assert.True(t, filter.Matches("ns1", nil))
+	assert.False(t, filter.Matches("ns2", nil))
+	assert.False(t, filter.Matches("ns3", nil))
+
+	selector, err := labels.Parse("traffic.managed=true")
+	assert.Nil(t, err)
+	filter = &NamespaceFilter{Exclude: []string{"kube-system"}, Selector: selector}
+	assert.True(t, filter.Matches("ns1", map[string]string{"traffic.managed": "true"}))
+	assert.False(t, filter.Matches("ns1", nil))
+	assert.False(t, filter.Matches("kube-system", map[string]string{"traffic.managed": "true"}))
+
+	webhookSelector := filter.webhookNamespaceSelector()
+	assert.Equal(t, len(webhookSelector.MatchExpressions), 2)
+	assert.Equal(t, webhookSelector.MatchExpressions[1].Key, "traffic.managed")
+}
+
+func TestNamespaceSelector(t *testing.T) {
+	manager := NewFakeK8sResourceManager()
+	selector, _ := labels.Parse("traffic.managed=true")
+	manager.namespaceFilter = &NamespaceFilter{Selector: selector}
+	handler := &lastServiceHandler{services: make(map[string]*ServiceInfo)}
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+	go manager.WatchServices(stopper, manager, handler)
+	go manager.WatchNamespaces(stopper, manager)
+
+	var namespace corev1.Namespace
+	namespace.Name = "test-ns"
+	manager.GetListerWatcher("namespaces").Add(&namespace)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "svc1"
+	manager.GetListerWatcher("services").Add(&service)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	assert.Nil(t, handler.services["svc1"])
+	assert.Equal(t, len(manager.labelTypeResourceMap[namespaceIndexKey("test-ns")][SERVICE_TYPE]), 0)
+	manager.Unlock()
+
+	//opt in
+	namespace.Labels = map[string]string{"traffic.managed": "true"}
+	manager.GetListerWatcher("namespaces").Modify(&namespace)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	assert.NotNil(t, handler.services["svc1"])
+	manager.Unlock()
+
+	//opt out, the service is deleted from handlers
+	namespace.Labels = nil
+	manager.GetListerWatcher("namespaces").Modify(&namespace)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	assert.Nil(t, handler.services["svc1"])
+	manager.Unlock()
+}
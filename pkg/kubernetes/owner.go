@@ -0,0 +1,92 @@
+package kubernetes
+
+import (
+	"fmt"
+)
+
+//ownerReferences deeper than this are ignored, e.g. Pod -> Job -> CronJob is 2
+const MAX_OWNER_DEPTH = 5
+
+func workloadKey(kind string, ns string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", kind, ns, name)
+}
+
+func (manager *K8sResourceManager) addWorkload(deployment *DeploymentInfo) {
+	key := workloadKey(deployment.Kind(), deployment.Namespace(), deployment.Name())
+	manager.workloads[key] = deployment
+	if deployment.OwnerKind == "" {
+		return
+	}
+	ownerKey := workloadKey(deployment.OwnerKind, deployment.Namespace(), deployment.OwnerName)
+	children := manager.ownedWorkloads[ownerKey]
+	if children == nil {
+		children = make(map[string]*DeploymentInfo)
+		manager.ownedWorkloads[ownerKey] = children
+	}
+	children[key] = deployment
+}
+
+func (manager *K8sResourceManager) removeWorkload(deployment *DeploymentInfo) {
+	key := workloadKey(deployment.Kind(), deployment.Namespace(), deployment.Name())
+	delete(manager.workloads, key)
+	if deployment.OwnerKind == "" {
+		return
+	}
+	ownerKey := workloadKey(deployment.OwnerKind, deployment.Namespace(), deployment.OwnerName)
+	delete(manager.ownedWorkloads[ownerKey], key)
+	if len(manager.ownedWorkloads[ownerKey]) == 0 {
+		delete(manager.ownedWorkloads, ownerKey)
+	}
+}
+
+/**
+ * Return watched workloads controlling the pod through ownerReferences, nearest first,
+ * e.g. ReplicaSet then Deployment. Return nil if the pod has no controller owner.
+ * Should be called with K8sResourceManager locked.
+ */
+func (manager *K8sResourceManager) GetOwnerChain(pod *PodInfo) []*DeploymentInfo {
+	if !manager.IsLocked() {
+		panic("K8sResourceManager should be locked in GetOwnerChain()")
+	}
+	var result []*DeploymentInfo
+	kind, name := pod.OwnerKind, pod.OwnerName
+	for kind != "" && len(result) < MAX_OWNER_DEPTH {
+		owner := manager.workloads[workloadKey(kind, pod.Namespace(), name)]
+		if owner == nil {
+			break
+		}
+		result = append(result, owner)
+		kind, name = owner.OwnerKind, owner.OwnerName
+	}
+	return result
+}
+
+/**
+ * Return pods controlled by the workload directly or through workloads owned by it,
+ * e.g. pods of the ReplicaSets of a Deployment.
+ * Should be called with K8sResourceManager locked.
+ */
+func (manager *K8sResourceManager) GetOwnedPods(deployment *DeploymentInfo) []*PodInfo {
+	if !manager.IsLocked() {
+		panic("K8sResourceManager should be locked in GetOwnedPods()")
+	}
+	return manager.getOwnedPods(deployment, 0)
+}
+
+func (manager *K8sResourceManager) getOwnedPods(deployment *DeploymentInfo, depth int) []*PodInfo {
+	var result []*PodInfo
+	for _, resource := range manager.labelTypeResourceMap[namespaceIndexKey(deployment.Namespace())][POD_TYPE] {
+		pod := resource.(*PodInfo)
+		if pod.OwnerKind == deployment.Kind() && pod.OwnerName == deployment.Name() {
+			result = append(result, pod)
+		}
+	}
+	if depth >= MAX_OWNER_DEPTH {
+		return result
+	}
+	key := workloadKey(deployment.Kind(), deployment.Namespace(), deployment.Name())
+	for _, child := range manager.ownedWorkloads[key] {
+		result = append(result, manager.getOwnedPods(child, depth+1)...)
+	}
+	return result
+}
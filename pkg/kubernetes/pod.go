@@ -1,9 +1,12 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/metrics"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"strconv"
 	"strings"
 	"time"
@@ -11,6 +14,7 @@ import (
 
 type PodInfo struct {
 	ResourceVersion string
+	UID             types.UID
 	name            string
 	namespace       string
 	PodIP           string
@@ -19,6 +23,23 @@ type PodInfo struct {
 	Labels          map[string]string
 	Annotations     map[string]string
 	Containers      []string
+	//pod is being deleted
+	Terminating bool
+	//container port name => port number
+	ContainerPorts map[string]uint32
+	//controller owner, e.g. ReplicaSet or Job, empty if not owned
+	OwnerKind string
+	OwnerName string
+	//traffic.* config of TrafficPolicies selecting the pod as workload
+	PolicyConfig map[string]string
+	//service name => config of the service for this pod, e.g. traffic.port.9080=http, joined in memory
+	ServiceConfig map[string]map[string]string
+	//traffic.* labels of workloads controlling the pod, nearer owner overrides farther one
+	WorkloadConfig map[string]string
+	//name of the top level workload controlling the pod, e.g. Deployment of its ReplicaSet
+	Workload string
+	//traffic.* defaults of traffic-defaults ConfigMap and namespace labels
+	DefaultConfig map[string]string
 }
 
 func (pod *PodInfo) Valid() bool {
@@ -34,10 +55,14 @@ func (pod *PodInfo) NodeId() string {
 
 func (pod *PodInfo) EnvoyEnabled() bool {
 	if pod.Labels[ENVOY_ENABLED] != "" {
-		//ENVOY_ENABLED label will overide annotation set by deployment label
+		//ENVOY_ENABLED label will overide deployment label
 		return GetLabelValueBool(pod.Labels[ENVOY_ENABLED])
 	}
-	return GetLabelValueBool(pod.Annotations[ENVOY_ENABLED_BY_DEPLOYMENT])
+	if pod.WorkloadConfig[ENVOY_ENABLED] != "" {
+		//workload label overrides namespace label and mesh default
+		return GetLabelValueBool(pod.WorkloadConfig[ENVOY_ENABLED])
+	}
+	return GetLabelValueBool(pod.DefaultConfig[ENVOY_ENABLED])
 }
 
 type PodPortInfo struct {
@@ -54,16 +79,13 @@ func getPort(value string) uint32 {
 	return uint32(port)
 }
 
-func getServiceAndPort(annotation string) (string, uint32) {
-	tokens := strings.Split(annotation, ".")
-	if len(tokens) < 5 || tokens[0] != "traffic" || tokens[1] != "svc" || tokens[2] == "" || tokens[3] != "port" {
-		return "", 0
-	}
-	port := getPort(tokens[4])
-	if port == 0 {
-		return "", 0
+//return the port of traffic.port.N key, 0 if the key is not a port protocol
+func getPortOfKey(key string) uint32 {
+	tokens := strings.Split(key, ".")
+	if len(tokens) < 3 || tokens[0] != "traffic" || tokens[1] != "port" {
+		return 0
 	}
-	return tokens[2], port
+	return getPort(tokens[2])
 }
 
 /**
@@ -71,26 +93,28 @@ func getServiceAndPort(annotation string) (string, uint32) {
  * LDS should create a listener for each clusterip:port of the service
  * CDS should create a service cluster for each clusterip:port of the service
  * example:
- * traffic.svc.service1.port.1234=http
+ * service1 config traffic.port.1234=http
  * should return map 1234 => service1 => true
  */
 
 func (pod *PodInfo) GetPortSet() map[uint32]map[string]bool {
 	result := make(map[uint32]map[string]bool)
-	for k, v := range pod.Annotations {
-		if v == "" {
-			continue
-		}
-		service, port := getServiceAndPort(k)
-		if port == 0 {
-			continue
-		}
-		if result[port] == nil {
-			result[port] = map[string]bool{
-				service: true,
+	for service, config := range pod.ServiceConfig {
+		for k, v := range config {
+			if v == "" {
+				continue
+			}
+			port := getPortOfKey(k)
+			if port == 0 {
+				continue
+			}
+			if result[port] == nil {
+				result[port] = map[string]bool{
+					service: true,
+				}
+			} else {
+				result[port][service] = true
 			}
-		} else {
-			result[port][service] = true
 		}
 	}
 
@@ -98,11 +122,7 @@ func (pod *PodInfo) GetPortSet() map[uint32]map[string]bool {
 		if v == "" {
 			continue
 		}
-		tokens := strings.Split(k, ".")
-		if len(tokens) < 3 || tokens[0] != "traffic" || tokens[1] != "port" {
-			continue
-		}
-		port := getPort(tokens[2])
+		port := getPortOfKey(k)
 		if port == 0 {
 			continue
 		}
@@ -142,18 +162,17 @@ func (pod *PodInfo) collectTargetPort(configMap map[string]string, result map[ui
 		}
 
 		//if the port has two services's annotations,merge their config
+		config := make(map[string]string)
 		for k1, v1 := range configMap {
-			if !strings.HasPrefix(k1, "traffic.") {
-				continue
-			}
 			if strings.HasPrefix(k1, "traffic.port.") {
 				continue
 			}
 			if strings.HasPrefix(k1, "traffic.target.port.") {
 				continue
 			}
-			portInfo.ConfigMap[k1] = v1
+			config[k1] = v1
 		}
+		overrideConfig(portInfo.ConfigMap, config)
 
 	}
 }
@@ -163,38 +182,30 @@ func (pod *PodInfo) collectTargetPort(configMap map[string]string, result map[ui
  * LDS should create a listener for each podip:targetPort of the service
  * CDS should create a static cluster for each podip:targetPort of the service
  * example:
- * traffic.svc.service1.attr=value
- * traffic.svc.service1.target.port.5678=http
+ * service1 config traffic.attr=value, traffic.target.port.5678=http
  * should return map 5678 => PodPortInfo{PROTO_HTTP, traffic.attr=value }
  */
 func (pod *PodInfo) GetTargetPortConfig() map[uint32]*PodPortInfo {
-	serviceConfig := make(map[string]map[string]string)
-
-	for k, v := range pod.Annotations {
-		if v == "" {
-			continue
-		}
-		tokens := strings.Split(k, ".")
-		if len(tokens) < 4 || tokens[0] != "traffic" || tokens[1] != "svc" || tokens[2] == "" {
-			continue
-		}
-		service := tokens[2]
-
-		configMap := serviceConfig[service]
-		if configMap == nil {
-			configMap = make(map[string]string)
-			serviceConfig[service] = configMap
-		}
-
-		newKey := "traffic" + k[len("traffic.svc.")+len(service):]
-		configMap[newKey] = v
-	}
 	result := make(map[uint32]*PodPortInfo)
 
-	for _, configMap := range serviceConfig {
+	for _, configMap := range pod.ServiceConfig {
 		pod.collectTargetPort(configMap, result)
 	}
+	//workload labels override service config, TrafficPolicies override both, pod labels override all
+	for _, portInfo := range result {
+		overrideConfig(portInfo.ConfigMap, pod.WorkloadConfig)
+		overrideConfig(portInfo.ConfigMap, pod.PolicyConfig)
+	}
 	pod.collectTargetPort(pod.Labels, result)
+	//service config already includes defaults, ports only given by pod labels need them too
+	if len(pod.DefaultConfig) > 0 {
+		for _, portInfo := range result {
+			config := make(map[string]string)
+			overrideConfig(config, pod.DefaultConfig)
+			overrideConfig(config, portInfo.ConfigMap)
+			portInfo.ConfigMap = config
+		}
+	}
 	return result
 }
 
@@ -220,10 +231,6 @@ func (pod *PodInfo) String() string {
 		pod.EnvoyEnabled())
 }
 
-func (pod *PodInfo) IsSkip() bool {
-	return pod.namespace == "kube-system"
-}
-
 func NewPodInfo(pod *v1.Pod) *PodInfo {
 	if pod.Status.PodIP == "" {
 		return nil
@@ -238,13 +245,29 @@ func NewPodInfo(pod *v1.Pod) *PodInfo {
 		containers = append(containers, id)
 	}
 
+	containerPorts := make(map[string]uint32)
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name != "" {
+				containerPorts[port.Name] = uint32(port.ContainerPort)
+			}
+		}
+	}
+
 	if pod.Annotations == nil {
 		pod.Annotations = make(map[string]string)
 	}
 	if pod.Labels == nil {
 		pod.Labels = make(map[string]string)
 	}
+	var ownerKind, ownerName string
+	if owner := metav1.GetControllerOf(pod); owner != nil {
+		ownerKind = owner.Kind
+		ownerName = owner.Name
+	}
 	return &PodInfo{
+		OwnerKind:       ownerKind,
+		OwnerName:       ownerName,
 		PodIP:           pod.Status.PodIP,
 		HostIP:          pod.Status.HostIP,
 		namespace:       pod.Namespace,
@@ -254,16 +277,22 @@ func NewPodInfo(pod *v1.Pod) *PodInfo {
 		HostNetwork:     pod.Spec.HostNetwork,
 		Containers:      containers,
 		ResourceVersion: pod.ResourceVersion,
+		UID:             pod.UID,
+		Terminating:     pod.DeletionTimestamp != nil,
+		ContainerPorts:  containerPorts,
 	}
 }
 
 func (manager *K8sResourceManager) UpdatePodAnnotation(podInfo *PodInfo, annotation map[string]string) error {
+	if manager.skipWrite("UpdatePodAnnotation", podInfo.Name()) {
+		return nil
+	}
 	var err error
 	var rawPod *v1.Pod
 	for i := 0; i < 3; i++ {
-		rawPod, err = manager.ClientSet.CoreV1().Pods(podInfo.Namespace()).Get(podInfo.Name(), metav1.GetOptions{})
+		rawPod, err = manager.ClientSet.CoreV1().Pods(podInfo.Namespace()).Get(context.TODO(), podInfo.Name(), metav1.GetOptions{})
 		if err != nil {
-			return err
+			return metrics.K8sWrite("UpdatePodAnnotation", err)
 		}
 		if rawPod.Annotations == nil {
 			rawPod.Annotations = annotation
@@ -279,22 +308,25 @@ func (manager *K8sResourceManager) UpdatePodAnnotation(podInfo *PodInfo, annotat
 				return nil
 			}
 		}
-		_, err = manager.ClientSet.CoreV1().Pods(podInfo.Namespace()).Update(rawPod)
+		_, err = manager.ClientSet.CoreV1().Pods(podInfo.Namespace()).Update(context.TODO(), rawPod, metav1.UpdateOptions{})
 		if err == nil {
 			return nil
 		}
 		time.Sleep(1 * time.Second)
 	}
-	return err
+	return metrics.K8sWrite("UpdatePodAnnotation", err)
 }
 
 func (manager *K8sResourceManager) RemovePodAnnotation(podInfo *PodInfo, annotationkeys []string) error {
+	if manager.skipWrite("RemovePodAnnotation", podInfo.Name()) {
+		return nil
+	}
 	var err error
 	var rawPod *v1.Pod
 	for i := 0; i < 3; i++ {
-		rawPod, err = manager.ClientSet.CoreV1().Pods(podInfo.Namespace()).Get(podInfo.Name(), metav1.GetOptions{})
+		rawPod, err = manager.ClientSet.CoreV1().Pods(podInfo.Namespace()).Get(context.TODO(), podInfo.Name(), metav1.GetOptions{})
 		if err != nil {
-			return err
+			return metrics.K8sWrite("RemovePodAnnotation", err)
 		}
 		if rawPod.Annotations == nil {
 			return nil
@@ -310,11 +342,11 @@ func (manager *K8sResourceManager) RemovePodAnnotation(podInfo *PodInfo, annotat
 		if !changed {
 			return nil
 		}
-		_, err = manager.ClientSet.CoreV1().Pods(podInfo.Namespace()).Update(rawPod)
+		_, err = manager.ClientSet.CoreV1().Pods(podInfo.Namespace()).Update(context.TODO(), rawPod, metav1.UpdateOptions{})
 		if err == nil {
 			return nil
 		}
 		time.Sleep(1 * time.Second)
 	}
-	return err
+	return metrics.K8sWrite("RemovePodAnnotation", err)
 }
@@ -0,0 +1,108 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/**
+ * Whether a traffic.* label of a service applies to listeners and clusters of the pods selected by it.
+ * Port protocols are derived from service ports instead, load balancing and subset routing only apply to the service cluster.
+ */
+func isPodServiceConfigKey(key string) bool {
+	if !strings.HasPrefix(key, "traffic.") {
+		return false
+	}
+	for _, prefix := range []string{"traffic.port.", "traffic.target.port.", "traffic.lb.", "traffic.hash.", "traffic.subset."} {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Config of the service for one of the pods it selects, e.g.
+ * traffic.port.9080=http, traffic.target.port.8080=http and traffic.request.timeout=3s
+ */
+func (svc *ServiceInfo) podConfig(pod *PodInfo) map[string]string {
+	result := make(map[string]string)
+	for key, value := range svc.ConfigLabels() {
+		if value != "" && isPodServiceConfigKey(key) {
+			result[key] = value
+		}
+	}
+	for _, port := range svc.Ports {
+		protocol := svc.ProtocolName(port.Port)
+		if protocol == "" {
+			continue
+		}
+		result[ServicePortProtocol(port.Port)] = protocol
+		//used for headless cluster, should create a listener for each podip:targetPort of the cluster
+		if targetPort := port.ResolveTargetPort(pod); targetPort != 0 {
+			result[fmt.Sprintf("traffic.target.port.%d", targetPort)] = protocol
+		}
+	}
+	return result
+}
+
+/**
+ * Workloads whose labels apply to the pod. Pods with a watched controller owner use their
+ * ownerReferences chain (e.g. ReplicaSet then Deployment), so overlapping selectors of other
+ * workloads are ignored. Other pods use workloads selecting them.
+ * Should be called with K8sResourceManager locked.
+ */
+func (manager *K8sResourceManager) PodWorkloads(pod *PodInfo) []*DeploymentInfo {
+	if chain := manager.GetOwnerChain(pod); len(chain) > 0 {
+		return chain
+	}
+	var result []*DeploymentInfo
+	for _, resource := range manager.GetMatchedResources(pod, DEPLOYMENT_TYPE) {
+		result = append(result, resource.(*DeploymentInfo))
+	}
+	return result
+}
+
+/**
+ * Join config of services selecting the pod and workloads controlling it from the label index,
+ * versions of the joined resources become part of the pod resource version.
+ * Services and workloads refresh pods of their namespace when they change.
+ */
+func (manager *K8sResourceManager) applyPodConfig(pod *PodInfo) {
+	manager.Lock()
+	defer manager.Unlock()
+
+	var versions []string
+	for _, resource := range manager.GetMatchedResources(pod, SERVICE_TYPE) {
+		svc := resource.(*ServiceInfo)
+		if pod.ServiceConfig == nil {
+			pod.ServiceConfig = make(map[string]map[string]string)
+		}
+		pod.ServiceConfig[svc.Name()] = svc.podConfig(pod)
+		versions = append(versions, fmt.Sprintf("Service/%s/%s", svc.Name(), svc.ResourceVersion))
+	}
+
+	//nearer owner overrides farther one
+	workloads := manager.PodWorkloads(pod)
+	if chain := manager.GetOwnerChain(pod); len(chain) > 0 {
+		pod.Workload = chain[len(chain)-1].Name()
+	} else if len(workloads) == 1 {
+		pod.Workload = workloads[0].Name()
+	}
+	for i := len(workloads) - 1; i >= 0; i-- {
+		for key, value := range workloads[i].Labels {
+			if strings.HasPrefix(key, "traffic.") {
+				if pod.WorkloadConfig == nil {
+					pod.WorkloadConfig = make(map[string]string)
+				}
+				pod.WorkloadConfig[key] = value
+			}
+		}
+		versions = append(versions, fmt.Sprintf("%s/%s/%s", workloads[i].Kind(), workloads[i].Name(), workloads[i].ResourceVersion))
+	}
+	if len(versions) > 0 {
+		sort.Strings(versions)
+		pod.ResourceVersion = fmt.Sprintf("%s-%s", pod.ResourceVersion, strings.Join(versions, ","))
+	}
+}
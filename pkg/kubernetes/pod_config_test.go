@@ -0,0 +1,97 @@
+package kubernetes
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+type lastPodHandler struct {
+	pods map[string]*PodInfo
+}
+
+func (h *lastPodHandler) PodValid(pod *PodInfo) bool {
+	return pod.Valid()
+}
+func (h *lastPodHandler) PodAdded(pod *PodInfo) {
+	h.pods[pod.Name()] = pod
+}
+func (h *lastPodHandler) PodDeleted(pod *PodInfo) {
+	delete(h.pods, pod.Name())
+}
+func (h *lastPodHandler) PodUpdated(oldPod, newPod *PodInfo) {
+	h.pods[newPod.Name()] = newPod
+}
+
+func TestPodConfig(t *testing.T) {
+	manager := NewFakeK8sResourceManager()
+	handler := &lastPodHandler{pods: make(map[string]*PodInfo)}
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+	go manager.WatchPods(stopper, manager, handler)
+	go manager.WatchServices(stopper, manager)
+	go manager.WatchDeployments(stopper, manager)
+
+	var pod corev1.Pod
+	pod.Namespace = "test-ns"
+	pod.Name = "reviews-pod"
+	pod.Labels = map[string]string{"app": "reviews"}
+	pod.Status.PodIP = "10.1.1.1"
+	manager.ClientSet.CoreV1().Pods("test-ns").Create(context.TODO(), &pod, metav1.CreateOptions{})
+	manager.GetListerWatcher("pods").Add(&pod)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	info := handler.pods["reviews-pod"]
+	manager.Unlock()
+	assert.False(t, info.EnvoyEnabled())
+	assert.Equal(t, len(info.GetPortSet()), 0)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "reviews"
+	service.Labels = map[string]string{"traffic.port.9080": "http", "traffic.request.timeout": "3s", "traffic.lb.policy": "RANDOM"}
+	service.Spec.Selector = map[string]string{"app": "reviews"}
+	service.Spec.Ports = []corev1.ServicePort{{Name: "http", Port: 9080}}
+	manager.GetListerWatcher("services").Add(&service)
+
+	var deploy appsv1.Deployment
+	deploy.Namespace = "test-ns"
+	deploy.Name = "reviews"
+	deploy.Labels = map[string]string{"traffic.envoy.enabled": "true", "traffic.endpoint.weight": "50", "traffic.request.timeout": "5s"}
+	deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "reviews"}}
+	manager.GetListerWatcher("deployments").Add(&deploy)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	info = handler.pods["reviews-pod"]
+	manager.Unlock()
+	assert.True(t, info.EnvoyEnabled())
+	assert.Equal(t, info.WorkloadConfig["traffic.endpoint.weight"], "50")
+	assert.True(t, info.GetPortSet()[9080]["reviews"])
+	assert.Equal(t, info.ServiceConfig["reviews"]["traffic.request.timeout"], "3s")
+	assert.Equal(t, info.ServiceConfig["reviews"]["traffic.target.port.9080"], "http")
+	//deployment label overrides service label
+	assert.Equal(t, info.GetTargetPortConfig()[9080].ConfigMap["traffic.request.timeout"], "5s")
+	//load balancing only applies to the service cluster
+	assert.Equal(t, info.ServiceConfig["reviews"]["traffic.lb.policy"], "")
+	assert.NotEqual(t, info.ResourceVersion, pod.ResourceVersion)
+
+	//nothing is written to the pod
+	pod1, _ := manager.ClientSet.CoreV1().Pods("test-ns").Get(context.TODO(), "reviews-pod", metav1.GetOptions{})
+	assert.Equal(t, len(pod1.Annotations), 0)
+
+	manager.GetListerWatcher("services").Delete(&service)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	info = handler.pods["reviews-pod"]
+	manager.Unlock()
+	assert.Equal(t, len(info.GetPortSet()), 0)
+	assert.True(t, info.EnvoyEnabled())
+}
@@ -11,9 +11,11 @@ func TestGetPortSet(t *testing.T) {
 			"traffic.port.1234": "http",
 			"traffic.port.2345": "",
 		},
-		Annotations: map[string]string{
-			"traffic.svc.testsvc.port.3456": "http",
-			"traffic.svc.testsvc.port.4567": "",
+		ServiceConfig: map[string]map[string]string{
+			"testsvc": {
+				"traffic.port.3456": "http",
+				"traffic.port.4567": "",
+			},
 		},
 	}
 	result := pod.GetPortSet()
@@ -32,11 +34,10 @@ func TestGetPortConfig(t *testing.T) {
 			"traffic.target.port.6789.rate.limit": "100",
 			"traffic.target.port.5678":            "tcp",
 		},
-		Annotations: map[string]string{
-			"traffic.svc.svc2.target.port.1234": "tcp",
-			"traffic.svc.svc1.target.port.3456": "http",
-			"traffic.svc.svc2.tracing.enabled":  "true",
-			"traffic.svc.svc3.target.port.4567": "",
+		ServiceConfig: map[string]map[string]string{
+			"svc1": {"traffic.target.port.3456": "http"},
+			"svc2": {"traffic.target.port.1234": "tcp", "traffic.tracing.enabled": "true"},
+			"svc3": {"traffic.target.port.4567": ""},
 		},
 	}
 	result := pod.GetTargetPortConfig()
@@ -50,3 +51,34 @@ func TestGetPortConfig(t *testing.T) {
 	assert.Equal(t, len(result[5678].ConfigMap), 1)
 	assert.Equal(t, result[5678].ConfigMap["traffic.rate.limit"], "200")
 }
+
+func TestPodVisibility(t *testing.T) {
+	pod := PodInfo{
+		namespace: "ns1",
+		ServiceConfig: map[string]map[string]string{
+			"svc1": {"traffic.port.3456": "http", "traffic.visibility": "namespace"},
+			"svc2": {"traffic.port.4567": "http", "traffic.visibility": "ns3,ns2"},
+		},
+	}
+	assert.Equal(t, pod.Visibility(), "ns2,ns3")
+	assert.True(t, IsVisible(pod.Visibility(), "ns1", "ns1"))
+	assert.True(t, IsVisible(pod.Visibility(), "ns1", "ns2"))
+	assert.False(t, IsVisible(pod.Visibility(), "ns1", "ns4"))
+	assert.True(t, IsVisible(pod.Visibility(), "ns1", ""))
+
+	pod.ServiceConfig["svc3"] = map[string]string{"traffic.port.5678": "http"}
+	assert.Equal(t, pod.Visibility(), VISIBILITY_PUBLIC)
+
+	//target ports are only visible to namespaces which can access the services targeting them
+	pod.ServiceConfig["svc1"]["traffic.target.port.8080"] = "http"
+	pod.ServiceConfig["svc2"]["traffic.target.port.8080"] = "http"
+	pod.ServiceConfig["svc2"]["traffic.target.port.9090"] = "http"
+	visibility, exposed := pod.TargetPortVisibility(8080)
+	assert.True(t, exposed)
+	assert.Equal(t, visibility, "ns2,ns3")
+	visibility, exposed = pod.TargetPortVisibility(7070)
+	assert.False(t, exposed)
+
+	assert.Equal(t, NodeNamespace("pod1.ns1"), "ns1")
+	assert.Equal(t, NodeNamespace("traffic-ingress"), "")
+}
@@ -0,0 +1,336 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/cache"
+	"sort"
+	"strings"
+)
+
+const (
+	TRAFFIC_POLICY_GROUP   = "traffic.luguoxiang.github.io"
+	TRAFFIC_POLICY_VERSION = "v1alpha1"
+
+	TRAFFIC_POLICY_KIND         = "TrafficPolicy"
+	DEFAULT_TRAFFIC_POLICY_KIND = "DefaultTrafficPolicy"
+)
+
+//custom resources watched through dynamic client, resource name => group version resource
+var CustomResources = map[string]schema.GroupVersionResource{
+	"trafficpolicies": {
+		Group: TRAFFIC_POLICY_GROUP, Version: TRAFFIC_POLICY_VERSION, Resource: "trafficpolicies"},
+	"defaulttrafficpolicies": {
+		Group: TRAFFIC_POLICY_GROUP, Version: TRAFFIC_POLICY_VERSION, Resource: "defaulttrafficpolicies"},
+}
+
+func newDynamicListWatch(client dynamic.Interface, gvr schema.GroupVersionResource) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.Resource(gvr).List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Resource(gvr).Watch(context.TODO(), options)
+		},
+	}
+}
+
+/**
+ * TrafficPolicy and DefaultTrafficPolicy custom resources.
+ * A TrafficPolicy applies to services selected by spec.services and to pods selected by spec.workloads
+ * in its namespace, a DefaultTrafficPolicy applies to all services of its namespace.
+ */
+type TrafficPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec TrafficPolicySpec `json:"spec"`
+}
+
+type TrafficPolicySpec struct {
+	//select services by their labels, ignored by DefaultTrafficPolicy
+	Services *metav1.LabelSelector `json:"services,omitempty"`
+	//select pods of workloads by their labels, ignored by DefaultTrafficPolicy
+	Workloads *metav1.LabelSelector `json:"workloads,omitempty"`
+
+	Timeouts       *TimeoutPolicy        `json:"timeouts,omitempty"`
+	Retries        *RetryPolicy          `json:"retries,omitempty"`
+	Fault          *FaultPolicy          `json:"fault,omitempty"`
+	CircuitBreaker *CircuitBreakerPolicy `json:"circuitBreaker,omitempty"`
+	LoadBalancer   *LoadBalancerPolicy   `json:"loadBalancer,omitempty"`
+}
+
+type TimeoutPolicy struct {
+	Connect *metav1.Duration `json:"connect,omitempty"`
+	Request *metav1.Duration `json:"request,omitempty"`
+}
+
+type RetryPolicy struct {
+	//5xx, connect-failure or gateway-error
+	On       string `json:"on"`
+	Attempts int32  `json:"attempts"`
+}
+
+type FaultPolicy struct {
+	Delay *FaultDelayPolicy `json:"delay,omitempty"`
+	Abort *FaultAbortPolicy `json:"abort,omitempty"`
+}
+
+type FaultDelayPolicy struct {
+	Fixed      *metav1.Duration `json:"fixed,omitempty"`
+	Percentage int32            `json:"percentage"`
+}
+
+type FaultAbortPolicy struct {
+	HttpStatus int32 `json:"httpStatus,omitempty"`
+	Percentage int32 `json:"percentage"`
+}
+
+type CircuitBreakerPolicy struct {
+	MaxConnections     int32 `json:"maxConnections,omitempty"`
+	MaxPendingRequests int32 `json:"maxPendingRequests,omitempty"`
+	MaxRequests        int32 `json:"maxRequests,omitempty"`
+	MaxRetries         int32 `json:"maxRetries,omitempty"`
+}
+
+type LoadBalancerPolicy struct {
+	//ROUND_ROBIN, LEAST_REQUEST, RING_HASH, RANDOM or MAGLEV
+	Policy string      `json:"policy,omitempty"`
+	Hash   *HashPolicy `json:"hash,omitempty"`
+}
+
+type HashPolicy struct {
+	Cookie *CookieHashPolicy `json:"cookie,omitempty"`
+	Header string            `json:"header,omitempty"`
+}
+
+type CookieHashPolicy struct {
+	Name string           `json:"name"`
+	TTL  *metav1.Duration `json:"ttl,omitempty"`
+}
+
+type TrafficPolicyInfo struct {
+	ResourceVersion string
+	name            string
+	namespace       string
+	Default         bool
+	//nil if the policy selects no service or workload
+	services  labels.Selector
+	workloads labels.Selector
+	//same keys and value format as traffic.* labels
+	Config map[string]string
+}
+
+func (policy *TrafficPolicyInfo) Name() string {
+	return policy.name
+}
+
+func (policy *TrafficPolicyInfo) Namespace() string {
+	return policy.namespace
+}
+
+func (policy *TrafficPolicyInfo) Kind() string {
+	if policy.Default {
+		return DEFAULT_TRAFFIC_POLICY_KIND
+	}
+	return TRAFFIC_POLICY_KIND
+}
+
+func (policy *TrafficPolicyInfo) String() string {
+	return fmt.Sprintf("%s %s@%s", policy.Kind(), policy.name, policy.namespace)
+}
+
+//Whether the policy applies to the service with the labels
+func (policy *TrafficPolicyInfo) SelectsService(labelSet map[string]string) bool {
+	if policy.Default {
+		return true
+	}
+	return policy.services != nil && policy.services.Matches(labels.Set(labelSet))
+}
+
+//Whether the policy applies to the pod with the labels
+func (policy *TrafficPolicyInfo) SelectsWorkload(labelSet map[string]string) bool {
+	return !policy.Default && policy.workloads != nil && policy.workloads.Matches(labels.Set(labelSet))
+}
+
+func policySelector(policy *TrafficPolicy, selector *metav1.LabelSelector) labels.Selector {
+	if selector == nil {
+		return nil
+	}
+	result, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		glog.Errorf("Invalid selector of %s %s@%s: %s", policy.Kind, policy.Name, policy.Namespace, err.Error())
+		return nil
+	}
+	return result
+}
+
+//Return nil if the object can not be converted to TrafficPolicy
+func NewTrafficPolicyInfo(obj *unstructured.Unstructured) *TrafficPolicyInfo {
+	var policy TrafficPolicy
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &policy)
+	if err != nil {
+		glog.Errorf("Invalid %s %s@%s: %s", obj.GetKind(), obj.GetName(), obj.GetNamespace(), err.Error())
+		return nil
+	}
+	return &TrafficPolicyInfo{
+		ResourceVersion: policy.ResourceVersion,
+		name:            policy.Name,
+		namespace:       policy.Namespace,
+		Default:         policy.Kind == DEFAULT_TRAFFIC_POLICY_KIND,
+		services:        policySelector(&policy, policy.Spec.Services),
+		workloads:       policySelector(&policy, policy.Spec.Workloads),
+		Config:          policy.Spec.Config(),
+	}
+}
+
+func durationNanos(d *metav1.Duration) string {
+	return fmt.Sprintf("%d", d.Duration.Nanoseconds())
+}
+
+//Return the spec as traffic.* label keys and values, so it is applied exactly like labels
+func (spec *TrafficPolicySpec) Config() map[string]string {
+	result := make(map[string]string)
+	if timeouts := spec.Timeouts; timeouts != nil {
+		if timeouts.Connect != nil {
+			result["traffic.connection.timeout"] = durationNanos(timeouts.Connect)
+		}
+		if timeouts.Request != nil {
+			result["traffic.request.timeout"] = durationNanos(timeouts.Request)
+		}
+	}
+	if retries := spec.Retries; retries != nil && retries.On != "" {
+		result["traffic.retries."+retries.On] = fmt.Sprintf("%d", retries.Attempts)
+	}
+	if fault := spec.Fault; fault != nil {
+		if fault.Delay != nil && fault.Delay.Fixed != nil {
+			result["traffic.fault.delay.time"] = durationNanos(fault.Delay.Fixed)
+			result["traffic.fault.delay.percentage"] = fmt.Sprintf("%d", fault.Delay.Percentage)
+		}
+		if fault.Abort != nil {
+			if fault.Abort.HttpStatus != 0 {
+				result["traffic.fault.abort.status"] = fmt.Sprintf("%d", fault.Abort.HttpStatus)
+			}
+			result["traffic.fault.abort.percentage"] = fmt.Sprintf("%d", fault.Abort.Percentage)
+		}
+	}
+	if cb := spec.CircuitBreaker; cb != nil {
+		for key, value := range map[string]int32{
+			"traffic.connection.max":      cb.MaxConnections,
+			"traffic.request.max-pending": cb.MaxPendingRequests,
+			"traffic.request.max":         cb.MaxRequests,
+			"traffic.retries.max":         cb.MaxRetries,
+		} {
+			if value > 0 {
+				result[key] = fmt.Sprintf("%d", value)
+			}
+		}
+	}
+	if lb := spec.LoadBalancer; lb != nil {
+		if lb.Policy != "" {
+			result["traffic.lb.policy"] = lb.Policy
+		}
+		if hash := lb.Hash; hash != nil {
+			if hash.Cookie != nil && hash.Cookie.Name != "" {
+				result["traffic.hash.cookie.name"] = hash.Cookie.Name
+				if hash.Cookie.TTL != nil {
+					result["traffic.hash.cookie.ttl"] = fmt.Sprintf("%d", int64(hash.Cookie.TTL.Duration.Seconds()))
+				}
+			}
+			if hash.Header != "" {
+				result["traffic.hash.header.name"] = hash.Header
+			}
+		}
+	}
+	return result
+}
+
+//traffic.retries.5xx, traffic.retries.connect-failure and traffic.retries.gateway-error select the same retry policy
+func isRetryOnKey(key string) bool {
+	return strings.HasPrefix(key, "traffic.retries.") && key != "traffic.retries.max"
+}
+
+/**
+ * Set traffic.* config of src into dst, src overrides dst.
+ * Retry condition keys of dst are removed if src has one, since only one of them is applied.
+ */
+func overrideConfig(dst map[string]string, src map[string]string) {
+	for key, value := range src {
+		if isRetryOnKey(key) && value != "" {
+			for dstKey, _ := range dst {
+				if isRetryOnKey(dstKey) {
+					delete(dst, dstKey)
+				}
+			}
+			break
+		}
+	}
+	for key, value := range src {
+		if strings.HasPrefix(key, "traffic.") {
+			dst[key] = value
+		}
+	}
+}
+
+/**
+ * Merged config of policies in the namespace accepted by selects, DefaultTrafficPolicies first,
+ * then TrafficPolicies in name order, later ones override. Also return a version of the merged policies,
+ * empty if no policy applies.
+ */
+func (manager *K8sResourceManager) policyConfig(namespace string, selects func(policy *TrafficPolicyInfo) bool) (map[string]string, string) {
+	manager.policyMutex.RLock()
+	defer manager.policyMutex.RUnlock()
+
+	var matched []*TrafficPolicyInfo
+	for _, policy := range manager.policies[namespace] {
+		if selects(policy) && len(policy.Config) > 0 {
+			matched = append(matched, policy)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, ""
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Default != matched[j].Default {
+			return matched[i].Default
+		}
+		return matched[i].name < matched[j].name
+	})
+	config := make(map[string]string)
+	var versions []string
+	for _, policy := range matched {
+		overrideConfig(config, policy.Config)
+		versions = append(versions, fmt.Sprintf("%s/%s", policy.name, policy.ResourceVersion))
+	}
+	return config, strings.Join(versions, ",")
+}
+
+//set config of policies selecting the service, policy version becomes part of the resource version
+func (manager *K8sResourceManager) applyServicePolicies(svc *ServiceInfo) {
+	config, version := manager.policyConfig(svc.Namespace(), func(policy *TrafficPolicyInfo) bool {
+		return policy.SelectsService(svc.Labels)
+	})
+	if version != "" {
+		svc.PolicyConfig = config
+		svc.ResourceVersion = fmt.Sprintf("%s-%s", svc.ResourceVersion, version)
+	}
+}
+
+//set config of policies selecting the pod's workload, policy version becomes part of the resource version
+func (manager *K8sResourceManager) applyWorkloadPolicies(pod *PodInfo) {
+	config, version := manager.policyConfig(pod.Namespace(), func(policy *TrafficPolicyInfo) bool {
+		return policy.SelectsWorkload(pod.Labels)
+	})
+	if version != "" {
+		pod.PolicyConfig = config
+		pod.ResourceVersion = fmt.Sprintf("%s-%s", pod.ResourceVersion, version)
+	}
+}
@@ -0,0 +1,93 @@
+package kubernetes
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+type TrafficPolicyEventHandler interface {
+	TrafficPolicyAdded(policy *TrafficPolicyInfo)
+	TrafficPolicyDeleted(policy *TrafficPolicyInfo)
+	TrafficPolicyUpdated(oldPolicy, newPolicy *TrafficPolicyInfo)
+}
+
+func policyKey(policy *TrafficPolicyInfo) string {
+	return fmt.Sprintf("%s/%s", policy.Kind(), policy.Name())
+}
+
+//services and pods of the namespace are delivered again with the new policy config
+func (manager *K8sResourceManager) TrafficPolicyAdded(policy *TrafficPolicyInfo) {
+	manager.TrafficPolicyUpdated(nil, policy)
+}
+func (manager *K8sResourceManager) TrafficPolicyDeleted(policy *TrafficPolicyInfo) {
+	manager.TrafficPolicyUpdated(policy, nil)
+}
+func (manager *K8sResourceManager) TrafficPolicyUpdated(oldPolicy, newPolicy *TrafficPolicyInfo) {
+	var namespace string
+	manager.policyMutex.Lock()
+	if oldPolicy != nil {
+		namespace = oldPolicy.Namespace()
+		delete(manager.policies[namespace], policyKey(oldPolicy))
+		if len(manager.policies[namespace]) == 0 {
+			delete(manager.policies, namespace)
+		}
+	}
+	if newPolicy != nil {
+		namespace = newPolicy.Namespace()
+		if manager.policies[namespace] == nil {
+			manager.policies[namespace] = make(map[string]*TrafficPolicyInfo)
+		}
+		manager.policies[namespace][policyKey(newPolicy)] = newPolicy
+	}
+	manager.policyMutex.Unlock()
+
+	manager.refresh("services", namespace)
+	manager.refresh("pods", namespace)
+}
+
+func trafficPolicyDispatcher(h TrafficPolicyEventHandler) dispatchFunc {
+	return func(oldInfo interface{}, newInfo interface{}) (err error) {
+		defer recoverHandler(&err)
+		oldPolicy, _ := oldInfo.(*TrafficPolicyInfo)
+		newPolicy, _ := newInfo.(*TrafficPolicyInfo)
+		if oldPolicy == nil && newPolicy != nil {
+			h.TrafficPolicyAdded(newPolicy)
+		} else if oldPolicy != nil && newPolicy == nil {
+			h.TrafficPolicyDeleted(oldPolicy)
+		} else if oldPolicy != nil && newPolicy != nil {
+			h.TrafficPolicyUpdated(oldPolicy, newPolicy)
+		}
+		return nil
+	}
+}
+
+func (manager *K8sResourceManager) watchTrafficPolicies(stopper chan struct{}, resource string, kind string, handlers []TrafficPolicyEventHandler) {
+	var inline, dispatchers []dispatchFunc
+	for _, h := range handlers {
+		if h == TrafficPolicyEventHandler(manager) {
+			inline = append(inline, trafficPolicyDispatcher(h))
+		} else {
+			dispatchers = append(dispatchers, trafficPolicyDispatcher(h))
+		}
+	}
+	//informers of unstructured objects can not be shared through informer factory
+	informer := cache.NewSharedIndexInformer(manager.watchListMap[resource], &unstructured.Unstructured{}, resyncPeriod(),
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	go informer.Run(stopper)
+	manager.watch(stopper, resource, kind, informer,
+		func(obj interface{}) interface{} {
+			if policy := NewTrafficPolicyInfo(obj.(*unstructured.Unstructured)); policy != nil {
+				return policy
+			}
+			return nil
+		}, inline, dispatchers)
+}
+
+func (manager *K8sResourceManager) WatchTrafficPolicies(stopper chan struct{}, handlers ...TrafficPolicyEventHandler) {
+	manager.watchTrafficPolicies(stopper, "trafficpolicies", "trafficpolicy", handlers)
+}
+
+func (manager *K8sResourceManager) WatchDefaultTrafficPolicies(stopper chan struct{}, handlers ...TrafficPolicyEventHandler) {
+	manager.watchTrafficPolicies(stopper, "defaulttrafficpolicies", "defaulttrafficpolicy", handlers)
+}
@@ -0,0 +1,91 @@
+package kubernetes
+
+import (
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"testing"
+	"time"
+)
+
+type lastServiceHandler struct {
+	services map[string]*ServiceInfo
+}
+
+func (h *lastServiceHandler) ServiceValid(svc *ServiceInfo) bool {
+	return true
+}
+func (h *lastServiceHandler) ServiceAdded(svc *ServiceInfo) {
+	h.services[svc.Name()] = svc
+}
+func (h *lastServiceHandler) ServiceDeleted(svc *ServiceInfo) {
+	delete(h.services, svc.Name())
+}
+func (h *lastServiceHandler) ServiceUpdated(oldService, newService *ServiceInfo) {
+	h.services[newService.Name()] = newService
+}
+
+func newPolicy(kind string, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion(TRAFFIC_POLICY_GROUP + "/" + TRAFFIC_POLICY_VERSION)
+	obj.SetKind(kind)
+	obj.SetName(name)
+	obj.SetNamespace("test-ns")
+	return obj
+}
+
+func TestTrafficPolicy(t *testing.T) {
+	manager := NewFakeK8sResourceManager()
+	handler := &lastServiceHandler{services: make(map[string]*ServiceInfo)}
+
+	stopper := make(chan struct{})
+	defer close(stopper)
+	go manager.WatchServices(stopper, manager, handler)
+	go manager.WatchTrafficPolicies(stopper, manager)
+	go manager.WatchDefaultTrafficPolicies(stopper, manager)
+
+	var service corev1.Service
+	service.Namespace = "test-ns"
+	service.Name = "svc1"
+	service.Labels = map[string]string{"app": "reviews", "traffic.retries.5xx": "2"}
+	manager.GetListerWatcher("services").Add(&service)
+
+	manager.GetListerWatcher("defaulttrafficpolicies").Add(newPolicy(DEFAULT_TRAFFIC_POLICY_KIND, "default", map[string]interface{}{
+		"timeouts": map[string]interface{}{"connect": "5s", "request": "10s"},
+	}))
+	policy := newPolicy(TRAFFIC_POLICY_KIND, "reviews", map[string]interface{}{
+		"services": map[string]interface{}{
+			"matchExpressions": []interface{}{map[string]interface{}{
+				"key": "app", "operator": "In", "values": []interface{}{"reviews"},
+			}},
+		},
+		"timeouts":     map[string]interface{}{"request": "2s"},
+		"retries":      map[string]interface{}{"on": "gateway-error", "attempts": int64(3)},
+		"loadBalancer": map[string]interface{}{"policy": "RING_HASH", "hash": map[string]interface{}{"header": "x-user"}},
+	})
+	manager.GetListerWatcher("trafficpolicies").Add(policy)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	svc := handler.services["svc1"]
+	manager.Unlock()
+	config := svc.ConfigLabels()
+	assert.Equal(t, config["traffic.connection.timeout"], "5000000000")
+	//TrafficPolicy overrides DefaultTrafficPolicy
+	assert.Equal(t, config["traffic.request.timeout"], "2000000000")
+	assert.Equal(t, config["traffic.lb.policy"], "RING_HASH")
+	assert.Equal(t, config["traffic.hash.header.name"], "x-user")
+	//labels override policies, including the retry condition
+	assert.Equal(t, config["traffic.retries.5xx"], "2")
+	assert.Equal(t, config["traffic.retries.gateway-error"], "")
+	assert.NotEqual(t, svc.ResourceVersion, service.ResourceVersion)
+
+	manager.GetListerWatcher("trafficpolicies").Delete(policy)
+	time.Sleep(time.Second)
+
+	manager.Lock()
+	svc = handler.services["svc1"]
+	manager.Unlock()
+	assert.Equal(t, svc.ConfigLabels()["traffic.request.timeout"], "10000000000")
+	assert.Equal(t, svc.ConfigLabels()["traffic.lb.policy"], "")
+}
@@ -1,17 +1,39 @@
 package kubernetes
 
 import (
+	"context"
 	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/client-go/tools/cache"
-	"reflect"
-	"time"
+	"os"
+	"strings"
+	"sync"
 )
 
 const (
 	SECRET_TLS_HOST = "traffic.tls.host"
 )
 
+//comma separated namespaces whose secrets are watched, namespaces of ingresses with tls if empty
+var secretNamespaces = os.Getenv("TRAFFIC_SECRET_NAMESPACES")
+
+//label selector of watched secrets, e.g. traffic.sds=true
+var secretSelector = os.Getenv("TRAFFIC_SECRET_SELECTOR")
+
+func watchedSecretNamespaces() []string {
+	var result []string
+	for _, ns := range strings.Split(secretNamespaces, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns != "" {
+			result = append(result, ns)
+		}
+	}
+	return result
+}
+
 type SecretInfo struct {
 	Name            string
 	Namespace       string
@@ -48,71 +70,131 @@ func (manager *K8sResourceManager) PostSecret(name string, namespace string, cer
 	}
 
 	secret.Type = "kubernetes.io/tls"
-	_, err := manager.ClientSet.CoreV1().Secrets(namespace).Create(secret)
+	_, err := manager.ClientSet.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
 	return err
 }
 
-func (manager *K8sResourceManager) WatchSecrets(stopper chan struct{}, handlers ...SecretEventHandler) {
-	watchlist := cache.NewListWatchFromClient(
-		manager.ClientSet.Core().RESTClient(), "secrets", "",
-		fields.Everything())
-	_, controller := cache.NewInformer(
-		watchlist,
-		&v1.Secret{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				secret := NewSecretInfo(obj.(*v1.Secret))
-
-				manager.Lock()
-				defer manager.Unlock()
-
-				for _, h := range handlers {
-					if h.SecretValid(secret) {
-						h.SecretAdded(secret)
-					}
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				secret := NewSecretInfo(obj.(*v1.Secret))
+//return nil if secret does not exist
+func (manager *K8sResourceManager) GetSecret(name string, namespace string) (*SecretInfo, error) {
+	secret, err := manager.ClientSet.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return NewSecretInfo(secret), nil
+}
+
+//create an opaque secret, return false if secret already exists
+func (manager *K8sResourceManager) PostOpaqueSecret(name string, namespace string, data map[string][]byte) (bool, error) {
+	secret := &v1.Secret{}
+	secret.Name = name
+	secret.Namespace = namespace
+	secret.Data = data
+	secret.Type = v1.SecretTypeOpaque
+	_, err := manager.ClientSet.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+	if err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
 
-				manager.Lock()
-				defer manager.Unlock()
+/**
+ * Only tls secrets matching TRAFFIC_SECRET_SELECTOR are watched, so that key material of other secrets is never loaded.
+ * Secrets are watched in TRAFFIC_SECRET_NAMESPACES, or in managed namespaces of ingresses with tls if it is empty.
+ * A namespace stays watched once an ingress with tls is seen in it.
+ */
+func (manager *K8sResourceManager) WatchSecrets(stopper chan struct{}, handlers ...SecretEventHandler) {
+	var dispatchers []dispatchFunc
+	for _, h := range handlers {
+		dispatchers = append(dispatchers, secretDispatcher(h))
+	}
+	mutex := &sync.Mutex{}
+	var resources []string
+	watchNamespace := func(namespace string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		resource := "secrets/" + namespace
+		for _, watched := range resources {
+			if watched == resource {
+				return
+			}
+		}
+		watchlist := cache.NewFilteredListWatchFromClient(
+			manager.ClientSet.CoreV1().RESTClient(), "secrets", namespace,
+			func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("type", string(v1.SecretTypeTLS)).String()
+				options.LabelSelector = secretSelector
+			})
+		//filtered informers are not shared, secrets are only used by sds
+		informer := cache.NewSharedIndexInformer(watchlist, &v1.Secret{}, resyncPeriod(), cache.Indexers{})
+		resources = append(resources, resource)
+
+		go informer.Run(stopper)
+		go manager.watch(stopper, resource, "secret", informer,
+			func(obj interface{}) interface{} {
+				return NewSecretInfo(obj.(*v1.Secret))
+			}, nil, dispatchers)
+	}
 
-				for _, h := range handlers {
-					if h.SecretValid(secret) {
-						h.SecretDeleted(secret)
-					}
-				}
-			},
+	var ingresses cache.SharedIndexInformer
+	watchIngress := func(obj interface{}) {
+		ingress := obj.(*networkingv1.Ingress)
+		if len(ingress.Spec.TLS) > 0 && manager.NamespaceManaged(ingress.Namespace) {
+			watchNamespace(ingress.Namespace)
+		}
+	}
+	if namespaces := watchedSecretNamespaces(); len(namespaces) > 0 {
+		for _, namespace := range namespaces {
+			watchNamespace(namespace)
+		}
+	} else {
+		//namespaces managed later are watched on ingress resync
+		ingresses = manager.sharedInformer("ingresses", &networkingv1.Ingress{})
+		ingresses.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: watchIngress,
 			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldSecret := NewSecretInfo(oldObj.(*v1.Secret))
-				newSecret := NewSecretInfo(newObj.(*v1.Secret))
-
-				newVersion := newSecret.ResourceVersion
-				//ignore ResourceVersion diff
-				newSecret.ResourceVersion = oldSecret.ResourceVersion
-				if reflect.DeepEqual(oldSecret, newSecret) {
-					return
-				}
-
-				newSecret.ResourceVersion = newVersion
-				manager.Lock()
-				defer manager.Unlock()
-
-				for _, h := range handlers {
-					oldValid := h.SecretValid(oldSecret)
-					newValid := h.SecretValid(newSecret)
-					if !oldValid && newValid {
-						h.SecretAdded(newSecret)
-					} else if oldValid && !newValid {
-						h.SecretDeleted(oldSecret)
-					} else if oldValid && newValid {
-						h.SecretUpdated(oldSecret, newSecret)
-					}
-				}
+				watchIngress(newObj)
 			},
-		},
-	)
-	controller.Run(stopper)
+		})
+		manager.informerFactory.Start(stopper)
+	}
+	manager.registerInformer("secrets", func() bool {
+		if ingresses != nil {
+			if !ingresses.HasSynced() {
+				return false
+			}
+			//handlers of listed ingresses may not have run yet
+			for _, obj := range ingresses.GetStore().List() {
+				watchIngress(obj)
+			}
+		}
+		mutex.Lock()
+		watched := append([]string(nil), resources...)
+		mutex.Unlock()
+		return manager.InformersSynced(watched...)
+	})
+	<-stopper
+}
+
+func secretDispatcher(h SecretEventHandler) dispatchFunc {
+	return func(oldInfo interface{}, newInfo interface{}) (err error) {
+		defer recoverHandler(&err)
+		oldSecret, _ := oldInfo.(*SecretInfo)
+		newSecret, _ := newInfo.(*SecretInfo)
+		oldValid := (oldSecret != nil && h.SecretValid(oldSecret))
+		newValid := (newSecret != nil && h.SecretValid(newSecret))
+		if !oldValid && newValid {
+			h.SecretAdded(newSecret)
+		} else if oldValid && !newValid {
+			h.SecretDeleted(oldSecret)
+		} else if oldValid && newValid {
+			h.SecretUpdated(oldSecret, newSecret)
+		}
+		return nil
+	}
 }
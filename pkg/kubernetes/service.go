@@ -2,9 +2,13 @@ package kubernetes
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/metrics"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"strings"
 	"time"
 )
@@ -12,10 +16,54 @@ import (
 type ServicePortInfo struct {
 	Port       uint32
 	TargetPort uint32
-	Name       string
+	//name of the container port if targetPort is a string
+	TargetPortName string
+	Name           string
+	AppProtocol    string
 }
+
+/**
+ * Return the target port on the given pod, named target port is resolved through container port names of the pod.
+ * Return 0 if the name is not found.
+ */
+func (port *ServicePortInfo) ResolveTargetPort(pod *PodInfo) uint32 {
+	if port.TargetPortName != "" {
+		return pod.ContainerPorts[port.TargetPortName]
+	}
+	if port.TargetPort == 0 {
+		//targetPort defaults to port
+		return port.Port
+	}
+	return port.TargetPort
+}
+
+//map appProtocol or istio style port name prefix to protocol label value, empty if unknown
+func protocolByName(name string) string {
+	name = strings.ToLower(strings.TrimPrefix(name, "kubernetes.io/"))
+	if index := strings.Index(name, "-"); index >= 0 {
+		name = name[:index]
+	}
+	switch name {
+	case "http", "http2", "h2c", "grpc":
+		return "http"
+	case "https", "tls", "tcp", "mongo", "mysql", "redis":
+		return "tcp"
+	default:
+		return ""
+	}
+}
+
+//protocol detected from appProtocol, then from port name like http-web, grpc-api or tcp-db
+func (port *ServicePortInfo) DefaultProtocol() string {
+	if protocol := protocolByName(port.AppProtocol); protocol != "" {
+		return protocol
+	}
+	return protocolByName(port.Name)
+}
+
 type ServiceInfo struct {
 	ResourceVersion string
+	UID             types.UID
 	name            string
 	namespace       string
 	ClusterIP       string
@@ -23,6 +71,22 @@ type ServiceInfo struct {
 	Labels          map[string]string
 	Annotations     map[string]string
 	Ports           []*ServicePortInfo
+	//traffic.* config of TrafficPolicies selecting the service
+	PolicyConfig map[string]string
+	//traffic.* defaults of traffic-defaults ConfigMap and namespace labels
+	DefaultConfig map[string]string
+}
+
+//traffic.* config of the service, labels override TrafficPolicy config which overrides defaults
+func (service *ServiceInfo) ConfigLabels() map[string]string {
+	if len(service.PolicyConfig) == 0 && len(service.DefaultConfig) == 0 {
+		return service.Labels
+	}
+	result := make(map[string]string)
+	overrideConfig(result, service.DefaultConfig)
+	overrideConfig(result, service.PolicyConfig)
+	overrideConfig(result, service.Labels)
+	return result
 }
 
 func (service *ServiceInfo) Type() ResourceType {
@@ -34,11 +98,49 @@ func (service *ServiceInfo) IsIngressHttpPort(port uint32) bool {
 	return service.Annotations[label] != ""
 }
 func (svc *ServiceInfo) Protocol(port uint32) int {
+	return GetProtocol(svc.ProtocolName(port))
+}
+
+/**
+ * Protocol label value of the port, traffic.port.N label overrides the protocol detected
+ * from appProtocol and port name. Empty if unknown.
+ */
+func (svc *ServiceInfo) ProtocolName(port uint32) string {
 	if svc.IsIngressHttpPort(port) {
-		return PROTO_HTTP
+		return "http"
 	}
 	key := ServicePortProtocol(port)
-	return GetProtocol(svc.Labels[key])
+	if svc.Labels[key] != "" {
+		return svc.Labels[key]
+	}
+	for _, portInfo := range svc.Ports {
+		if portInfo.Port == port {
+			return portInfo.DefaultProtocol()
+		}
+	}
+	return ""
+}
+
+//Return the service port with the given name, 0 if not found
+func (service *ServiceInfo) PortByName(name string) uint32 {
+	for _, port := range service.Ports {
+		if port.Name == name {
+			return port.Port
+		}
+	}
+	return 0
+}
+
+//Return the port of cached service with the given port name, 0 if the service or the port is not found or not managed
+func (manager *K8sResourceManager) ServicePortByName(name string, ns string, portName string) uint32 {
+	if !manager.NamespaceManaged(ns) {
+		return 0
+	}
+	service, err := manager.ServiceLister().Services(ns).Get(name)
+	if err != nil {
+		return 0
+	}
+	return NewServiceInfo(service).PortByName(portName)
 }
 
 func (service *ServiceInfo) Name() string {
@@ -75,6 +177,7 @@ func NewServiceInfo(service *v1.Service) *ServiceInfo {
 		ClusterIP:       service.Spec.ClusterIP,
 		Annotations:     service.Annotations,
 		ResourceVersion: service.ResourceVersion,
+		UID:             service.UID,
 	}
 	if info.Labels == nil {
 		info.Labels = map[string]string{}
@@ -83,16 +186,19 @@ func NewServiceInfo(service *v1.Service) *ServiceInfo {
 		info.Annotations = map[string]string{}
 	}
 	for _, port := range service.Spec.Ports {
-		var targetPort uint32
-		if port.TargetPort.IntVal > 0 {
-			targetPort = uint32(port.TargetPort.IntVal)
+		portInfo := &ServicePortInfo{
+			Name: port.Name,
+			Port: uint32(port.Port),
 		}
-
-		info.Ports = append(info.Ports, &ServicePortInfo{
-			Name:       port.Name,
-			Port:       uint32(port.Port),
-			TargetPort: targetPort,
-		})
+		if port.TargetPort.Type == intstr.String {
+			portInfo.TargetPortName = port.TargetPort.StrVal
+		} else if port.TargetPort.IntVal > 0 {
+			portInfo.TargetPort = uint32(port.TargetPort.IntVal)
+		}
+		if port.AppProtocol != nil {
+			portInfo.AppProtocol = *port.AppProtocol
+		}
+		info.Ports = append(info.Ports, portInfo)
 	}
 
 	return info
@@ -100,12 +206,15 @@ func NewServiceInfo(service *v1.Service) *ServiceInfo {
 }
 
 func (manager *K8sResourceManager) AddServiceLabel(serviceInfo *ServiceInfo, key string, value string) error {
+	if manager.skipWrite("AddServiceLabel", serviceInfo.Name()) {
+		return nil
+	}
 	var err error
 	var rawService *v1.Service
 	for i := 0; i < 3; i++ {
-		rawService, err = manager.ClientSet.CoreV1().Services(serviceInfo.Namespace()).Get(serviceInfo.Name(), metav1.GetOptions{})
+		rawService, err = manager.ClientSet.CoreV1().Services(serviceInfo.Namespace()).Get(context.TODO(), serviceInfo.Name(), metav1.GetOptions{})
 		if err != nil {
-			return err
+			return metrics.K8sWrite("AddServiceLabel", err)
 		}
 
 		if rawService.Labels[key] == value {
@@ -113,13 +222,13 @@ func (manager *K8sResourceManager) AddServiceLabel(serviceInfo *ServiceInfo, key
 		}
 		rawService.Labels[key] = value
 
-		_, err = manager.ClientSet.CoreV1().Services(serviceInfo.Namespace()).Update(rawService)
+		_, err = manager.ClientSet.CoreV1().Services(serviceInfo.Namespace()).Update(context.TODO(), rawService, metav1.UpdateOptions{})
 		if err == nil {
 			return nil
 		}
 		time.Sleep(1 * time.Second)
 	}
-	return err
+	return metrics.K8sWrite("AddServiceLabel", err)
 }
 
 func mergeValue(oldValue string, value string) (string, bool) {
@@ -163,12 +272,15 @@ func removeValue(oldValue string, value string) (string, bool) {
 }
 
 func (manager *K8sResourceManager) MergeServiceAnnotation(name string, ns string, values map[string]string) error {
+	if manager.skipWrite("MergeServiceAnnotation", name) {
+		return nil
+	}
 	var err error
 	var rawService *v1.Service
 	for i := 0; i < 3; i++ {
-		rawService, err = manager.ClientSet.CoreV1().Services(ns).Get(name, metav1.GetOptions{})
+		rawService, err = manager.ClientSet.CoreV1().Services(ns).Get(context.TODO(), name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return metrics.K8sWrite("MergeServiceAnnotation", err)
 		}
 		if rawService.Annotations == nil {
 			rawService.Annotations = values
@@ -186,22 +298,25 @@ func (manager *K8sResourceManager) MergeServiceAnnotation(name string, ns string
 			}
 		}
 
-		_, err = manager.ClientSet.CoreV1().Services(ns).Update(rawService)
+		_, err = manager.ClientSet.CoreV1().Services(ns).Update(context.TODO(), rawService, metav1.UpdateOptions{})
 		if err == nil {
 			return nil
 		}
 		time.Sleep(1 * time.Second)
 	}
-	return err
+	return metrics.K8sWrite("MergeServiceAnnotation", err)
 }
 
 func (manager *K8sResourceManager) RemoveServiceAnnotation(name string, ns string, values map[string]string) error {
+	if manager.skipWrite("RemoveServiceAnnotation", name) {
+		return nil
+	}
 	var err error
 	var rawService *v1.Service
 	for i := 0; i < 3; i++ {
-		rawService, err = manager.ClientSet.CoreV1().Services(ns).Get(name, metav1.GetOptions{})
+		rawService, err = manager.ClientSet.CoreV1().Services(ns).Get(context.TODO(), name, metav1.GetOptions{})
 		if err != nil {
-			return err
+			return metrics.K8sWrite("RemoveServiceAnnotation", err)
 		}
 		if rawService.Annotations == nil {
 			return nil
@@ -219,11 +334,11 @@ func (manager *K8sResourceManager) RemoveServiceAnnotation(name string, ns strin
 			return nil
 		}
 
-		_, err = manager.ClientSet.CoreV1().Services(ns).Update(rawService)
+		_, err = manager.ClientSet.CoreV1().Services(ns).Update(context.TODO(), rawService, metav1.UpdateOptions{})
 		if err == nil {
 			return nil
 		}
 		time.Sleep(1 * time.Second)
 	}
-	return err
+	return metrics.K8sWrite("RemoveServiceAnnotation", err)
 }
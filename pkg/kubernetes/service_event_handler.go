@@ -1,11 +1,7 @@
 package kubernetes
 
 import (
-	"github.com/golang/glog"
 	"k8s.io/api/core/v1"
-	"k8s.io/client-go/tools/cache"
-	"reflect"
-	"time"
 )
 
 type ServiceEventHandler interface {
@@ -19,13 +15,15 @@ func (manager *K8sResourceManager) ServiceValid(info *ServiceInfo) bool {
 	return true
 }
 
+//pods of the namespace are delivered again with the new service config
 func (manager *K8sResourceManager) ServiceAdded(info *ServiceInfo) {
 	manager.addResource(info)
-
+	manager.refresh("pods", info.Namespace())
 }
 
 func (manager *K8sResourceManager) ServiceDeleted(info *ServiceInfo) {
 	manager.removeResource(info)
+	manager.refresh("pods", info.Namespace())
 }
 
 func (manager *K8sResourceManager) ServiceUpdated(oldService, newService *ServiceInfo) {
@@ -33,66 +31,38 @@ func (manager *K8sResourceManager) ServiceUpdated(oldService, newService *Servic
 	manager.ServiceAdded(newService)
 }
 
-func (manager *K8sResourceManager) WatchServices(stopper chan struct{}, handlers ...ServiceEventHandler) {
-	_, controller := cache.NewInformer(
-		manager.watchListMap["services"],
-		&v1.Service{},
-		time.Second*0,
-		cache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				service := NewServiceInfo(obj.(*v1.Service))
-
-				manager.Lock()
-				defer manager.Unlock()
-
-				for _, h := range handlers {
-					if h.ServiceValid(service) {
-						h.ServiceAdded(service)
-					}
-				}
-			},
-			DeleteFunc: func(obj interface{}) {
-				service := NewServiceInfo(obj.(*v1.Service))
-
-				manager.Lock()
-				defer manager.Unlock()
-
-				for _, h := range handlers {
-					if h.ServiceValid(service) {
-						h.ServiceDeleted(service)
-					}
-				}
-			},
-			UpdateFunc: func(oldObj, newObj interface{}) {
-				oldService := NewServiceInfo(oldObj.(*v1.Service))
-				newService := NewServiceInfo(newObj.(*v1.Service))
-
-				newVersion := newService.ResourceVersion
-				//ignore ResourceVersion diff
-				newService.ResourceVersion = oldService.ResourceVersion
-				if reflect.DeepEqual(oldService, newService) {
-					return
-				}
-
-				newService.ResourceVersion = newVersion
-				manager.Lock()
-				defer manager.Unlock()
+func serviceDispatcher(h ServiceEventHandler) dispatchFunc {
+	return func(oldInfo interface{}, newInfo interface{}) (err error) {
+		defer recoverHandler(&err)
+		oldService, _ := oldInfo.(*ServiceInfo)
+		newService, _ := newInfo.(*ServiceInfo)
+		oldValid := (oldService != nil && h.ServiceValid(oldService))
+		newValid := (newService != nil && h.ServiceValid(newService))
+		if !oldValid && newValid {
+			h.ServiceAdded(newService)
+		} else if oldValid && !newValid {
+			h.ServiceDeleted(oldService)
+		} else if oldValid && newValid {
+			h.ServiceUpdated(oldService, newService)
+		}
+		return nil
+	}
+}
 
-				for _, h := range handlers {
-					oldValid := h.ServiceValid(oldService)
-					newValid := h.ServiceValid(newService)
-					if !oldValid && newValid {
-						h.ServiceAdded(newService)
-					} else if oldValid && !newValid {
-						h.ServiceDeleted(oldService)
-					} else if oldValid && newValid {
-						h.ServiceUpdated(oldService, newService)
-					}
-				}
-			},
-		},
-	)
-	glog.Info("Start watching services")
-	controller.Run(stopper)
-	glog.Info("WatchServices terminated")
+func (manager *K8sResourceManager) WatchServices(stopper chan struct{}, handlers ...ServiceEventHandler) {
+	var inline, dispatchers []dispatchFunc
+	for _, h := range handlers {
+		if h == ServiceEventHandler(manager) {
+			inline = append(inline, serviceDispatcher(h))
+		} else {
+			dispatchers = append(dispatchers, serviceDispatcher(h))
+		}
+	}
+	manager.watch(stopper, "services", "service", manager.sharedInformer("services", &v1.Service{}),
+		manager.managedOnly(func(obj interface{}) interface{} {
+			info := NewServiceInfo(obj.(*v1.Service))
+			manager.applyServiceDefaults(info)
+			manager.applyServicePolicies(info)
+			return info
+		}), inline, dispatchers)
 }
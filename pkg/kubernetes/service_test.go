@@ -0,0 +1,54 @@
+package kubernetes
+
+import (
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"testing"
+)
+
+func TestServiceProtocol(t *testing.T) {
+	grpc := "kubernetes.io/h2c"
+	var service corev1.Service
+	service.Labels = map[string]string{"traffic.port.5000": "tcp"}
+	service.Spec.Ports = []corev1.ServicePort{
+		{Name: "http-web", Port: 1000},
+		{Name: "grpc", Port: 2000},
+		{Name: "tcp-db", Port: 3000},
+		{Name: "api", Port: 4000, AppProtocol: &grpc},
+		//label overrides port name
+		{Name: "http", Port: 5000},
+		{Name: "metrics", Port: 6000},
+	}
+	svc := NewServiceInfo(&service)
+
+	assert.Equal(t, svc.Protocol(1000), PROTO_HTTP)
+	assert.Equal(t, svc.Protocol(2000), PROTO_HTTP)
+	assert.Equal(t, svc.Protocol(3000), PROTO_TCP)
+	assert.Equal(t, svc.Protocol(4000), PROTO_HTTP)
+	assert.Equal(t, svc.Protocol(5000), PROTO_TCP)
+	assert.Equal(t, svc.Protocol(6000), -1)
+}
+
+func TestResolveTargetPort(t *testing.T) {
+	var service corev1.Service
+	service.Spec.Ports = []corev1.ServicePort{
+		{Port: 80, TargetPort: intstr.FromString("web")},
+		{Port: 81, TargetPort: intstr.FromInt(8081)},
+		{Port: 82},
+		{Port: 83, TargetPort: intstr.FromString("unknown")},
+	}
+	svc := NewServiceInfo(&service)
+
+	var pod corev1.Pod
+	pod.Status.PodIP = "10.1.1.1"
+	pod.Spec.Containers = []corev1.Container{{
+		Ports: []corev1.ContainerPort{{Name: "web", ContainerPort: 8080}},
+	}}
+	podInfo := NewPodInfo(&pod)
+
+	assert.Equal(t, svc.Ports[0].ResolveTargetPort(podInfo), uint32(8080))
+	assert.Equal(t, svc.Ports[1].ResolveTargetPort(podInfo), uint32(8081))
+	assert.Equal(t, svc.Ports[2].ResolveTargetPort(podInfo), uint32(82))
+	assert.Equal(t, svc.Ports[3].ResolveTargetPort(podInfo), uint32(0))
+}
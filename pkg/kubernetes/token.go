@@ -0,0 +1,26 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//Return username of the bearer token through TokenReview api, error if token is not authenticated
+func (manager *K8sResourceManager) ReviewToken(token string) (string, error) {
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}
+	result, err := manager.ClientSet.AuthenticationV1().TokenReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	if result.Status.Error != "" {
+		return "", fmt.Errorf("Token review failed: %s", result.Status.Error)
+	}
+	if !result.Status.Authenticated {
+		return "", fmt.Errorf("Token is not authenticated")
+	}
+	return result.Status.User.Username, nil
+}
@@ -0,0 +1,141 @@
+package kubernetes
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//error of a value which is used, but probably not as the user meant
+type ValidationWarning struct {
+	message string
+}
+
+func (w *ValidationWarning) Error() string {
+	return w.message
+}
+
+func IsValidationWarning(err error) bool {
+	_, ok := err.(*ValidationWarning)
+	return ok
+}
+
+/**
+ * Validate a label value.
+ * Config functions keep accepting invalid values (e.g. GetLabelValueUInt32 returns 0), these functions
+ * only tell users that their config is ignored.
+ */
+func ValidateUInt32(value string) error {
+	_, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return fmt.Errorf("should be a non-negative 32 bit integer")
+	}
+	return nil
+}
+
+func ValidateUInt64(value string) error {
+	_, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("should be a non-negative integer")
+	}
+	return nil
+}
+
+func ValidateRange(value string, min int64, max int64) error {
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || i < min || i > max {
+		return fmt.Errorf("should be an integer in [%d, %d]", min, max)
+	}
+	return nil
+}
+
+func ValidateFloatRange(value string, min float64, max float64) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f < min || f > max {
+		return fmt.Errorf("should be a number in [%v, %v]", min, max)
+	}
+	return nil
+}
+
+func ValidateBool(value string) error {
+	if !strings.EqualFold(value, "true") && !strings.EqualFold(value, "false") {
+		return fmt.Errorf("should be true or false")
+	}
+	return nil
+}
+
+/**
+ * Validate a value of GetLabelValueDuration.
+ * Bare nanoseconds below 1ms are used as is, but a ValidationWarning is returned since they are ambiguous,
+ * e.g. 3000 is 3µs rather than 3000ms or 3000s.
+ */
+func ValidateDuration(value string, unit time.Duration) error {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		d := time.Duration(i) * unit
+		if i < 0 {
+			return fmt.Errorf("should not be negative")
+		}
+		if d > 0 && d < time.Millisecond {
+			return &ValidationWarning{fmt.Sprintf("is ambiguous, bare integer is %s in nanoseconds, add a unit like %sms or %ss", d, value, value)}
+		}
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("should be a duration like 3s, 250ms or 1m")
+	}
+	if d < 0 {
+		return fmt.Errorf("should not be negative")
+	}
+	return nil
+}
+
+func ValidateOneOf(value string, values ...string) error {
+	for _, v := range values {
+		if value == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("should be one of %s", strings.Join(values, ", "))
+}
+
+func validatePortKey(port string) error {
+	return ValidateRange(port, 1, 65535)
+}
+
+/**
+ * Validate traffic.* labels interpreted by this package: traffic.port.N, traffic.target.port.N,
+ * traffic.envoy.enabled and traffic.visibility.
+ * Return false if the key is not one of them.
+ */
+func ValidateLabel(key string, value string) (bool, error) {
+	tokens := strings.Split(key, ".")
+	switch {
+	case key == ENVOY_ENABLED:
+		return true, ValidateBool(value)
+	case key == VISIBILITY_LABEL:
+		if value == VISIBILITY_PUBLIC || value == VISIBILITY_NAMESPACE {
+			return true, nil
+		}
+		for _, ns := range strings.Split(value, ",") {
+			if len(validation.IsDNS1123Label(strings.TrimSpace(ns))) > 0 {
+				return true, fmt.Errorf("should be public, namespace or comma separated namespaces")
+			}
+		}
+		return true, nil
+	case len(tokens) == 3 && tokens[1] == "port":
+		if err := validatePortKey(tokens[2]); err != nil {
+			return true, fmt.Errorf("port in key %s", err.Error())
+		}
+		return true, ValidateOneOf(strings.ToLower(value), "http", "tcp", "direct")
+	case len(tokens) == 4 && tokens[1] == "target" && tokens[2] == "port":
+		if err := validatePortKey(tokens[3]); err != nil {
+			return true, fmt.Errorf("port in key %s", err.Error())
+		}
+		return true, ValidateOneOf(strings.ToLower(value), "http", "tcp", "direct")
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,121 @@
+package kubernetes
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	VISIBILITY_LABEL     = "traffic.visibility"
+	VISIBILITY_PUBLIC    = "public"
+	VISIBILITY_NAMESPACE = "namespace"
+)
+
+//visibility of services without traffic.visibility label
+var defaultVisibility = os.Getenv("TRAFFIC_DEFAULT_VISIBILITY")
+
+func isPublic(visibility string) bool {
+	if visibility == "" {
+		visibility = defaultVisibility
+	}
+	return visibility == "" || visibility == VISIBILITY_PUBLIC
+}
+
+/**
+ * Whether pods in namespace can access a resource in ownNamespace with the given visibility.
+ * visibility could be "public", "namespace" or a comma separated list of namespaces.
+ * Empty namespace means the node can see all resources, e.g. ingress.
+ */
+func IsVisible(visibility string, ownNamespace string, namespace string) bool {
+	if namespace == "" || namespace == ownNamespace || isPublic(visibility) {
+		return true
+	}
+	for _, ns := range strings.Split(visibility, ",") {
+		if strings.TrimSpace(ns) == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+//envoy node id is podname.namespace, return empty for nodes not bound to a pod
+func NodeNamespace(nodeId string) string {
+	index := strings.LastIndex(nodeId, ".")
+	if index < 0 {
+		return ""
+	}
+	return nodeId[index+1:]
+}
+
+//traffic.visibility of the service, may be given by namespace label or mesh defaults
+func (service *ServiceInfo) Visibility() string {
+	return service.ConfigLabels()[VISIBILITY_LABEL]
+}
+
+//visibility of the service which selects this pod
+func (pod *PodInfo) ServiceVisibility(service string) string {
+	return pod.ServiceConfig[service][VISIBILITY_LABEL]
+}
+
+//union of the visibilities of services
+func mergeVisibility(visibilities []string) string {
+	namespaces := make(map[string]bool)
+	for _, visibility := range visibilities {
+		if isPublic(visibility) {
+			return VISIBILITY_PUBLIC
+		}
+		if visibility == VISIBILITY_NAMESPACE {
+			continue
+		}
+		for _, ns := range strings.Split(visibility, ",") {
+			namespaces[strings.TrimSpace(ns)] = true
+		}
+	}
+	if len(namespaces) == 0 {
+		return VISIBILITY_NAMESPACE
+	}
+	var result []string
+	for ns, _ := range namespaces {
+		result = append(result, ns)
+	}
+	sort.Strings(result)
+	return strings.Join(result, ",")
+}
+
+/**
+ * Pod is visible to the namespaces which can access any service of the pod.
+ * Pod without service has default visibility.
+ */
+func (pod *PodInfo) Visibility() string {
+	var visibilities []string
+	for _, serviceMap := range pod.GetPortSet() {
+		for service, _ := range serviceMap {
+			visibilities = append(visibilities, pod.ServiceVisibility(service))
+		}
+	}
+	if len(visibilities) == 0 {
+		return ""
+	}
+	return mergeVisibility(visibilities)
+}
+
+/**
+ * Visibility of the resources of pod ip and target port, e.g. static clusters, which is the union of the visibilities
+ * of the services targeting the port. Return false if no service targets the port, e.g. the port is only given
+ * by traffic.target.port label of the pod, then only the pod itself can use them.
+ */
+func (pod *PodInfo) TargetPortVisibility(port uint32) (string, bool) {
+	key := fmt.Sprintf("traffic.target.port.%d", port)
+	var visibilities []string
+	for service, config := range pod.ServiceConfig {
+		if config[key] != "" {
+			visibilities = append(visibilities, pod.ServiceVisibility(service))
+		}
+	}
+	if len(visibilities) == 0 {
+		return "", false
+	}
+	return mergeVisibility(visibilities), true
+}
@@ -0,0 +1,84 @@
+package kubernetes
+
+import (
+	"context"
+	"github.com/golang/glog"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	VALIDATING_WEBHOOK_NAME = "traffic-control-validation"
+	VALIDATING_WEBHOOK_PATH = "/validate"
+)
+
+func validatingWebhookRule(group string, version string, resources ...string) admissionv1.RuleWithOperations {
+	return admissionv1.RuleWithOperations{
+		Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Update},
+		Rule: admissionv1.Rule{
+			APIGroups:   []string{group},
+			APIVersions: []string{version},
+			Resources:   resources,
+		},
+	}
+}
+
+/**
+ * Create or update ValidatingWebhookConfiguration traffic-control-validation which sends services, pods, namespaces and workloads
+ * to path /validate of service@namespace on port 443. caBundle is the pem root certificate which signed the webhook certificate.
+ * Requests are allowed if the webhook is not available, since labels are validated again by ConfigReporter.
+ * Retried on conflict, since all replicas of traffic-control register the webhook when they start.
+ */
+func (manager *K8sResourceManager) EnsureValidatingWebhook(service string, namespace string, caBundle []byte) error {
+	path := VALIDATING_WEBHOOK_PATH
+	port := int32(443)
+	timeout := int32(5)
+	failurePolicy := admissionv1.Ignore
+	sideEffects := admissionv1.SideEffectClassNone
+	config := &admissionv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: VALIDATING_WEBHOOK_NAME},
+		Webhooks: []admissionv1.ValidatingWebhook{{
+			Name: "traffic-labels.traffic.luguoxiang.github.io",
+			ClientConfig: admissionv1.WebhookClientConfig{
+				Service: &admissionv1.ServiceReference{
+					Name:      service,
+					Namespace: namespace,
+					Path:      &path,
+					Port:      &port,
+				},
+				CABundle: caBundle,
+			},
+			Rules: []admissionv1.RuleWithOperations{
+				validatingWebhookRule("", "v1", "services", "pods", "namespaces"),
+				validatingWebhookRule("apps", "v1", "deployments", "statefulsets", "daemonsets", "replicasets"),
+				validatingWebhookRule("batch", "v1", "jobs", "cronjobs"),
+			},
+			//objects of namespaces not managed are not validated
+			NamespaceSelector:       manager.namespaceFilter.webhookNamespaceSelector(),
+			FailurePolicy:           &failurePolicy,
+			SideEffects:             &sideEffects,
+			TimeoutSeconds:          &timeout,
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+	client := manager.ClientSet.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	conflict := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+	return retry.OnError(retry.DefaultRetry, conflict, func() error {
+		existing, err := client.Get(context.TODO(), VALIDATING_WEBHOOK_NAME, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			glog.Infof("Creating ValidatingWebhookConfiguration %s", VALIDATING_WEBHOOK_NAME)
+			_, err = client.Create(context.TODO(), config, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		config.ResourceVersion = existing.ResourceVersion
+		_, err = client.Update(context.TODO(), config, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,189 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/metrics"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MAX_WRITE_RETRIES = 10
+
+type writeOp struct {
+	operation string
+	write     func() error
+}
+
+/**
+ * Kubernetes api writes issued by handlers, so that slow or failing writes never block handlers
+ * holding K8sResourceManager lock. Writes on the same object are applied in order,
+ * failed writes are retried with exponential backoff.
+ */
+type writeQueue struct {
+	queue   workqueue.RateLimitingInterface
+	mutex   *sync.Mutex
+	pending map[string][]*writeOp
+	once    *sync.Once
+}
+
+func newWriteQueue() *writeQueue {
+	return &writeQueue{
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "writes"),
+		mutex:   &sync.Mutex{},
+		pending: make(map[string][]*writeOp),
+		once:    &sync.Once{},
+	}
+}
+
+func (q *writeQueue) add(key string, op *writeOp) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.pending[key] = append(q.pending[key], op)
+	q.queue.Add(key)
+}
+
+func (q *writeQueue) processNext() bool {
+	item, quit := q.queue.Get()
+	if quit {
+		return false
+	}
+	defer q.queue.Done(item)
+	key := item.(string)
+
+	q.mutex.Lock()
+	ops := q.pending[key]
+	delete(q.pending, key)
+	q.mutex.Unlock()
+
+	for len(ops) > 0 {
+		op := ops[0]
+		err := op.write()
+		if err != nil {
+			if q.queue.NumRequeues(item) < MAX_WRITE_RETRIES {
+				glog.Warningf("%s on %s failed, retry later: %s", op.operation, key, err.Error())
+				q.mutex.Lock()
+				q.pending[key] = append(ops, q.pending[key]...)
+				q.mutex.Unlock()
+				q.queue.AddRateLimited(item)
+				return true
+			}
+			glog.Errorf("%s on %s failed after %d retries: %s", op.operation, key, MAX_WRITE_RETRIES, err.Error())
+			//reset the backoff, so that the following writes on the object are retried again
+			q.queue.Forget(item)
+		}
+		ops = ops[1:]
+	}
+	q.queue.Forget(item)
+	return true
+}
+
+//start the worker once, later calls are ignored
+func (manager *K8sResourceManager) startWriteQueue(stopper chan struct{}) {
+	q := manager.writes
+	q.once.Do(func() {
+		go wait.Until(func() {
+			for q.processNext() {
+			}
+		}, time.Second, stopper)
+		go func() {
+			<-stopper
+			q.queue.ShutDown()
+		}()
+	})
+}
+
+//Queue UpdatePodAnnotation, ignored if the pod in cache already has the annotations
+func (manager *K8sResourceManager) QueuePodAnnotation(podInfo *PodInfo, annotation map[string]string) {
+	changed := false
+	for k, v := range annotation {
+		if podInfo.Annotations[k] != v {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return
+	}
+	manager.writes.add(fmt.Sprintf("pod/%s/%s", podInfo.Namespace(), podInfo.Name()), &writeOp{
+		operation: "UpdatePodAnnotation",
+		write: func() error {
+			return manager.UpdatePodAnnotation(podInfo, annotation)
+		},
+	})
+}
+
+func (manager *K8sResourceManager) QueueRemovePodAnnotation(podInfo *PodInfo, annotationKeys []string) {
+	manager.writes.add(fmt.Sprintf("pod/%s/%s", podInfo.Namespace(), podInfo.Name()), &writeOp{
+		operation: "RemovePodAnnotation",
+		write: func() error {
+			return manager.RemovePodAnnotation(podInfo, annotationKeys)
+		},
+	})
+}
+
+func (manager *K8sResourceManager) QueueMergeServiceAnnotation(name string, ns string, values map[string]string) {
+	manager.writes.add(fmt.Sprintf("service/%s/%s", ns, name), &writeOp{
+		operation: "MergeServiceAnnotation",
+		write: func() error {
+			return manager.MergeServiceAnnotation(name, ns, values)
+		},
+	})
+}
+
+func (manager *K8sResourceManager) QueueRemoveServiceAnnotation(name string, ns string, values map[string]string) {
+	manager.writes.add(fmt.Sprintf("service/%s/%s", ns, name), &writeOp{
+		operation: "RemoveServiceAnnotation",
+		write: func() error {
+			return manager.RemoveServiceAnnotation(name, ns, values)
+		},
+	})
+}
+
+/**
+ * Set annotations of an object of one of the kinds in kindResources with a merge patch,
+ * annotations with empty value are removed.
+ */
+func (manager *K8sResourceManager) QueueObjectAnnotation(kind string, ns string, name string, values map[string]string) {
+	gvr, ok := kindResources[kind]
+	if !ok {
+		glog.Errorf("Unexpected kind %s of %s@%s", kind, name, ns)
+		return
+	}
+	annotations := make(map[string]interface{})
+	for k, v := range values {
+		if v == "" {
+			annotations[k] = nil
+		} else {
+			annotations[k] = v
+		}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		glog.Errorf("Failed to marshal annotation patch: %s", err.Error())
+		return
+	}
+	manager.writes.add(fmt.Sprintf("%s/%s/%s", strings.ToLower(kind), ns, name), &writeOp{
+		operation: "PatchObjectAnnotation",
+		write: func() error {
+			if manager.skipWrite("PatchObjectAnnotation", name) {
+				return nil
+			}
+			_, err := manager.DynamicClient.Resource(gvr).Namespace(ns).Patch(context.TODO(), name, types.MergePatchType, patch, metav1.PatchOptions{})
+			if apierrors.IsNotFound(err) {
+				return nil
+			}
+			return metrics.K8sWrite("PatchObjectAnnotation", err)
+		},
+	})
+}
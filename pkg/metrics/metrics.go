@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+var (
+	AdsStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "traffic_ads_streams",
+		Help: "Connected ADS streams watching each type",
+	}, []string{"type_url"})
+
+	XdsPushes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "traffic_xds_pushes_total",
+		Help: "Responses sent to envoy",
+	}, []string{"type_url"})
+
+	XdsPushDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "traffic_xds_push_duration_seconds",
+		Help:    "Time from a response being built to being sent",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"type_url"})
+
+	XdsNacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "traffic_xds_nacks_total",
+		Help: "Responses rejected by envoy",
+	}, []string{"type_url"})
+
+	Resources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "traffic_resources",
+		Help: "Resources held by each control plane service",
+	}, []string{"service"})
+
+	LockHoldDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "traffic_k8s_lock_hold_seconds",
+		Help:    "Time spent holding the K8sResourceManager lock",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+	})
+
+	InformerEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "traffic_informer_events_total",
+		Help: "Events received from kubernetes informers",
+	}, []string{"kind", "event"})
+
+	K8sWriteFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "traffic_k8s_write_failures_total",
+		Help: "Failed kubernetes api writes",
+	}, []string{"operation"})
+)
+
+func init() {
+	prometheus.MustRegister(AdsStreams, XdsPushes, XdsPushDuration, XdsNacks, Resources,
+		LockHoldDuration, InformerEvents, K8sWriteFailures)
+}
+
+func ObservePush(typeUrl string, start time.Time) {
+	XdsPushes.WithLabelValues(typeUrl).Inc()
+	XdsPushDuration.WithLabelValues(typeUrl).Observe(time.Since(start).Seconds())
+}
+
+func InformerEvent(kind string, event string) {
+	InformerEvents.WithLabelValues(kind, event).Inc()
+}
+
+//record the error if not nil
+func K8sWrite(operation string, err error) error {
+	if err != nil {
+		K8sWriteFailures.WithLabelValues(operation).Inc()
+	}
+	return err
+}
@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestK8sWrite(t *testing.T) {
+	assert.Nil(t, K8sWrite("TestWrite", nil))
+	assert.Equal(t, testutil.ToFloat64(K8sWriteFailures.WithLabelValues("TestWrite")), float64(0))
+
+	err := fmt.Errorf("conflict")
+	assert.Equal(t, K8sWrite("TestWrite", err), err)
+	assert.Equal(t, testutil.ToFloat64(K8sWriteFailures.WithLabelValues("TestWrite")), float64(1))
+}
+
+func TestObservePush(t *testing.T) {
+	ObservePush("test-type", time.Now())
+	ObservePush("test-type", time.Now())
+	assert.Equal(t, testutil.ToFloat64(XdsPushes.WithLabelValues("test-type")), float64(2))
+}
@@ -0,0 +1,130 @@
+package validation
+
+import (
+	"fmt"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+)
+
+const (
+	INVALID_CONFIG_REASON = "InvalidTrafficConfig"
+	//annotation listing invalid traffic.* labels of the object, written if enabled
+	CONFIG_STATUS_ANNOTATION = "traffic.config.status"
+)
+
+/**
+ * Report invalid traffic.* labels of services, pods, workloads and namespaces, and invalid entries of
+ * traffic-defaults ConfigMap as Warning events,
+ * and optionally in traffic.config.status annotation.
+ * Only the leader reports, problems are reported again when they change.
+ */
+type ConfigReporter struct {
+	k8sManager       *kubernetes.K8sResourceManager
+	statusAnnotation bool
+	//kind/namespace/name => problems last reported
+	reported map[string]string
+}
+
+func NewConfigReporter(k8sManager *kubernetes.K8sResourceManager, statusAnnotation bool) *ConfigReporter {
+	return &ConfigReporter{
+		k8sManager:       k8sManager,
+		statusAnnotation: statusAnnotation,
+		reported:         make(map[string]string),
+	}
+}
+
+func (reporter *ConfigReporter) report(kind string, ns string, name string, uid types.UID, labels map[string]string, annotations map[string]string) {
+	if !reporter.k8sManager.IsLeader() {
+		return
+	}
+	invalid, ambiguous := ValidateLabels(labels)
+	problems := strings.Join(append(invalid, ambiguous...), "; ")
+	key := fmt.Sprintf("%s/%s/%s", kind, ns, name)
+	if problems != reporter.reported[key] {
+		if problems != "" {
+			reporter.reported[key] = problems
+			var messages []string
+			if len(invalid) > 0 {
+				messages = append(messages, fmt.Sprintf("Ignored invalid traffic labels: %s", strings.Join(invalid, "; ")))
+			}
+			if len(ambiguous) > 0 {
+				messages = append(messages, fmt.Sprintf("Ambiguous traffic labels: %s", strings.Join(ambiguous, "; ")))
+			}
+			reporter.k8sManager.ObjectWarning(kind, ns, name, uid, INVALID_CONFIG_REASON, strings.Join(messages, ". "))
+		} else {
+			delete(reporter.reported, key)
+		}
+	}
+	if reporter.statusAnnotation && annotations[CONFIG_STATUS_ANNOTATION] != problems {
+		reporter.k8sManager.QueueObjectAnnotation(kind, ns, name, map[string]string{CONFIG_STATUS_ANNOTATION: problems})
+	}
+}
+
+func (reporter *ConfigReporter) forget(kind string, ns string, name string) {
+	delete(reporter.reported, fmt.Sprintf("%s/%s/%s", kind, ns, name))
+}
+
+func (reporter *ConfigReporter) ServiceValid(svc *kubernetes.ServiceInfo) bool {
+	return true
+}
+func (reporter *ConfigReporter) ServiceAdded(svc *kubernetes.ServiceInfo) {
+	reporter.report("Service", svc.Namespace(), svc.Name(), svc.UID, svc.Labels, svc.Annotations)
+}
+func (reporter *ConfigReporter) ServiceDeleted(svc *kubernetes.ServiceInfo) {
+	reporter.forget("Service", svc.Namespace(), svc.Name())
+}
+func (reporter *ConfigReporter) ServiceUpdated(oldService, newService *kubernetes.ServiceInfo) {
+	reporter.ServiceAdded(newService)
+}
+
+func (reporter *ConfigReporter) PodValid(pod *kubernetes.PodInfo) bool {
+	return pod.Valid()
+}
+func (reporter *ConfigReporter) PodAdded(pod *kubernetes.PodInfo) {
+	reporter.report("Pod", pod.Namespace(), pod.Name(), pod.UID, pod.Labels, pod.Annotations)
+}
+func (reporter *ConfigReporter) PodDeleted(pod *kubernetes.PodInfo) {
+	reporter.forget("Pod", pod.Namespace(), pod.Name())
+}
+func (reporter *ConfigReporter) PodUpdated(oldPod, newPod *kubernetes.PodInfo) {
+	reporter.PodAdded(newPod)
+}
+
+func (reporter *ConfigReporter) DeploymentValid(deployment *kubernetes.DeploymentInfo) bool {
+	return true
+}
+func (reporter *ConfigReporter) DeploymentAdded(deployment *kubernetes.DeploymentInfo) {
+	reporter.report(deployment.Kind(), deployment.Namespace(), deployment.Name(), deployment.UID, deployment.Labels, deployment.Annotations)
+}
+func (reporter *ConfigReporter) DeploymentDeleted(deployment *kubernetes.DeploymentInfo) {
+	reporter.forget(deployment.Kind(), deployment.Namespace(), deployment.Name())
+}
+func (reporter *ConfigReporter) DeploymentUpdated(oldDeployment, newDeployment *kubernetes.DeploymentInfo) {
+	reporter.DeploymentAdded(newDeployment)
+}
+
+func (reporter *ConfigReporter) NamespaceAdded(ns *kubernetes.NamespaceInfo) {
+	reporter.report("Namespace", "", ns.Name(), ns.UID, ns.Labels, ns.Annotations)
+}
+func (reporter *ConfigReporter) NamespaceDeleted(ns *kubernetes.NamespaceInfo) {
+	reporter.forget("Namespace", "", ns.Name())
+}
+func (reporter *ConfigReporter) NamespaceUpdated(oldNamespace, newNamespace *kubernetes.NamespaceInfo) {
+	reporter.NamespaceAdded(newNamespace)
+}
+
+func (reporter *ConfigReporter) MeshDefaultsAdded(defaults *kubernetes.MeshDefaultsInfo) {
+	reporter.report("ConfigMap", defaults.Namespace(), defaults.Name(), defaults.UID, defaults.Data, defaults.Annotations)
+}
+func (reporter *ConfigReporter) MeshDefaultsDeleted(defaults *kubernetes.MeshDefaultsInfo) {
+	reporter.forget("ConfigMap", defaults.Namespace(), defaults.Name())
+}
+func (reporter *ConfigReporter) MeshDefaultsUpdated(oldDefaults, newDefaults *kubernetes.MeshDefaultsInfo) {
+	reporter.MeshDefaultsAdded(newDefaults)
+}
+
+//problems reported by the previous leader are reported again on next event or resync
+func (reporter *ConfigReporter) StartedLeading() {
+	reporter.reported = make(map[string]string)
+}
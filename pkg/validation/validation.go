@@ -0,0 +1,48 @@
+package validation
+
+import (
+	"fmt"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/cluster"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/endpoint"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/envoy/listener"
+	"github.com/luguoxiang/kubernetes-traffic-manager/pkg/kubernetes"
+	"sort"
+	"strings"
+)
+
+//validators of the packages interpreting traffic.* labels
+var validators = []func(key string, value string) (bool, error){
+	kubernetes.ValidateLabel,
+	cluster.ValidateLabel,
+	listener.ValidateLabel,
+	endpoint.ValidateLabel,
+}
+
+/**
+ * Return problems of recognized traffic.* labels sorted by key, e.g. "traffic.endpoint.weight=500: should be an integer in [0, 128]",
+ * and warnings of labels which are used but ambiguous, e.g. bare integer durations below 1ms.
+ * Unknown keys are ignored.
+ */
+func ValidateLabels(labels map[string]string) ([]string, []string) {
+	var problems, warnings []string
+	for key, value := range labels {
+		if !strings.HasPrefix(key, "traffic.") {
+			continue
+		}
+		for _, validate := range validators {
+			recognized, err := validate(key, value)
+			if !recognized {
+				continue
+			}
+			if kubernetes.IsValidationWarning(err) {
+				warnings = append(warnings, fmt.Sprintf("%s=%s: %s", key, value, err.Error()))
+			} else if err != nil {
+				problems = append(problems, fmt.Sprintf("%s=%s: %s", key, value, err.Error()))
+			}
+			break
+		}
+	}
+	sort.Strings(problems)
+	sort.Strings(warnings)
+	return problems, warnings
+}
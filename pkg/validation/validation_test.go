@@ -0,0 +1,83 @@
+package validation
+
+import (
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"testing"
+)
+
+func TestValidateLabels(t *testing.T) {
+	problems, warnings := ValidateLabels(map[string]string{
+		"app":                        "reviews",
+		"traffic.unknown":            "abc",
+		"traffic.endpoint.weight":    "500",
+		"traffic.lb.policy":          "ROUND_ROBBIN",
+		"traffic.request.timeout":    "3000",
+		"traffic.connection.timeout": "3 seconds",
+		"traffic.fault.delay.time":   "250ms",
+		"traffic.port.9080":          "http",
+		"traffic.port.70000":         "http",
+		"traffic.envoy.enabled":      "true",
+		"traffic.tracing.sampling":   "50.5",
+		"traffic.fault.abort.status": "505",
+	})
+	assert.Equal(t, []string{
+		"traffic.connection.timeout=3 seconds: should be a duration like 3s, 250ms or 1m",
+		"traffic.endpoint.weight=500: should be an integer in [0, 128]",
+		"traffic.lb.policy=ROUND_ROBBIN: should be one of ROUND_ROBIN, LEAST_REQUEST, RING_HASH, RANDOM, MAGLEV",
+		"traffic.port.70000=http: port in key should be an integer in [1, 65535]",
+	}, problems)
+	assert.Equal(t, []string{
+		"traffic.request.timeout=3000: is ambiguous, bare integer is 3µs in nanoseconds, add a unit like 3000ms or 3000s",
+	}, warnings)
+
+	problems, warnings = ValidateLabels(map[string]string{
+		"traffic.visibility":       "ns1, ns2",
+		"traffic.retries.5xx":      "3",
+		"traffic.connection.max":   "100",
+		"traffic.hash.header.name": "x-user",
+		"traffic.request.timeout":  "5000000000",
+		"traffic.hash.cookie.ttl":  "100",
+	})
+	assert.Empty(t, problems)
+	assert.Empty(t, warnings)
+}
+
+func TestWebhookReview(t *testing.T) {
+	deployment := []byte(`{"apiVersion":"apps/v1","kind":"Deployment","metadata":{"name":"reviews","labels":{"traffic.envoy.enabled":"true"}},
+		"spec":{"template":{"metadata":{"labels":{"traffic.endpoint.weight":"500"}}}}}`)
+	response := review(&admissionv1.AdmissionRequest{UID: "1", Object: runtime.RawExtension{Raw: deployment}})
+	assert.False(t, response.Allowed)
+	assert.Equal(t, "invalid traffic labels: spec.template.metadata.labels: traffic.endpoint.weight=500: should be an integer in [0, 128]",
+		response.Result.Message)
+
+	service := []byte(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"reviews","labels":{"traffic.lb.policy":"RANDOM"}}}`)
+	response = review(&admissionv1.AdmissionRequest{UID: "2", Object: runtime.RawExtension{Raw: service}})
+	assert.True(t, response.Allowed)
+
+	service = []byte(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"reviews","labels":{"traffic.request.timeout":"3000"}}}`)
+	response = review(&admissionv1.AdmissionRequest{UID: "6", Object: runtime.RawExtension{Raw: service}})
+	assert.True(t, response.Allowed)
+	assert.Equal(t, []string{"metadata.labels: traffic.request.timeout=3000: is ambiguous, bare integer is 3µs in nanoseconds, add a unit like 3000ms or 3000s"},
+		response.Warnings)
+
+	//pods of workloads are not validated
+	pod := []byte(`{"apiVersion":"v1","kind":"Pod","metadata":{"name":"reviews-1","labels":{"traffic.endpoint.weight":"500"},
+		"ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"reviews","uid":"2","controller":true}]}}`)
+	response = review(&admissionv1.AdmissionRequest{UID: "3", Object: runtime.RawExtension{Raw: pod}})
+	assert.True(t, response.Allowed)
+
+	//updates keeping invalid labels are allowed with warnings
+	oldService := []byte(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"reviews","labels":{"traffic.endpoint.weight":"500"}}}`)
+	service = []byte(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"reviews","labels":{"traffic.endpoint.weight":"500","app":"reviews"}}}`)
+	response = review(&admissionv1.AdmissionRequest{UID: "4", Operation: admissionv1.Update,
+		Object: runtime.RawExtension{Raw: service}, OldObject: runtime.RawExtension{Raw: oldService}})
+	assert.True(t, response.Allowed)
+	assert.Equal(t, []string{"metadata.labels: traffic.endpoint.weight=500: should be an integer in [0, 128]"}, response.Warnings)
+
+	service = []byte(`{"apiVersion":"v1","kind":"Service","metadata":{"name":"reviews","labels":{"traffic.endpoint.weight":"600"}}}`)
+	response = review(&admissionv1.AdmissionRequest{UID: "5", Operation: admissionv1.Update,
+		Object: runtime.RawExtension{Raw: service}, OldObject: runtime.RawExtension{Raw: oldService}})
+	assert.False(t, response.Allowed)
+}
@@ -0,0 +1,136 @@
+package validation
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+//label maps of the admitted object which are validated, pod templates of workloads are validated as well
+var labelPaths = [][]string{
+	{"metadata", "labels"},
+	{"spec", "template", "metadata", "labels"},
+	{"spec", "jobTemplate", "spec", "template", "metadata", "labels"},
+}
+
+func decodeObject(raw []byte) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+//traffic.* labels at the path, nil if there are none
+func trafficLabels(obj *unstructured.Unstructured, path []string) map[string]string {
+	if obj == nil {
+		return nil
+	}
+	labels, found, err := unstructured.NestedStringMap(obj.Object, path...)
+	if err != nil || !found {
+		return nil
+	}
+	var result map[string]string
+	for key, value := range labels {
+		if strings.HasPrefix(key, "traffic.") {
+			if result == nil {
+				result = make(map[string]string)
+			}
+			result[key] = value
+		}
+	}
+	return result
+}
+
+//pods created by workloads, e.g. ReplicaSet, get their labels from the pod template which is validated on the workload
+func controlled(obj *unstructured.Unstructured) bool {
+	for _, owner := range obj.GetOwnerReferences() {
+		if owner.Controller != nil && *owner.Controller {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Return problems of traffic.* labels changed by the request, and warnings of ambiguous labels and problems of labels unchanged since oldObj.
+ * oldObj is nil for CREATE requests.
+ */
+func validateObject(obj *unstructured.Unstructured, oldObj *unstructured.Unstructured) ([]string, []string) {
+	var problems, warnings []string
+	for _, path := range labelPaths {
+		labels := trafficLabels(obj, path)
+		invalid, ambiguous := ValidateLabels(labels)
+		for _, warning := range ambiguous {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", strings.Join(path, "."), warning))
+		}
+		for _, problem := range invalid {
+			problem = fmt.Sprintf("%s: %s", strings.Join(path, "."), problem)
+			if oldObj != nil && reflect.DeepEqual(labels, trafficLabels(oldObj, path)) {
+				warnings = append(warnings, problem)
+			} else {
+				problems = append(problems, problem)
+			}
+		}
+	}
+	return problems, warnings
+}
+
+/**
+ * Deny objects whose changed traffic.* labels are invalid. Ambiguous labels and invalid labels which are not changed
+ * by an UPDATE are returned as warnings, so that unrelated updates of existing objects are not blocked.
+ */
+func review(request *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	response := &admissionv1.AdmissionResponse{UID: request.UID, Allowed: true}
+	obj, err := decodeObject(request.Object.Raw)
+	if err != nil {
+		glog.Errorf("Failed to decode %s %s@%s: %s", request.Kind.Kind, request.Name, request.Namespace, err.Error())
+		return response
+	}
+	if obj.GetKind() == "Pod" && controlled(obj) {
+		return response
+	}
+	var oldObj *unstructured.Unstructured
+	if request.Operation == admissionv1.Update && len(request.OldObject.Raw) > 0 {
+		oldObj, err = decodeObject(request.OldObject.Raw)
+		if err != nil {
+			glog.Errorf("Failed to decode old %s %s@%s: %s", request.Kind.Kind, request.Name, request.Namespace, err.Error())
+			return response
+		}
+	}
+	problems, warnings := validateObject(obj, oldObj)
+	response.Warnings = warnings
+	if len(problems) > 0 {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Message: fmt.Sprintf("invalid traffic labels: %s", strings.Join(problems, "; ")),
+		}
+	}
+	return response
+}
+
+//Handler of admission/v1 AdmissionReview requests which rejects objects with invalid traffic.* labels
+func WebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var admissionReview admissionv1.AdmissionReview
+		if err := json.NewDecoder(r.Body).Decode(&admissionReview); err != nil || admissionReview.Request == nil {
+			http.Error(w, "invalid AdmissionReview", http.StatusBadRequest)
+			return
+		}
+		admissionReview.Response = review(admissionReview.Request)
+		admissionReview.Request = nil
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&admissionReview); err != nil {
+			glog.Errorf("Failed to write AdmissionReview response: %s", err.Error())
+		}
+	})
+}